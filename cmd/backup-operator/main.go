@@ -17,6 +17,7 @@ package main
 import (
 	"context"
 	"flag"
+	"net/http"
 	"os"
 	"runtime"
 	"time"
@@ -26,6 +27,7 @@ import (
 	"github.com/coreos/etcd-operator/pkg/util/k8sutil"
 	version "github.com/coreos/etcd-operator/version/backup-operator"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
 	"k8s.io/api/core/v1"
 	"k8s.io/client-go/kubernetes"
@@ -37,11 +39,13 @@ import (
 )
 
 var (
-	createCRD bool
+	createCRD  bool
+	listenAddr string
 )
 
 func init() {
 	flag.BoolVar(&createCRD, "create-crd", true, "The backup operator will not create the EtcdBackup CRD when this flag is set to false.")
+	flag.StringVar(&listenAddr, "listen-addr", "0.0.0.0:8080", "The address on which the HTTP server serving metrics will listen to")
 	flag.Parse()
 }
 
@@ -64,6 +68,9 @@ func main() {
 	logrus.Infof("etcd-backup-operator Version: %v", version.Version)
 	logrus.Infof("Git SHA: %s", version.GitSHA)
 
+	http.Handle("/metrics", prometheus.Handler())
+	go http.ListenAndServe(listenAddr, nil)
+
 	kubecli := k8sutil.MustNewKubeClient()
 	rl, err := resourcelock.New(
 		resourcelock.EndpointsResourceLock,
@@ -26,6 +26,10 @@ const (
 	BackupStorageTypeABS      BackupStorageType = "ABS"
 	AzureSecretStorageAccount                   = "storage-account"
 	AzureSecretStorageKey                       = "storage-key"
+	AzureSecretEncryptionKey                    = "encryption-key"
+	// AzureSecretStorageEndpointSuffix is the optional storage endpoint suffix of
+	// the Azure cloud the storage account lives in, e.g. "core.usgovcloudapi.net".
+	AzureSecretStorageEndpointSuffix = "storage-endpoint-suffix"
 )
 
 type BackupStorageType string
@@ -57,6 +61,9 @@ type BackupSpec struct {
 	// the backup from the endpoint that has the most up-to-date state.
 	// The given endpoints must belong to the same etcd cluster.
 	EtcdEndpoints []string `json:"etcdEndpoints,omitempty"`
+	// ClusterName is the name of the backed up etcd cluster.
+	// If set, it is recorded in the metadata of the backups.
+	ClusterName string `json:"clusterName,omitempty"`
 	// StorageType is the etcd backup storage type.
 	// We need this field because CRD doesn't support validation against invalid fields
 	// and we cannot verify invalid backup storage source.
@@ -89,6 +96,13 @@ type BackupSchedule struct {
 	BackupIntervalInSecond int `json:"backupIntervalInSecond"`
 	// MaxBackups imply how many snapshots you want to back up
 	MaxBackups int `json:"maxBackups"`
+	// MaxBackupsPerVersion imply how many snapshots you want to keep for each etcd version.
+	// If set, it's used instead of MaxBackups to purge stale backups,
+	// so that upgrading etcd does not immediately purge the backups of the previous version.
+	MaxBackupsPerVersion int `json:"maxBackupsPerVersion,omitempty"`
+	// MaxBackupAgeInSecond imply how long you want to retain snapshots.
+	// If set, snapshots older than it are purged, except the latest one.
+	MaxBackupAgeInSecond int `json:"maxBackupAgeInSecond,omitempty"`
 }
 
 // BackupStatus represents the status of the EtcdBackup Custom Resource.
@@ -124,8 +138,42 @@ type ABSBackupSource struct {
 	// Path is the full abs path where the backup is saved.
 	// The format of the path must be: "<abs-container-name>/<path-to-backup-file>"
 	// e.g: "myabscontainer/etcd.backup"
+	// The path may contain the "{namespace}" and "{clusterName}" placeholders, which are
+	// substituted with the namespace and cluster name of the backup,
+	// e.g: "myabscontainer/{namespace}/{clusterName}/v1/etcd.backup"
 	Path string `json:"path"`
 
 	// The name of the secret object that stores the Azure storage credential
 	ABSSecret string `json:"absSecret"`
+
+	// Compression enables gzip compression of the backup before it is uploaded.
+	// Compressed backups are saved with a ".gz" suffix appended to the path.
+	Compression bool `json:"compression,omitempty"`
+
+	// EncryptionSecret is the name of the secret object that stores the 32 bytes
+	// AES-256 key used to encrypt the backup before it is uploaded.
+	// The key MUST be stored under 'encryption-key'.
+	EncryptionSecret string `json:"encryptionSecret,omitempty"`
+
+	// BlockSizeBytes is the size of the blocks the backup is uploaded in, up to 100 MiB.
+	// A backup can have at most 50000 blocks, which bounds its size. Defaults to 4 MiB.
+	BlockSizeBytes int `json:"blockSizeBytes,omitempty"`
+
+	// TimeoutInSecond bounds each ABS request, and each read of a copied backup that makes no progress.
+	// Uploading a backup is not bounded as a whole, so that large backups can be saved over slow links.
+	// Defaults to 5 minutes.
+	TimeoutInSecond int `json:"timeoutInSecond,omitempty"`
+
+	// Tags are saved along with each backup, e.g. for cost allocation, and can be used to find backups.
+	// Keys may only contain lower case letters, digits and underscores.
+	Tags map[string]string `json:"tags,omitempty"`
+
+	// QuotaBytes refuses to save a backup before uploading anything if the backups of the backup path
+	// would exceed QuotaBytes in total, counted before compression. Zero means no quota.
+	QuotaBytes int64 `json:"quotaBytes,omitempty"`
+
+	// AccessTier is the access tier each backup is moved to once saved: Hot, Cool or Archive.
+	// Backups in the Archive tier must be rehydrated before they can be restored.
+	// Defaults to the default access tier of the storage account.
+	AccessTier string `json:"accessTier,omitempty"`
 }
@@ -91,6 +91,15 @@ type ABSRestoreSource struct {
 
 	// The name of the secret object that stores the Azure Blob Storage credential.
 	ABSSecret string `json:"absSecret"`
+
+	// EncryptionSecret is the name of the secret object that stores the AES-256 key
+	// the backup was encrypted with. It must be set if the backup is encrypted.
+	EncryptionSecret string `json:"encryptionSecret,omitempty"`
+
+	// TimeoutInSecond bounds each ABS request, and each read of the backup that makes no progress.
+	// Downloading the backup is not bounded as a whole, so that large backups can be restored over slow links.
+	// Defaults to 5 minutes.
+	TimeoutInSecond int `json:"timeoutInSecond,omitempty"`
 }
 
 // RestoreStatus reports the status of this restore operation.
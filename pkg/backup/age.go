@@ -0,0 +1,72 @@
+// Copyright 2018 The etcd-operator Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backup
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/coreos/etcd-operator/pkg/backup/reader"
+	"github.com/coreos/etcd-operator/pkg/backup/util"
+)
+
+// AgeHistogram counts the backups saved with revision appended to path of r by age at now,
+// e.g. to alert on the number of backups of the last hour, day and week. The i-th count is the number of backups
+// younger than buckets[i], so backups younger than several buckets count towards each of them.
+// The age of a backup is taken from the save time embedded in its name if any, and its last modified time otherwise.
+// buckets must be positive and increasing.
+func AgeHistogram(ctx context.Context, r reader.Reader, path string, now time.Time, buckets []time.Duration) ([]int, error) {
+	for i, b := range buckets {
+		if b <= 0 || (i > 0 && b <= buckets[i-1]) {
+			return nil, fmt.Errorf("invalid age buckets %v: must be positive and increasing", buckets)
+		}
+	}
+	counts := make([]int, len(buckets))
+	err := r.WalkBackups(ctx, path, func(info util.BackupInfo) error {
+		age := now.Sub(info.SavedAt())
+		for i := len(buckets) - 1; i >= 0 && age < buckets[i]; i-- {
+			counts[i]++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
+
+// ListBetween returns the info of the backups saved with revision appended to path of r between start and end,
+// both included, sorted from the oldest to the latest with util.ByRecency, e.g. to find the backups taken
+// during an incident. Save times are taken like in AgeHistogram.
+func ListBetween(ctx context.Context, r reader.Reader, path string, start, end time.Time) ([]util.BackupInfo, error) {
+	if end.Before(start) {
+		return nil, fmt.Errorf("invalid time range: end %v is before start %v", end, start)
+	}
+	var infos []util.BackupInfo
+	err := r.WalkBackups(ctx, path, func(info util.BackupInfo) error {
+		saved := info.SavedAt()
+		if !saved.Before(start) && !saved.After(end) {
+			infos = append(infos, info)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(util.ByRecency(infos))
+	return infos, nil
+}
@@ -0,0 +1,82 @@
+// Copyright 2018 The etcd-operator Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backup
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd-operator/pkg/backup/util"
+)
+
+func TestAgeHistogram(t *testing.T) {
+	ctx := context.Background()
+	b := NewMemoryBackend()
+	now := time.Now()
+	ages := []time.Duration{10 * time.Minute, 30 * time.Minute, 5 * time.Hour, 3 * 24 * time.Hour, 30 * 24 * time.Hour}
+	for i, age := range ages {
+		path := "cluster-a/etcd.backup_" + util.MakeTimestampedBackupName(now.Add(-age), "", "3.2.13", int64(i))
+		if _, err := b.Write(ctx, path, strings.NewReader("backup")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	buckets := []time.Duration{time.Hour, 24 * time.Hour, 7 * 24 * time.Hour}
+	counts, err := AgeHistogram(ctx, b, "cluster-a/etcd.backup", now, buckets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{2, 3, 4}; !reflect.DeepEqual(counts, want) {
+		t.Errorf("expect counts=%v, get=%v", want, counts)
+	}
+
+	if _, err := AgeHistogram(ctx, b, "cluster-a/etcd.backup", now, []time.Duration{24 * time.Hour, time.Hour}); err == nil {
+		t.Error("expect decreasing buckets to be rejected")
+	}
+}
+
+func TestListBetween(t *testing.T) {
+	ctx := context.Background()
+	b := NewMemoryBackend()
+	start := time.Date(2018, 3, 5, 0, 0, 0, 0, time.UTC)
+	// One backup a day for a week, saved in reverse order.
+	for day := 6; day >= 0; day-- {
+		path := "cluster-a/etcd.backup_" + util.MakeTimestampedBackupName(start.Add(time.Duration(day)*24*time.Hour), "", "3.2.13", int64(day+1))
+		if _, err := b.Write(ctx, path, strings.NewReader("backup")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The window includes the backups saved exactly on its bounds.
+	from, to := start.Add(2*24*time.Hour), start.Add(4*24*time.Hour)
+	infos, err := ListBetween(ctx, b, "cluster-a/etcd.backup", from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var revs []uint64
+	for _, info := range infos {
+		revs = append(revs, info.Revision)
+	}
+	if want := []uint64{3, 4, 5}; !reflect.DeepEqual(revs, want) {
+		t.Errorf("expect revisions=%v, get=%v", want, revs)
+	}
+
+	if _, err := ListBetween(ctx, b, "cluster-a/etcd.backup", to, from); err == nil {
+		t.Error("expect an inverted time range to be rejected")
+	}
+}
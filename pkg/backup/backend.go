@@ -0,0 +1,284 @@
+// Copyright 2018 The etcd-operator Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backup
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/coreos/etcd-operator/pkg/backup/reader"
+	"github.com/coreos/etcd-operator/pkg/backup/util"
+	"github.com/coreos/etcd-operator/pkg/backup/writer"
+
+	"github.com/Azure/azure-sdk-for-go/storage"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// Backend defines the operations on a backup storage,
+// so that backup and restore logic does not depend on a specific storage.
+type Backend interface {
+	writer.Writer
+	reader.Reader
+}
+
+// ensure backend satisfies Backend interface.
+var _ Backend = &backend{}
+
+// backend combines the writer and reader of the same backup storage.
+type backend struct {
+	writer.Writer
+	reader.Reader
+}
+
+// ABSBackend is a Backend saving backups to ABS, which can also replicate them to another container,
+// reclaim the storage of interrupted uploads, archive stale backups, move and undelete backups,
+// append WAL segments, get the storage properties of backups
+// read them as stored, find them by tag, list them a page at a time, get their stored checksums
+// and check them against their index.
+type ABSBackend interface {
+	Backend
+	writer.ABSCopier
+	writer.ABSPruner
+	writer.ABSArchiver
+	writer.ABSMover
+	writer.ABSUndeleter
+	writer.ABSWALAppender
+	writer.ABSTierSetter
+	reader.ABSPropertiesGetter
+	reader.ABSRawOpener
+	reader.ABSTagLister
+	reader.ABSMarkerLister
+	reader.ABSIndexVerifier
+	reader.ChecksumGetter
+}
+
+// absBackend combines the writer and reader of ABS.
+type absBackend struct {
+	writer.Writer
+	reader.Reader
+	writer.ABSCopier
+	writer.ABSPruner
+	writer.ABSArchiver
+	writer.ABSMover
+	writer.ABSUndeleter
+	writer.ABSWALAppender
+	writer.ABSTierSetter
+	reader.ABSPropertiesGetter
+	reader.ABSRawOpener
+	reader.ABSTagLister
+	reader.ABSMarkerLister
+	reader.ABSIndexVerifier
+	reader.ChecksumGetter
+}
+
+// ABSConfig configures an ABS backend created with NewABS.
+type ABSConfig struct {
+	// Client is the ABS client of the storage account backups are saved to.
+	Client *storage.BlobStorageClient
+	// Compress gzip compresses backups, which are saved with the util.GzipSuffix appended.
+	Compress bool
+	// EncryptionKey encrypts backups with AES-256-GCM after compression if not empty.
+	// It must be util.EncryptionKeySize bytes long.
+	EncryptionKey []byte
+	// ClusterName is recorded in the metadata of backups if not empty.
+	// It must contain neither "/" nor "_".
+	ClusterName string
+	// Timeout bounds each request, or util.DefaultOperationTimeout if 0. See writer.NewABSWriter and reader.NewABSReader.
+	Timeout time.Duration
+	// BlockSize is the size of the blocks backups are uploaded in, or writer.DefaultBlockSizeInBytes if 0.
+	// It must be valid according to writer.ValidateBlockSize.
+	BlockSize int
+	// BlockParallelism is the number of blocks of a backup staged concurrently, buffering as many blocks in memory,
+	// or util.DefaultBlockParallelism if 0.
+	BlockParallelism int
+	// MinKeep is the number of latest backups purges keep whatever their policy, or util.DefaultMinKeep if 0.
+	MinKeep int
+	// PurgeWorkers is the number of backups purges delete concurrently, or util.DefaultPurgeWorkers if 0.
+	PurgeWorkers int
+	// CreateContainers creates missing containers with the ContainerAccess public access level when saving backups,
+	// including the containers the chunks of deduplicated backups are stored in.
+	CreateContainers bool
+	ContainerAccess  storage.ContainerAccessType
+	// Dedup saves backups deduplicated across backups, see writer.NewABSDedupWriter.
+	// Deduplicated backups can be neither compressed nor encrypted, and are uploaded in chunks instead of blocks.
+	Dedup bool
+	// DefaultTier is the access tier backups are moved to once saved, e.g. util.AccessTierCool,
+	// or the default access tier of the storage account if empty. See writer.ABSTierSetter to move them later.
+	// Deduplicated backups keep the default tier of the account, since their chunks are shared with other backups.
+	DefaultTier util.AccessTier
+	// VerifyChecksums checks the content of backups against their stored checksum as they are read,
+	// see reader.NewABSVerifyingReader.
+	VerifyChecksums bool
+}
+
+// Validate checks that the combination of options of cfg is valid.
+func (cfg ABSConfig) Validate() error {
+	if cfg.Client == nil {
+		return fmt.Errorf("ABS client is required")
+	}
+	if len(cfg.EncryptionKey) != 0 && len(cfg.EncryptionKey) != util.EncryptionKeySize {
+		return fmt.Errorf("invalid encryption key size: expect %d bytes, get %d", util.EncryptionKeySize, len(cfg.EncryptionKey))
+	}
+	if strings.ContainsAny(cfg.ClusterName, "/_") {
+		return fmt.Errorf("invalid cluster name (%v): must contain neither \"/\" nor \"_\"", cfg.ClusterName)
+	}
+	if cfg.Timeout < 0 {
+		return fmt.Errorf("invalid timeout (%v): must not be negative", cfg.Timeout)
+	}
+	if cfg.BlockSize != 0 {
+		if err := writer.ValidateBlockSize(cfg.BlockSize); err != nil {
+			return err
+		}
+	}
+	if cfg.BlockParallelism < 0 {
+		return fmt.Errorf("invalid block parallelism (%d): must be positive", cfg.BlockParallelism)
+	}
+	if cfg.MinKeep < 0 {
+		return fmt.Errorf("invalid min keep (%d): must not be negative", cfg.MinKeep)
+	}
+	if cfg.PurgeWorkers < 0 {
+		return fmt.Errorf("invalid purge workers (%d): must be positive", cfg.PurgeWorkers)
+	}
+	if len(cfg.DefaultTier) != 0 {
+		if err := util.ValidateAccessTier(cfg.DefaultTier); err != nil {
+			return err
+		}
+	}
+	if len(cfg.ContainerAccess) != 0 && !cfg.CreateContainers {
+		return fmt.Errorf("container access %q requires creating containers", cfg.ContainerAccess)
+	}
+	if cfg.Dedup {
+		switch {
+		case cfg.Compress:
+			return fmt.Errorf("deduplicated backups can't be compressed")
+		case len(cfg.EncryptionKey) != 0:
+			return fmt.Errorf("deduplicated backups can't be encrypted")
+		case cfg.BlockSize != 0, cfg.BlockParallelism != 0:
+			return fmt.Errorf("deduplicated backups are not uploaded in blocks")
+		case len(cfg.DefaultTier) != 0:
+			return fmt.Errorf("deduplicated backups can't set an access tier")
+		}
+	}
+	return nil
+}
+
+// NewABS creates a Backend saving backups to ABS configured by cfg,
+// or returns an error if cfg is not valid according to ABSConfig.Validate.
+func NewABS(cfg ABSConfig) (ABSBackend, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return newABSBackend(cfg), nil
+}
+
+// newABSBackend creates the ABS backend configured by cfg, without validating it.
+func newABSBackend(cfg ABSConfig) ABSBackend {
+	w := writer.NewABSWriterFromConfig(cfg.Client, writer.ABSWriterConfig{
+		Compress:         cfg.Compress,
+		EncryptionKey:    cfg.EncryptionKey,
+		ClusterName:      cfg.ClusterName,
+		Timeout:          cfg.Timeout,
+		BlockSize:        cfg.BlockSize,
+		BlockParallelism: cfg.BlockParallelism,
+		MinKeep:          cfg.MinKeep,
+		PurgeWorkers:     cfg.PurgeWorkers,
+		CreateContainer:  cfg.CreateContainers,
+		ContainerAccess:  cfg.ContainerAccess,
+		Dedup:            cfg.Dedup,
+		Tier:             cfg.DefaultTier,
+	})
+	var r reader.Reader
+	if cfg.VerifyChecksums {
+		r = reader.NewABSVerifyingReader(cfg.Client, cfg.EncryptionKey, cfg.Timeout)
+	} else {
+		r = reader.NewABSReader(cfg.Client, cfg.EncryptionKey, cfg.Timeout)
+	}
+	return &absBackend{
+		Writer:              w,
+		Reader:              r,
+		ABSCopier:           w.(writer.ABSCopier),
+		ABSPruner:           w.(writer.ABSPruner),
+		ABSArchiver:         w.(writer.ABSArchiver),
+		ABSMover:            w.(writer.ABSMover),
+		ABSUndeleter:        w.(writer.ABSUndeleter),
+		ABSWALAppender:      w.(writer.ABSWALAppender),
+		ABSTierSetter:       w.(writer.ABSTierSetter),
+		ABSPropertiesGetter: r.(reader.ABSPropertiesGetter),
+		ABSRawOpener:        r.(reader.ABSRawOpener),
+		ABSTagLister:        r.(reader.ABSTagLister),
+		ABSMarkerLister:     r.(reader.ABSMarkerLister),
+		ABSIndexVerifier:    r.(reader.ABSIndexVerifier),
+		ChecksumGetter:      r.(reader.ChecksumGetter),
+	}
+}
+
+// NewABSBackend creates a Backend saving backups to ABS.
+// Each request times out after timeout, or util.DefaultOperationTimeout if timeout is 0.
+// Backups are uploaded in blocks of blockSize bytes, or writer.DefaultBlockSizeInBytes if blockSize is 0.
+// Unlike NewABS, it does not validate its arguments.
+func NewABSBackend(abs *storage.BlobStorageClient, compress bool, encryptionKey []byte, clusterName string, timeout time.Duration, blockSize int) ABSBackend {
+	return newABSBackend(ABSConfig{
+		Client:        abs,
+		Compress:      compress,
+		EncryptionKey: encryptionKey,
+		ClusterName:   clusterName,
+		Timeout:       timeout,
+		BlockSize:     blockSize,
+	})
+}
+
+// NewABSBackendCreate creates a Backend saving backups to ABS like NewABSBackend,
+// which creates missing containers with the given public access level when saving backups.
+func NewABSBackendCreate(abs *storage.BlobStorageClient, compress bool, encryptionKey []byte, clusterName string, timeout time.Duration, blockSize int, access storage.ContainerAccessType) ABSBackend {
+	return newABSBackend(ABSConfig{
+		Client:           abs,
+		Compress:         compress,
+		EncryptionKey:    encryptionKey,
+		ClusterName:      clusterName,
+		Timeout:          timeout,
+		BlockSize:        blockSize,
+		CreateContainers: true,
+		ContainerAccess:  access,
+	})
+}
+
+// NewABSDedupBackend creates a Backend saving backups to ABS deduplicated across backups.
+// See writer.NewABSDedupWriter for how deduplicated backups are stored.
+func NewABSDedupBackend(abs *storage.BlobStorageClient, clusterName string, timeout time.Duration) ABSBackend {
+	return newABSBackend(ABSConfig{Client: abs, ClusterName: clusterName, Timeout: timeout, Dedup: true})
+}
+
+// NewS3Backend creates a Backend saving backups to S3.
+func NewS3Backend(s3 *s3.S3) Backend {
+	return &backend{
+		Writer: writer.NewS3Writer(s3),
+		Reader: reader.NewS3Reader(s3),
+	}
+}
+
+// NewFSBackend creates a Backend saving backups under the root directory.
+func NewFSBackend(root string) Backend {
+	return NewFSBackendMinKeep(root, util.DefaultMinKeep)
+}
+
+// NewFSBackendMinKeep creates a Backend like NewFSBackend, whose purges keep the latest minKeep backups
+// whatever their policy. See writer.NewFSWriterMinKeep.
+func NewFSBackendMinKeep(root string, minKeep int) Backend {
+	return &backend{
+		Writer: writer.NewFSWriterMinKeep(root, minKeep),
+		Reader: reader.NewFSReader(root),
+	}
+}
@@ -0,0 +1,294 @@
+// Copyright 2018 The etcd-operator Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backup
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd-operator/pkg/backup/util"
+	"github.com/coreos/etcd-operator/pkg/backup/writer"
+
+	"github.com/Azure/azure-sdk-for-go/storage"
+	"github.com/pborman/uuid"
+)
+
+// TestBackendPurgePolicies runs each retention policy against every backend that can run without a storage account.
+func TestBackendPurgePolicies(t *testing.T) {
+	const path = "cluster-a/etcd.backup"
+	backupPath := func(ver string, rev int64) string {
+		return path + "_" + util.MakeBackupName(ver, rev)
+	}
+	// Backups 1 and 2 are saved long enough before 3 and 4 to be purged by age.
+	seed := func(t *testing.T, b Backend) {
+		for _, bk := range []struct {
+			ver string
+			rev int64
+		}{{"3.2.13", 1}, {"3.2.13", 2}, {"3.3.0", 3}, {"3.3.0", 4}} {
+			if bk.rev == 3 {
+				time.Sleep(100 * time.Millisecond)
+			}
+			if _, err := b.Write(context.Background(), backupPath(bk.ver, bk.rev), bytes.NewReader(bytes.Repeat([]byte("a"), 100))); err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	backends := map[string]func(t *testing.T, minKeep int) (Backend, func()){
+		"memory": func(t *testing.T, minKeep int) (Backend, func()) {
+			return NewMemoryBackendMinKeep(minKeep), func() {}
+		},
+		"fs": func(t *testing.T, minKeep int) (Backend, func()) {
+			root, err := ioutil.TempDir("", "fs-backend")
+			if err != nil {
+				t.Fatal(err)
+			}
+			return NewFSBackendMinKeep(root, minKeep), func() { os.RemoveAll(root) }
+		},
+	}
+	tests := []struct {
+		policy  string
+		minKeep int
+		purge   func(b Backend, dryRun bool) ([]string, error)
+		wPurged []string
+	}{{
+		policy: "count",
+		purge: func(b Backend, dryRun bool) ([]string, error) {
+			return b.Purge(context.Background(), path, 3, dryRun)
+		},
+		wPurged: []string{backupPath("3.2.13", 1)},
+	}, {
+		policy: "version",
+		purge: func(b Backend, dryRun bool) ([]string, error) {
+			return b.PurgeByVersion(context.Background(), path, 1, dryRun)
+		},
+		wPurged: []string{backupPath("3.2.13", 1), backupPath("3.3.0", 3)},
+	}, {
+		policy: "age",
+		purge: func(b Backend, dryRun bool) ([]string, error) {
+			return b.PurgeOlderThan(context.Background(), path, 50*time.Millisecond, dryRun)
+		},
+		wPurged: []string{backupPath("3.2.13", 1), backupPath("3.2.13", 2)},
+	}, {
+		policy: "size",
+		purge: func(b Backend, dryRun bool) ([]string, error) {
+			return b.PurgeToSize(context.Background(), path, 250, dryRun)
+		},
+		wPurged: []string{backupPath("3.2.13", 1), backupPath("3.2.13", 2)},
+	}, {
+		// Purging every backup older than now would leave only the latest one.
+		policy:  "age with min keep",
+		minKeep: 3,
+		purge: func(b Backend, dryRun bool) ([]string, error) {
+			return b.PurgeOlderThan(context.Background(), path, 0, dryRun)
+		},
+		wPurged: []string{backupPath("3.2.13", 1)},
+	}, {
+		policy:  "count with min keep",
+		minKeep: 3,
+		purge: func(b Backend, dryRun bool) ([]string, error) {
+			return b.Purge(context.Background(), path, 1, dryRun)
+		},
+		wPurged: []string{backupPath("3.2.13", 1)},
+	}}
+
+	for name, newBackend := range backends {
+		for _, tt := range tests {
+			b, cleanup := newBackend(t, tt.minKeep)
+			seed(t, b)
+
+			dryRun, err := tt.purge(b, true)
+			if err != nil {
+				t.Fatalf("%s/%s: %v", name, tt.policy, err)
+			}
+			purged, err := tt.purge(b, false)
+			if err != nil {
+				t.Fatalf("%s/%s: %v", name, tt.policy, err)
+			}
+			sort.Strings(dryRun)
+			sort.Strings(purged)
+			if !reflect.DeepEqual(dryRun, tt.wPurged) {
+				t.Errorf("%s/%s: expect dry run to purge %v, get=%v", name, tt.policy, tt.wPurged, dryRun)
+			}
+			if !reflect.DeepEqual(purged, tt.wPurged) {
+				t.Errorf("%s/%s: expect purged=%v, get=%v", name, tt.policy, tt.wPurged, purged)
+			}
+			if n, err := b.Total(context.Background(), path); err != nil || n != 4-len(tt.wPurged) {
+				t.Errorf("%s/%s: expect %d backups left, get=%d (err=%v)", name, tt.policy, 4-len(tt.wPurged), n, err)
+			}
+			cleanup()
+		}
+	}
+}
+
+func TestBackendPurgeBatch(t *testing.T) {
+	const path = "cluster-a/etcd.backup"
+	backends := map[string]func(t *testing.T) (Backend, func()){
+		"memory": func(t *testing.T) (Backend, func()) {
+			return NewMemoryBackend(), func() {}
+		},
+		"fs": func(t *testing.T) (Backend, func()) {
+			root, err := ioutil.TempDir("", "fs-backend")
+			if err != nil {
+				t.Fatal(err)
+			}
+			return NewFSBackend(root), func() { os.RemoveAll(root) }
+		},
+	}
+	for name, newBackend := range backends {
+		b, cleanup := newBackend(t)
+		backupPath := func(rev int64) string {
+			return path + "_" + util.MakeBackupName("3.2.13", rev)
+		}
+		for rev := int64(0); rev < 10; rev++ {
+			if _, err := b.Write(context.Background(), backupPath(rev), strings.NewReader("backup")); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// Keeping the latest backup leaves 9 stale ones, purged in batches of 3.
+		for i, wRemaining := range []int{6, 3, 0} {
+			deleted, remaining, err := b.PurgeBatch(context.Background(), path, 1, 3)
+			if err != nil {
+				t.Fatalf("%s #%d: %v", name, i, err)
+			}
+			if deleted != 3 || remaining != wRemaining {
+				t.Errorf("%s #%d: expect 3 deleted and %d remaining, get %d and %d", name, i, wRemaining, deleted, remaining)
+			}
+		}
+		if latest, err := b.Latest(context.Background(), path); err != nil || latest != backupPath(9) {
+			t.Errorf("%s: expect latest backup %s kept, get=%s (err=%v)", name, backupPath(9), latest, err)
+		}
+		if n, err := b.Total(context.Background(), path); err != nil || n != 1 {
+			t.Errorf("%s: expect 1 backup left, get=%d (err=%v)", name, n, err)
+		}
+		cleanup()
+	}
+}
+
+func TestABSConfigValidate(t *testing.T) {
+	client := &storage.BlobStorageClient{}
+	key := bytes.Repeat([]byte{1}, util.EncryptionKeySize)
+	tests := []struct {
+		name  string
+		cfg   ABSConfig
+		valid bool
+	}{
+		{name: "defaults", cfg: ABSConfig{Client: client}, valid: true},
+		{name: "all options", cfg: ABSConfig{Client: client, Compress: true, EncryptionKey: key, ClusterName: "prod", Timeout: time.Minute,
+			BlockSize: 1024 * 1024, BlockParallelism: 8, MinKeep: 3, PurgeWorkers: 4, CreateContainers: true, DefaultTier: util.AccessTierCool, ContainerAccess: storage.ContainerAccessTypeBlob, VerifyChecksums: true}, valid: true},
+		{name: "dedup", cfg: ABSConfig{Client: client, ClusterName: "prod", Dedup: true}, valid: true},
+		{name: "dedup creating containers", cfg: ABSConfig{Client: client, Dedup: true, CreateContainers: true, ContainerAccess: storage.ContainerAccessTypeBlob}, valid: true},
+		{name: "no client", cfg: ABSConfig{}},
+		{name: "short encryption key", cfg: ABSConfig{Client: client, EncryptionKey: key[:16]}},
+		{name: "invalid cluster name", cfg: ABSConfig{Client: client, ClusterName: "prod_a"}},
+		{name: "negative timeout", cfg: ABSConfig{Client: client, Timeout: -time.Second}},
+		{name: "negative block size", cfg: ABSConfig{Client: client, BlockSize: -1}},
+		{name: "too large block size", cfg: ABSConfig{Client: client, BlockSize: writer.AzureBlobBlockChunkLimitInBytes + 1}},
+		{name: "negative block parallelism", cfg: ABSConfig{Client: client, BlockParallelism: -1}},
+		{name: "negative min keep", cfg: ABSConfig{Client: client, MinKeep: -1}},
+		{name: "negative purge workers", cfg: ABSConfig{Client: client, PurgeWorkers: -1}},
+		{name: "invalid default tier", cfg: ABSConfig{Client: client, DefaultTier: "Frozen"}},
+		{name: "access without creating containers", cfg: ABSConfig{Client: client, ContainerAccess: storage.ContainerAccessTypeBlob}},
+		{name: "compressed dedup", cfg: ABSConfig{Client: client, Dedup: true, Compress: true}},
+		{name: "encrypted dedup", cfg: ABSConfig{Client: client, Dedup: true, EncryptionKey: key}},
+		{name: "dedup with block size", cfg: ABSConfig{Client: client, Dedup: true, BlockSize: 1024}},
+		{name: "dedup with block parallelism", cfg: ABSConfig{Client: client, Dedup: true, BlockParallelism: 2}},
+		{name: "dedup with default tier", cfg: ABSConfig{Client: client, Dedup: true, DefaultTier: util.AccessTierArchive}},
+	}
+	for _, tt := range tests {
+		b, err := NewABS(tt.cfg)
+		if tt.valid && (err != nil || b == nil) {
+			t.Errorf("%s: expect a valid config, get err=%v", tt.name, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("%s: expect an invalid config", tt.name)
+		}
+	}
+}
+
+// TestBackends runs the same save, open and purge scenario against every backend given a storage to run on.
+func TestBackends(t *testing.T) {
+	backends := map[string]func(t *testing.T) (Backend, string, func()){
+		"memory": func(t *testing.T) (Backend, string, func()) {
+			return NewMemoryBackend(), "cluster-a", func() {}
+		},
+		"fs": func(t *testing.T) (Backend, string, func()) {
+			root, err := ioutil.TempDir("", "fs-backend")
+			if err != nil {
+				t.Fatal(err)
+			}
+			return NewFSBackend(root), "cluster-a", func() { os.RemoveAll(root) }
+		},
+		"abs": newTestABSBackend,
+	}
+	for name, newBackend := range backends {
+		t.Run(name, func(t *testing.T) {
+			b, prefix, cleanup := newBackend(t)
+			defer cleanup()
+
+			path := prefix + "/etcd.backup"
+			for _, rev := range []int64{1, 2} {
+				if _, err := b.Write(context.Background(), path+"_"+util.MakeBackupName("3.2.13", rev), strings.NewReader("backup")); err != nil {
+					t.Fatal(err)
+				}
+			}
+			rc, err := b.Open(context.Background(), path+"_"+util.MakeBackupName("3.2.13", 2))
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := ioutil.ReadAll(rc)
+			rc.Close()
+			if err != nil || string(data) != "backup" {
+				t.Errorf("expect content=%q, get=%q (err=%v)", "backup", data, err)
+			}
+
+			if _, err = b.Purge(context.Background(), path, 1, false); err != nil {
+				t.Fatal(err)
+			}
+			if rc, err = b.Open(context.Background(), path+"_"+util.MakeBackupName("3.2.13", 1)); err == nil {
+				rc.Close()
+				t.Error("expect the stale backup to be purged")
+			}
+		})
+	}
+}
+
+// newTestABSBackend returns an ABS backend saving to a new container, skipping the test without a storage account.
+func newTestABSBackend(t *testing.T) (Backend, string, func()) {
+	account, key := os.Getenv("TEST_AZURE_STORAGE_ACCOUNT"), os.Getenv("TEST_AZURE_STORAGE_KEY")
+	if len(account) == 0 || len(key) == 0 {
+		t.Skip("TEST_AZURE_STORAGE_ACCOUNT and TEST_AZURE_STORAGE_KEY are not set")
+	}
+	cli, err := storage.NewBasicClient(account, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	abs := cli.GetBlobService()
+	container := "etcd-operator-test-" + strings.ToLower(uuid.New())[:8]
+	containerRef := abs.GetContainerReference(container)
+	if err := containerRef.Create(&storage.CreateContainerOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	return NewABSBackend(&abs, false, nil, "", 0, 0), container, func() { containerRef.Delete(&storage.DeleteContainerOptions{}) }
+}
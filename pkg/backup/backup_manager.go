@@ -18,11 +18,14 @@ import (
 	"context"
 	"crypto/tls"
 	"fmt"
+	"time"
 
+	"github.com/coreos/etcd-operator/pkg/backup/util"
 	"github.com/coreos/etcd-operator/pkg/backup/writer"
 	"github.com/coreos/etcd-operator/pkg/util/constants"
 
 	"github.com/coreos/etcd/clientv3"
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"k8s.io/client-go/kubernetes"
 )
@@ -36,6 +39,42 @@ type BackupManager struct {
 	etcdTLSConfig *tls.Config
 
 	bw writer.Writer
+
+	// SkipDuplicates makes SaveSnap skip saving a snapshot if a backup of the same etcd version and
+	// revision is already saved under the backup path. It only applies when the revision is appended to the path.
+	SkipDuplicates bool
+	// RateLimitBytesPerSec limits how fast the snapshot is read from etcd while it is saved.
+	// Zero means unlimited.
+	RateLimitBytesPerSec int64
+	// ClusterName is embedded in the names of backups saved with revision appended if set,
+	// to tell apart the backups of different etcd clusters saved under the same path.
+	// It must contain neither "_" nor "/".
+	ClusterName string
+	// PreSaveTransforms process the snapshot in order before it is handed to the backup writer.
+	PreSaveTransforms []util.SnapshotTransform
+	// RetryBudget caps the number of retries of the storage operations of a save, all operations taken together.
+	// Once it is used up, failed operations are no longer retried. Zero means no cap.
+	RetryBudget int
+	// Tags are saved along with each backup by the writers supporting them.
+	Tags map[string]string
+	// Quota refuses a save before uploading anything if the backups of its backup path would exceed Quota bytes
+	// in total, by the writers supporting it. See util.WithQuota. Zero means no quota. With a quota, the length
+	// of the snapshot has to be known upfront: snapshots of unknown length, such as the ones streamed by etcd
+	// or processed by PreSaveTransforms, are spooled to a temporary file to measure them.
+	Quota int64
+	// TimestampNames makes backups saved with revision appended embed their save time in their names,
+	// so that their names sort chronologically. See util.MakeTimestampedBackupName.
+	// Since each save then has a different name, SkipDuplicates never finds a duplicate.
+	TimestampNames bool
+	// VerifyRevision makes SaveSnapFrom find the revision of the snapshot by walking its bolt database, spooled
+	// to a temporary file, so that backup names never lie about their revision. Backups are named after that
+	// revision rather than the one read from etcd before the snapshot, which a live cluster may have moved past.
+	// Saving a snapshot of a source whose revision is declared by its caller, such as NewFileSnapshotSource,
+	// instead fails with an error whose cause is ErrRevisionMismatch if the revisions differ.
+	// A backup named after another revision than the one read from etcd is logged as a warning to Logger.
+	VerifyRevision bool
+	// Logger logs the warnings of saves. If nil, they are logged to the standard logger.
+	Logger *logrus.Entry
 }
 
 // NewBackupManagerFromWriter creates a BackupManager with backup writer.
@@ -50,49 +89,150 @@ func NewBackupManagerFromWriter(kubecli kubernetes.Interface, bw writer.Writer,
 }
 
 // PurgeBackup used the s3Path as prefix, to purge stale backups more than maxBackups count
-func (bm *BackupManager) PurgeBackup(s3Path string, maxBackups int) error {
-	return bm.bw.Purge(s3Path, maxBackups)
+func (bm *BackupManager) PurgeBackup(ctx context.Context, s3Path string, maxBackups int) error {
+	_, err := bm.bw.Purge(ctx, s3Path, maxBackups, false)
+	return err
+}
+
+// PurgeBackupByVersion used the s3Path as prefix, to purge stale backups more than keepPerVersion count
+// for each etcd version.
+func (bm *BackupManager) PurgeBackupByVersion(ctx context.Context, s3Path string, keepPerVersion int) error {
+	_, err := bm.bw.PurgeByVersion(ctx, s3Path, keepPerVersion, false)
+	return err
+}
+
+// PurgeBackupOlderThan used the s3Path as prefix, to purge backups older than d except the latest one.
+func (bm *BackupManager) PurgeBackupOlderThan(ctx context.Context, s3Path string, d time.Duration) error {
+	_, err := bm.bw.PurgeOlderThan(ctx, s3Path, d, false)
+	return err
 }
 
 // SaveSnap uses backup writer to save etcd snapshot to a specified S3 path
 // and returns backup etcd server's kv store revision and its version.
-// appendRev specify whether we want to append Rev to the s3Path
-func (bm *BackupManager) SaveSnap(s3Path string, appendRev bool) (int64, string, error) {
-	etcdcli, rev, err := bm.etcdClientWithMaxRevision()
+// appendRev specify whether we want to append the etcd version and Rev to the s3Path
+// Taking and writing the snapshot is aborted once ctx is done.
+func (bm *BackupManager) SaveSnap(ctx context.Context, s3Path string, appendRev bool) (int64, string, error) {
+	return bm.SaveSnapWithProgress(ctx, s3Path, appendRev, nil)
+}
+
+// SaveSnapWithProgress is like SaveSnap, but calls progress with the number of snapshot bytes
+// handed to the backup writer so far while the snapshot is saved. progress may be nil.
+func (bm *BackupManager) SaveSnapWithProgress(ctx context.Context, s3Path string, appendRev bool, progress util.ProgressFunc) (int64, string, error) {
+	etcdcli, _, err := bm.etcdClientWithMaxRevision()
 	if err != nil {
 		return 0, "", fmt.Errorf("create etcd client failed: %v", err)
 	}
 	defer etcdcli.Close()
 
-	ctx, cancel := context.WithTimeout(context.Background(), constants.DefaultRequestTimeout)
-	resp, err := etcdcli.Status(ctx, etcdcli.Endpoints()[0])
+	statusCtx, cancel := context.WithTimeout(ctx, constants.DefaultRequestTimeout)
+	resp, err := etcdcli.Status(statusCtx, etcdcli.Endpoints()[0])
 	cancel()
 	if err != nil {
 		return 0, "", fmt.Errorf("failed to retrieve etcd version from the status call: %v", err)
 	}
 
-	ctx, cancel = context.WithTimeout(context.Background(), constants.DefaultSnapshotTimeout)
+	rev, err := bm.SaveSnapFrom(ctx, NewEtcdSnapshotSource(etcdcli), resp.Version, s3Path, appendRev, progress)
+	if err != nil {
+		return 0, "", err
+	}
+	return rev, resp.Version, nil
+}
+
+// SaveSnapFrom uses backup writer to save the etcd snapshot taken from src to a specified S3 path
+// like SaveSnapWithProgress, and returns the kv store revision of the snapshot.
+// etcdVersion is the version of the etcd cluster the snapshot was taken from, appended to the s3Path
+// along with the revision if appendRev is true.
+// With VerifyRevision, the revision of the snapshot found in its bolt database is checked against the revision of src,
+// which differ in two ways depending on src. The revision of a source read from etcd right before the snapshot,
+// such as NewEtcdSnapshotSource, may be behind the snapshot: the backup is named after the revision of the snapshot,
+// which is returned, and the mismatch is logged as a warning. The revision of a source declared by its caller,
+// such as NewFileSnapshotSource, must be the one of the snapshot: a mismatch fails the save with an error
+// whose cause is ErrRevisionMismatch, and nothing is saved.
+func (bm *BackupManager) SaveSnapFrom(ctx context.Context, src SnapshotSource, etcdVersion, s3Path string, appendRev bool, progress util.ProgressFunc) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, constants.DefaultSnapshotTimeout)
 	defer cancel() // Can't cancel() after Snapshot() because that will close the reader.
-	rc, err := etcdcli.Snapshot(ctx)
+	if bm.RetryBudget > 0 {
+		ctx = util.WithRetryBudget(ctx, util.NewRetryBudget(bm.RetryBudget))
+	}
+	if len(bm.Tags) != 0 {
+		ctx = util.WithTags(ctx, bm.Tags)
+	}
+	rc, rev, err := src.Snapshot(ctx)
 	if err != nil {
-		return 0, "", fmt.Errorf("failed to receive snapshot (%v)", err)
+		return 0, err
 	}
 	defer rc.Close()
+	if bm.VerifyRevision {
+		spooled, actual, err := spoolSnapshot(rc)
+		if err != nil {
+			return 0, err
+		}
+		defer spooled.Close()
+		if _, declared := src.(declaredRevisionSource); declared && actual != rev {
+			return 0, errors.Wrapf(ErrRevisionMismatch, "snapshot is at revision %d, not %d", actual, rev)
+		}
+		if actual != rev {
+			bm.logger().WithFields(logrus.Fields{"revision": rev, "snapshotRevision": actual}).
+				Warn("snapshot is not at the revision read from etcd before it was taken: naming the backup after the revision of the snapshot")
+		}
+		rc, rev = spooled, actual
+	}
 
-	_, err = bm.bw.Write(
-		appendRevToPath(appendRev, rev, s3Path),
-		rc)
+	var timestamp time.Time
+	if bm.TimestampNames {
+		timestamp = time.Now()
+	}
+	path := appendRevToPath(appendRev, timestamp, bm.ClusterName, etcdVersion, rev, s3Path)
+	r, err := util.ApplyTransforms(util.NewRateLimitedReader(ctx, rc, bm.RateLimitBytesPerSec), bm.PreSaveTransforms)
 	if err != nil {
-		return 0, "", fmt.Errorf("failed to write snapshot (%v)", err)
+		return 0, err
 	}
-	return rev, resp.Version, nil
+	if bm.Quota > 0 {
+		ctx = util.WithQuota(ctx, bm.Quota)
+		size, known := util.SourceLength(rc)
+		if !known || len(bm.PreSaveTransforms) != 0 {
+			spooled, n, err := spool(r)
+			if err != nil {
+				return 0, err
+			}
+			defer spooled.Close()
+			r, size = spooled, n
+		}
+		r = util.NewSizedReader(util.NewProgressReader(r, progress), size)
+	} else {
+		r = util.NewProgressReader(r, progress)
+	}
+	if bm.SkipDuplicates && appendRev {
+		var skipped bool
+		_, skipped, err = bm.bw.WriteIfAbsent(ctx, path, r)
+		if skipped {
+			logrus.Infof("skipped saving snapshot: backup of etcd version %s at revision %d already exists", etcdVersion, rev)
+		}
+	} else {
+		_, err = bm.bw.Write(ctx, path, r)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to write snapshot (%v)", err)
+	}
+	return rev, nil
 }
 
-func appendRevToPath(appendRev bool, rev int64, path string) string {
+// logger returns Logger, or an entry of the standard logger if it is nil.
+func (bm *BackupManager) logger() *logrus.Entry {
+	if bm.Logger != nil {
+		return bm.Logger
+	}
+	return logrus.WithFields(logrus.Fields{})
+}
+
+func appendRevToPath(appendRev bool, timestamp time.Time, clusterName, ver string, rev int64, path string) string {
 	if !appendRev {
 		return path
 	}
-	return fmt.Sprintf("%s_%016x", path, rev)
+	if !timestamp.IsZero() {
+		return fmt.Sprintf("%s_%s", path, util.MakeTimestampedBackupName(timestamp, clusterName, ver, rev))
+	}
+	return fmt.Sprintf("%s_%s", path, util.MakeClusterBackupName(clusterName, ver, rev))
 }
 
 // etcdClientWithMaxRevision gets the etcd endpoint with the maximum kv store revision
@@ -0,0 +1,178 @@
+// Copyright 2018 The etcd-operator Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/coreos/etcd-operator/pkg/backup/util"
+	"github.com/coreos/etcd-operator/pkg/backup/writer"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ensure dryRunBackend satisfies DryRunBackend interface.
+var _ DryRunBackend = &dryRunBackend{}
+
+// DryRunBackend is a Backend that records the operations it would run instead of modifying the storage.
+type DryRunBackend interface {
+	Backend
+	// Operations returns the saves, opens, purges and deletions intended so far, in order.
+	Operations() []DryRunOperation
+}
+
+// DryRunOperation is an operation recorded by a DryRunBackend.
+type DryRunOperation struct {
+	// Operation names the operation with the "operation" field value of NewLoggingBackend, e.g. "write" or "purge".
+	Operation string
+	// Path is the backup path the operation is run on.
+	Path string
+	// Args are the other arguments of the operation, e.g. "max_backups" for "purge".
+	Args map[string]interface{}
+}
+
+// dryRunBackend records the modifying operations of a Backend instead of running them.
+type dryRunBackend struct {
+	Backend
+	logger *logrus.Entry
+
+	mu  sync.Mutex
+	ops []DryRunOperation
+}
+
+// NewDryRunBackend returns a DryRunBackend of b, e.g. to rehearse a disaster recovery runbook against
+// the actual backups without modifying them. Saves read their content to the end and report it as saved
+// to the given path, without saving anything. Purges and deletions report the backup files b would purge,
+// as if dryRun were true. Opens and the other reads are run on b.
+// Saves, opens, purges and deletions are recorded, and logged at info level to logger unless it is nil.
+func NewDryRunBackend(b Backend, logger *logrus.Entry) DryRunBackend {
+	return &dryRunBackend{Backend: b, logger: logger}
+}
+
+func (db *dryRunBackend) Operations() []DryRunOperation {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return append([]DryRunOperation(nil), db.ops...)
+}
+
+// record records the operation op on path with args.
+func (db *dryRunBackend) record(op, path string, args logrus.Fields) {
+	db.mu.Lock()
+	db.ops = append(db.ops, DryRunOperation{Operation: op, Path: path, Args: args})
+	db.mu.Unlock()
+	if db.logger != nil {
+		db.logger.WithFields(args).WithFields(logrus.Fields{"operation": op, "path": path}).Info("dry run backup operation")
+	}
+}
+
+// drain reads r to the end and describes it as a backup file saved to path.
+func drain(ctx context.Context, path string, r io.Reader) (*writer.SaveResult, error) {
+	start := time.Now()
+	h := sha256.New()
+	size, err := io.Copy(h, r)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return &writer.SaveResult{Path: path, Size: size, SHA256: hex.EncodeToString(h.Sum(nil)), Duration: time.Since(start)}, nil
+}
+
+func (db *dryRunBackend) Write(ctx context.Context, path string, r io.Reader) (int64, error) {
+	res, err := db.WriteWithResult(ctx, path, r)
+	if err != nil {
+		return 0, err
+	}
+	return res.Size, nil
+}
+
+func (db *dryRunBackend) WriteWithResult(ctx context.Context, path string, r io.Reader) (*writer.SaveResult, error) {
+	db.record("write", path, nil)
+	return drain(ctx, path, r)
+}
+
+// WriteIfAbsent never skips the save, since whether a backup of the same revision exists depends on the saves
+// intended before.
+func (db *dryRunBackend) WriteIfAbsent(ctx context.Context, path string, r io.Reader) (int64, bool, error) {
+	db.record("write_if_absent", path, nil)
+	res, err := drain(ctx, path, r)
+	if err != nil {
+		return 0, false, err
+	}
+	return res.Size, false, nil
+}
+
+func (db *dryRunBackend) Purge(ctx context.Context, path string, maxBackups int, dryRun bool) ([]string, error) {
+	db.record("purge", path, logrus.Fields{"max_backups": maxBackups})
+	return db.Backend.Purge(ctx, path, maxBackups, true)
+}
+
+func (db *dryRunBackend) PurgeByVersion(ctx context.Context, path string, keepPerVersion int, dryRun bool) ([]string, error) {
+	db.record("purge_by_version", path, logrus.Fields{"keep_per_version": keepPerVersion})
+	return db.Backend.PurgeByVersion(ctx, path, keepPerVersion, true)
+}
+
+func (db *dryRunBackend) PurgeOlderThan(ctx context.Context, path string, d time.Duration, dryRun bool) ([]string, error) {
+	db.record("purge_older_than", path, logrus.Fields{"max_age": d})
+	return db.Backend.PurgeOlderThan(ctx, path, d, true)
+}
+
+func (db *dryRunBackend) PurgeToSize(ctx context.Context, path string, maxBytes int64, dryRun bool) ([]string, error) {
+	db.record("purge_to_size", path, logrus.Fields{"max_bytes": maxBytes})
+	return db.Backend.PurgeToSize(ctx, path, maxBytes, true)
+}
+
+// PurgeBatch reports the stale backup files b would purge with Purge as deleted, up to maxDelete.
+func (db *dryRunBackend) PurgeBatch(ctx context.Context, path string, keep, maxDelete int) (int, int, error) {
+	db.record("purge_batch", path, logrus.Fields{"keep": keep, "max_delete": maxDelete})
+	if err := util.ValidatePurgeBatch(keep, maxDelete); err != nil {
+		return 0, 0, err
+	}
+	stale, err := db.Backend.Purge(ctx, path, keep, true)
+	if err != nil {
+		return 0, 0, err
+	}
+	deleted := len(stale)
+	if deleted > maxDelete {
+		deleted = maxDelete
+	}
+	return deleted, len(stale) - deleted, nil
+}
+
+func (db *dryRunBackend) DeleteAll(ctx context.Context, path string, dryRun bool) ([]string, error) {
+	db.record("delete_all", path, nil)
+	return db.Backend.DeleteAll(ctx, path, true)
+}
+
+func (db *dryRunBackend) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	db.record("open", path, nil)
+	return db.Backend.Open(ctx, path)
+}
+
+func (db *dryRunBackend) OpenRange(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
+	db.record("open_range", path, logrus.Fields{"offset": offset})
+	return db.Backend.OpenRange(ctx, path, offset)
+}
+
+func (db *dryRunBackend) OpenLimited(ctx context.Context, path string, maxBytes int64) (io.ReadCloser, error) {
+	db.record("open_limited", path, logrus.Fields{"max_bytes": maxBytes})
+	return db.Backend.OpenLimited(ctx, path, maxBytes)
+}
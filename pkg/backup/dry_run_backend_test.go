@@ -0,0 +1,89 @@
+// Copyright 2018 The etcd-operator Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backup
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/coreos/etcd-operator/pkg/backup/util"
+)
+
+func TestDryRunBackend(t *testing.T) {
+	const path = "cluster-a/etcd.backup"
+	backupPath := func(rev int64) string {
+		return path + "_" + util.MakeBackupName("3.2.13", rev)
+	}
+	mb := NewMemoryBackend()
+	for rev := int64(1); rev <= 3; rev++ {
+		if _, err := mb.Write(context.Background(), backupPath(rev), bytes.NewReader([]byte("backup"))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	logger, hook := newTestLogger()
+	db := NewDryRunBackend(mb, logger)
+	ctx := context.Background()
+	res, err := db.WriteWithResult(ctx, backupPath(4), bytes.NewReader([]byte("new backup")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Path != backupPath(4) || res.Size != 10 || len(res.SHA256) == 0 {
+		t.Errorf("expect a plausible save result of %s, get=%+v", backupPath(4), res)
+	}
+	rc, err := db.Open(ctx, backupPath(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := ioutil.ReadAll(rc); err != nil || string(data) != "backup" {
+		t.Errorf("expect to read the backup of the wrapped backend, get=%q (err=%v)", data, err)
+	}
+	rc.Close()
+	purged, err := db.Purge(ctx, path, 1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(purged) != 2 {
+		t.Errorf("expect 2 backups reported as purged, get=%v", purged)
+	}
+	deleted, err := db.DeleteAll(ctx, path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 3 {
+		t.Errorf("expect 3 backups reported as deleted, get=%v", deleted)
+	}
+
+	want := []DryRunOperation{
+		{Operation: "write", Path: backupPath(4)},
+		{Operation: "open", Path: backupPath(3)},
+		{Operation: "purge", Path: path, Args: map[string]interface{}{"max_backups": 1}},
+		{Operation: "delete_all", Path: path},
+	}
+	if got := db.Operations(); !reflect.DeepEqual(got, want) {
+		t.Errorf("expect operations=%+v, get=%+v", want, got)
+	}
+	if len(hook.entries) != len(want) {
+		t.Errorf("expect %d log entries, get=%d", len(want), len(hook.entries))
+	}
+
+	// Nothing was saved or purged.
+	if total, err := mb.Total(ctx, path); err != nil || total != 3 {
+		t.Errorf("expect the 3 backups left as they are, get=%d (err=%v)", total, err)
+	}
+}
@@ -0,0 +1,209 @@
+// Copyright 2018 The etcd-operator Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backup
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/coreos/etcd-operator/pkg/backup/writer"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ensure loggingBackend satisfies Backend interface.
+var _ Backend = &loggingBackend{}
+
+// loggingBackend logs the operations of a Backend.
+type loggingBackend struct {
+	Backend
+	logger *logrus.Entry
+}
+
+// NewLoggingBackend returns a Backend of b logging its saves, purges, opens, verifications, latest backup lookups
+// and health checks to logger with the "operation", "path" and "duration" fields, along with "bytes" for saves
+// and "purged" for purges.
+// Starts are logged at debug level, failures at error level, and finishes at info level for operations
+// modifying the storage and at debug level otherwise. If logger is nil, b is returned as is.
+func NewLoggingBackend(b Backend, logger *logrus.Entry) Backend {
+	if logger == nil {
+		return b
+	}
+	return &loggingBackend{Backend: b, logger: logger}
+}
+
+// observe logs the start and outcome of the operation op on path run by fn,
+// which returns the fields describing its outcome.
+func (lb *loggingBackend) observe(op, path string, modifies bool, fn func() (logrus.Fields, error)) error {
+	l := lb.logger.WithFields(logrus.Fields{"operation": op, "path": path})
+	l.Debug("backup operation started")
+	start := time.Now()
+	fields, err := fn()
+	l = l.WithField("duration", time.Since(start))
+	if err != nil {
+		l.WithError(err).Error("backup operation failed")
+		return err
+	}
+	l = l.WithFields(fields)
+	if modifies {
+		l.Info("backup operation finished")
+	} else {
+		l.Debug("backup operation finished")
+	}
+	return nil
+}
+
+func (lb *loggingBackend) Write(ctx context.Context, path string, r io.Reader) (int64, error) {
+	var size int64
+	err := lb.observe("write", path, true, func() (logrus.Fields, error) {
+		var err error
+		size, err = lb.Backend.Write(ctx, path, r)
+		return logrus.Fields{"bytes": size}, err
+	})
+	return size, err
+}
+
+func (lb *loggingBackend) WriteWithResult(ctx context.Context, path string, r io.Reader) (*writer.SaveResult, error) {
+	var res *writer.SaveResult
+	err := lb.observe("write", path, true, func() (logrus.Fields, error) {
+		var err error
+		res, err = lb.Backend.WriteWithResult(ctx, path, r)
+		if err != nil {
+			return nil, err
+		}
+		return logrus.Fields{"bytes": res.Size, "saved_path": res.Path}, nil
+	})
+	return res, err
+}
+
+func (lb *loggingBackend) WriteIfAbsent(ctx context.Context, path string, r io.Reader) (int64, bool, error) {
+	var (
+		size    int64
+		skipped bool
+	)
+	err := lb.observe("write_if_absent", path, true, func() (logrus.Fields, error) {
+		var err error
+		size, skipped, err = lb.Backend.WriteIfAbsent(ctx, path, r)
+		return logrus.Fields{"bytes": size, "skipped": skipped}, err
+	})
+	return size, skipped, err
+}
+
+// purge logs the purge operation op on path run by fn.
+func (lb *loggingBackend) purge(op, path string, dryRun bool, fn func() ([]string, error)) ([]string, error) {
+	var purged []string
+	err := lb.observe(op, path, !dryRun, func() (logrus.Fields, error) {
+		var err error
+		purged, err = fn()
+		return logrus.Fields{"purged": len(purged), "dry_run": dryRun}, err
+	})
+	return purged, err
+}
+
+func (lb *loggingBackend) Purge(ctx context.Context, path string, maxBackups int, dryRun bool) ([]string, error) {
+	return lb.purge("purge", path, dryRun, func() ([]string, error) {
+		return lb.Backend.Purge(ctx, path, maxBackups, dryRun)
+	})
+}
+
+func (lb *loggingBackend) PurgeByVersion(ctx context.Context, path string, keepPerVersion int, dryRun bool) ([]string, error) {
+	return lb.purge("purge_by_version", path, dryRun, func() ([]string, error) {
+		return lb.Backend.PurgeByVersion(ctx, path, keepPerVersion, dryRun)
+	})
+}
+
+func (lb *loggingBackend) PurgeOlderThan(ctx context.Context, path string, d time.Duration, dryRun bool) ([]string, error) {
+	return lb.purge("purge_older_than", path, dryRun, func() ([]string, error) {
+		return lb.Backend.PurgeOlderThan(ctx, path, d, dryRun)
+	})
+}
+
+func (lb *loggingBackend) PurgeToSize(ctx context.Context, path string, maxBytes int64, dryRun bool) ([]string, error) {
+	return lb.purge("purge_to_size", path, dryRun, func() ([]string, error) {
+		return lb.Backend.PurgeToSize(ctx, path, maxBytes, dryRun)
+	})
+}
+
+func (lb *loggingBackend) PurgeBatch(ctx context.Context, path string, keep, maxDelete int) (int, int, error) {
+	var deleted, remaining int
+	err := lb.observe("purge_batch", path, true, func() (logrus.Fields, error) {
+		var err error
+		deleted, remaining, err = lb.Backend.PurgeBatch(ctx, path, keep, maxDelete)
+		return logrus.Fields{"purged": deleted, "remaining": remaining}, err
+	})
+	return deleted, remaining, err
+}
+
+func (lb *loggingBackend) DeleteAll(ctx context.Context, path string, dryRun bool) ([]string, error) {
+	return lb.purge("delete_all", path, dryRun, func() ([]string, error) {
+		return lb.Backend.DeleteAll(ctx, path, dryRun)
+	})
+}
+
+// open logs the open operation op on path run by fn.
+func (lb *loggingBackend) open(op, path string, fn func() (io.ReadCloser, error)) (io.ReadCloser, error) {
+	var rc io.ReadCloser
+	err := lb.observe(op, path, false, func() (logrus.Fields, error) {
+		var err error
+		rc, err = fn()
+		return nil, err
+	})
+	return rc, err
+}
+
+func (lb *loggingBackend) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	return lb.open("open", path, func() (io.ReadCloser, error) {
+		return lb.Backend.Open(ctx, path)
+	})
+}
+
+func (lb *loggingBackend) OpenRange(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
+	return lb.open("open_range", path, func() (io.ReadCloser, error) {
+		return lb.Backend.OpenRange(ctx, path, offset)
+	})
+}
+
+func (lb *loggingBackend) OpenLimited(ctx context.Context, path string, maxBytes int64) (io.ReadCloser, error) {
+	return lb.open("open_limited", path, func() (io.ReadCloser, error) {
+		return lb.Backend.OpenLimited(ctx, path, maxBytes)
+	})
+}
+
+func (lb *loggingBackend) Verify(ctx context.Context, path string) (bool, error) {
+	var valid bool
+	err := lb.observe("verify", path, false, func() (logrus.Fields, error) {
+		var err error
+		valid, err = lb.Backend.Verify(ctx, path)
+		return logrus.Fields{"valid": valid}, err
+	})
+	return valid, err
+}
+
+func (lb *loggingBackend) Latest(ctx context.Context, path string) (string, error) {
+	var latest string
+	err := lb.observe("latest", path, false, func() (logrus.Fields, error) {
+		var err error
+		latest, err = lb.Backend.Latest(ctx, path)
+		return logrus.Fields{"latest": latest}, err
+	})
+	return latest, err
+}
+
+func (lb *loggingBackend) HealthCheck(ctx context.Context, path string) error {
+	return lb.observe("health_check", path, false, func() (logrus.Fields, error) {
+		return nil, lb.Backend.HealthCheck(ctx, path)
+	})
+}
@@ -0,0 +1,93 @@
+// Copyright 2018 The etcd-operator Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backup
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+// recordingHook records the log entries of all levels.
+type recordingHook struct {
+	entries []*logrus.Entry
+}
+
+func (h *recordingHook) Levels() []logrus.Level { return logrus.AllLevels }
+
+func (h *recordingHook) Fire(e *logrus.Entry) error {
+	h.entries = append(h.entries, e)
+	return nil
+}
+
+func newTestLogger() (*logrus.Entry, *recordingHook) {
+	logger := logrus.New()
+	logger.Level = logrus.DebugLevel
+	hook := &recordingHook{}
+	logger.AddHook(hook)
+	return logrus.NewEntry(logger), hook
+}
+
+func TestLoggingBackendWrite(t *testing.T) {
+	logger, hook := newTestLogger()
+	b := NewLoggingBackend(NewMemoryBackend(), logger)
+	if _, err := b.Write(context.Background(), "cluster-a/etcd.backup", bytes.NewReader([]byte("backup"))); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(hook.entries) != 2 {
+		t.Fatalf("expect 2 log entries, get=%d", len(hook.entries))
+	}
+	for _, e := range hook.entries {
+		if e.Data["operation"] != "write" || e.Data["path"] != "cluster-a/etcd.backup" {
+			t.Errorf("expect entry of the write to cluster-a/etcd.backup, get=%v", e.Data)
+		}
+	}
+	if started := hook.entries[0]; started.Level != logrus.DebugLevel {
+		t.Errorf("expect start logged at debug level, get=%v", started.Level)
+	}
+	finished := hook.entries[1]
+	if finished.Level != logrus.InfoLevel {
+		t.Errorf("expect finish logged at info level, get=%v", finished.Level)
+	}
+	if finished.Data["bytes"] != int64(6) {
+		t.Errorf("expect bytes=6, get=%v", finished.Data["bytes"])
+	}
+	if _, ok := finished.Data["duration"]; !ok {
+		t.Errorf("expect duration field, get=%v", finished.Data)
+	}
+}
+
+func TestLoggingBackendError(t *testing.T) {
+	logger, hook := newTestLogger()
+	b := NewLoggingBackend(NewMemoryBackend(), logger)
+	if _, err := b.Purge(context.Background(), "cluster-a/etcd.backup", 0, false); err == nil {
+		t.Fatal("expect purge error")
+	}
+
+	last := hook.entries[len(hook.entries)-1]
+	if last.Level != logrus.ErrorLevel || last.Data["operation"] != "purge" || last.Data["error"] == nil {
+		t.Errorf("expect failed purge logged at error level with the error, get level=%v fields=%v", last.Level, last.Data)
+	}
+}
+
+func TestLoggingBackendNoLogger(t *testing.T) {
+	b := NewMemoryBackend()
+	if NewLoggingBackend(b, nil) != b {
+		t.Error("expect backend to be returned as is without a logger")
+	}
+}
@@ -0,0 +1,376 @@
+// Copyright 2018 The etcd-operator Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/etcd-operator/pkg/backup/util"
+	"github.com/coreos/etcd-operator/pkg/backup/writer"
+)
+
+// ensure memoryBackend satisfies Backend interface.
+var _ Backend = &memoryBackend{}
+
+// memoryFile is a backup file kept in memory.
+type memoryFile struct {
+	data     []byte
+	sha256   [sha256.Size]byte
+	modified time.Time
+}
+
+// memoryBackend keeps backup files in memory by path.
+type memoryBackend struct {
+	mu    sync.Mutex
+	files map[string]memoryFile
+	// minKeep is the number of latest backup files purges keep whatever their policy.
+	minKeep int
+}
+
+// NewMemoryBackend creates a Backend keeping backup files in memory, e.g. to test code depending on a Backend
+// without a real storage. Paths are grouped like paths of local files: the backups saved with revision appended
+// to a path are the ones named "<path>_<name>" where name has no "/".
+// It is safe for concurrent use.
+func NewMemoryBackend() Backend {
+	return NewMemoryBackendMinKeep(util.DefaultMinKeep)
+}
+
+// NewMemoryBackendMinKeep creates a Backend like NewMemoryBackend, whose purges keep the latest minKeep backup files
+// whatever their policy, or util.DefaultMinKeep if minKeep is not positive.
+func NewMemoryBackendMinKeep(minKeep int) Backend {
+	if minKeep <= 0 {
+		minKeep = util.DefaultMinKeep
+	}
+	return &memoryBackend{files: map[string]memoryFile{}, minKeep: minKeep}
+}
+
+// Write saves the content of r on path, replacing any backup file saved on path.
+func (mb *memoryBackend) Write(ctx context.Context, path string, r io.Reader) (int64, error) {
+	res, err := mb.WriteWithResult(ctx, path, r)
+	if err != nil {
+		return 0, err
+	}
+	return res.Size, nil
+}
+
+func (mb *memoryBackend) WriteWithResult(ctx context.Context, path string, r io.Reader) (*writer.SaveResult, error) {
+	start := time.Now()
+	data, err := ioutil.ReadAll(util.NewContextReader(ctx, r))
+	if err != nil {
+		return nil, err
+	}
+	f := memoryFile{data: data, sha256: sha256.Sum256(data), modified: time.Now()}
+
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	mb.files[path] = f
+	return &writer.SaveResult{Path: path, Size: int64(len(data)), SHA256: hex.EncodeToString(f.sha256[:]), Duration: time.Since(start)}, nil
+}
+
+// WriteIfAbsent saves the content of r on path unless a backup of the same etcd version and revision is already saved.
+func (mb *memoryBackend) WriteIfAbsent(ctx context.Context, path string, r io.Reader) (int64, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, false, err
+	}
+	if f, ok := util.FindDuplicate(mb.list(path), path); ok {
+		return f.Size, true, nil
+	}
+	size, err := mb.Write(ctx, path, r)
+	return size, false, err
+}
+
+func (mb *memoryBackend) Purge(ctx context.Context, path string, maxBackups int, dryRun bool) ([]string, error) {
+	if maxBackups <= 0 {
+		return nil, util.ErrInvalidMaxBackups
+	}
+	return mb.purge(ctx, path, dryRun, util.KeepLatest(util.PurgeByCount(maxBackups), mb.minKeep))
+}
+
+func (mb *memoryBackend) PurgeBatch(ctx context.Context, path string, keep, maxDelete int) (int, int, error) {
+	if err := util.ValidatePurgeBatch(keep, maxDelete); err != nil {
+		return 0, 0, err
+	}
+	var remaining int
+	purged, err := mb.purge(ctx, path, false, func(files []util.BackupFile) []util.BackupFile {
+		var batch []util.BackupFile
+		batch, remaining = util.BatchBackupFiles(util.KeepLatest(util.PurgeByCount(keep), mb.minKeep)(files), maxDelete)
+		return batch
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return len(purged), remaining, nil
+}
+
+func (mb *memoryBackend) PurgeByVersion(ctx context.Context, path string, keepPerVersion int, dryRun bool) ([]string, error) {
+	if keepPerVersion <= 0 {
+		return nil, util.ErrInvalidMaxBackups
+	}
+	return mb.purge(ctx, path, dryRun, util.KeepLatest(util.PurgeByVersion(keepPerVersion), mb.minKeep))
+}
+
+func (mb *memoryBackend) PurgeOlderThan(ctx context.Context, path string, d time.Duration, dryRun bool) ([]string, error) {
+	return mb.purge(ctx, path, dryRun, util.KeepLatest(util.PurgeOlderThan(d), mb.minKeep))
+}
+
+func (mb *memoryBackend) PurgeToSize(ctx context.Context, path string, maxBytes int64, dryRun bool) ([]string, error) {
+	return mb.purge(ctx, path, dryRun, util.KeepLatest(util.PurgeToSize(maxBytes), mb.minKeep))
+}
+
+func (mb *memoryBackend) DeleteAll(ctx context.Context, path string, dryRun bool) ([]string, error) {
+	return mb.purge(ctx, path, dryRun, func(files []util.BackupFile) []util.BackupFile {
+		return files
+	})
+}
+
+// purge deletes the backup files saved with revision appended to path chosen by policy, unless dryRun is true,
+// and returns their paths.
+func (mb *memoryBackend) purge(ctx context.Context, path string, dryRun bool, policy util.PurgePolicy) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	stale := policy(mb.listBackupFiles(path))
+	paths := make([]string, 0, len(stale))
+	for _, f := range stale {
+		paths = append(paths, f.Name)
+	}
+	if dryRun {
+		return paths, nil
+	}
+
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	for _, p := range paths {
+		delete(mb.files, p)
+	}
+	return paths, nil
+}
+
+// Open opens the backup file on path for reading.
+// A missing backup file is reported with an error satisfying os.IsNotExist, like for local files.
+func (mb *memoryBackend) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f, ok := mb.get(path)
+	if !ok {
+		return nil, notExistError(path)
+	}
+	return util.NewContextReadCloser(ctx, ioutil.NopCloser(bytes.NewReader(f.data))), nil
+}
+
+func (mb *memoryBackend) OpenRange(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if offset < 0 {
+		return nil, fmt.Errorf("invalid offset: %d", offset)
+	}
+	f, ok := mb.get(path)
+	if !ok {
+		return nil, notExistError(path)
+	}
+	if offset > int64(len(f.data)) {
+		offset = int64(len(f.data))
+	}
+	return ioutil.NopCloser(bytes.NewReader(f.data[offset:])), nil
+}
+
+func (mb *memoryBackend) Exists(ctx context.Context, path string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	_, ok := mb.get(path)
+	return ok, nil
+}
+
+func (mb *memoryBackend) OpenLimited(ctx context.Context, path string, maxBytes int64) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f, ok := mb.get(path)
+	if !ok {
+		return nil, notExistError(path)
+	}
+	if int64(len(f.data)) > maxBytes {
+		return nil, util.ErrBackupTooLarge
+	}
+	return mb.Open(ctx, path)
+}
+
+// Verify compares the checksum of the backup file on path with the one computed when it was saved.
+func (mb *memoryBackend) Verify(ctx context.Context, path string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	f, ok := mb.get(path)
+	if !ok {
+		return false, notExistError(path)
+	}
+	return sha256.Sum256(f.data) == f.sha256, nil
+}
+
+// Checksum returns the checksum computed when the backup file on path was saved.
+func (mb *memoryBackend) Checksum(ctx context.Context, path string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	f, ok := mb.get(path)
+	if !ok {
+		return "", notExistError(path)
+	}
+	return hex.EncodeToString(f.sha256[:]), nil
+}
+
+func (mb *memoryBackend) Latest(ctx context.Context, path string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	name := util.GetLatestBackupNameByDate(mb.listBackupFiles(path))
+	if len(name) == 0 {
+		return "", util.ErrNoBackups
+	}
+	return name, nil
+}
+
+func (mb *memoryBackend) NthLatest(ctx context.Context, path string, n int) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	f, ok := util.GetNthLatestBackupFileByDate(mb.listBackupFiles(path), n)
+	if !ok {
+		return "", util.ErrNoBackups
+	}
+	return f.Name, nil
+}
+
+func (mb *memoryBackend) LatestOfCluster(ctx context.Context, path, clusterName string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	name := util.GetLatestBackupNameByDate(util.BackupFilesOfCluster(mb.listBackupFiles(path), clusterName))
+	if len(name) == 0 {
+		return "", util.ErrNoBackups
+	}
+	return name, nil
+}
+
+func (mb *memoryBackend) ByRevision(ctx context.Context, path string, rev uint64) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	name := util.GetBackupNameByRevision(mb.listBackupFiles(path), rev)
+	if len(name) == 0 {
+		return "", util.ErrNoBackups
+	}
+	return name, nil
+}
+
+func (mb *memoryBackend) ListPage(ctx context.Context, path string, sortDesc bool, limit, offset int) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	paths := []string{}
+	for _, f := range util.PageBackupFiles(mb.listBackupFiles(path), sortDesc, limit, offset) {
+		paths = append(paths, f.Name)
+	}
+	return paths, nil
+}
+
+func (mb *memoryBackend) WalkBackups(ctx context.Context, path string, fn func(util.BackupInfo) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return util.WalkBackupFiles(mb.listBackupFiles(path), fn)
+}
+
+func (mb *memoryBackend) Total(ctx context.Context, path string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return len(mb.listBackupFiles(path)), nil
+}
+
+func (mb *memoryBackend) TotalBytes(ctx context.Context, path string) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return util.TotalSize(mb.listBackupFiles(path)), nil
+}
+
+func (mb *memoryBackend) ListVersions(ctx context.Context, path string) (map[string]int, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return util.CountBackupsByVersion(mb.listBackupFiles(path)), nil
+}
+
+// LastBackupTime returns when the latest backup file was saved.
+func (mb *memoryBackend) LastBackupTime(ctx context.Context, path string) (time.Time, error) {
+	if err := ctx.Err(); err != nil {
+		return time.Time{}, err
+	}
+	latest, ok := util.GetLatestBackupFileByDate(mb.listBackupFiles(path))
+	if !ok {
+		return time.Time{}, util.ErrNoBackups
+	}
+	return latest.LastModified, nil
+}
+
+// HealthCheck always succeeds since the memory is always reachable.
+func (mb *memoryBackend) HealthCheck(ctx context.Context, path string) error {
+	return ctx.Err()
+}
+
+func (mb *memoryBackend) get(path string) (memoryFile, bool) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	f, ok := mb.files[path]
+	return f, ok
+}
+
+// listBackupFiles lists the backup files saved with revision appended to path.
+func (mb *memoryBackend) listBackupFiles(path string) []util.BackupFile {
+	return mb.list(path + "_")
+}
+
+// list lists the backup files whose path starts with prefix, not followed by any "/",
+// like local files starting with prefix in the same directory.
+func (mb *memoryBackend) list(prefix string) []util.BackupFile {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	var files []util.BackupFile
+	for p, f := range mb.files {
+		if strings.HasPrefix(p, prefix) && !strings.Contains(p[len(prefix):], "/") {
+			files = append(files, util.BackupFile{Name: p, Size: int64(len(f.data)), LastModified: f.modified})
+		}
+	}
+	return files
+}
+
+// notExistError returns the error of opening the missing backup file on path.
+func notExistError(path string) error {
+	return &os.PathError{Op: "open", Path: path, Err: os.ErrNotExist}
+}
@@ -0,0 +1,129 @@
+// Copyright 2018 The etcd-operator Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backup
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/coreos/etcd-operator/pkg/backup/util"
+)
+
+// testBackendScenario runs the common backup operations against b.
+func testBackendScenario(t *testing.T, b Backend) {
+	ctx := context.Background()
+	path := "cluster-a/etcd.backup"
+	backupPath := func(rev int64) string {
+		return path + "_" + util.MakeBackupName("3.2.13", rev)
+	}
+	for i := int64(1); i <= 3; i++ {
+		if _, err := b.Write(ctx, backupPath(i), bytes.NewReader(bytes.Repeat([]byte("a"), 100))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := b.Write(ctx, "cluster-b/etcd.backup_"+util.MakeBackupName("3.2.13", 4), bytes.NewReader([]byte("other"))); err != nil {
+		t.Fatal(err)
+	}
+
+	if latest, err := b.Latest(ctx, path); err != nil || latest != backupPath(3) {
+		t.Errorf("expect latest=%v, get=%v (err=%v)", backupPath(3), latest, err)
+	}
+	if p, err := b.ByRevision(ctx, path, 2); err != nil || p != backupPath(2) {
+		t.Errorf("expect backup by revision=%v, get=%v (err=%v)", backupPath(2), p, err)
+	}
+	if n, err := b.Total(ctx, path); err != nil || n != 3 {
+		t.Errorf("expect total=3, get=%d (err=%v)", n, err)
+	}
+	if n, err := b.TotalBytes(ctx, path); err != nil || n != 300 {
+		t.Errorf("expect total bytes=300, get=%d (err=%v)", n, err)
+	}
+	page, err := b.ListPage(ctx, path, true, 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{backupPath(3), backupPath(2)}; !reflect.DeepEqual(page, want) {
+		t.Errorf("expect page=%v, get=%v", want, page)
+	}
+
+	if _, skipped, err := b.WriteIfAbsent(ctx, backupPath(2), bytes.NewReader([]byte("dup"))); err != nil || !skipped {
+		t.Errorf("expect duplicate save to be skipped, get skipped=%v (err=%v)", skipped, err)
+	}
+	rc, err := b.Open(ctx, backupPath(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil || len(data) != 100 {
+		t.Errorf("expect to read 100 bytes, get=%d (err=%v)", len(data), err)
+	}
+	rc, err = b.OpenRange(ctx, backupPath(2), 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err = ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil || len(data) != 40 {
+		t.Errorf("expect to read 40 bytes from offset 60, get=%d (err=%v)", len(data), err)
+	}
+	if _, err := b.OpenLimited(ctx, backupPath(2), 10); err != util.ErrBackupTooLarge {
+		t.Errorf("expect error=%v, get=%v", util.ErrBackupTooLarge, err)
+	}
+	if _, err := b.Open(ctx, path+"_missing"); !os.IsNotExist(err) {
+		t.Errorf("expect not exist error, get=%v", err)
+	}
+
+	if _, err := b.Purge(ctx, path, 0, false); err != util.ErrInvalidMaxBackups {
+		t.Errorf("expect error=%v, get=%v", util.ErrInvalidMaxBackups, err)
+	}
+	if _, err := b.PurgeByVersion(ctx, path, 0, false); err != util.ErrInvalidMaxBackups {
+		t.Errorf("expect error=%v, get=%v", util.ErrInvalidMaxBackups, err)
+	}
+	purged, err := b.Purge(ctx, path, 1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{backupPath(1), backupPath(2)}; !reflect.DeepEqual(purged, want) {
+		t.Errorf("expect purged=%v, get=%v", want, purged)
+	}
+	if _, err := b.DeleteAll(ctx, path, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Latest(ctx, path); err != util.ErrNoBackups {
+		t.Errorf("expect error=%v, get=%v", util.ErrNoBackups, err)
+	}
+	if n, err := b.Total(ctx, "cluster-b/etcd.backup"); err != nil || n != 1 {
+		t.Errorf("expect backups of another path to be kept, get total=%d (err=%v)", n, err)
+	}
+}
+
+func TestMemoryBackend(t *testing.T) {
+	testBackendScenario(t, NewMemoryBackend())
+}
+
+// TestFSBackendScenario checks that memory backends behave like a real storage.
+func TestFSBackendScenario(t *testing.T) {
+	root, err := ioutil.TempDir("", "fs-backend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	testBackendScenario(t, NewFSBackend(root))
+}
@@ -0,0 +1,240 @@
+// Copyright 2018 The etcd-operator Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/etcd-operator/pkg/backup/writer"
+)
+
+// ensure multiBackend satisfies MultiBackend interface.
+var _ MultiBackend = &multiBackend{}
+
+// MultiBackend is a Backend saving each backup to several backends at once, in a single pass over the snapshot,
+// e.g. to both ABS and S3 for redundancy.
+type MultiBackend interface {
+	Backend
+	// WriteAll saves the backup file read from r to path of every backend concurrently and returns
+	// the result of each backend, in the order the backends were given. It returns an error
+	// if the save failed for any backend, in which case the other backends may still have saved it.
+	WriteAll(ctx context.Context, path string, r io.Reader) ([]MultiWriteResult, error)
+}
+
+// MultiWriteResult is the outcome of a save of a MultiBackend to one of its backends.
+type MultiWriteResult struct {
+	// Result describes the saved backup file if the save succeeded.
+	Result *writer.SaveResult
+	// Err is the reason the save failed, or nil if it succeeded.
+	Err error
+}
+
+// multiBackend saves backups to all of its backends and reads them from the first one.
+type multiBackend struct {
+	Backend
+	backends []Backend
+}
+
+// NewMultiBackend returns a Backend saving and purging backups on primary and all others, and reading them from primary.
+// Saves read their input once and stream it to every backend, and succeed only if they succeed for every backend.
+// Purges run on every backend in turn and return the paths purged from all of them.
+func NewMultiBackend(primary Backend, others ...Backend) MultiBackend {
+	return &multiBackend{Backend: primary, backends: append([]Backend{primary}, others...)}
+}
+
+func (mb *multiBackend) WriteAll(ctx context.Context, path string, r io.Reader) ([]MultiWriteResult, error) {
+	results := make([]MultiWriteResult, len(mb.backends))
+	errs := fanOut(r, len(mb.backends), func(i int, r io.Reader) error {
+		var err error
+		results[i].Result, err = mb.backends[i].WriteWithResult(ctx, path, r)
+		return err
+	})
+	for i, err := range errs {
+		results[i].Err = err
+	}
+	return results, joinBackendErrors("save backup", errs)
+}
+
+func (mb *multiBackend) Write(ctx context.Context, path string, r io.Reader) (int64, error) {
+	res, err := mb.WriteWithResult(ctx, path, r)
+	if err != nil {
+		return 0, err
+	}
+	return res.Size, nil
+}
+
+// WriteWithResult saves the backup file like WriteAll and returns the result of the primary backend.
+func (mb *multiBackend) WriteWithResult(ctx context.Context, path string, r io.Reader) (*writer.SaveResult, error) {
+	start := time.Now()
+	results, err := mb.WriteAll(ctx, path, r)
+	if err != nil {
+		return nil, err
+	}
+	res := *results[0].Result
+	res.Duration = time.Since(start)
+	return &res, nil
+}
+
+// WriteIfAbsent saves the backup file to the backends which don't have a backup of the same etcd version
+// and revision yet. It reports the backup file as skipped only if every backend already had one,
+// and returns the size of the backup file of the primary backend.
+func (mb *multiBackend) WriteIfAbsent(ctx context.Context, path string, r io.Reader) (int64, bool, error) {
+	sizes := make([]int64, len(mb.backends))
+	skipped := make([]bool, len(mb.backends))
+	errs := fanOut(r, len(mb.backends), func(i int, r io.Reader) error {
+		var err error
+		sizes[i], skipped[i], err = mb.backends[i].WriteIfAbsent(ctx, path, r)
+		return err
+	})
+	if err := joinBackendErrors("save backup", errs); err != nil {
+		return 0, false, err
+	}
+	allSkipped := true
+	for _, s := range skipped {
+		allSkipped = allSkipped && s
+	}
+	return sizes[0], allSkipped, nil
+}
+
+func (mb *multiBackend) Purge(ctx context.Context, path string, maxBackups int, dryRun bool) ([]string, error) {
+	return mb.purge("purge backups", func(b Backend) ([]string, error) {
+		return b.Purge(ctx, path, maxBackups, dryRun)
+	})
+}
+
+func (mb *multiBackend) PurgeByVersion(ctx context.Context, path string, keepPerVersion int, dryRun bool) ([]string, error) {
+	return mb.purge("purge backups", func(b Backend) ([]string, error) {
+		return b.PurgeByVersion(ctx, path, keepPerVersion, dryRun)
+	})
+}
+
+func (mb *multiBackend) PurgeOlderThan(ctx context.Context, path string, d time.Duration, dryRun bool) ([]string, error) {
+	return mb.purge("purge backups", func(b Backend) ([]string, error) {
+		return b.PurgeOlderThan(ctx, path, d, dryRun)
+	})
+}
+
+func (mb *multiBackend) PurgeToSize(ctx context.Context, path string, maxBytes int64, dryRun bool) ([]string, error) {
+	return mb.purge("purge backups", func(b Backend) ([]string, error) {
+		return b.PurgeToSize(ctx, path, maxBytes, dryRun)
+	})
+}
+
+// PurgeBatch purges at most maxDelete stale backup files from each backend, and returns the numbers of backup files
+// deleted from and left on all of them.
+func (mb *multiBackend) PurgeBatch(ctx context.Context, path string, keep, maxDelete int) (int, int, error) {
+	var deleted, remaining int
+	errs := make([]error, len(mb.backends))
+	for i, b := range mb.backends {
+		var d, r int
+		d, r, errs[i] = b.PurgeBatch(ctx, path, keep, maxDelete)
+		deleted, remaining = deleted+d, remaining+r
+	}
+	return deleted, remaining, joinBackendErrors("purge backups", errs)
+}
+
+func (mb *multiBackend) DeleteAll(ctx context.Context, path string, dryRun bool) ([]string, error) {
+	return mb.purge("delete backups", func(b Backend) ([]string, error) {
+		return b.DeleteAll(ctx, path, dryRun)
+	})
+}
+
+// purge runs fn on every backend, even if it fails for some, and returns the paths it returned for all of them.
+func (mb *multiBackend) purge(op string, fn func(b Backend) ([]string, error)) ([]string, error) {
+	purged := []string{}
+	errs := make([]error, len(mb.backends))
+	for i, b := range mb.backends {
+		var paths []string
+		paths, errs[i] = fn(b)
+		purged = append(purged, paths...)
+	}
+	return purged, joinBackendErrors(op, errs)
+}
+
+// joinBackendErrors returns an error listing the backends op failed for by index, or nil if it failed for none.
+func joinBackendErrors(op string, errs []error) error {
+	var msgs []string
+	for i, err := range errs {
+		if err != nil {
+			msgs = append(msgs, fmt.Sprintf("backend %d: %v", i, err))
+		}
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("failed to %s on %d of %d backends: %s", op, len(msgs), len(errs), strings.Join(msgs, "; "))
+}
+
+// errFanOutDone is returned to the writes to a fan out destination which is done reading.
+var errFanOutDone = errors.New("fan out destination done")
+
+// fanOut calls fn concurrently n times, each with a reader of the content of r, which is read only once.
+// A call returning before reading all of its reader doesn't hold up the others.
+// It returns the errors of the calls by index.
+func fanOut(r io.Reader, n int, fn func(i int, r io.Reader) error) []error {
+	errs := make([]error, n)
+	pws := make([]*io.PipeWriter, n)
+	var wg sync.WaitGroup
+	for i := range pws {
+		pr, pw := io.Pipe()
+		pws[i] = pw
+		wg.Add(1)
+		go func(i int, pr *io.PipeReader) {
+			defer wg.Done()
+			errs[i] = fn(i, pr)
+			pr.CloseWithError(errFanOutDone)
+		}(i, pr)
+	}
+
+	_, err := io.Copy(&fanOutWriter{pws: append([]*io.PipeWriter(nil), pws...)}, r)
+	if err == errFanOutDone {
+		err = nil
+	}
+	for _, pw := range pws {
+		// A nil err makes the readers return io.EOF.
+		pw.CloseWithError(err)
+	}
+	wg.Wait()
+	return errs
+}
+
+// fanOutWriter writes to every pipe whose reader is still reading.
+type fanOutWriter struct {
+	pws []*io.PipeWriter
+}
+
+func (fw *fanOutWriter) Write(p []byte) (int, error) {
+	active := 0
+	for i, pw := range fw.pws {
+		if pw == nil {
+			continue
+		}
+		if _, err := pw.Write(p); err != nil {
+			fw.pws[i] = nil
+			continue
+		}
+		active++
+	}
+	if active == 0 {
+		return 0, errFanOutDone
+	}
+	return len(p), nil
+}
@@ -0,0 +1,71 @@
+// Copyright 2018 The etcd-operator Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backup
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/coreos/etcd-operator/pkg/backup/util"
+)
+
+func TestMultiBackendWriteAll(t *testing.T) {
+	ctx := context.Background()
+	backends := []Backend{NewMemoryBackend(), NewMemoryBackend()}
+	mb := NewMultiBackend(backends[0], backends[1])
+
+	path := "cluster-a/etcd.backup_" + util.MakeBackupName("3.2.13", 1)
+	data := bytes.Repeat([]byte("backup"), 100000)
+	results, err := mb.WriteAll(ctx, path, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, b := range backends {
+		if results[i].Err != nil || results[i].Result.Size != int64(len(data)) {
+			t.Errorf("backend %d: expect %d bytes saved, get=%+v", i, len(data), results[i])
+		}
+		rc, err := b.Open(ctx, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil || !bytes.Equal(got, data) {
+			t.Errorf("backend %d: expect the saved content to be the source content (err=%v)", i, err)
+		}
+	}
+}
+
+func TestMultiBackendWriteAllFailure(t *testing.T) {
+	ctx := context.Background()
+	ok := NewMemoryBackend()
+	mb := NewMultiBackend(&readOnlyBackend{NewMemoryBackend()}, ok)
+
+	path := "cluster-a/etcd.backup_" + util.MakeBackupName("3.2.13", 1)
+	results, err := mb.WriteAll(ctx, path, strings.NewReader("backup"))
+	if err == nil || !strings.Contains(err.Error(), "backend 0") {
+		t.Fatalf("expect the failure of backend 0 to be reported, get=%v", err)
+	}
+	if results[0].Err == nil || results[1].Err != nil {
+		t.Errorf("expect only backend 0 to fail, get=%+v", results)
+	}
+	// The failing backend does not hold up the save to the other one.
+	if exists, err := ok.Exists(ctx, path); err != nil || !exists {
+		t.Errorf("expect the backup saved to backend 1, get exists=%v (err=%v)", exists, err)
+	}
+}
@@ -15,8 +15,18 @@
 package reader
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"hash"
 	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/coreos/etcd-operator/pkg/backup/util"
 
@@ -25,34 +35,968 @@ import (
 
 // ensure absReader satisfies reader interface.
 var _ Reader = &absReader{}
+var _ ABSPropertiesGetter = &absReader{}
+var _ ABSRawOpener = &absReader{}
+var _ ABSTagLister = &absReader{}
+var _ ABSMarkerLister = &absReader{}
+var _ ABSIndexVerifier = &absReader{}
+var _ ChecksumGetter = &absReader{}
+
+// BlobInfo describes a backup blob from its storage properties.
+// The access tier is not included since the storage SDK in use does not expose it.
+type BlobInfo struct {
+	// Name is the blob name of the backup.
+	Name string
+	// ContentLength is the size of the blob in bytes.
+	ContentLength int64
+	// BlobType is the type of the blob, e.g. storage.BlobTypeBlock.
+	BlobType storage.BlobType
+	// LastModified is when the blob was last modified.
+	LastModified time.Time
+}
+
+// ABSRawOpener reads backups saved to ABS as stored.
+type ABSRawOpener interface {
+	// OpenRaw opens the blob on path for reading its content as stored, e.g. still compressed.
+	OpenRaw(ctx context.Context, path string) (io.ReadCloser, error)
+}
+
+// ABSTagLister finds backups saved to ABS by their tags.
+type ABSTagLister interface {
+	// ListByTag returns the paths of the backup files saved with revision appended to path
+	// which are tagged with the given key and value, sorted by name.
+	ListByTag(ctx context.Context, path, key, value string) ([]string, error)
+}
+
+// ABSMarkerLister lists backups saved to ABS a page at a time.
+type ABSMarkerLister interface {
+	// ListWithMarker returns the paths of at most max backup files saved with revision appended to path,
+	// sorted by name, starting at the continuation marker returned by the previous call,
+	// or at the first backup file if marker is empty. nextMarker is empty once the last page is returned.
+	ListWithMarker(ctx context.Context, path, marker string, max int) (names []string, nextMarker string, err error)
+}
+
+// ABSIndexVerifier checks backups saved to ABS against the backup index saved along with them.
+type ABSIndexVerifier interface {
+	// VerifyAll returns the paths of the backup files saved with revision appended to path that are recorded
+	// in the backup index of path but are missing, or whose size or stored checksum differs from the index.
+	VerifyAll(ctx context.Context, path string) ([]string, error)
+}
+
+// ABSPropertiesGetter gets the storage properties of backups saved to ABS.
+type ABSPropertiesGetter interface {
+	// GetProperties returns the storage properties of the blob on path without downloading it.
+	GetProperties(ctx context.Context, path string) (*BlobInfo, error)
+}
 
 // absReader provides Reader implementation for reading a file from ABS
 type absReader struct {
 	abs *storage.BlobStorageClient
+	// encryptionKey is used to decrypt backups if set.
+	encryptionKey []byte
+	// retry is the retry policy of blob operations.
+	retry util.RetryPolicy
+	// timeout bounds each request, and each operation not streaming the content of a backup.
+	// Reading the content of a backup instead fails once a read makes no progress within timeout,
+	// so that downloads of large backups over slow links are not aborted.
+	timeout time.Duration
+	// verify makes Open check the content of backups against their stored checksum as they are read.
+	verify bool
+}
+
+// NewABSReader creates a abs reader.
+// If encryptionKey is not empty, backups are decrypted with it.
+// Each request times out after timeout, or util.DefaultOperationTimeout if timeout is 0. So does each operation,
+// except for reading the content of backups, which times out once a read makes no progress for as long.
+func NewABSReader(abs *storage.BlobStorageClient, encryptionKey []byte, timeout time.Duration) Reader {
+	if timeout <= 0 {
+		timeout = util.DefaultOperationTimeout
+	}
+	return &absReader{abs: abs, encryptionKey: encryptionKey, retry: util.DefaultRetryPolicy, timeout: timeout}
+}
+
+// NewABSVerifyingReader creates a abs reader like NewABSReader whose Open also computes the SHA-256 checksum
+// of backups as they are read, without a separate download like Verify. Reading a backup whose content
+// does not match its stored checksum returns an error with util.ErrChecksumMismatch as cause at the end of the content.
+// Backups saved without a checksum are read unchecked.
+func NewABSVerifyingReader(abs *storage.BlobStorageClient, encryptionKey []byte, timeout time.Duration) Reader {
+	absr := NewABSReader(abs, encryptionKey, timeout).(*absReader)
+	absr.verify = true
+	return absr
+}
+
+// do runs the blob request fn with the retry policy, wrapping ABS service errors into *util.StorageError.
+// The request, including its retries, times out after absr.timeout.
+func (absr *absReader) do(ctx context.Context, fn func() error) error {
+	ctx, cancel := context.WithTimeout(ctx, absr.timeout)
+	defer cancel()
+	return util.WrapStorageError(absr.retry.Do(ctx, fn))
 }
 
-func NewABSReader(abs *storage.BlobStorageClient) Reader {
-	return &absReader{abs}
+func (absr *absReader) getContainer(ctx context.Context, container string) (*storage.Container, error) {
+	var containerRef *storage.Container
+	err := absr.do(ctx, func() error {
+		ref, err := util.GetContainer(absr.abs, container)
+		if err != nil {
+			return err
+		}
+		containerRef = ref
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return containerRef, nil
+}
+
+// getBlob opens the raw content of the blob, retrying transient failures of the request.
+// Reading the content fails once a read makes no progress within absr.timeout.
+func (absr *absReader) getBlob(ctx context.Context, blob *storage.Blob) (io.ReadCloser, error) {
+	var rc io.ReadCloser
+	err := absr.do(ctx, func() error {
+		body, err := blob.Get(&storage.GetBlobOptions{})
+		if err != nil {
+			return err
+		}
+		rc = body
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return util.NewIdleReadCloser(rc, absr.timeout), nil
 }
 
 // Open opens the file on path where path must be in the format "<abs-container-name>/<key>"
-func (absr *absReader) Open(path string) (io.ReadCloser, error) {
+// Gzip compressed files are transparently decompressed, whether or not they have the util.GzipSuffix,
+// and files with the util.ManifestSuffix are reassembled from their chunks, which are always checked against their checksums.
+// Opening a blob in the Archive access tier returns an error with util.ErrBlobArchived as cause.
+func (absr *absReader) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	container, key, err := util.ParseBucketAndKey(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse abs container and key: %v", err)
+	}
+
+	containerRef, err := absr.getContainer(ctx, container)
+	if err != nil {
+		return nil, err
+	}
+
+	blob := containerRef.GetBlobReference(key)
+	if strings.HasSuffix(key, util.ManifestSuffix) {
+		return absr.openChunks(ctx, containerRef, blob)
+	}
+	if absr.verify {
+		err = absr.do(ctx, func() error {
+			return blob.GetMetadata(&storage.GetBlobMetadataOptions{})
+		})
+		if err != nil {
+			return nil, util.CheckBlobArchived(path, err)
+		}
+	}
+	rc, err := absr.getBlob(ctx, blob)
+	if err != nil {
+		return nil, util.CheckBlobArchived(path, err)
+	}
+	rc = util.NewContextReadCloser(ctx, rc)
+	// The checksum is computed over the content as stored, before it is decrypted and decompressed.
+	if checksum, ok := blob.Metadata[util.MetadataSHA256]; absr.verify && ok {
+		rc = util.NewChecksumReadCloser(rc, checksum)
+	}
+	if len(absr.encryptionKey) != 0 {
+		rc, err = absr.decrypt(rc)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return util.DecompressIfGzipped(rc)
+}
+
+// OpenRaw opens the blob on path like Open, but reads its content as stored,
+// without decrypting, decompressing or reassembling it from chunks.
+func (absr *absReader) OpenRaw(ctx context.Context, path string) (io.ReadCloser, error) {
+	container, key, err := util.ParseBucketAndKey(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse abs container and key: %v", err)
+	}
+	containerRef, err := absr.getContainer(ctx, container)
+	if err != nil {
+		return nil, err
+	}
+	rc, err := absr.getBlob(ctx, containerRef.GetBlobReference(key))
+	if err != nil {
+		return nil, util.CheckBlobArchived(path, err)
+	}
+	return util.NewContextReadCloser(ctx, rc), nil
+}
+
+// OpenRange opens the file on path for reading from offset with a Range request.
+// Compressed, encrypted and deduplicated backups can't be read from an offset of their content,
+// so opening them returns an error. Compressed backups saved without the util.GzipSuffix are read as stored.
+func (absr *absReader) OpenRange(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
+	if offset < 0 {
+		return nil, fmt.Errorf("invalid offset: %d", offset)
+	}
+	container, key, err := util.ParseBucketAndKey(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse abs container and key: %v", err)
+	}
+	if len(absr.encryptionKey) != 0 || strings.HasSuffix(key, util.GzipSuffix) || strings.HasSuffix(key, util.ManifestSuffix) {
+		return nil, fmt.Errorf("range reads of compressed, encrypted or deduplicated backups are not supported (%v)", path)
+	}
+
+	containerRef, err := absr.getContainer(ctx, container)
+	if err != nil {
+		return nil, err
+	}
+	blob := containerRef.GetBlobReference(key)
+	var rc io.ReadCloser
+	err = absr.do(ctx, func() error {
+		// A range without an end reads up to the end of the blob.
+		body, err := blob.GetRange(&storage.GetBlobRangeOptions{Range: &storage.BlobRange{Start: uint64(offset)}})
+		if err != nil {
+			return err
+		}
+		rc = body
+		return nil
+	})
+	if err != nil {
+		return nil, util.CheckBlobArchived(path, err)
+	}
+	return util.NewContextReadCloser(ctx, util.NewIdleReadCloser(rc, absr.timeout)), nil
+}
+
+// openChunks reads the manifest of a deduplicated backup from blob and returns a ReadCloser
+// reassembling the backup from its chunks stored in containerRef.
+func (absr *absReader) openChunks(ctx context.Context, containerRef *storage.Container, blob *storage.Blob) (io.ReadCloser, error) {
+	rc, err := absr.getBlob(ctx, blob)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	var manifest util.ChunkManifest
+	if err := json.NewDecoder(util.NewContextReadCloser(ctx, rc)).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("failed to decode backup manifest: %v", err)
+	}
+	return &chunkReadCloser{ctx: ctx, absr: absr, containerRef: containerRef, manifest: manifest}, nil
+}
+
+// chunkReadCloser reads a deduplicated backup by downloading its chunks in order,
+// failing if the checksum of a chunk does not match its manifest entry.
+type chunkReadCloser struct {
+	ctx          context.Context
+	absr         *absReader
+	containerRef *storage.Container
+	manifest     util.ChunkManifest
+
+	// next is the index of the chunk being read.
+	next int
+	cur  io.ReadCloser
+	h    hash.Hash
+}
+
+func (c *chunkReadCloser) Read(p []byte) (int, error) {
+	for {
+		if c.cur == nil {
+			if c.next == len(c.manifest.Chunks) {
+				return 0, io.EOF
+			}
+			name := util.ChunkBlobName(c.manifest.Chunks[c.next])
+			rc, err := c.absr.getBlob(c.ctx, c.containerRef.GetBlobReference(name))
+			if err != nil {
+				return 0, fmt.Errorf("failed to open chunk %s: %v", name, err)
+			}
+			c.cur, c.h = util.NewContextReadCloser(c.ctx, rc), sha256.New()
+		}
+
+		n, err := c.cur.Read(p)
+		c.h.Write(p[:n])
+		if err != io.EOF {
+			return n, err
+		}
+		c.cur.Close()
+		c.cur = nil
+		want := c.manifest.Chunks[c.next]
+		c.next++
+		if got := hex.EncodeToString(c.h.Sum(nil)); got != want {
+			return n, fmt.Errorf("chunk %s is corrupted: checksum %s", want, got)
+		}
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+func (c *chunkReadCloser) Close() error {
+	if c.cur == nil {
+		return nil
+	}
+	return c.cur.Close()
+}
+
+// Exists checks whether the blob on path exists with a properties request.
+func (absr *absReader) Exists(ctx context.Context, path string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, absr.timeout)
+	defer cancel()
+
+	container, key, err := util.ParseBucketAndKey(path)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse abs container and key: %v", err)
+	}
+	blob := absr.abs.GetContainerReference(container).GetBlobReference(key)
+	var exists bool
+	err = absr.do(ctx, func() error {
+		ok, err := blob.Exists()
+		if err != nil {
+			return err
+		}
+		exists = ok
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return exists, nil
+}
+
+// GetProperties returns the storage properties of the blob on path with a properties request.
+func (absr *absReader) GetProperties(ctx context.Context, path string) (*BlobInfo, error) {
+	ctx, cancel := context.WithTimeout(ctx, absr.timeout)
+	defer cancel()
+
 	container, key, err := util.ParseBucketAndKey(path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse abs container and key: %v", err)
 	}
+	blob := absr.abs.GetContainerReference(container).GetBlobReference(key)
+	err = absr.do(ctx, func() error {
+		return blob.GetProperties(&storage.GetBlobPropertiesOptions{})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &BlobInfo{
+		Name:          key,
+		ContentLength: blob.Properties.ContentLength,
+		BlobType:      blob.Properties.BlobType,
+		LastModified:  time.Time(blob.Properties.LastModified),
+	}, nil
+}
 
-	containerRef := absr.abs.GetContainerReference(container)
-	containerExists, err := containerRef.Exists()
+// OpenLimited opens the file on path like Open, failing if it is larger than maxBytes.
+// The size of the blob is checked before downloading it.
+func (absr *absReader) OpenLimited(ctx context.Context, path string, maxBytes int64) (io.ReadCloser, error) {
+	container, key, err := util.ParseBucketAndKey(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse abs container and key: %v", err)
+	}
+
+	containerRef, err := absr.getContainer(ctx, container)
 	if err != nil {
 		return nil, err
 	}
 
-	if !containerExists {
-		return nil, fmt.Errorf("container %v does not exist", container)
+	blob := containerRef.GetBlobReference(key)
+	err = absr.do(ctx, func() error {
+		return blob.GetProperties(&storage.GetBlobPropertiesOptions{})
+	})
+	if err != nil {
+		return nil, err
+	}
+	if blob.Properties.ContentLength > maxBytes {
+		return nil, util.ErrBackupTooLarge
+	}
+
+	rc, err := absr.Open(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	// The blob of a deduplicated backup is its manifest, which records the size of the backup.
+	if cr, ok := rc.(*chunkReadCloser); ok && cr.manifest.Size > maxBytes {
+		rc.Close()
+		return nil, util.ErrBackupTooLarge
+	}
+	return util.NewLimitedReadCloser(rc, maxBytes), nil
+}
+
+// Verify downloads the backup file on path and compares its SHA-256 checksum with the stored one.
+// Only the manifest of a deduplicated backup is checked, its chunks are checked as they are read.
+func (absr *absReader) Verify(ctx context.Context, path string) (bool, error) {
+	container, key, err := util.ParseBucketAndKey(path)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse abs container and key: %v", err)
+	}
+
+	containerRef, err := absr.getContainer(ctx, container)
+	if err != nil {
+		return false, err
 	}
 
 	blob := containerRef.GetBlobReference(key)
-	getBlobOpts := &storage.GetBlobOptions{}
-	return blob.Get(getBlobOpts)
+	err = absr.do(ctx, func() error {
+		return blob.GetMetadata(&storage.GetBlobMetadataOptions{})
+	})
+	if err != nil {
+		return false, err
+	}
+	checksum, ok := blob.Metadata[util.MetadataSHA256]
+	if !ok {
+		return true, nil
+	}
+
+	rc, err := absr.getBlob(ctx, blob)
+	if err != nil {
+		return false, util.CheckBlobArchived(path, err)
+	}
+	defer rc.Close()
+
+	h := sha256.New()
+	if _, err = io.Copy(h, util.NewContextReader(ctx, rc)); err != nil {
+		return false, err
+	}
+	return hex.EncodeToString(h.Sum(nil)) == checksum, nil
+}
+
+// Checksum returns the SHA-256 checksum stored in the metadata of the blob of the backup file on path.
+func (absr *absReader) Checksum(ctx context.Context, path string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, absr.timeout)
+	defer cancel()
+
+	container, key, err := util.ParseBucketAndKey(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse abs container and key: %v", err)
+	}
+
+	containerRef, err := absr.getContainer(ctx, container)
+	if err != nil {
+		return "", err
+	}
+
+	blob := containerRef.GetBlobReference(key)
+	err = absr.do(ctx, func() error {
+		return blob.GetMetadata(&storage.GetBlobMetadataOptions{})
+	})
+	if err != nil {
+		return "", err
+	}
+	return blob.Metadata[util.MetadataSHA256], nil
+}
+
+// Latest returns the path of the latest backup file saved with revision appended to path,
+// in the format "<abs-container-name>/<key>".
+// It reads the latest backup pointer saved along with the backups by the abs writer, and only lists the backups
+// if the pointer is missing or points to a backup that does not exist anymore. A stale pointer is then
+// rewritten to the listed latest backup, unless a writer updated it in the meantime.
+func (absr *absReader) Latest(ctx context.Context, path string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, absr.timeout)
+	defer cancel()
+
+	latest, etag, ok := absr.latestFromPointer(ctx, path)
+	if ok {
+		return latest, nil
+	}
+	container, files, err := absr.listBackupFiles(ctx, path)
+	if err != nil {
+		return "", err
+	}
+	name := util.GetLatestBackupNameByDate(files)
+	if len(name) == 0 {
+		return "", util.ErrNoBackups
+	}
+	if len(etag) != 0 {
+		absr.repairPointer(ctx, path, name, etag)
+	}
+	return container + "/" + name, nil
+}
+
+// latestFromPointer returns the path of the backup recorded in the latest backup pointer of path,
+// or false if the pointer can't be read or is stale. The etag of a stale pointer is returned
+// so that it can be repaired.
+func (absr *absReader) latestFromPointer(ctx context.Context, path string) (string, string, bool) {
+	container, key, err := util.ParseBucketAndKey(path)
+	if err != nil {
+		return "", "", false
+	}
+	containerRef, err := absr.getContainer(ctx, container)
+	if err != nil {
+		return "", "", false
+	}
+
+	pointer := containerRef.GetBlobReference(key + util.LatestPointerSuffix)
+	var name []byte
+	err = absr.do(ctx, func() error {
+		rc, err := pointer.Get(&storage.GetBlobOptions{})
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		data, err := ioutil.ReadAll(rc)
+		if err != nil {
+			return err
+		}
+		name = data
+		return nil
+	})
+	if err != nil {
+		return "", "", false
+	}
+	etag := pointer.Properties.Etag
+	if !strings.HasPrefix(string(name), key+"_") {
+		return "", etag, false
+	}
+
+	var exists bool
+	err = absr.do(ctx, func() error {
+		ok, err := containerRef.GetBlobReference(string(name)).Exists()
+		if err != nil {
+			return err
+		}
+		exists = ok
+		return nil
+	})
+	if err != nil {
+		return "", "", false
+	}
+	if !exists {
+		return "", etag, false
+	}
+	return container + "/" + string(name), "", true
+}
+
+// repairPointer rewrites the stale latest backup pointer of path of the given etag to record name.
+// It is best effort: failing to repair the pointer only makes the next Latest list the backups again.
+func (absr *absReader) repairPointer(ctx context.Context, path, name, etag string) {
+	container, key, err := util.ParseBucketAndKey(path)
+	if err != nil {
+		return
+	}
+	containerRef, err := absr.getContainer(ctx, container)
+	if err != nil {
+		return
+	}
+	pointer := containerRef.GetBlobReference(key + util.LatestPointerSuffix)
+	absr.do(ctx, func() error {
+		return pointer.CreateBlockBlobFromReader(strings.NewReader(name), &storage.PutBlobOptions{IfMatch: etag})
+	})
+}
+
+// NthLatest returns the path of the n-th latest backup file saved with revision appended to path,
+// in the format "<abs-container-name>/<key>".
+func (absr *absReader) NthLatest(ctx context.Context, path string, n int) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, absr.timeout)
+	defer cancel()
+
+	container, files, err := absr.listBackupFiles(ctx, path)
+	if err != nil {
+		return "", err
+	}
+	f, ok := util.GetNthLatestBackupFileByDate(files, n)
+	if !ok {
+		return "", util.ErrNoBackups
+	}
+	return container + "/" + f.Name, nil
+}
+
+// LatestOfCluster returns the path of the latest backup file of the etcd cluster named clusterName,
+// in the format "<abs-container-name>/<key>".
+func (absr *absReader) LatestOfCluster(ctx context.Context, path, clusterName string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, absr.timeout)
+	defer cancel()
+
+	container, files, err := absr.listBackupFiles(ctx, path)
+	if err != nil {
+		return "", err
+	}
+	name := util.GetLatestBackupNameByDate(util.BackupFilesOfCluster(files, clusterName))
+	if len(name) == 0 {
+		return "", util.ErrNoBackups
+	}
+	return container + "/" + name, nil
+}
+
+// ByRevision returns the path of the backup file whose revision is the largest one less than or equal to rev,
+// in the format "<abs-container-name>/<key>".
+func (absr *absReader) ByRevision(ctx context.Context, path string, rev uint64) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, absr.timeout)
+	defer cancel()
+
+	container, files, err := absr.listBackupFiles(ctx, path)
+	if err != nil {
+		return "", err
+	}
+	name := util.GetBackupNameByRevision(files, rev)
+	if len(name) == 0 {
+		return "", util.ErrNoBackups
+	}
+	return container + "/" + name, nil
+}
+
+// ListPage returns a page of the paths of the backup files sorted by date,
+// in the format "<abs-container-name>/<key>".
+func (absr *absReader) ListPage(ctx context.Context, path string, sortDesc bool, limit, offset int) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, absr.timeout)
+	defer cancel()
+
+	container, files, err := absr.listBackupFiles(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	paths := []string{}
+	for _, f := range util.PageBackupFiles(files, sortDesc, limit, offset) {
+		paths = append(paths, container+"/"+f.Name)
+	}
+	return paths, nil
+}
+
+// WalkBackups calls fn for each backup file saved with revision appended to path, listing a page of blobs at a time
+// in the order of their names. Names passed to fn are blob names, without the container.
+func (absr *absReader) WalkBackups(ctx context.Context, path string, fn func(util.BackupInfo) error) error {
+	ctx, cancel := context.WithTimeout(ctx, absr.timeout)
+	defer cancel()
+
+	container, key, err := util.ParseBucketAndKey(path)
+	if err != nil {
+		return fmt.Errorf("failed to parse abs container and key: %v", err)
+	}
+	containerRef, err := absr.getContainer(ctx, container)
+	if err != nil {
+		return err
+	}
+
+	params := storage.ListBlobsParameters{Prefix: key + "_"}
+	for {
+		var resp storage.BlobListResponse
+		err = absr.do(ctx, func() error {
+			list, err := containerRef.ListBlobs(params)
+			if err != nil {
+				return err
+			}
+			resp = list
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		files := make([]util.BackupFile, 0, len(resp.Blobs))
+		for _, blob := range resp.Blobs {
+			files = append(files, util.BackupFile{
+				Name:         blob.Name,
+				LastModified: time.Time(blob.Properties.LastModified),
+				Size:         blob.Properties.ContentLength,
+			})
+		}
+		if err = util.WalkBackupFiles(files, fn); err != nil {
+			return err
+		}
+		if len(resp.NextMarker) == 0 {
+			return nil
+		}
+		params.Marker = resp.NextMarker
+	}
+}
+
+// Total returns the number of backup files saved with revision appended to path.
+func (absr *absReader) Total(ctx context.Context, path string) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, absr.timeout)
+	defer cancel()
+
+	_, files, err := absr.listBackupFiles(ctx, path)
+	if err != nil {
+		return 0, err
+	}
+	return len(files), nil
+}
+
+// TotalBytes returns the total size in bytes of the backup files saved with revision appended to path.
+func (absr *absReader) TotalBytes(ctx context.Context, path string) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, absr.timeout)
+	defer cancel()
+
+	_, files, err := absr.listBackupFiles(ctx, path)
+	if err != nil {
+		return 0, err
+	}
+	return util.TotalSize(files), nil
+}
+
+// ListVersions returns the number of backup files of each etcd version.
+func (absr *absReader) ListVersions(ctx context.Context, path string) (map[string]int, error) {
+	ctx, cancel := context.WithTimeout(ctx, absr.timeout)
+	defer cancel()
+
+	_, files, err := absr.listBackupFiles(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return util.CountBackupsByVersion(files), nil
+}
+
+// LastBackupTime returns the completion time recorded in the metadata of the latest backup file,
+// or its last modified time if none is recorded.
+func (absr *absReader) LastBackupTime(ctx context.Context, path string) (time.Time, error) {
+	ctx, cancel := context.WithTimeout(ctx, absr.timeout)
+	defer cancel()
+
+	container, files, err := absr.listBackupFiles(ctx, path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	latest, ok := util.GetLatestBackupFileByDate(files)
+	if !ok {
+		return time.Time{}, util.ErrNoBackups
+	}
+
+	blob := absr.abs.GetContainerReference(container).GetBlobReference(latest.Name)
+	err = absr.do(ctx, func() error {
+		return blob.GetMetadata(&storage.GetBlobMetadataOptions{})
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+	if completed, ok := blob.Metadata[util.MetadataCompleted]; ok {
+		t, err := time.Parse(time.RFC3339Nano, completed)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid completion time (%v) of backup %v: %v", completed, latest.Name, err)
+		}
+		return t, nil
+	}
+	return latest.LastModified, nil
+}
+
+// HealthCheck checks that the container of path exists and that its blobs can be listed.
+// It makes a single attempt of each request so that it fails fast.
+func (absr *absReader) HealthCheck(ctx context.Context, path string) error {
+	container, _, err := util.ParseBucketAndKey(path)
+	if err != nil {
+		return fmt.Errorf("failed to parse abs container and key: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, absr.timeout)
+	defer cancel()
+
+	once := util.RetryPolicy{MaxAttempts: 1}
+	var containerRef *storage.Container
+	err = once.Do(ctx, func() error {
+		ref, err := util.GetContainer(absr.abs, container)
+		if err != nil {
+			return err
+		}
+		containerRef = ref
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return once.Do(ctx, func() error {
+		_, err := containerRef.ListBlobs(storage.ListBlobsParameters{MaxResults: 1})
+		return err
+	})
+}
+
+// listBackupFiles lists the backup files saved with revision appended to the given abs path
+// and returns them along with the container name.
+func (absr *absReader) listBackupFiles(ctx context.Context, path string) (string, []util.BackupFile, error) {
+	container, key, err := util.ParseBucketAndKey(path)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to parse abs container and key: %v", err)
+	}
+
+	containerRef, err := absr.getContainer(ctx, container)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var files []util.BackupFile
+	err = absr.do(ctx, func() error {
+		list, err := util.ListBackupFiles(containerRef, key)
+		if err != nil {
+			return err
+		}
+		files = list
+		return nil
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return container, files, nil
+}
+
+// ListByTag returns the paths of the backup files saved with revision appended to path tagged with key and value,
+// in the format "<abs-container-name>/<key>". Since tags are saved as blob metadata, it lists the metadata
+// of every backup file under path instead of querying a blob index.
+func (absr *absReader) ListByTag(ctx context.Context, path, key, value string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, absr.timeout)
+	defer cancel()
+
+	container, prefix, err := util.ParseBucketAndKey(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse abs container and key: %v", err)
+	}
+	containerRef, err := absr.getContainer(ctx, container)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := []string{}
+	params := storage.ListBlobsParameters{Prefix: prefix + "_", Include: &storage.IncludeBlobDataset{Metadata: true}}
+	for {
+		var resp storage.BlobListResponse
+		err = absr.do(ctx, func() error {
+			list, err := containerRef.ListBlobs(params)
+			if err != nil {
+				return err
+			}
+			resp = list
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, blob := range resp.Blobs {
+			if util.IsTmpFile(blob.Name) {
+				continue
+			}
+			if v, ok := blob.Metadata[util.TagMetadataKey(key)]; ok && v == value {
+				paths = append(paths, container+"/"+blob.Name)
+			}
+		}
+		if len(resp.NextMarker) == 0 {
+			return paths, nil
+		}
+		params.Marker = resp.NextMarker
+	}
+}
+
+// ListWithMarker returns a page of at most max paths of the backup files saved with revision appended to path,
+// in the format "<abs-container-name>/<key>", and the ABS continuation marker of the next page.
+// Temporary blobs of interrupted saves count towards max but are left out, so a page may have fewer than max paths
+// even if it is not the last one.
+func (absr *absReader) ListWithMarker(ctx context.Context, path, marker string, max int) ([]string, string, error) {
+	if max <= 0 {
+		return nil, "", fmt.Errorf("invalid max results: %d", max)
+	}
+	ctx, cancel := context.WithTimeout(ctx, absr.timeout)
+	defer cancel()
+
+	container, prefix, err := util.ParseBucketAndKey(path)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to parse abs container and key: %v", err)
+	}
+	containerRef, err := absr.getContainer(ctx, container)
+	if err != nil {
+		return nil, "", err
+	}
+
+	params := storage.ListBlobsParameters{Prefix: prefix + "_", Marker: marker, MaxResults: uint(max)}
+	var resp storage.BlobListResponse
+	err = absr.do(ctx, func() error {
+		list, err := containerRef.ListBlobs(params)
+		if err != nil {
+			return err
+		}
+		resp = list
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	names := []string{}
+	for _, blob := range resp.Blobs {
+		if !util.IsTmpFile(blob.Name) {
+			names = append(names, container+"/"+blob.Name)
+		}
+	}
+	return names, resp.NextMarker, nil
+}
+
+// VerifyAll compares the backup files recorded in the backup index of path, saved by the abs writer,
+// with a single listing of the blobs under path, so that missing or truncated backups are found without
+// downloading them. The returned paths are in the format "<abs-container-name>/<key>", sorted by name.
+// It returns an empty list if path has no index.
+func (absr *absReader) VerifyAll(ctx context.Context, path string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, absr.timeout)
+	defer cancel()
+
+	container, prefix, err := util.ParseBucketAndKey(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse abs container and key: %v", err)
+	}
+	containerRef, err := absr.getContainer(ctx, container)
+	if err != nil {
+		return nil, err
+	}
+
+	var idx util.BackupIndex
+	err = absr.do(ctx, func() error {
+		rc, err := containerRef.GetBlobReference(prefix + util.IndexSuffix).Get(&storage.GetBlobOptions{})
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		var decoded util.BackupIndex
+		if err := json.NewDecoder(rc).Decode(&decoded); err != nil {
+			return err
+		}
+		idx = decoded
+		return nil
+	})
+	if util.HasStatusCode(err, http.StatusNotFound) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup index: %v", err)
+	}
+
+	blobs := make(map[string]storage.Blob)
+	params := storage.ListBlobsParameters{Prefix: prefix + "_", Include: &storage.IncludeBlobDataset{Metadata: true}}
+	for {
+		var resp storage.BlobListResponse
+		err = absr.do(ctx, func() error {
+			list, err := containerRef.ListBlobs(params)
+			if err != nil {
+				return err
+			}
+			resp = list
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, blob := range resp.Blobs {
+			blobs[blob.Name] = blob
+		}
+		if len(resp.NextMarker) == 0 {
+			break
+		}
+		params.Marker = resp.NextMarker
+	}
+
+	suspects := []string{}
+	for _, e := range idx.Backups {
+		blob, ok := blobs[e.Name]
+		if !ok || blob.Properties.ContentLength != e.Size {
+			suspects = append(suspects, container+"/"+e.Name)
+			continue
+		}
+		if checksum, ok := blob.Metadata[util.MetadataSHA256]; ok && checksum != e.SHA256 {
+			suspects = append(suspects, container+"/"+e.Name)
+		}
+	}
+	sort.Strings(suspects)
+	return suspects, nil
+}
+
+// decrypt returns a ReadCloser of the plaintext of the encrypted backup read from rc, decrypted as it is read.
+func (absr *absReader) decrypt(rc io.ReadCloser) (io.ReadCloser, error) {
+	r, err := util.NewDecryptReader(absr.encryptionKey, rc)
+	if err != nil {
+		rc.Close()
+		return nil, fmt.Errorf("failed to decrypt backup: %v", err)
+	}
+	return &decryptReadCloser{Reader: r, Closer: rc}, nil
+}
+
+type decryptReadCloser struct {
+	io.Reader
+	io.Closer
 }
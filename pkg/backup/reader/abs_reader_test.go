@@ -0,0 +1,400 @@
+// Copyright 2018 The etcd-operator Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reader
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/storage"
+	"github.com/coreos/etcd-operator/pkg/backup/util"
+	"github.com/pborman/uuid"
+	"github.com/pkg/errors"
+)
+
+// newTestABSClient returns an ABS client of the storage account given by the
+// TEST_AZURE_STORAGE_ACCOUNT and TEST_AZURE_STORAGE_KEY environment variables,
+// and skips the test if they are not set.
+func newTestABSClient(t *testing.T) *storage.BlobStorageClient {
+	account, key := os.Getenv("TEST_AZURE_STORAGE_ACCOUNT"), os.Getenv("TEST_AZURE_STORAGE_KEY")
+	if len(account) == 0 || len(key) == 0 {
+		t.Skip("TEST_AZURE_STORAGE_ACCOUNT and TEST_AZURE_STORAGE_KEY are not set")
+	}
+	cli, err := storage.NewBasicClient(account, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	abs := cli.GetBlobService()
+	return &abs
+}
+
+// fakeTransport answers every storage request with the response of respond.
+type fakeTransport struct {
+	respond func(req *http.Request) *http.Response
+}
+
+func (f *fakeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp := f.respond(req)
+	resp.Request = req
+	return resp, nil
+}
+
+// newFakeABSClient returns an ABS client sending its requests to transport instead of Azure.
+func newFakeABSClient(t *testing.T, transport http.RoundTripper) *storage.BlobStorageClient {
+	cli, err := storage.NewClient("fakeaccount", "ZmFrZWtleQ==", storage.DefaultBaseURL, storage.DefaultAPIVersion, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cli.HTTPClient = &http.Client{Transport: transport}
+	abs := cli.GetBlobService()
+	return &abs
+}
+
+func TestABSReaderStorageErrorRequestID(t *testing.T) {
+	const requestID = "5f6a3c1e-601e-0042-1b7e-0a5c2e000000"
+	abs := newFakeABSClient(t, &fakeTransport{respond: func(req *http.Request) *http.Response {
+		header := http.Header{}
+		header.Set("x-ms-request-id", requestID)
+		return &http.Response{
+			StatusCode: http.StatusForbidden,
+			Status:     "403 Forbidden",
+			Header:     header,
+			Body:       ioutil.NopCloser(strings.NewReader("")),
+		}
+	}})
+
+	_, err := NewABSReader(abs, nil, 0).Exists(context.Background(), "backups/etcd.backup")
+	if err == nil {
+		t.Fatal("expect the request to fail")
+	}
+	if !strings.Contains(err.Error(), requestID) {
+		t.Errorf("expect request id %v in the error message, get=%v", requestID, err)
+	}
+	serr, ok := util.AsStorageError(err)
+	if !ok {
+		t.Fatalf("expect a *util.StorageError, get=%T", err)
+	}
+	if serr.RequestID != requestID || serr.StatusCode != http.StatusForbidden {
+		t.Errorf("expect request id=%v and status code=%d, get=%+v", requestID, http.StatusForbidden, serr)
+	}
+}
+
+func TestABSReaderRetryBudget(t *testing.T) {
+	requests := 0
+	abs := newFakeABSClient(t, &fakeTransport{respond: func(req *http.Request) *http.Response {
+		requests++
+		return &http.Response{
+			StatusCode: http.StatusServiceUnavailable,
+			Status:     "503 Service Unavailable",
+			Header:     http.Header{},
+			Body:       ioutil.NopCloser(strings.NewReader("")),
+		}
+	}})
+	r := NewABSReader(abs, nil, 0).(*absReader)
+	r.retry.Sleep = func(ctx context.Context, d time.Duration) error { return nil }
+
+	ctx := util.WithRetryBudget(context.Background(), util.NewRetryBudget(2))
+	for i := 0; i < 3; i++ {
+		if _, err := r.Exists(ctx, "backups/etcd.backup"); err == nil {
+			t.Fatalf("#%d: expect the request to fail", i)
+		}
+	}
+	// Without the budget, each of the 3 operations would be tried util.DefaultRetryPolicy.MaxAttempts times.
+	if requests != 3+2 {
+		t.Errorf("expect %d requests, get=%d", 3+2, requests)
+	}
+}
+
+func TestABSReaderRetryAfter(t *testing.T) {
+	requests := 0
+	transport := &fakeTransport{respond: func(req *http.Request) *http.Response {
+		requests++
+		if requests > 1 {
+			return &http.Response{StatusCode: http.StatusOK, Status: "200 OK", Header: http.Header{}, Body: ioutil.NopCloser(strings.NewReader(""))}
+		}
+		header := http.Header{}
+		header.Set("x-ms-request-id", uuid.New())
+		header.Set("Retry-After", "7")
+		return &http.Response{
+			StatusCode: http.StatusTooManyRequests,
+			Status:     "429 Too Many Requests",
+			Header:     header,
+			Body:       ioutil.NopCloser(strings.NewReader("")),
+		}
+	}}
+	r := NewABSReader(newFakeABSClient(t, util.NewThrottleTransport(transport)), nil, time.Minute).(*absReader)
+	var delays []time.Duration
+	r.retry.Sleep = func(ctx context.Context, d time.Duration) error {
+		delays = append(delays, d)
+		return nil
+	}
+
+	exists, err := r.Exists(context.Background(), "backups/etcd.backup")
+	if err != nil || !exists {
+		t.Fatalf("expect the retried request to succeed, get exists=%v (err=%v)", exists, err)
+	}
+	if len(delays) != 1 || delays[0] != 7*time.Second {
+		t.Errorf("expect a single wait of the Retry-After delay, get=%v", delays)
+	}
+}
+
+func TestABSReaderHealthCheck(t *testing.T) {
+	abs := newTestABSClient(t)
+	name := "etcd-operator-test-" + strings.ToLower(uuid.New())[:8]
+	containerRef := abs.GetContainerReference(name)
+	if err := containerRef.Create(&storage.CreateContainerOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	defer containerRef.Delete(&storage.DeleteContainerOptions{})
+
+	r := NewABSReader(abs, nil, 0)
+	if err := r.HealthCheck(context.Background(), name+"/etcd.backup"); err != nil {
+		t.Errorf("expect no error for an existing container, get=%v", err)
+	}
+	err := r.HealthCheck(context.Background(), name+"-missing/etcd.backup")
+	if !util.IsContainerNotFound(err) {
+		t.Errorf("expect container not found error for a missing container, get=%v", err)
+	}
+}
+
+func TestABSReaderExists(t *testing.T) {
+	abs := newTestABSClient(t)
+	name := "etcd-operator-test-" + strings.ToLower(uuid.New())[:8]
+	containerRef := abs.GetContainerReference(name)
+	if err := containerRef.Create(&storage.CreateContainerOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	defer containerRef.Delete(&storage.DeleteContainerOptions{})
+
+	blob := containerRef.GetBlobReference("etcd.backup")
+	if err := blob.CreateBlockBlobFromReader(strings.NewReader("backup"), &storage.PutBlobOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewABSReader(abs, nil, 0)
+	if exists, err := r.Exists(context.Background(), name+"/etcd.backup"); err != nil || !exists {
+		t.Errorf("expect saved blob to exist, exists=%v err=%v", exists, err)
+	}
+	if exists, err := r.Exists(context.Background(), name+"/"+uuid.New()); err != nil || exists {
+		t.Errorf("expect random blob not to exist, exists=%v err=%v", exists, err)
+	}
+}
+
+func TestABSReaderGetProperties(t *testing.T) {
+	abs := newTestABSClient(t)
+	name := "etcd-operator-test-" + strings.ToLower(uuid.New())[:8]
+	containerRef := abs.GetContainerReference(name)
+	if err := containerRef.Create(&storage.CreateContainerOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	defer containerRef.Delete(&storage.DeleteContainerOptions{})
+
+	data := "backup"
+	blob := containerRef.GetBlobReference("etcd.backup")
+	if err := blob.CreateBlockBlobFromReader(strings.NewReader(data), &storage.PutBlobOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := NewABSReader(abs, nil, 0).(ABSPropertiesGetter).GetProperties(context.Background(), name+"/etcd.backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ContentLength != int64(len(data)) {
+		t.Errorf("expect size=%d, get=%d", len(data), info.ContentLength)
+	}
+	if info.BlobType != storage.BlobTypeBlock {
+		t.Errorf("expect blob type=%v, get=%v", storage.BlobTypeBlock, info.BlobType)
+	}
+	if info.LastModified.IsZero() {
+		t.Errorf("expect last modified time to be set")
+	}
+}
+
+func TestABSReaderOpenRange(t *testing.T) {
+	abs := newTestABSClient(t)
+	name := "etcd-operator-test-" + strings.ToLower(uuid.New())[:8]
+	containerRef := abs.GetContainerReference(name)
+	if err := containerRef.Create(&storage.CreateContainerOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	defer containerRef.Delete(&storage.DeleteContainerOptions{})
+
+	data := []byte("first half|second half")
+	blob := containerRef.GetBlobReference("etcd.backup")
+	if err := blob.CreateBlockBlobFromReader(bytes.NewReader(data), &storage.PutBlobOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewABSReader(abs, nil, 0)
+	rc, err := r.OpenRange(context.Background(), name+"/etcd.backup", 11)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	got, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data[11:]) {
+		t.Errorf("expect content from offset=%q, get=%q", data[11:], got)
+	}
+}
+
+func TestABSVerifyingReaderCorrupted(t *testing.T) {
+	abs := newTestABSClient(t)
+	name := "etcd-operator-test-" + strings.ToLower(uuid.New())[:8]
+	containerRef := abs.GetContainerReference(name)
+	if err := containerRef.Create(&storage.CreateContainerOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	defer containerRef.Delete(&storage.DeleteContainerOptions{})
+
+	// Store the checksum of the original content along with corrupted content.
+	sum := sha256.Sum256([]byte("backup"))
+	blob := containerRef.GetBlobReference("etcd.backup")
+	if err := blob.CreateBlockBlobFromReader(strings.NewReader("corrupted"), &storage.PutBlobOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	blob.Metadata = storage.BlobMetadata{util.MetadataSHA256: hex.EncodeToString(sum[:])}
+	if err := blob.SetMetadata(&storage.SetBlobMetadataOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	rc, err := NewABSReader(abs, nil, 0).Open(context.Background(), name+"/etcd.backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(rc); err != nil {
+		t.Errorf("expect no error without verification, get=%v", err)
+	}
+	rc.Close()
+
+	rc, err = NewABSVerifyingReader(abs, nil, 0).Open(context.Background(), name+"/etcd.backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	if _, err := ioutil.ReadAll(rc); errors.Cause(err) != util.ErrChecksumMismatch {
+		t.Errorf("expect error caused by %v, get=%v", util.ErrChecksumMismatch, err)
+	}
+}
+
+func TestABSReaderOpenDetectsGzip(t *testing.T) {
+	abs := newTestABSClient(t)
+	name := "etcd-operator-test-" + strings.ToLower(uuid.New())[:8]
+	containerRef := abs.GetContainerReference(name)
+	if err := containerRef.Create(&storage.CreateContainerOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	defer containerRef.Delete(&storage.DeleteContainerOptions{})
+
+	data := []byte("backup")
+	compressed, err := ioutil.ReadAll(util.CompressReader(bytes.NewReader(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The compressed backup is saved without the util.GzipSuffix.
+	blobs := map[string][]byte{"etcd.backup_compressed": compressed, "etcd.backup_plain": data}
+	for blobName, content := range blobs {
+		blob := containerRef.GetBlobReference(blobName)
+		if err := blob.CreateBlockBlobFromReader(bytes.NewReader(content), &storage.PutBlobOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := NewABSReader(abs, nil, 0)
+	for blobName, content := range blobs {
+		rc, err := r.Open(context.Background(), name+"/"+blobName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("%s: expect content=%q, get=%q", blobName, data, got)
+		}
+
+		rc, err = r.(ABSRawOpener).OpenRaw(context.Background(), name+"/"+blobName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err = ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, content) {
+			t.Errorf("%s: expect raw content=%q, get=%q", blobName, content, got)
+		}
+	}
+}
+
+func TestABSReaderListWithMarker(t *testing.T) {
+	abs := newTestABSClient(t)
+	name := "etcd-operator-test-" + strings.ToLower(uuid.New())[:8]
+	containerRef := abs.GetContainerReference(name)
+	if err := containerRef.Create(&storage.CreateContainerOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	defer containerRef.Delete(&storage.DeleteContainerOptions{})
+
+	want := map[string]bool{}
+	for i := 0; i < 5; i++ {
+		blobName := "etcd.backup_" + util.MakeBackupName("3.2.13", int64(i))
+		blob := containerRef.GetBlobReference(blobName)
+		if err := blob.CreateBlockBlobFromReader(strings.NewReader("backup"), &storage.PutBlobOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		want[name+"/"+blobName] = true
+	}
+
+	r := NewABSReader(abs, nil, 0).(ABSMarkerLister)
+	names, marker, err := r.ListWithMarker(context.Background(), name+"/etcd.backup", "", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 3 || len(marker) == 0 {
+		t.Fatalf("expect 3 names and a marker, get=%v, marker=%q", names, marker)
+	}
+	rest, marker, err := r.ListWithMarker(context.Background(), name+"/etcd.backup", marker, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(marker) != 0 {
+		t.Errorf("expect no marker after the last page, get=%q", marker)
+	}
+	got := map[string]bool{}
+	for _, n := range append(names, rest...) {
+		if got[n] {
+			t.Errorf("expect no duplicates, get %s twice", n)
+		}
+		got[n] = true
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expect names=%v, get=%v", want, got)
+	}
+}
@@ -0,0 +1,204 @@
+// Copyright 2018 The etcd-operator Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reader
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// ChecksumGetter gets the checksums stored along with backups without downloading them.
+type ChecksumGetter interface {
+	// Checksum returns the hex encoded SHA-256 checksum stored along with the backup file on path,
+	// or "" if it was saved without one.
+	Checksum(ctx context.Context, path string) (string, error)
+}
+
+// cacheEntry is a backup file cached on disk.
+type cacheEntry struct {
+	key  string
+	size int64
+	// sha256 is the checksum of the cached content, checked before the entry is served.
+	sha256 [sha256.Size]byte
+}
+
+type cachingReader struct {
+	Reader
+	checksums ChecksumGetter
+	dir       string
+	maxBytes  int64
+
+	mu sync.Mutex
+	// lru holds the cached entries from the most to the least recently used.
+	lru     *list.List
+	entries map[string]*list.Element
+	size    int64
+}
+
+// NewCachingReader returns a Reader of r which keeps the content of the backup files it opens under dir,
+// so that opening them again reads them from disk instead of downloading them.
+// Backup files are cached under their path and stored checksum, so a backup file saved again under the same path
+// is downloaded again. The least recently opened backup files are evicted once the cache exceeds maxBytes.
+// Cached content is checked against the checksum it was cached with before it is served, and downloaded again
+// if it does not match. If r does not implement ChecksumGetter, r is returned as is, and backup files
+// saved without a checksum are never cached.
+func NewCachingReader(r Reader, dir string, maxBytes int64) Reader {
+	checksums, ok := r.(ChecksumGetter)
+	if !ok {
+		return r
+	}
+	return &cachingReader{
+		Reader:    r,
+		checksums: checksums,
+		dir:       dir,
+		maxBytes:  maxBytes,
+		lru:       list.New(),
+		entries:   make(map[string]*list.Element),
+	}
+}
+
+// Open serves the backup file on path from the cache if it is cached, and otherwise caches it as it is read.
+// Backup files are only cached once read to the end.
+func (cr *cachingReader) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	checksum, err := cr.checksums.Checksum(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if len(checksum) == 0 {
+		return cr.Reader.Open(ctx, path)
+	}
+	sum := sha256.Sum256([]byte(path + "\x00" + checksum))
+	key := hex.EncodeToString(sum[:])
+	if rc, ok := cr.get(key); ok {
+		return rc, nil
+	}
+
+	rc, err := cr.Reader.Open(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(cr.dir, 0700); err != nil {
+		return rc, nil
+	}
+	tmp, err := ioutil.TempFile(cr.dir, key+".tmp")
+	if err != nil {
+		return rc, nil
+	}
+	return &cacheFillReadCloser{cr: cr, key: key, rc: rc, tmp: tmp, h: sha256.New()}, nil
+}
+
+// get returns the content of the cached entry of key, checked against its checksum.
+// Entries failing the check are removed.
+func (cr *cachingReader) get(key string) (io.ReadCloser, bool) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	el, ok := cr.entries[key]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*cacheEntry)
+	data, err := ioutil.ReadFile(cr.entryPath(key))
+	if err != nil || int64(len(data)) != e.size || sha256.Sum256(data) != e.sha256 {
+		cr.removeLocked(el)
+		return nil, false
+	}
+	cr.lru.MoveToFront(el)
+	return ioutil.NopCloser(bytes.NewReader(data)), true
+}
+
+// add moves the fully read temporary file tmpPath into the cache as the entry of key,
+// evicting the least recently used entries to stay within maxBytes.
+func (cr *cachingReader) add(key, tmpPath string, size int64, sum [sha256.Size]byte) error {
+	if size > cr.maxBytes {
+		return fmt.Errorf("backup of %d bytes exceeds the cache size", size)
+	}
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	if el, ok := cr.entries[key]; ok {
+		cr.removeLocked(el)
+	}
+	if err := os.Rename(tmpPath, cr.entryPath(key)); err != nil {
+		return err
+	}
+	cr.entries[key] = cr.lru.PushFront(&cacheEntry{key: key, size: size, sha256: sum})
+	cr.size += size
+	for cr.size > cr.maxBytes {
+		cr.removeLocked(cr.lru.Back())
+	}
+	return nil
+}
+
+func (cr *cachingReader) removeLocked(el *list.Element) {
+	e := cr.lru.Remove(el).(*cacheEntry)
+	delete(cr.entries, e.key)
+	cr.size -= e.size
+	os.Remove(cr.entryPath(e.key))
+}
+
+func (cr *cachingReader) entryPath(key string) string {
+	return filepath.Join(cr.dir, key)
+}
+
+// cacheFillReadCloser copies the content of a downloaded backup file to a temporary file,
+// which is added to the cache once the content is read to the end.
+type cacheFillReadCloser struct {
+	cr   *cachingReader
+	key  string
+	rc   io.ReadCloser
+	tmp  *os.File
+	h    hash.Hash
+	size int64
+	// failed is set once the content can't be cached.
+	failed bool
+}
+
+func (c *cacheFillReadCloser) Read(p []byte) (int, error) {
+	n, err := c.rc.Read(p)
+	if !c.failed {
+		if _, werr := c.tmp.Write(p[:n]); werr != nil {
+			c.failed = true
+		}
+		c.h.Write(p[:n])
+		c.size += int64(n)
+	}
+	if err == io.EOF && !c.failed {
+		c.failed = true // the content is cached at most once
+		var sum [sha256.Size]byte
+		copy(sum[:], c.h.Sum(nil))
+		if c.tmp.Close() == nil && c.cr.add(c.key, c.tmp.Name(), c.size, sum) == nil {
+			c.tmp = nil
+		}
+	}
+	return n, err
+}
+
+func (c *cacheFillReadCloser) Close() error {
+	if c.tmp != nil {
+		c.tmp.Close()
+		os.Remove(c.tmp.Name())
+		c.tmp = nil
+	}
+	return c.rc.Close()
+}
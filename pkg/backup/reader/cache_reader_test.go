@@ -0,0 +1,124 @@
+// Copyright 2018 The etcd-operator Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reader
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// countingReader is a Reader of an FS root which counts the backup files it opens.
+type countingReader struct {
+	Reader
+	checksum string
+	opens    int
+}
+
+func (cr *countingReader) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	cr.opens++
+	return cr.Reader.Open(ctx, path)
+}
+
+func (cr *countingReader) Checksum(ctx context.Context, path string) (string, error) {
+	return cr.checksum, nil
+}
+
+func readAll(t *testing.T, r Reader, path string) string {
+	rc, err := r.Open(context.Background(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	data, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestCachingReaderOpen(t *testing.T) {
+	root, err := ioutil.TempDir("", "cache-reader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	if err := ioutil.WriteFile(filepath.Join(root, "etcd.backup"), []byte("backup"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cr := &countingReader{Reader: NewFSReader(root), checksum: "checksum"}
+	cacheDir := filepath.Join(root, "cache")
+	r := NewCachingReader(cr, cacheDir, 1024)
+	for i := 0; i < 2; i++ {
+		if got := readAll(t, r, "etcd.backup"); got != "backup" {
+			t.Fatalf("expect content=%q, get=%q", "backup", got)
+		}
+	}
+	if cr.opens != 1 {
+		t.Errorf("expect 1 download, get=%d", cr.opens)
+	}
+
+	// Corrupted cached content is downloaded again.
+	files, err := ioutil.ReadDir(cacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("expect 1 cached file, get=%d", len(files))
+	}
+	if err := ioutil.WriteFile(filepath.Join(cacheDir, files[0].Name()), []byte("bakcup"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if got := readAll(t, r, "etcd.backup"); got != "backup" {
+		t.Fatalf("expect content=%q, get=%q", "backup", got)
+	}
+	if cr.opens != 2 {
+		t.Errorf("expect 2 downloads, get=%d", cr.opens)
+	}
+
+	// A backup saved again under the same path has another checksum and is downloaded again.
+	cr.checksum = "other"
+	readAll(t, r, "etcd.backup")
+	if cr.opens != 3 {
+		t.Errorf("expect 3 downloads, get=%d", cr.opens)
+	}
+}
+
+func TestCachingReaderEviction(t *testing.T) {
+	root, err := ioutil.TempDir("", "cache-reader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	for _, name := range []string{"a", "b"} {
+		if err := ioutil.WriteFile(filepath.Join(root, name), []byte("backup"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cr := &countingReader{Reader: NewFSReader(root), checksum: "checksum"}
+	// The cache only fits one of the backups.
+	r := NewCachingReader(cr, filepath.Join(root, "cache"), 10)
+	for _, name := range []string{"a", "b", "b", "a"} {
+		readAll(t, r, name)
+	}
+	if cr.opens != 3 {
+		t.Errorf("expect 3 downloads, get=%d", cr.opens)
+	}
+}
@@ -0,0 +1,259 @@
+// Copyright 2018 The etcd-operator Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/coreos/etcd-operator/pkg/backup/util"
+)
+
+// ensure fsReader satisfies reader interface.
+var _ Reader = &fsReader{}
+
+// fsReader provides Reader implementation for reading a backup file under a local directory.
+type fsReader struct {
+	root string
+}
+
+// NewFSReader creates a reader of backup files under the root directory.
+func NewFSReader(root string) Reader {
+	return &fsReader{root}
+}
+
+// Open opens the file on path relative to the root directory.
+func (fsr *fsReader) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	fpath, err := util.ParseFilePath(fsr.root, path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(fpath)
+	if err != nil {
+		return nil, err
+	}
+	return util.NewContextReadCloser(ctx, f), nil
+}
+
+// OpenRange opens the file on path relative to the root directory for reading from offset.
+func (fsr *fsReader) OpenRange(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
+	if offset < 0 {
+		return nil, fmt.Errorf("invalid offset: %d", offset)
+	}
+	fpath, err := util.ParseFilePath(fsr.root, path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(fpath)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return util.NewContextReadCloser(ctx, f), nil
+}
+
+// Exists checks whether the file on path exists.
+func (fsr *fsReader) Exists(ctx context.Context, path string) (bool, error) {
+	fpath, err := util.ParseFilePath(fsr.root, path)
+	if err != nil {
+		return false, err
+	}
+	_, err = os.Stat(fpath)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// OpenLimited opens the file on path like Open, failing if it is larger than maxBytes.
+func (fsr *fsReader) OpenLimited(ctx context.Context, path string, maxBytes int64) (io.ReadCloser, error) {
+	fpath, err := util.ParseFilePath(fsr.root, path)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(fpath)
+	if err != nil {
+		return nil, err
+	}
+	if fi.Size() > maxBytes {
+		return nil, util.ErrBackupTooLarge
+	}
+
+	rc, err := fsr.Open(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return util.NewLimitedReadCloser(rc, maxBytes), nil
+}
+
+// Latest returns the path of the latest backup file by modification time saved with revision appended to path.
+func (fsr *fsReader) Latest(ctx context.Context, path string) (string, error) {
+	files, err := fsr.listBackupFiles(path)
+	if err != nil {
+		return "", err
+	}
+	name := util.GetLatestBackupNameByDate(files)
+	if len(name) == 0 {
+		return "", util.ErrNoBackups
+	}
+	return filepath.Rel(filepath.Clean(fsr.root), name)
+}
+
+// NthLatest returns the path of the n-th latest backup file by modification time saved with revision appended to path.
+func (fsr *fsReader) NthLatest(ctx context.Context, path string, n int) (string, error) {
+	files, err := fsr.listBackupFiles(path)
+	if err != nil {
+		return "", err
+	}
+	f, ok := util.GetNthLatestBackupFileByDate(files, n)
+	if !ok {
+		return "", util.ErrNoBackups
+	}
+	return filepath.Rel(filepath.Clean(fsr.root), f.Name)
+}
+
+// LatestOfCluster returns the path of the latest backup file by modification time of the etcd cluster named clusterName.
+func (fsr *fsReader) LatestOfCluster(ctx context.Context, path, clusterName string) (string, error) {
+	files, err := fsr.listBackupFiles(path)
+	if err != nil {
+		return "", err
+	}
+	name := util.GetLatestBackupNameByDate(util.BackupFilesOfCluster(files, clusterName))
+	if len(name) == 0 {
+		return "", util.ErrNoBackups
+	}
+	return filepath.Rel(filepath.Clean(fsr.root), name)
+}
+
+// ByRevision returns the path of the backup file whose revision is the largest one less than or equal to rev.
+func (fsr *fsReader) ByRevision(ctx context.Context, path string, rev uint64) (string, error) {
+	files, err := fsr.listBackupFiles(path)
+	if err != nil {
+		return "", err
+	}
+	name := util.GetBackupNameByRevision(files, rev)
+	if len(name) == 0 {
+		return "", util.ErrNoBackups
+	}
+	return filepath.Rel(filepath.Clean(fsr.root), name)
+}
+
+// ListPage returns a page of the paths of the backup files sorted by date, relative to the root directory.
+func (fsr *fsReader) ListPage(ctx context.Context, path string, sortDesc bool, limit, offset int) ([]string, error) {
+	files, err := fsr.listBackupFiles(path)
+	if err != nil {
+		return nil, err
+	}
+	paths := []string{}
+	for _, f := range util.PageBackupFiles(files, sortDesc, limit, offset) {
+		p, err := filepath.Rel(filepath.Clean(fsr.root), f.Name)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, p)
+	}
+	return paths, nil
+}
+
+// WalkBackups calls fn for each backup file saved with revision appended to path.
+// Names passed to fn are file paths including the root directory.
+func (fsr *fsReader) WalkBackups(ctx context.Context, path string, fn func(util.BackupInfo) error) error {
+	files, err := fsr.listBackupFiles(path)
+	if err != nil {
+		return err
+	}
+	return util.WalkBackupFiles(files, fn)
+}
+
+// Total returns the number of backup files saved with revision appended to path.
+func (fsr *fsReader) Total(ctx context.Context, path string) (int, error) {
+	files, err := fsr.listBackupFiles(path)
+	if err != nil {
+		return 0, err
+	}
+	return len(files), nil
+}
+
+// TotalBytes returns the total size in bytes of the backup files saved with revision appended to path.
+func (fsr *fsReader) TotalBytes(ctx context.Context, path string) (int64, error) {
+	files, err := fsr.listBackupFiles(path)
+	if err != nil {
+		return 0, err
+	}
+	return util.TotalSize(files), nil
+}
+
+// ListVersions returns the number of backup files of each etcd version.
+func (fsr *fsReader) ListVersions(ctx context.Context, path string) (map[string]int, error) {
+	files, err := fsr.listBackupFiles(path)
+	if err != nil {
+		return nil, err
+	}
+	return util.CountBackupsByVersion(files), nil
+}
+
+// LastBackupTime returns the modification time of the latest backup file.
+func (fsr *fsReader) LastBackupTime(ctx context.Context, path string) (time.Time, error) {
+	files, err := fsr.listBackupFiles(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	latest, ok := util.GetLatestBackupFileByDate(files)
+	if !ok {
+		return time.Time{}, util.ErrNoBackups
+	}
+	return latest.LastModified, nil
+}
+
+// HealthCheck checks that the directory of path exists.
+func (fsr *fsReader) HealthCheck(ctx context.Context, path string) error {
+	fpath, err := util.ParseFilePath(fsr.root, path)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(fpath)
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("%v is not a directory", dir)
+	}
+	return nil
+}
+
+// listBackupFiles lists the backup files saved with revision appended to the given path.
+func (fsr *fsReader) listBackupFiles(path string) ([]util.BackupFile, error) {
+	fpath, err := util.ParseFilePath(fsr.root, path)
+	if err != nil {
+		return nil, err
+	}
+	return util.ListLocalBackupFiles(fpath)
+}
+
+// Verify always returns true since no checksum is stored along with local backup files.
+func (fsr *fsReader) Verify(ctx context.Context, path string) (bool, error) {
+	return true, nil
+}
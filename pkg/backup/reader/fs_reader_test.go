@@ -0,0 +1,522 @@
+// Copyright 2018 The etcd-operator Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd-operator/pkg/backup/util"
+)
+
+func TestFSReaderLatest(t *testing.T) {
+	root, err := ioutil.TempDir("", "fs-reader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	r := NewFSReader(root)
+	_, err = r.Latest(context.Background(), "cluster-a/etcd.backup")
+	if err != util.ErrNoBackups {
+		t.Fatalf("expect error=%v, get=%v", util.ErrNoBackups, err)
+	}
+
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		path := filepath.Join(root, "cluster-a", "etcd.backup_"+util.MakeBackupName("3.2.13", int64(i)))
+		writeBackupFile(t, path, now.Add(time.Duration(i)*time.Minute))
+	}
+
+	// A save interrupted while writing a newer backup leaves its temporary file behind.
+	tmpPath := filepath.Join(root, "cluster-a", "etcd.backup_"+util.MakeBackupName("3.2.13", 3)+util.TmpSuffix)
+	writeBackupFile(t, tmpPath, now.Add(time.Hour))
+
+	latest, err := r.Latest(context.Background(), "cluster-a/etcd.backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "cluster-a/etcd.backup_" + util.MakeBackupName("3.2.13", 2)
+	if latest != want {
+		t.Errorf("expect latest=%v, get=%v", want, latest)
+	}
+}
+
+func writeBackupFile(t *testing.T, path string, mtime time.Time) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(path), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFSReaderTotalBytes(t *testing.T) {
+	root, err := ioutil.TempDir("", "fs-reader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	for i, size := range []int{1024, 17} {
+		path := filepath.Join(root, "cluster-a", "etcd.backup_"+util.MakeBackupName("3.2.13", int64(i)))
+		if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(path, make([]byte, size), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := NewFSReader(root)
+	total, err := r.Total(context.Background(), "cluster-a/etcd.backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 2 {
+		t.Errorf("expect total=2, get=%d", total)
+	}
+	size, err := r.TotalBytes(context.Background(), "cluster-a/etcd.backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != 1024+17 {
+		t.Errorf("expect total bytes=%d, get=%d", 1024+17, size)
+	}
+}
+
+func TestFSReaderNthLatest(t *testing.T) {
+	root, err := ioutil.TempDir("", "fs-reader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	now := time.Now()
+	var names []string
+	for i := 0; i < 4; i++ {
+		name := "etcd.backup_" + util.MakeBackupName("3.2.13", int64(i))
+		fpath := filepath.Join(root, name)
+		if err := ioutil.WriteFile(fpath, []byte("backup"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		mtime := now.Add(time.Duration(i) * time.Minute)
+		if err := os.Chtimes(fpath, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+
+	r := NewFSReader(root)
+	for n, want := range map[int]string{0: names[3], 2: names[1]} {
+		got, err := r.NthLatest(context.Background(), "etcd.backup", n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("expect %d-th latest backup=%v, get=%v", n, want, got)
+		}
+	}
+	for _, n := range []int{4, -1} {
+		if _, err := r.NthLatest(context.Background(), "etcd.backup", n); err != util.ErrNoBackups {
+			t.Errorf("n=%d: expect error=%v, get=%v", n, util.ErrNoBackups, err)
+		}
+	}
+}
+
+func TestFSReaderLatestOfCluster(t *testing.T) {
+	root, err := ioutil.TempDir("", "fs-reader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	now := time.Now()
+	names := []string{
+		"etcd.backup_" + util.MakeBackupName("3.2.13", 1),
+		"etcd.backup_" + util.MakeClusterBackupName("cluster-a", "3.2.13", 2),
+		"etcd.backup_" + util.MakeClusterBackupName("cluster-b", "3.2.13", 3),
+	}
+	for i, name := range names {
+		fpath := filepath.Join(root, name)
+		if err := ioutil.WriteFile(fpath, []byte("backup"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		mtime := now.Add(time.Duration(i) * time.Minute)
+		if err := os.Chtimes(fpath, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := NewFSReader(root)
+	for clusterName, want := range map[string]string{"cluster-a": names[1], "cluster-b": names[2], util.DefaultClusterName: names[0]} {
+		got, err := r.LatestOfCluster(context.Background(), "etcd.backup", clusterName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("expect latest backup of %v=%v, get=%v", clusterName, want, got)
+		}
+	}
+	if _, err := r.LatestOfCluster(context.Background(), "etcd.backup", "cluster-c"); err != util.ErrNoBackups {
+		t.Errorf("expect error=%v, get=%v", util.ErrNoBackups, err)
+	}
+}
+
+func TestFSReaderWalkBackups(t *testing.T) {
+	root, err := ioutil.TempDir("", "fs-reader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	for _, name := range []string{
+		"etcd.backup_" + util.MakeBackupName("3.2.13", 1),
+		"etcd.backup_" + util.MakeBackupName("3.2.13", 2),
+		"etcd.backup_" + util.MakeBackupName("3.2.13", 3),
+		util.MakeTmpName("etcd.backup_" + util.MakeBackupName("3.2.13", 4)),
+	} {
+		if err := ioutil.WriteFile(filepath.Join(root, name), []byte("backup"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := NewFSReader(root)
+	revs := map[uint64]int{}
+	err = r.WalkBackups(context.Background(), "etcd.backup", func(info util.BackupInfo) error {
+		revs[info.Revision]++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := map[uint64]int{1: 1, 2: 1, 3: 1}; !reflect.DeepEqual(revs, w) {
+		t.Errorf("expect walked revisions=%v, get=%v", w, revs)
+	}
+
+	errStop := errors.New("stop")
+	calls := 0
+	err = r.WalkBackups(context.Background(), "etcd.backup", func(info util.BackupInfo) error {
+		calls++
+		return errStop
+	})
+	if err != errStop {
+		t.Errorf("expect error=%v, get=%v", errStop, err)
+	}
+	if calls != 1 {
+		t.Errorf("expect walk to stop after 1 call, get=%d calls", calls)
+	}
+}
+
+func TestFSReaderByRevision(t *testing.T) {
+	root, err := ioutil.TempDir("", "fs-reader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	now := time.Now()
+	for _, rev := range []int64{10, 20, 30} {
+		path := filepath.Join(root, "cluster-a", "etcd.backup_"+util.MakeBackupName("3.2.13", rev))
+		writeBackupFile(t, path, now)
+	}
+
+	r := NewFSReader(root)
+	tests := []struct {
+		rev   uint64
+		wRev  int64
+		wNone bool
+	}{
+		{rev: 20, wRev: 20},
+		{rev: 25, wRev: 20},
+		{rev: 100, wRev: 30},
+		{rev: 9, wNone: true},
+	}
+	for i, tt := range tests {
+		path, err := r.ByRevision(context.Background(), "cluster-a/etcd.backup", tt.rev)
+		if tt.wNone {
+			if err != util.ErrNoBackups {
+				t.Errorf("#%d: expect error=%v, get=%v", i, util.ErrNoBackups, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("#%d: %v", i, err)
+		}
+		want := "cluster-a/etcd.backup_" + util.MakeBackupName("3.2.13", tt.wRev)
+		if path != want {
+			t.Errorf("#%d: expect path=%v, get=%v", i, want, path)
+		}
+	}
+}
+
+func TestFSReaderListPage(t *testing.T) {
+	root, err := ioutil.TempDir("", "fs-reader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	now := time.Now()
+	for i := 0; i < 10; i++ {
+		path := filepath.Join(root, "cluster-a", "etcd.backup_"+util.MakeBackupName("3.2.13", int64(i)))
+		writeBackupFile(t, path, now.Add(time.Duration(i)*time.Minute))
+	}
+
+	paths, err := NewFSReader(root).ListPage(context.Background(), "cluster-a/etcd.backup", true, 3, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{}
+	for _, rev := range []int64{9, 8, 7} {
+		want = append(want, "cluster-a/etcd.backup_"+util.MakeBackupName("3.2.13", rev))
+	}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("expect paths=%v, get=%v", want, paths)
+	}
+}
+
+func TestFSReaderHealthCheck(t *testing.T) {
+	root, err := ioutil.TempDir("", "fs-reader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	if err := os.Mkdir(filepath.Join(root, "cluster-a"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	r := NewFSReader(root)
+	if err := r.HealthCheck(context.Background(), "cluster-a/etcd.backup"); err != nil {
+		t.Errorf("expect no error for an existing directory, get=%v", err)
+	}
+	if err := r.HealthCheck(context.Background(), "cluster-b/etcd.backup"); err == nil {
+		t.Errorf("expect error for a missing directory")
+	}
+}
+
+func TestFSReaderOpenLimited(t *testing.T) {
+	root, err := ioutil.TempDir("", "fs-reader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	data := make([]byte, 1024)
+	if err := ioutil.WriteFile(filepath.Join(root, "etcd.backup"), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	r := NewFSReader(root)
+
+	if _, err := r.OpenLimited(context.Background(), "etcd.backup", 1023); err != util.ErrBackupTooLarge {
+		t.Errorf("expect error=%v, get=%v", util.ErrBackupTooLarge, err)
+	}
+
+	rc, err := r.OpenLimited(context.Background(), "etcd.backup", 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	got, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(data) {
+		t.Errorf("expect to read %d bytes, get=%d", len(data), len(got))
+	}
+}
+
+func TestDownloadTo(t *testing.T) {
+	root, err := ioutil.TempDir("", "fs-reader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	data := []byte("backup")
+	if err := ioutil.WriteFile(filepath.Join(root, "etcd.backup"), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+	n, err := DownloadTo(context.Background(), NewFSReader(root), "etcd.backup", buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(data)) {
+		t.Errorf("expect %d bytes copied, get=%d", len(data), n)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Errorf("expect content=%q, get=%q", data, buf.Bytes())
+	}
+}
+
+func TestDownloadToRateLimited(t *testing.T) {
+	root, err := ioutil.TempDir("", "fs-reader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	data := make([]byte, 3000)
+	if err := ioutil.WriteFile(filepath.Join(root, "etcd.backup"), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	n, err := DownloadTo(context.Background(), NewRateLimitedReader(NewFSReader(root), 10000), "etcd.backup", ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(data)) {
+		t.Errorf("expect %d bytes copied, get=%d", len(data), n)
+	}
+	// 3000 bytes at 10000 bytes/s take at least 300ms.
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+		t.Errorf("expect download to take at least 300ms, get=%v", elapsed)
+	}
+}
+
+func TestFSReaderOpenRange(t *testing.T) {
+	root, err := ioutil.TempDir("", "fs-reader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	data := []byte("first half|second half")
+	if err := ioutil.WriteFile(filepath.Join(root, "etcd.backup"), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewFSReader(root)
+	buf := new(bytes.Buffer)
+	// The first download is interrupted after half of the content.
+	rc, err := r.OpenRange(context.Background(), "etcd.backup", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = io.CopyN(buf, rc, int64(len(data)/2))
+	rc.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc, err = r.OpenRange(context.Background(), "etcd.backup", int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = io.Copy(buf, rc)
+	rc.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Errorf("expect reassembled content=%q, get=%q", data, buf.Bytes())
+	}
+
+	if _, err := r.OpenRange(context.Background(), "etcd.backup", -1); err == nil {
+		t.Error("expect error for a negative offset")
+	}
+}
+
+func TestFSReaderLastBackupTime(t *testing.T) {
+	root, err := ioutil.TempDir("", "fs-reader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	r := NewFSReader(root)
+	if _, err := r.LastBackupTime(context.Background(), "etcd.backup"); err != util.ErrNoBackups {
+		t.Fatalf("expect error=%v, get=%v", util.ErrNoBackups, err)
+	}
+
+	newest := time.Now().Truncate(time.Second)
+	for i := 0; i < 3; i++ {
+		path := filepath.Join(root, "etcd.backup_"+util.MakeBackupName("3.2.13", int64(i)))
+		writeBackupFile(t, path, newest.Add(time.Duration(i-2)*time.Minute))
+	}
+	last, err := r.LastBackupTime(context.Background(), "etcd.backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !last.Equal(newest) {
+		t.Errorf("expect last backup time=%v, get=%v", newest, last)
+	}
+}
+
+func TestFSReaderListVersions(t *testing.T) {
+	root, err := ioutil.TempDir("", "fs-reader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	now := time.Now()
+	rev := int64(0)
+	for ver, n := range map[string]int{"3.1.0": 1, "3.1.8": 2, "3.2.0": 3} {
+		for i := 0; i < n; i++ {
+			rev++
+			writeBackupFile(t, filepath.Join(root, "etcd.backup_"+util.MakeBackupName(ver, rev)), now)
+		}
+	}
+
+	versions, err := NewFSReader(root).ListVersions(context.Background(), "etcd.backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]int{"3.1.0": 1, "3.1.8": 2, "3.2.0": 3}
+	if !reflect.DeepEqual(versions, expected) {
+		t.Errorf("expect versions=%v, get=%v", expected, versions)
+	}
+}
+
+func TestFSReaderExists(t *testing.T) {
+	root, err := ioutil.TempDir("", "fs-reader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	path := "etcd.backup_" + util.MakeBackupName("3.2.13", 1)
+	writeBackupFile(t, filepath.Join(root, path), time.Now())
+
+	r := NewFSReader(root)
+	exists, err := r.Exists(context.Background(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exists {
+		t.Errorf("expect saved backup %v to exist", path)
+	}
+	exists, err = r.Exists(context.Background(), "etcd.backup_"+util.MakeBackupName("3.2.13", 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Errorf("expect missing backup not to exist")
+	}
+}
@@ -14,10 +14,115 @@
 
 package reader
 
-import "io"
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/coreos/etcd-operator/pkg/backup/util"
+)
 
 // Reader defines required reader operations
+// All operations, including reads from an opened backup file, abort with ctx.Err() once ctx is done.
 type Reader interface {
 	// Open opens up a backup file for reading.
-	Open(path string) (rc io.ReadCloser, err error)
+	Open(ctx context.Context, path string) (rc io.ReadCloser, err error)
+	// Exists checks whether the backup file on path exists without downloading it.
+	// A missing backup file is reported as (false, nil), not an error.
+	Exists(ctx context.Context, path string) (bool, error)
+	// OpenLimited opens up a backup file for reading like Open, guarding against backups larger than maxBytes.
+	// It returns util.ErrBackupTooLarge if the stored size of the backup file exceeds maxBytes,
+	// and reads from the opened file fail with util.ErrBackupTooLarge once more than maxBytes are read.
+	OpenLimited(ctx context.Context, path string, maxBytes int64) (io.ReadCloser, error)
+	// OpenRange opens up a backup file for reading from offset up to its end, without downloading
+	// the preceding bytes, so that an interrupted download can be resumed.
+	// Offsets are in the content read with Open.
+	OpenRange(ctx context.Context, path string, offset int64) (io.ReadCloser, error)
+	// Verify checks the integrity of a backup file against its stored checksum.
+	// Backup files without a stored checksum are reported as valid.
+	Verify(ctx context.Context, path string) (bool, error)
+	// Latest returns the path of the latest backup file by date saved with revision appended to path.
+	// It returns util.ErrNoBackups if there is none.
+	Latest(ctx context.Context, path string) (string, error)
+	// NthLatest returns the path of the n-th latest backup file by date saved with revision appended to path,
+	// where n=0 is the latest one like Latest and n=1 the one before it.
+	// It returns util.ErrNoBackups if there are not more than n backup files.
+	NthLatest(ctx context.Context, path string, n int) (string, error)
+	// LatestOfCluster returns the path of the latest backup file by date saved with revision appended to path
+	// of the etcd cluster named clusterName, where backups without a cluster name belong to util.DefaultClusterName.
+	// It returns util.ErrNoBackups if there is none.
+	LatestOfCluster(ctx context.Context, path, clusterName string) (string, error)
+	// ByRevision returns the path of the backup file saved with revision appended to path
+	// whose revision is the largest one less than or equal to rev.
+	// It returns util.ErrNoBackups if there is none.
+	ByRevision(ctx context.Context, path string, rev uint64) (string, error)
+	// ListPage returns the paths of the backup files saved with revision appended to path sorted by date,
+	// from the latest if sortDesc is true, skipping the first offset ones and returning at most limit.
+	// A limit less than or equal to 0 means no limit.
+	ListPage(ctx context.Context, path string, sortDesc bool, limit, offset int) ([]string, error)
+	// WalkBackups calls fn with the info of each backup file saved with revision appended to path, in no
+	// particular order, listing the backup files a page at a time instead of all at once where the storage allows.
+	// It stops at the first error returned by fn and returns it.
+	WalkBackups(ctx context.Context, path string, fn func(util.BackupInfo) error) error
+	// Total returns the number of backup files saved with revision appended to path.
+	Total(ctx context.Context, path string) (int, error)
+	// TotalBytes returns the total size in bytes of the backup files saved with revision appended to path.
+	TotalBytes(ctx context.Context, path string) (int64, error)
+	// ListVersions returns the number of backup files saved with revision appended to path of each etcd version.
+	ListVersions(ctx context.Context, path string) (map[string]int, error)
+	// LastBackupTime returns when the latest backup file saved with revision appended to path was completed.
+	// It returns util.ErrNoBackups if there is none.
+	LastBackupTime(ctx context.Context, path string) (time.Time, error)
+	// HealthCheck checks that the storage backing path is reachable with the configured credentials.
+	// It neither downloads nor modifies any backup file.
+	HealthCheck(ctx context.Context, path string) error
+}
+
+// DownloadTo copies the backup file on path opened with r into w and returns the number of bytes copied.
+// The opened backup file is closed before returning.
+func DownloadTo(ctx context.Context, r Reader, path string, w io.Writer) (int64, error) {
+	rc, err := r.Open(ctx, path)
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+	return io.Copy(w, rc)
+}
+
+type rateLimitedReader struct {
+	Reader
+	bytesPerSec int64
+}
+
+// NewRateLimitedReader returns a Reader of r whose opened backup files are read
+// at most bytesPerSec bytes per second. If bytesPerSec is 0 or less, r is returned as is.
+func NewRateLimitedReader(r Reader, bytesPerSec int64) Reader {
+	if bytesPerSec <= 0 {
+		return r
+	}
+	return &rateLimitedReader{Reader: r, bytesPerSec: bytesPerSec}
+}
+
+func (rl *rateLimitedReader) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	rc, err := rl.Reader.Open(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return util.NewRateLimitedReadCloser(ctx, rc, rl.bytesPerSec), nil
+}
+
+func (rl *rateLimitedReader) OpenRange(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
+	rc, err := rl.Reader.OpenRange(ctx, path, offset)
+	if err != nil {
+		return nil, err
+	}
+	return util.NewRateLimitedReadCloser(ctx, rc, rl.bytesPerSec), nil
+}
+
+func (rl *rateLimitedReader) OpenLimited(ctx context.Context, path string, maxBytes int64) (io.ReadCloser, error) {
+	rc, err := rl.Reader.OpenLimited(ctx, path, maxBytes)
+	if err != nil {
+		return nil, err
+	}
+	return util.NewRateLimitedReadCloser(ctx, rc, rl.bytesPerSec), nil
 }
@@ -15,12 +15,16 @@
 package reader
 
 import (
+	"context"
 	"fmt"
 	"io"
+	"net/http"
+	"time"
 
 	"github.com/coreos/etcd-operator/pkg/backup/util"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/s3"
 )
 
@@ -37,12 +41,12 @@ func NewS3Reader(s3 *s3.S3) Reader {
 }
 
 // Open opens the file on path where path must be in the format "<s3-bucket-name>/<key>"
-func (s3r *s3Reader) Open(path string) (io.ReadCloser, error) {
+func (s3r *s3Reader) Open(ctx context.Context, path string) (io.ReadCloser, error) {
 	bucket, key, err := util.ParseBucketAndKey(path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse s3 bucket and key: %v", err)
 	}
-	resp, err := s3r.s3.GetObject(&s3.GetObjectInput{
+	resp, err := s3r.s3.GetObjectWithContext(ctx, &s3.GetObjectInput{
 		Bucket: aws.String(bucket),
 		Key:    aws.String(key),
 	})
@@ -52,3 +56,253 @@ func (s3r *s3Reader) Open(path string) (io.ReadCloser, error) {
 
 	return resp.Body, nil
 }
+
+// OpenRange opens the file on path for reading from offset with a Range request.
+func (s3r *s3Reader) OpenRange(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
+	if offset < 0 {
+		return nil, fmt.Errorf("invalid offset: %d", offset)
+	}
+	bucket, key, err := util.ParseBucketAndKey(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse s3 bucket and key: %v", err)
+	}
+	resp, err := s3r.s3.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-", offset)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Exists checks whether the object on path exists with a HEAD request.
+func (s3r *s3Reader) Exists(ctx context.Context, path string) (bool, error) {
+	bucket, key, err := util.ParseBucketAndKey(path)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse s3 bucket and key: %v", err)
+	}
+	_, err = s3r.s3.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if reqErr, ok := err.(awserr.RequestFailure); ok && reqErr.StatusCode() == http.StatusNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// OpenLimited opens the file on path like Open, failing if it is larger than maxBytes.
+// The size of the object is checked before downloading it.
+func (s3r *s3Reader) OpenLimited(ctx context.Context, path string, maxBytes int64) (io.ReadCloser, error) {
+	bucket, key, err := util.ParseBucketAndKey(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse s3 bucket and key: %v", err)
+	}
+	head, err := s3r.s3.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if aws.Int64Value(head.ContentLength) > maxBytes {
+		return nil, util.ErrBackupTooLarge
+	}
+
+	rc, err := s3r.Open(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return util.NewLimitedReadCloser(rc, maxBytes), nil
+}
+
+// Latest returns the path of the latest backup file saved with revision appended to path,
+// in the format "<s3-bucket-name>/<key>".
+func (s3r *s3Reader) Latest(ctx context.Context, path string) (string, error) {
+	bucket, files, err := s3r.listBackupFiles(ctx, path)
+	if err != nil {
+		return "", err
+	}
+	name := util.GetLatestBackupNameByDate(files)
+	if len(name) == 0 {
+		return "", util.ErrNoBackups
+	}
+	return bucket + "/" + name, nil
+}
+
+// NthLatest returns the path of the n-th latest backup file saved with revision appended to path,
+// in the format "<s3-bucket-name>/<key>".
+func (s3r *s3Reader) NthLatest(ctx context.Context, path string, n int) (string, error) {
+	bucket, files, err := s3r.listBackupFiles(ctx, path)
+	if err != nil {
+		return "", err
+	}
+	f, ok := util.GetNthLatestBackupFileByDate(files, n)
+	if !ok {
+		return "", util.ErrNoBackups
+	}
+	return bucket + "/" + f.Name, nil
+}
+
+// LatestOfCluster returns the path of the latest backup file of the etcd cluster named clusterName,
+// in the format "<s3-bucket-name>/<key>".
+func (s3r *s3Reader) LatestOfCluster(ctx context.Context, path, clusterName string) (string, error) {
+	bucket, files, err := s3r.listBackupFiles(ctx, path)
+	if err != nil {
+		return "", err
+	}
+	name := util.GetLatestBackupNameByDate(util.BackupFilesOfCluster(files, clusterName))
+	if len(name) == 0 {
+		return "", util.ErrNoBackups
+	}
+	return bucket + "/" + name, nil
+}
+
+// ByRevision returns the path of the backup file whose revision is the largest one less than or equal to rev,
+// in the format "<s3-bucket-name>/<key>".
+func (s3r *s3Reader) ByRevision(ctx context.Context, path string, rev uint64) (string, error) {
+	bucket, files, err := s3r.listBackupFiles(ctx, path)
+	if err != nil {
+		return "", err
+	}
+	name := util.GetBackupNameByRevision(files, rev)
+	if len(name) == 0 {
+		return "", util.ErrNoBackups
+	}
+	return bucket + "/" + name, nil
+}
+
+// ListPage returns a page of the paths of the backup files sorted by date,
+// in the format "<s3-bucket-name>/<key>".
+func (s3r *s3Reader) ListPage(ctx context.Context, path string, sortDesc bool, limit, offset int) ([]string, error) {
+	bucket, files, err := s3r.listBackupFiles(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	paths := []string{}
+	for _, f := range util.PageBackupFiles(files, sortDesc, limit, offset) {
+		paths = append(paths, bucket+"/"+f.Name)
+	}
+	return paths, nil
+}
+
+// Total returns the number of backup files saved with revision appended to path.
+func (s3r *s3Reader) Total(ctx context.Context, path string) (int, error) {
+	_, files, err := s3r.listBackupFiles(ctx, path)
+	if err != nil {
+		return 0, err
+	}
+	return len(files), nil
+}
+
+// TotalBytes returns the total size in bytes of the backup files saved with revision appended to path.
+func (s3r *s3Reader) TotalBytes(ctx context.Context, path string) (int64, error) {
+	_, files, err := s3r.listBackupFiles(ctx, path)
+	if err != nil {
+		return 0, err
+	}
+	return util.TotalSize(files), nil
+}
+
+// ListVersions returns the number of backup files of each etcd version.
+func (s3r *s3Reader) ListVersions(ctx context.Context, path string) (map[string]int, error) {
+	_, files, err := s3r.listBackupFiles(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return util.CountBackupsByVersion(files), nil
+}
+
+// LastBackupTime returns the last modified time of the latest backup file.
+func (s3r *s3Reader) LastBackupTime(ctx context.Context, path string) (time.Time, error) {
+	_, files, err := s3r.listBackupFiles(ctx, path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	latest, ok := util.GetLatestBackupFileByDate(files)
+	if !ok {
+		return time.Time{}, util.ErrNoBackups
+	}
+	return latest.LastModified, nil
+}
+
+// HealthCheck checks that the objects of the bucket of path can be listed.
+func (s3r *s3Reader) HealthCheck(ctx context.Context, path string) error {
+	bucket, _, err := util.ParseBucketAndKey(path)
+	if err != nil {
+		return fmt.Errorf("failed to parse s3 bucket and key: %v", err)
+	}
+	_, err = s3r.s3.ListObjectsV2WithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket:  aws.String(bucket),
+		MaxKeys: aws.Int64(1),
+	})
+	return err
+}
+
+// listBackupFiles lists the backup files saved with revision appended to the given s3 path
+// and returns them along with the bucket name.
+func (s3r *s3Reader) listBackupFiles(ctx context.Context, path string) (string, []util.BackupFile, error) {
+	bucket, key, err := util.ParseBucketAndKey(path)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to parse s3 bucket and key: %v", err)
+	}
+
+	files := []util.BackupFile{}
+	err = s3r.s3.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(key + "_"),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, obj := range page.Contents {
+			files = append(files, util.BackupFile{
+				Name:         aws.StringValue(obj.Key),
+				LastModified: aws.TimeValue(obj.LastModified),
+				Size:         aws.Int64Value(obj.Size),
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return bucket, files, nil
+}
+
+// WalkBackups calls fn for each backup file saved with revision appended to path, listing a page of objects
+// at a time in the order of their keys. Names passed to fn are object keys, without the bucket.
+func (s3r *s3Reader) WalkBackups(ctx context.Context, path string, fn func(util.BackupInfo) error) error {
+	bucket, key, err := util.ParseBucketAndKey(path)
+	if err != nil {
+		return fmt.Errorf("failed to parse s3 bucket and key: %v", err)
+	}
+
+	var walkErr error
+	err = s3r.s3.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(key + "_"),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		files := make([]util.BackupFile, 0, len(page.Contents))
+		for _, obj := range page.Contents {
+			files = append(files, util.BackupFile{
+				Name:         aws.StringValue(obj.Key),
+				LastModified: aws.TimeValue(obj.LastModified),
+				Size:         aws.Int64Value(obj.Size),
+			})
+		}
+		walkErr = util.WalkBackupFiles(files, fn)
+		return walkErr == nil
+	})
+	if err != nil {
+		return err
+	}
+	return walkErr
+}
+
+// Verify always returns true since no checksum is stored along with S3 backups.
+func (s3r *s3Reader) Verify(ctx context.Context, path string) (bool, error) {
+	return true, nil
+}
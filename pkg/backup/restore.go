@@ -0,0 +1,233 @@
+// Copyright 2018 The etcd-operator Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/coreos/etcd-operator/pkg/backup/util"
+
+	"github.com/pkg/errors"
+)
+
+// QuarantineTimeFormat is the layout of the restore time recorded in the names of pre-restore snapshots.
+const QuarantineTimeFormat = "20060102T150405Z"
+
+// RestoreOptions describes how to restore a backup.
+type RestoreOptions struct {
+	// QuarantinePrefix, if set, makes Restore first copy the latest backup under path to the directory
+	// of that name next to it, so that a mistaken restore can be undone by restoring the copy.
+	QuarantinePrefix string
+	// RetryBudget caps the number of retries of the storage operations of the restore, all operations taken together.
+	// Zero means no cap.
+	RetryBudget int
+	// ValidateSnapshot makes Restore check that the backup starts with the header of an etcd snapshot
+	// before writing anything, and fail otherwise.
+	ValidateSnapshot bool
+}
+
+// RestoreResult summarizes a restore.
+type RestoreResult struct {
+	// Path is the path of the restored backup.
+	Path string
+	// Size is the number of bytes restored.
+	Size int64
+	// Quarantined is the path of the pre-restore snapshot, or "" if none was taken.
+	Quarantined string
+	// Skipped are the paths of the newer backups RestoreLatestVerified skipped since they failed verification,
+	// from the latest.
+	Skipped []string
+}
+
+// Restore writes the backup on backupPath of b to w, or the latest backup saved with revision appended to path
+// if backupPath is empty. If the pre-restore snapshot requested by opts can't be taken, nothing is restored.
+func Restore(ctx context.Context, b Backend, path, backupPath string, w io.Writer, opts RestoreOptions) (*RestoreResult, error) {
+	if opts.RetryBudget > 0 {
+		ctx = util.WithRetryBudget(ctx, util.NewRetryBudget(opts.RetryBudget))
+	}
+	res := &RestoreResult{Path: backupPath}
+	if len(opts.QuarantinePrefix) != 0 {
+		latest, err := b.Latest(ctx, path)
+		switch {
+		case err == nil:
+			res.Quarantined = quarantinePath(opts.QuarantinePrefix, latest, time.Now())
+			if err := copyBackup(ctx, b, latest, res.Quarantined); err != nil {
+				return nil, fmt.Errorf("failed to save pre-restore snapshot (%v)", err)
+			}
+		case err != util.ErrNoBackups:
+			return nil, fmt.Errorf("failed to find latest backup to quarantine (%v)", err)
+		}
+	}
+
+	if len(res.Path) == 0 {
+		latest, err := b.Latest(ctx, path)
+		if err != nil {
+			return res, fmt.Errorf("failed to find latest backup (%v)", err)
+		}
+		res.Path = latest
+	}
+	rc, err := b.Open(ctx, res.Path)
+	if err != nil {
+		return res, fmt.Errorf("failed to open backup (%v)", err)
+	}
+	defer rc.Close()
+	var r io.Reader = rc
+	if opts.ValidateSnapshot {
+		if r, err = validateSnapshotHeader(rc); err != nil {
+			return res, fmt.Errorf("failed to validate backup (%v)", err)
+		}
+	}
+	res.Size, err = io.Copy(w, r)
+	if err != nil {
+		return res, fmt.Errorf("failed to restore backup (%v)", err)
+	}
+	return res, nil
+}
+
+// RestoreLatestVerified writes the latest backup saved with revision appended to path of b that passes b.Verify
+// to w, walking the backups from the latest, so that a corrupted latest backup falls back to the one before it.
+// It returns util.ErrNoBackups if no backup passes verification. Errors other than failed verifications,
+// including failures of the restore once the chosen backup is being written to w, are returned as is.
+func RestoreLatestVerified(ctx context.Context, b Backend, path string, w io.Writer) (*RestoreResult, error) {
+	res := &RestoreResult{}
+	for n := 0; ; n++ {
+		backupPath, err := b.NthLatest(ctx, path, n)
+		if err == util.ErrNoBackups {
+			return res, util.ErrNoBackups
+		}
+		if err != nil {
+			return res, fmt.Errorf("failed to find backup (%v)", err)
+		}
+		valid, err := b.Verify(ctx, backupPath)
+		if err != nil {
+			return res, fmt.Errorf("failed to verify backup %s (%v)", backupPath, err)
+		}
+		if !valid {
+			res.Skipped = append(res.Skipped, backupPath)
+			continue
+		}
+
+		res.Path = backupPath
+		rc, err := b.Open(ctx, backupPath)
+		if err != nil {
+			return res, fmt.Errorf("failed to open backup (%v)", err)
+		}
+		defer rc.Close()
+		if res.Size, err = io.Copy(w, rc); err != nil {
+			return res, fmt.Errorf("failed to restore backup (%v)", err)
+		}
+		return res, nil
+	}
+}
+
+// RestoreFileMode is the permission bits of the files written by RestoreToFile,
+// which are only readable by the user running etcd like its data directory.
+const RestoreFileMode os.FileMode = 0600
+
+// RestoreToFile downloads the backup on backupPath of b to the file destPath, e.g. on a PersistentVolume
+// for etcdctl snapshot restore, and returns its size. The backup is streamed to destPath with ".tmp" appended,
+// which is synced and read back to check its checksum against the one of the downloaded content, and only then
+// renamed to destPath, so that destPath is either absent or the complete backup, even across a crash.
+// Backends checking their backups as they are read, such as ABS backends with VerifyChecksums, fail the download
+// of a corrupted backup before anything is renamed. The temporary file is removed if the restore fails.
+func RestoreToFile(ctx context.Context, b Backend, backupPath, destPath string) (int64, error) {
+	rc, err := b.Open(ctx, backupPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open backup (%v)", err)
+	}
+	defer rc.Close()
+
+	tmpPath := destPath + ".tmp"
+	f, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, RestoreFileMode)
+	if err != nil {
+		return 0, err
+	}
+	size, err := writeSynced(f, rc, tmpPath)
+	if err != nil {
+		os.Remove(tmpPath)
+		return 0, err
+	}
+	if err = os.Rename(tmpPath, destPath); err != nil {
+		os.Remove(tmpPath)
+		return 0, err
+	}
+	// Sync the directory so that the rename survives a crash.
+	if dir, err := os.Open(filepath.Dir(destPath)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return size, nil
+}
+
+// writeSynced copies r to the file f of path, syncs and closes it, and reads it back to check that
+// its checksum is the one of the content of r.
+func writeSynced(f *os.File, r io.Reader, path string) (int64, error) {
+	h := sha256.New()
+	size, err := io.Copy(f, io.TeeReader(r, h))
+	if err != nil {
+		f.Close()
+		return 0, errors.Wrap(err, "failed to restore backup")
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return 0, err
+	}
+	if err = f.Close(); err != nil {
+		return 0, err
+	}
+
+	f, err = os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	written := sha256.New()
+	if _, err = io.Copy(written, f); err != nil {
+		return 0, err
+	}
+	if !bytes.Equal(written.Sum(nil), h.Sum(nil)) {
+		return 0, errors.Wrapf(util.ErrChecksumMismatch, "restored file %s does not match the downloaded backup", path)
+	}
+	return size, nil
+}
+
+// quarantinePath returns the path of the pre-restore snapshot of the backup on backupPath taken at t:
+// the backup name prefixed with the restore time, under the prefix directory next to the backup.
+func quarantinePath(prefix, backupPath string, t time.Time) string {
+	dir, name := "", backupPath
+	if i := strings.LastIndex(backupPath, "/"); i >= 0 {
+		dir, name = backupPath[:i+1], backupPath[i+1:]
+	}
+	return dir + strings.Trim(prefix, "/") + "/" + t.UTC().Format(QuarantineTimeFormat) + "." + name
+}
+
+// copyBackup copies the backup on src of b to dst.
+func copyBackup(ctx context.Context, b Backend, src, dst string) error {
+	rc, err := b.Open(ctx, src)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	_, err = b.Write(ctx, dst, rc)
+	return err
+}
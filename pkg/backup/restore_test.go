@@ -0,0 +1,193 @@
+// Copyright 2018 The etcd-operator Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backup
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd-operator/pkg/backup/util"
+
+	"github.com/pkg/errors"
+)
+
+func TestRestoreQuarantine(t *testing.T) {
+	ctx := context.Background()
+	b := NewMemoryBackend()
+	path := "cluster-a/etcd.backup"
+	backupPath := func(rev int64) string {
+		return path + "_" + util.MakeBackupName("3.2.13", rev)
+	}
+	for _, rev := range []int64{1, 2} {
+		if _, err := b.Write(ctx, backupPath(rev), strings.NewReader(backupPath(rev))); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	var buf bytes.Buffer
+	start := time.Now().UTC().Truncate(time.Second)
+	res, err := Restore(ctx, b, path, backupPath(1), &buf, RestoreOptions{QuarantinePrefix: "quarantine"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != backupPath(1) || res.Path != backupPath(1) || res.Size != int64(buf.Len()) {
+		t.Errorf("expect backup %v to be restored, get=%+v content=%q", backupPath(1), res, buf.String())
+	}
+
+	// The pre-restore snapshot is the latest backup, saved under the quarantine prefix with the restore time.
+	prefix := "cluster-a/quarantine/"
+	if !strings.HasPrefix(res.Quarantined, prefix) || !strings.HasSuffix(res.Quarantined, "."+strings.TrimPrefix(backupPath(2), "cluster-a/")) {
+		t.Fatalf("unexpected pre-restore snapshot path: %v", res.Quarantined)
+	}
+	ts, err := time.Parse(QuarantineTimeFormat, strings.SplitN(strings.TrimPrefix(res.Quarantined, prefix), ".", 2)[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ts.Before(start) || ts.After(time.Now()) {
+		t.Errorf("expect restore time in the pre-restore snapshot name, get=%v", ts)
+	}
+	rc, err := b.Open(ctx, res.Quarantined)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	if data, err := ioutil.ReadAll(rc); err != nil || string(data) != backupPath(2) {
+		t.Errorf("expect pre-restore snapshot of %v, get=%q (err=%v)", backupPath(2), data, err)
+	}
+
+	// The quarantined snapshot is not mistaken for a backup.
+	if latest, err := b.Latest(ctx, path); err != nil || latest != backupPath(2) {
+		t.Errorf("expect latest=%v, get=%v (err=%v)", backupPath(2), latest, err)
+	}
+}
+
+func TestRestoreLatestWithoutQuarantine(t *testing.T) {
+	ctx := context.Background()
+	b := NewMemoryBackend()
+	path := "cluster-a/etcd.backup"
+	backupPath := path + "_" + util.MakeBackupName("3.2.13", 1)
+	if _, err := b.Write(ctx, backupPath, strings.NewReader("backup")); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	res, err := Restore(ctx, b, path, "", &buf, RestoreOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Path != backupPath || res.Quarantined != "" || buf.String() != "backup" {
+		t.Errorf("expect latest backup restored without pre-restore snapshot, get=%+v content=%q", res, buf.String())
+	}
+}
+
+// corruptBackend fails reading its backups at their end like a backend checking their checksums as they are read.
+type corruptBackend struct {
+	Backend
+}
+
+func (cb *corruptBackend) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	rc, err := cb.Backend.Open(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return util.NewChecksumReadCloser(rc, "not the checksum"), nil
+}
+
+func TestRestoreToFile(t *testing.T) {
+	ctx := context.Background()
+	b := NewMemoryBackend()
+	backupPath := "cluster-a/etcd.backup_" + util.MakeBackupName("3.2.13", 1)
+	if _, err := b.Write(ctx, backupPath, strings.NewReader("backup")); err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "restore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dest := filepath.Join(dir, "snapshot.db")
+	size, err := RestoreToFile(ctx, b, backupPath, dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != 6 {
+		t.Errorf("expect size=6, get=%d", size)
+	}
+	if data, err := ioutil.ReadFile(dest); err != nil || string(data) != "backup" {
+		t.Errorf("expect the backup restored to %s, get=%q (err=%v)", dest, data, err)
+	}
+	if fi, err := os.Stat(dest); err != nil || fi.Mode().Perm() != RestoreFileMode {
+		t.Errorf("expect mode=%v, get=%v (err=%v)", RestoreFileMode, fi.Mode().Perm(), err)
+	}
+	if _, err := os.Stat(dest + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("expect no temporary file left, get err=%v", err)
+	}
+
+	// A corrupted download leaves neither the file nor the temporary file behind.
+	corrupt := filepath.Join(dir, "corrupt.db")
+	if _, err := RestoreToFile(ctx, &corruptBackend{b}, backupPath, corrupt); errors.Cause(err) != util.ErrChecksumMismatch {
+		t.Errorf("expect error with cause %v, get=%v", util.ErrChecksumMismatch, err)
+	}
+	for _, path := range []string{corrupt, corrupt + ".tmp"} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("expect %s not to exist, get err=%v", path, err)
+		}
+	}
+}
+
+func TestRestoreLatestVerified(t *testing.T) {
+	ctx := context.Background()
+	b := NewMemoryBackend()
+	path := "cluster-a/etcd.backup"
+	backupPath := func(rev int64) string {
+		return path + "_" + util.MakeBackupName("3.2.13", rev)
+	}
+	for _, rev := range []int64{1, 2, 3} {
+		if _, err := b.Write(ctx, backupPath(rev), strings.NewReader(backupPath(rev))); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	// Corrupt the latest backup after its checksum was computed.
+	mb := b.(*memoryBackend)
+	f := mb.files[backupPath(3)]
+	f.data = []byte("corrupt")
+	mb.files[backupPath(3)] = f
+
+	var buf bytes.Buffer
+	res, err := RestoreLatestVerified(ctx, b, path, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Path != backupPath(2) || buf.String() != backupPath(2) || res.Size != int64(buf.Len()) {
+		t.Errorf("expect the previous backup %s to be restored, get=%+v content=%q", backupPath(2), res, buf.String())
+	}
+	if len(res.Skipped) != 1 || res.Skipped[0] != backupPath(3) {
+		t.Errorf("expect the corrupt latest backup to be skipped, get=%v", res.Skipped)
+	}
+
+	if _, err := RestoreLatestVerified(ctx, b, "cluster-b/etcd.backup", &buf); err != util.ErrNoBackups {
+		t.Errorf("expect error=%v, get=%v", util.ErrNoBackups, err)
+	}
+}
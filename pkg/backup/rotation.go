@@ -0,0 +1,80 @@
+// Copyright 2018 The etcd-operator Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// RotationPolicy describes which backups to keep under a backup path after saving a new one.
+type RotationPolicy struct {
+	// MaxBackups is the number of latest backups to keep. 0 means keeping all backups.
+	// It is ignored if MaxBackupsPerVersion is set.
+	MaxBackups int
+	// MaxBackupsPerVersion is the number of latest backups to keep for each etcd version.
+	MaxBackupsPerVersion int
+	// MaxBackupAge purges the backups older than it except the latest one. 0 means no age limit.
+	MaxBackupAge time.Duration
+}
+
+// RotationResult summarizes a rotation.
+type RotationResult struct {
+	// Path is the path the snapshot is saved to.
+	Path string
+	// Size is the number of bytes saved.
+	Size int64
+	// Purged are the paths of the purged backups.
+	Purged []string
+}
+
+// Rotate saves the snapshot of the given etcd version and revision to b, appending them to path,
+// and then purges the backups under path according to policy.
+// If the save fails, no backups are purged.
+func Rotate(ctx context.Context, b Backend, path string, policy RotationPolicy, snapshot io.Reader, version string, rev uint64) (*RotationResult, error) {
+	if rev > math.MaxInt64 {
+		return nil, fmt.Errorf("revision %d out of range", rev)
+	}
+	res := &RotationResult{Path: appendRevToPath(true, time.Time{}, "", version, int64(rev), path)}
+	size, err := b.Write(ctx, res.Path, snapshot)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save snapshot (%v)", err)
+	}
+	res.Size = size
+
+	var purged []string
+	switch {
+	case policy.MaxBackupsPerVersion > 0:
+		purged, err = b.PurgeByVersion(ctx, path, policy.MaxBackupsPerVersion, false)
+	case policy.MaxBackups > 0:
+		purged, err = b.Purge(ctx, path, policy.MaxBackups, false)
+	}
+	res.Purged = append(res.Purged, purged...)
+	if err != nil {
+		return res, fmt.Errorf("failed to purge backups (%v)", err)
+	}
+
+	if policy.MaxBackupAge > 0 {
+		purged, err = b.PurgeOlderThan(ctx, path, policy.MaxBackupAge, false)
+		res.Purged = append(res.Purged, purged...)
+		if err != nil {
+			return res, fmt.Errorf("failed to purge backups older than %v (%v)", policy.MaxBackupAge, err)
+		}
+	}
+	return res, nil
+}
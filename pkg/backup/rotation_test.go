@@ -0,0 +1,83 @@
+// Copyright 2018 The etcd-operator Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backup
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/coreos/etcd-operator/pkg/backup/util"
+)
+
+// fakeBackend records the saves and purges of a Backend.
+type fakeBackend struct {
+	Backend
+	writes map[string][]byte
+	purges []int
+}
+
+func (fb *fakeBackend) Write(ctx context.Context, path string, r io.Reader) (int64, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return 0, err
+	}
+	fb.writes[path] = data
+	return int64(len(data)), nil
+}
+
+func (fb *fakeBackend) Purge(ctx context.Context, path string, maxBackups int, dryRun bool) ([]string, error) {
+	fb.purges = append(fb.purges, maxBackups)
+	return []string{path + "_" + util.MakeBackupName("3.2.13", 1)}, nil
+}
+
+func TestRotate(t *testing.T) {
+	fb := &fakeBackend{writes: map[string][]byte{}}
+	policy := RotationPolicy{MaxBackups: 3}
+	res, err := Rotate(context.Background(), fb, "mycontainer/etcd.backup", policy, bytes.NewReader([]byte("snapshot")), "3.2.13", 26)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := "mycontainer/etcd.backup_" + util.MakeBackupName("3.2.13", 26)
+	if len(fb.writes) != 1 || string(fb.writes[path]) != "snapshot" {
+		t.Errorf("expect one save to %v, get=%v", path, fb.writes)
+	}
+	if !reflect.DeepEqual(fb.purges, []int{3}) {
+		t.Errorf("expect one purge keeping 3 backups, get=%v", fb.purges)
+	}
+	expected := &RotationResult{
+		Path:   path,
+		Size:   int64(len("snapshot")),
+		Purged: []string{"mycontainer/etcd.backup_" + util.MakeBackupName("3.2.13", 1)},
+	}
+	if !reflect.DeepEqual(res, expected) {
+		t.Errorf("expect result=%+v, get=%+v", expected, res)
+	}
+}
+
+func TestRotateKeepAll(t *testing.T) {
+	fb := &fakeBackend{writes: map[string][]byte{}}
+	_, err := Rotate(context.Background(), fb, "mycontainer/etcd.backup", RotationPolicy{}, bytes.NewReader(nil), "3.2.13", 26)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fb.purges) != 0 {
+		t.Errorf("expect no purge without a policy limit, get=%v", fb.purges)
+	}
+}
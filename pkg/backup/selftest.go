@@ -0,0 +1,71 @@
+// Copyright 2018 The etcd-operator Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backup
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// SelfTestPrefix is the directory next to the backups that SelfTest saves its sentinel backup under.
+const SelfTestPrefix = ".selftest"
+
+// selfTestVersion is the etcd version recorded in the name of the sentinel backup.
+const selfTestVersion = "0.0.0"
+
+// SelfTest checks that backups can be saved to, read from and deleted from b under path, typically before relying
+// on a new backup configuration. It saves a small sentinel backup under SelfTestPrefix next to path, opens it,
+// compares its content and deletes it. The returned error tells which of the steps failed.
+func SelfTest(ctx context.Context, b Backend, path string) error {
+	dir := ""
+	if i := strings.LastIndex(path, "/"); i >= 0 {
+		dir = path[:i+1]
+	}
+	sentinelPath := dir + SelfTestPrefix + "/etcd.backup"
+	backupPath := appendRevToPath(true, time.Time{}, "", selfTestVersion, time.Now().UnixNano(), sentinelPath)
+	sentinel := []byte("etcd-operator self-test " + backupPath)
+
+	res, err := b.WriteWithResult(ctx, backupPath, bytes.NewReader(sentinel))
+	if err != nil {
+		return fmt.Errorf("self-test failed to save %v: %v", backupPath, err)
+	}
+	// Delete the sentinel backup even if reading it fails, but report the first failure.
+	// It is read from the path of the result, which includes any suffix added by the writer.
+	err = checkSentinel(ctx, b, res.Path, sentinel)
+	if _, derr := b.DeleteAll(ctx, sentinelPath, false); derr != nil && err == nil {
+		err = fmt.Errorf("self-test failed to delete %v: %v", backupPath, derr)
+	}
+	return err
+}
+
+func checkSentinel(ctx context.Context, b Backend, backupPath string, sentinel []byte) error {
+	rc, err := b.Open(ctx, backupPath)
+	if err != nil {
+		return fmt.Errorf("self-test failed to open %v: %v", backupPath, err)
+	}
+	defer rc.Close()
+	data, err := ioutil.ReadAll(rc)
+	if err != nil {
+		return fmt.Errorf("self-test failed to read %v: %v", backupPath, err)
+	}
+	if !bytes.Equal(data, sentinel) {
+		return fmt.Errorf("self-test read back different content from %v: %d bytes instead of %d", backupPath, len(data), len(sentinel))
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+// Copyright 2018 The etcd-operator Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backup
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/coreos/etcd-operator/pkg/backup/writer"
+)
+
+// readOnlyBackend fails every save like a backend whose credentials only allow reads.
+type readOnlyBackend struct {
+	Backend
+}
+
+func (rb *readOnlyBackend) WriteWithResult(ctx context.Context, path string, r io.Reader) (*writer.SaveResult, error) {
+	return nil, errors.New("403 AuthorizationPermissionMismatch")
+}
+
+func TestSelfTest(t *testing.T) {
+	b := NewMemoryBackend()
+	if err := SelfTest(context.Background(), b, "cluster-a/etcd.backup"); err != nil {
+		t.Fatalf("expect self-test to pass, get=%v", err)
+	}
+	// The sentinel backup is deleted once checked.
+	if n, err := b.Total(context.Background(), "cluster-a/"+SelfTestPrefix+"/etcd.backup"); err != nil || n != 0 {
+		t.Errorf("expect no sentinel backup left, get=%d (err=%v)", n, err)
+	}
+
+	err := SelfTest(context.Background(), &readOnlyBackend{NewMemoryBackend()}, "cluster-a/etcd.backup")
+	if err == nil {
+		t.Fatal("expect self-test to fail for a read-only backend")
+	}
+	if !strings.Contains(err.Error(), "failed to save") || !strings.Contains(err.Error(), "AuthorizationPermissionMismatch") {
+		t.Errorf("expect error telling the save failed and why, get=%v", err)
+	}
+}
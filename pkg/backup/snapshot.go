@@ -0,0 +1,374 @@
+// Copyright 2018 The etcd-operator Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backup
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// ErrInvalidSnapshot is returned when a backup does not start with the header of an etcd snapshot.
+var ErrInvalidSnapshot = errors.New("backup is not an etcd snapshot")
+
+// ErrRevisionMismatch is returned when the revision of a snapshot does not match the revision declared
+// by the caller of its SnapshotSource.
+var ErrRevisionMismatch = errors.New("snapshot revision does not match the declared revision")
+
+const (
+	// boltMetaPageFlag is the flags of the first page of a bolt database, which is a meta page.
+	boltMetaPageFlag = 0x04
+	// boltMagic is the magic number recorded in the meta pages of a bolt database.
+	boltMagic = 0xED0CDAED
+	// snapshotHeaderSize is the size of the page header of the first page of a bolt database
+	// followed by the magic number of its meta page.
+	snapshotHeaderSize = 20
+
+	// boltBranchPageFlag and boltLeafPageFlag are the flags of the branch and leaf pages of a bolt database.
+	boltBranchPageFlag = 0x01
+	boltLeafPageFlag   = 0x02
+	// boltBucketLeafFlag is the flags of the leaf elements holding a bucket.
+	boltBucketLeafFlag = 0x01
+	// boltPageHeaderSize, boltElementSize and boltBucketHeaderSize are the sizes of a page header,
+	// of a branch or leaf page element and of a bucket header in a bolt database.
+	boltPageHeaderSize   = 16
+	boltElementSize      = 16
+	boltBucketHeaderSize = 16
+	// boltMetaSize is the size of the fields of a meta page covered by its checksum.
+	boltMetaSize = 56
+	// boltMaxPageSize bounds the page sizes recorded in snapshots, guarding against corrupted meta pages.
+	boltMaxPageSize = 1 << 20
+
+	// etcd stores the kv store revisions in the keys of its "key" bucket, as an 8 bytes big endian main revision
+	// followed by more bytes, and the main revision of the latest compaction in its "meta" bucket.
+	etcdKeyBucket           = "key"
+	etcdMetaBucket          = "meta"
+	etcdFinishedCompactKey  = "finishedCompactRev"
+	etcdRevisionMainRevSize = 8
+)
+
+// validateSnapshotHeader checks that r starts with the header of an etcd snapshot, i.e. of a bolt database,
+// and returns a reader of the whole content of r, including the header it read.
+// It returns an error with ErrInvalidSnapshot as cause if r is empty or starts with anything else.
+func validateSnapshotHeader(r io.Reader) (io.Reader, error) {
+	header := make([]byte, snapshotHeaderSize)
+	n, err := io.ReadFull(r, header)
+	switch {
+	case err == io.EOF:
+		return nil, errors.Wrap(ErrInvalidSnapshot, "backup is empty")
+	case err == io.ErrUnexpectedEOF:
+		return nil, errors.Wrapf(ErrInvalidSnapshot, "backup has only %d bytes", n)
+	case err != nil:
+		return nil, err
+	}
+	// Page header: id (8 bytes), flags (2), count (2), overflow (4). Bolt writes them in the byte order of the host,
+	// which is little endian on every platform etcd supports.
+	if flags := binary.LittleEndian.Uint16(header[8:10]); flags != boltMetaPageFlag {
+		return nil, ErrInvalidSnapshot
+	}
+	if magic := binary.LittleEndian.Uint32(header[16:20]); magic != boltMagic {
+		return nil, ErrInvalidSnapshot
+	}
+	return io.MultiReader(bytes.NewReader(header), r), nil
+}
+
+// spoolSnapshot returns a reader of the whole etcd snapshot read from r, and the kv store revision of the snapshot.
+// Since the revision can only be found by walking the bolt database, the snapshot is saved to a temporary file
+// first, which is removed once the returned reader is closed.
+func spoolSnapshot(r io.Reader) (io.ReadCloser, int64, error) {
+	rc, size, err := spool(r)
+	if err != nil {
+		return nil, 0, err
+	}
+	rev, err := snapshotRevision(rc.File, size)
+	if err != nil {
+		rc.Close()
+		return nil, 0, err
+	}
+	return rc, rev, nil
+}
+
+// spool saves the content of r to a temporary file, which is removed once the returned reader is closed,
+// and returns a reader of the file from its start along with the size of the content.
+func spool(r io.Reader) (*tmpFileReadCloser, int64, error) {
+	f, err := ioutil.TempFile("", "etcd-snapshot")
+	if err != nil {
+		return nil, 0, err
+	}
+	rc := &tmpFileReadCloser{f}
+	size, err := io.Copy(f, r)
+	if err != nil {
+		rc.Close()
+		return nil, 0, errors.Wrap(err, "failed to read snapshot")
+	}
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		rc.Close()
+		return nil, 0, err
+	}
+	return rc, size, nil
+}
+
+// tmpFileReadCloser is a temporary file removed once closed.
+type tmpFileReadCloser struct {
+	*os.File
+}
+
+func (t *tmpFileReadCloser) Close() error {
+	err := t.File.Close()
+	os.Remove(t.Name())
+	return err
+}
+
+// snapshotRevision returns the kv store revision of the etcd snapshot of size bytes read from ra, that is the main revision
+// of its latest key like etcd computes it on startup: the latest compaction revision if there is no later key.
+// It returns an error with ErrInvalidSnapshot as cause if ra is not the bolt database of an etcd snapshot.
+func snapshotRevision(ra io.ReaderAt, size int64) (int64, error) {
+	db, err := openBoltDB(ra, size)
+	if err != nil {
+		return 0, err
+	}
+	keys, ok, err := db.bucket(db.root, etcdKeyBucket)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, errors.Wrapf(ErrInvalidSnapshot, "snapshot has no %q bucket", etcdKeyBucket)
+	}
+	var rev int64
+	last, err := db.lastKey(keys)
+	if err != nil {
+		return 0, err
+	}
+	if len(last) >= etcdRevisionMainRevSize {
+		rev = int64(binary.BigEndian.Uint64(last))
+	}
+
+	meta, ok, err := db.bucket(db.root, etcdMetaBucket)
+	if err != nil || !ok {
+		return rev, err
+	}
+	compacted, ok, err := db.get(meta, etcdFinishedCompactKey)
+	if err != nil {
+		return 0, err
+	}
+	if ok && len(compacted) >= etcdRevisionMainRevSize {
+		if compactRev := int64(binary.BigEndian.Uint64(compacted)); compactRev > rev {
+			rev = compactRev
+		}
+	}
+	return rev, nil
+}
+
+// boltDB reads the pages of a bolt database, e.g. an etcd snapshot, without the bolt library.
+// Only the lookups needed to find the revision of an etcd snapshot are supported.
+type boltDB struct {
+	ra       io.ReaderAt
+	size     int64
+	pageSize int64
+	// root is the root page of the root bucket of the latest valid meta page.
+	root []byte
+}
+
+// openBoltDB reads the meta pages of the bolt database of size bytes read from ra, and the root page of the latest valid one.
+func openBoltDB(ra io.ReaderAt, size int64) (*boltDB, error) {
+	header := make([]byte, boltPageHeaderSize+boltMetaSize+8)
+	if _, err := ra.ReadAt(header, 0); err != nil {
+		return nil, errors.Wrap(ErrInvalidSnapshot, "failed to read meta page")
+	}
+	pageSize := int64(binary.LittleEndian.Uint32(header[boltPageHeaderSize+8:]))
+	if pageSize < int64(len(header)) || pageSize > boltMaxPageSize {
+		return nil, errors.Wrapf(ErrInvalidSnapshot, "invalid page size %d", pageSize)
+	}
+	db := &boltDB{ra: ra, size: size, pageSize: pageSize}
+
+	var (
+		root  uint64
+		txid  uint64
+		valid bool
+	)
+	for i := int64(0); i < 2; i++ {
+		if _, err := ra.ReadAt(header, i*pageSize); err != nil {
+			continue
+		}
+		if flags := binary.LittleEndian.Uint16(header[8:10]); flags != boltMetaPageFlag {
+			continue
+		}
+		meta := header[boltPageHeaderSize:]
+		if magic := binary.LittleEndian.Uint32(meta); magic != boltMagic {
+			continue
+		}
+		h := fnv.New64a()
+		h.Write(meta[:boltMetaSize])
+		if h.Sum64() != binary.LittleEndian.Uint64(meta[boltMetaSize:]) {
+			continue
+		}
+		if t := binary.LittleEndian.Uint64(meta[48:]); !valid || t > txid {
+			root, txid, valid = binary.LittleEndian.Uint64(meta[16:]), t, true
+		}
+	}
+	if !valid {
+		return nil, errors.Wrap(ErrInvalidSnapshot, "no valid meta page")
+	}
+	var err error
+	if db.root, err = db.page(root); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// page reads the page pgid along with its overflow pages.
+// The page id and overflow, read from the database, are checked against its size before the page is allocated.
+func (db *boltDB) page(pgid uint64) ([]byte, error) {
+	if pgid >= uint64(db.size/db.pageSize) {
+		return nil, errors.Wrapf(ErrInvalidSnapshot, "page %d is out of the database", pgid)
+	}
+	off := int64(pgid) * db.pageSize
+	header := make([]byte, boltPageHeaderSize)
+	if _, err := db.ra.ReadAt(header, off); err != nil {
+		return nil, errors.Wrapf(ErrInvalidSnapshot, "failed to read page %d", pgid)
+	}
+	overflow := int64(binary.LittleEndian.Uint32(header[12:16]))
+	if off+(overflow+1)*db.pageSize > db.size {
+		return nil, errors.Wrapf(ErrInvalidSnapshot, "page %d overflows the database", pgid)
+	}
+	p := make([]byte, (overflow+1)*db.pageSize)
+	if _, err := db.ra.ReadAt(p, off); err != nil {
+		return nil, errors.Wrapf(ErrInvalidSnapshot, "failed to read page %d", pgid)
+	}
+	return p, nil
+}
+
+// element returns the key and value of the i-th element of page p, and its bucket flags or child page id
+// for leaf and branch pages respectively.
+func (db *boltDB) element(p []byte, i int) (key, value []byte, flagsOrPgid uint64, err error) {
+	off := boltPageHeaderSize + i*boltElementSize
+	if off+boltElementSize > len(p) {
+		return nil, nil, 0, errors.Wrap(ErrInvalidSnapshot, "page element out of bounds")
+	}
+	e := p[off : off+boltElementSize]
+	var pos, ksize, vsize int
+	if binary.LittleEndian.Uint16(p[8:10])&boltBranchPageFlag != 0 {
+		pos, ksize = int(binary.LittleEndian.Uint32(e[0:4])), int(binary.LittleEndian.Uint32(e[4:8]))
+		flagsOrPgid = binary.LittleEndian.Uint64(e[8:16])
+	} else {
+		flagsOrPgid = uint64(binary.LittleEndian.Uint32(e[0:4]))
+		pos, ksize, vsize = int(binary.LittleEndian.Uint32(e[4:8])), int(binary.LittleEndian.Uint32(e[8:12])), int(binary.LittleEndian.Uint32(e[12:16]))
+	}
+	if pos < 0 || ksize < 0 || vsize < 0 || off+pos+ksize+vsize > len(p) {
+		return nil, nil, 0, errors.Wrap(ErrInvalidSnapshot, "page element out of bounds")
+	}
+	key = p[off+pos : off+pos+ksize]
+	value = p[off+pos+ksize : off+pos+ksize+vsize]
+	return key, value, flagsOrPgid, nil
+}
+
+// leaf returns the leaf page of the tree rooted at page p where key is or would be, or the rightmost one
+// if key is nil.
+func (db *boltDB) leaf(p []byte, key []byte) ([]byte, error) {
+	for depth := 0; ; depth++ {
+		flags := binary.LittleEndian.Uint16(p[8:10])
+		if flags&boltLeafPageFlag != 0 {
+			return p, nil
+		}
+		count := int(binary.LittleEndian.Uint16(p[10:12]))
+		if flags&boltBranchPageFlag == 0 || count == 0 || depth > 64 {
+			return nil, errors.Wrap(ErrInvalidSnapshot, "invalid branch page")
+		}
+		child := -1
+		var pgid uint64
+		for i := 0; i < count; i++ {
+			k, _, id, err := db.element(p, i)
+			if err != nil {
+				return nil, err
+			}
+			if child >= 0 && key != nil && bytes.Compare(k, key) > 0 {
+				break
+			}
+			child, pgid = i, id
+		}
+		var err error
+		if p, err = db.page(pgid); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// getElement returns the value and the flags of the leaf element of key in the tree rooted at page p,
+// and whether it was found.
+func (db *boltDB) getElement(p []byte, key string) ([]byte, uint64, bool, error) {
+	leaf, err := db.leaf(p, []byte(key))
+	if err != nil {
+		return nil, 0, false, err
+	}
+	for i := 0; i < int(binary.LittleEndian.Uint16(leaf[10:12])); i++ {
+		k, v, flags, err := db.element(leaf, i)
+		if err != nil {
+			return nil, 0, false, err
+		}
+		if string(k) == key {
+			return v, flags, true, nil
+		}
+	}
+	return nil, 0, false, nil
+}
+
+// get returns the value of key in the bucket whose root page is p, and whether it was found.
+func (db *boltDB) get(p []byte, key string) ([]byte, bool, error) {
+	v, flags, ok, err := db.getElement(p, key)
+	if err != nil || !ok || flags&boltBucketLeafFlag != 0 {
+		return nil, false, err
+	}
+	return v, true, nil
+}
+
+// bucket returns the root page of the bucket name nested in the bucket whose root page is p,
+// and whether it was found.
+func (db *boltDB) bucket(p []byte, name string) ([]byte, bool, error) {
+	v, flags, ok, err := db.getElement(p, name)
+	if err != nil || !ok || flags&boltBucketLeafFlag == 0 {
+		return nil, false, err
+	}
+	if len(v) < boltBucketHeaderSize {
+		return nil, false, errors.Wrapf(ErrInvalidSnapshot, "invalid bucket %q", name)
+	}
+	if root := binary.LittleEndian.Uint64(v); root != 0 {
+		p, err = db.page(root)
+		return p, err == nil, err
+	}
+	// Small buckets are stored inline, with their single page following their header.
+	if len(v) < boltBucketHeaderSize+boltPageHeaderSize {
+		return nil, false, errors.Wrapf(ErrInvalidSnapshot, "invalid inline bucket %q", name)
+	}
+	return v[boltBucketHeaderSize:], true, nil
+}
+
+// lastKey returns the last key of the bucket whose root page is p, or nil if it is empty.
+func (db *boltDB) lastKey(p []byte) ([]byte, error) {
+	leaf, err := db.leaf(p, nil)
+	if err != nil {
+		return nil, err
+	}
+	count := int(binary.LittleEndian.Uint16(leaf[10:12]))
+	if count == 0 {
+		return nil, nil
+	}
+	k, _, _, err := db.element(leaf, count-1)
+	return k, err
+}
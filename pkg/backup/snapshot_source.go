@@ -0,0 +1,86 @@
+// Copyright 2018 The etcd-operator Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/coreos/etcd-operator/pkg/util/constants"
+	"github.com/coreos/etcd/clientv3"
+)
+
+// SnapshotSource provides the etcd snapshots saved by a BackupManager with SaveSnapFrom.
+type SnapshotSource interface {
+	// Snapshot returns a reader of an etcd snapshot, to be closed by the caller,
+	// and the kv store revision the snapshot was taken at.
+	Snapshot(ctx context.Context) (io.ReadCloser, int64, error)
+}
+
+// etcdSnapshotSource takes snapshots with the snapshot API of an etcd member.
+type etcdSnapshotSource struct {
+	cli *clientv3.Client
+}
+
+// NewEtcdSnapshotSource creates a SnapshotSource taking snapshots of the etcd member cli is connected to
+// with the etcd snapshot API. The revision of a snapshot is the kv store revision of the member
+// right before the snapshot is taken, which the snapshot may be past if the member keeps serving writes.
+func NewEtcdSnapshotSource(cli *clientv3.Client) SnapshotSource {
+	return &etcdSnapshotSource{cli: cli}
+}
+
+func (s *etcdSnapshotSource) Snapshot(ctx context.Context) (io.ReadCloser, int64, error) {
+	getCtx, cancel := context.WithTimeout(ctx, constants.DefaultRequestTimeout)
+	resp, err := s.cli.Get(getCtx, "/", clientv3.WithSerializable())
+	cancel()
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get revision (%v)", err)
+	}
+	rc, err := s.cli.Snapshot(ctx)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to receive snapshot (%v)", err)
+	}
+	return rc, resp.Header.Revision, nil
+}
+
+// declaredRevisionSource is implemented by the SnapshotSources whose revision is declared by their caller
+// rather than read from etcd, which BackupManager.VerifyRevision checks snapshots against.
+type declaredRevisionSource interface {
+	declaredRevision()
+}
+
+// fileSnapshotSource reads snapshots saved to a local file.
+type fileSnapshotSource struct {
+	path string
+	rev  int64
+}
+
+// NewFileSnapshotSource creates a SnapshotSource reading the etcd snapshot saved to the local file on path,
+// e.g. with "etcdctl snapshot save", taken at the kv store revision rev.
+func NewFileSnapshotSource(path string, rev int64) SnapshotSource {
+	return &fileSnapshotSource{path: path, rev: rev}
+}
+
+func (s *fileSnapshotSource) declaredRevision() {}
+
+func (s *fileSnapshotSource) Snapshot(ctx context.Context) (io.ReadCloser, int64, error) {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return nil, 0, err
+	}
+	return f, s.rev, nil
+}
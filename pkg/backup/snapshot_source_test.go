@@ -0,0 +1,147 @@
+// Copyright 2018 The etcd-operator Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backup
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/coreos/etcd-operator/pkg/backup/util"
+)
+
+// fakeSnapshotSource provides a fixed snapshot taken at a fixed revision.
+type fakeSnapshotSource struct {
+	data string
+	rev  int64
+}
+
+func (s *fakeSnapshotSource) Snapshot(ctx context.Context) (io.ReadCloser, int64, error) {
+	return ioutil.NopCloser(strings.NewReader(s.data)), s.rev, nil
+}
+
+func TestSaveSnapFrom(t *testing.T) {
+	ctx := context.Background()
+	b := NewMemoryBackend()
+	bm := NewBackupManagerFromWriter(nil, b, nil, nil, "")
+
+	rev, err := bm.SaveSnapFrom(ctx, &fakeSnapshotSource{data: "snapshot", rev: 42}, "3.2.13", "cluster-a/etcd.backup", true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rev != 42 {
+		t.Errorf("expect rev=42, get=%d", rev)
+	}
+	path := "cluster-a/etcd.backup_" + util.MakeBackupName("3.2.13", 42)
+	rc, err := b.Open(ctx, path)
+	if err != nil {
+		t.Fatalf("expect the snapshot saved to %s, get=%v", path, err)
+	}
+	defer rc.Close()
+	data, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "snapshot" {
+		t.Errorf("expect saved snapshot=%q, get=%q", "snapshot", data)
+	}
+}
+
+// lengthRecordingBackend records the quota and the length of the content of the saves it is handed.
+type lengthRecordingBackend struct {
+	Backend
+	quota int64
+	size  int64
+	known bool
+}
+
+func (b *lengthRecordingBackend) Write(ctx context.Context, path string, r io.Reader) (int64, error) {
+	b.quota = util.QuotaFrom(ctx)
+	b.size, b.known = util.SourceLength(r)
+	return b.Backend.Write(ctx, path, r)
+}
+
+func TestSaveSnapFromQuota(t *testing.T) {
+	upper := func(r io.Reader) (io.Reader, error) {
+		data, err := ioutil.ReadAll(r)
+		return strings.NewReader(strings.ToUpper(string(data))), err
+	}
+	tests := []struct {
+		name       string
+		transforms []util.SnapshotTransform
+		wData      string
+	}{
+		{name: "streamed", wData: "snapshot"},
+		{name: "transformed", transforms: []util.SnapshotTransform{upper}, wData: "SNAPSHOT"},
+	}
+	for _, tt := range tests {
+		b := &lengthRecordingBackend{Backend: NewMemoryBackend()}
+		bm := NewBackupManagerFromWriter(nil, b, nil, nil, "")
+		bm.Quota = 100
+		bm.PreSaveTransforms = tt.transforms
+		// The fake source streams a snapshot of unknown length, which is spooled to be measured.
+		if _, err := bm.SaveSnapFrom(context.Background(), &fakeSnapshotSource{data: "snapshot", rev: 42}, "3.2.13", "cluster-a/etcd.backup", false, nil); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if b.quota != 100 {
+			t.Errorf("%s: expect the save to run with quota=100, get=%d", tt.name, b.quota)
+		}
+		if !b.known || b.size != int64(len(tt.wData)) {
+			t.Errorf("%s: expect the writer to know the length %d of the snapshot, get size=%d known=%v", tt.name, len(tt.wData), b.size, b.known)
+		}
+		rc, err := b.Open(context.Background(), "cluster-a/etcd.backup")
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil || string(data) != tt.wData {
+			t.Errorf("%s: expect saved snapshot=%q, get=%q (err=%v)", tt.name, tt.wData, data, err)
+		}
+	}
+}
+
+func TestFileSnapshotSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot-source")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "snapshot.db")
+	if err := ioutil.WriteFile(file, []byte("snapshot"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	rc, rev, err := NewFileSnapshotSource(file, 7).Snapshot(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	data, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "snapshot" || rev != 7 {
+		t.Errorf("expect snapshot=%q rev=7, get snapshot=%q rev=%d", "snapshot", data, rev)
+	}
+
+	if _, _, err := NewFileSnapshotSource(filepath.Join(dir, "missing.db"), 7).Snapshot(context.Background()); !os.IsNotExist(err) {
+		t.Errorf("expect a not exist error, get=%v", err)
+	}
+}
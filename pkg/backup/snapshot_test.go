@@ -0,0 +1,308 @@
+// Copyright 2018 The etcd-operator Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backup
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"hash/fnv"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/coreos/etcd-operator/pkg/backup/util"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// testSnapshot returns the first bytes of a bolt database followed by data.
+func testSnapshot(data string) []byte {
+	header := make([]byte, snapshotHeaderSize)
+	binary.LittleEndian.PutUint16(header[8:10], boltMetaPageFlag)
+	binary.LittleEndian.PutUint32(header[16:20], boltMagic)
+	return append(header, data...)
+}
+
+func TestValidateSnapshotHeader(t *testing.T) {
+	snapshot := testSnapshot("rest of the database")
+	r, err := validateSnapshotHeader(bytes.NewReader(snapshot))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The header is replayed.
+	if got, err := ioutil.ReadAll(r); err != nil || !bytes.Equal(got, snapshot) {
+		t.Errorf("expect content=%q, get=%q (err=%v)", snapshot, got, err)
+	}
+
+	for _, content := range []string{"", "short", strings.Repeat("garbage ", 10)} {
+		if _, err := validateSnapshotHeader(strings.NewReader(content)); errors.Cause(err) != ErrInvalidSnapshot {
+			t.Errorf("expect error caused by %v for %q, get=%v", ErrInvalidSnapshot, content, err)
+		}
+	}
+}
+
+func TestRestoreValidateSnapshot(t *testing.T) {
+	ctx := context.Background()
+	b := NewMemoryBackend()
+	if _, err := b.Write(ctx, "cluster-a/garbage", strings.NewReader(strings.Repeat("garbage ", 10))); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err := Restore(ctx, b, "cluster-a/etcd.backup", "cluster-a/garbage", &buf, RestoreOptions{ValidateSnapshot: true}); err == nil {
+		t.Error("expect restoring a garbage backup to fail")
+	}
+	if buf.Len() != 0 {
+		t.Errorf("expect nothing restored, get=%q", buf.String())
+	}
+}
+
+const testBoltPageSize = 4096
+
+// testBoltElement is a key and value of a bolt page, holding a bucket if bucket is true.
+type testBoltElement struct {
+	key, value string
+	bucket     bool
+}
+
+// testBoltPage returns a bolt page of id pgid with the given flags and elements, which are child page ids
+// in the values of the elements of branch pages.
+func testBoltPage(pgid uint64, flags uint16, elems []testBoltElement) []byte {
+	p := make([]byte, boltPageHeaderSize+len(elems)*boltElementSize)
+	binary.LittleEndian.PutUint64(p[0:8], pgid)
+	binary.LittleEndian.PutUint16(p[8:10], flags)
+	binary.LittleEndian.PutUint16(p[10:12], uint16(len(elems)))
+	for i, e := range elems {
+		off := boltPageHeaderSize + i*boltElementSize
+		pos := len(p) - off
+		if flags == boltBranchPageFlag {
+			binary.LittleEndian.PutUint32(p[off:], uint32(pos))
+			binary.LittleEndian.PutUint32(p[off+4:], uint32(len(e.key)))
+			binary.LittleEndian.PutUint64(p[off+8:], binary.LittleEndian.Uint64([]byte(e.value)))
+			p = append(p, e.key...)
+			continue
+		}
+		if e.bucket {
+			binary.LittleEndian.PutUint32(p[off:], boltBucketLeafFlag)
+		}
+		binary.LittleEndian.PutUint32(p[off+4:], uint32(pos))
+		binary.LittleEndian.PutUint32(p[off+8:], uint32(len(e.key)))
+		binary.LittleEndian.PutUint32(p[off+12:], uint32(len(e.value)))
+		p = append(p, e.key+e.value...)
+	}
+	return p
+}
+
+// testBoltPgid encodes a page id as the value of a branch page element, or the header of a bucket.
+func testBoltPgid(pgid uint64) string {
+	b := make([]byte, boltBucketHeaderSize)
+	binary.LittleEndian.PutUint64(b, pgid)
+	return string(b)
+}
+
+// testRevision encodes a revision like etcd does in the keys of its "key" bucket.
+func testRevision(main int64) string {
+	b := make([]byte, 17)
+	binary.BigEndian.PutUint64(b, uint64(main))
+	b[8] = '_'
+	return string(b)
+}
+
+// testBoltSnapshot returns the bolt database of an etcd snapshot holding keys at the revisions revs, in order,
+// and compacted at compactRev if it is not 0. The "key" bucket is split across two leaf pages
+// under a branch page, while the "meta" bucket is stored inline.
+func testBoltSnapshot(revs []int64, compactRev int64) []byte {
+	var left, right []testBoltElement
+	for i, rev := range revs {
+		e := testBoltElement{key: testRevision(rev), value: "kv"}
+		if i < len(revs)/2 {
+			left = append(left, e)
+		} else {
+			right = append(right, e)
+		}
+	}
+	branch := []testBoltElement{{key: "", value: testBoltPgid(4)}}
+	if len(right) != 0 {
+		branch = append(branch, testBoltElement{key: right[0].key, value: testBoltPgid(5)})
+	}
+	var metaElems []testBoltElement
+	if compactRev != 0 {
+		metaElems = append(metaElems, testBoltElement{key: etcdFinishedCompactKey, value: testRevision(compactRev)})
+	}
+	root := []testBoltElement{
+		{key: etcdKeyBucket, value: testBoltPgid(3), bucket: true},
+		{key: etcdMetaBucket, value: testBoltPgid(0) + string(testBoltPage(0, boltLeafPageFlag, metaElems)), bucket: true},
+	}
+
+	pages := [][]byte{nil, nil,
+		testBoltPage(2, boltLeafPageFlag, root),
+		testBoltPage(3, boltBranchPageFlag, branch),
+		testBoltPage(4, boltLeafPageFlag, left),
+		testBoltPage(5, boltLeafPageFlag, right),
+	}
+	for i := 0; i < 2; i++ {
+		p := make([]byte, boltPageHeaderSize+boltMetaSize+8)
+		binary.LittleEndian.PutUint64(p[0:8], uint64(i))
+		binary.LittleEndian.PutUint16(p[8:10], boltMetaPageFlag)
+		meta := p[boltPageHeaderSize:]
+		binary.LittleEndian.PutUint32(meta[0:], boltMagic)
+		binary.LittleEndian.PutUint32(meta[4:], 2)
+		binary.LittleEndian.PutUint32(meta[8:], testBoltPageSize)
+		binary.LittleEndian.PutUint64(meta[16:], 2)
+		binary.LittleEndian.PutUint64(meta[40:], uint64(len(pages)))
+		binary.LittleEndian.PutUint64(meta[48:], uint64(i))
+		h := fnv.New64a()
+		h.Write(meta[:boltMetaSize])
+		binary.LittleEndian.PutUint64(meta[boltMetaSize:], h.Sum64())
+		pages[i] = p
+	}
+	var db []byte
+	for _, p := range pages {
+		db = append(db, p...)
+		db = append(db, make([]byte, testBoltPageSize-len(p))...)
+	}
+	return db
+}
+
+func TestSnapshotRevision(t *testing.T) {
+	tests := []struct {
+		revs       []int64
+		compactRev int64
+		want       int64
+	}{
+		{revs: []int64{2, 3, 5, 8, 13}, want: 13},
+		{revs: []int64{42}, want: 42},
+		// The latest keys may have been compacted away.
+		{revs: []int64{2, 3}, compactRev: 10, want: 10},
+		{revs: []int64{2, 30}, compactRev: 10, want: 30},
+	}
+	for i, tt := range tests {
+		snapshot := testBoltSnapshot(tt.revs, tt.compactRev)
+		rev, err := snapshotRevision(bytes.NewReader(snapshot), int64(len(snapshot)))
+		if err != nil {
+			t.Errorf("#%d: %v", i, err)
+			continue
+		}
+		if rev != tt.want {
+			t.Errorf("#%d: expect rev=%d, get=%d", i, tt.want, rev)
+		}
+	}
+
+	invalid := testSnapshot("rest of the database")
+	if _, err := snapshotRevision(bytes.NewReader(invalid), int64(len(invalid))); errors.Cause(err) != ErrInvalidSnapshot {
+		t.Errorf("expect error caused by %v, get=%v", ErrInvalidSnapshot, err)
+	}
+}
+
+func TestSnapshotRevisionCorruptPageHeader(t *testing.T) {
+	tests := []struct {
+		desc    string
+		corrupt func(db []byte)
+	}{{
+		desc: "overflow of the root page beyond the database",
+		corrupt: func(db []byte) {
+			binary.LittleEndian.PutUint32(db[2*testBoltPageSize+12:], 0xFFFFFFFF)
+		},
+	}, {
+		desc: "branch element pointing beyond the database",
+		corrupt: func(db []byte) {
+			// The page id of the last element of the branch page of the "key" bucket, which holds the latest key.
+			binary.LittleEndian.PutUint64(db[3*testBoltPageSize+boltPageHeaderSize+boltElementSize+8:], 1<<62)
+		},
+	}}
+	for _, tt := range tests {
+		snapshot := testBoltSnapshot([]int64{2, 3, 5}, 0)
+		tt.corrupt(snapshot)
+		if _, err := snapshotRevision(bytes.NewReader(snapshot), int64(len(snapshot))); errors.Cause(err) != ErrInvalidSnapshot {
+			t.Errorf("%s: expect error caused by %v, get=%v", tt.desc, ErrInvalidSnapshot, err)
+		}
+	}
+}
+
+func TestSaveSnapFromVerifyRevision(t *testing.T) {
+	ctx := context.Background()
+	b := NewMemoryBackend()
+	bm := NewBackupManagerFromWriter(nil, b, nil, nil, "")
+	bm.VerifyRevision = true
+	logger, hook := newTestLogger()
+	bm.Logger = logger
+	snapshot := testBoltSnapshot([]int64{2, 3, 5}, 0)
+
+	// Like an etcd member keeping serving writes, the source reads its revision before the snapshot.
+	rev, err := bm.SaveSnapFrom(ctx, &fakeSnapshotSource{data: string(snapshot), rev: 3}, "3.2.13", "cluster-a/etcd.backup", true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rev != 5 {
+		t.Errorf("expect the revision of the snapshot 5, get=%d", rev)
+	}
+	if len(hook.entries) != 1 || hook.entries[0].Level != logrus.WarnLevel {
+		t.Fatalf("expect the renamed backup to be logged as a warning, get=%v", hook.entries)
+	}
+	if fields := hook.entries[0].Data; fields["revision"] != int64(3) || fields["snapshotRevision"] != int64(5) {
+		t.Errorf("expect the warning to log both revisions, get=%v", fields)
+	}
+	path := "cluster-a/etcd.backup_" + util.MakeBackupName("3.2.13", 5)
+	rc, err := b.Open(ctx, path)
+	if err != nil {
+		t.Fatalf("expect the snapshot saved to %s, get=%v", path, err)
+	}
+	defer rc.Close()
+	if data, err := ioutil.ReadAll(rc); err != nil || !bytes.Equal(data, snapshot) {
+		t.Errorf("expect the verified snapshot to be saved as is (err=%v)", err)
+	}
+
+	// A source at the revision of its snapshot is saved without warning.
+	hook.entries = nil
+	if _, err = bm.SaveSnapFrom(ctx, &fakeSnapshotSource{data: string(snapshot), rev: 5}, "3.2.13", "cluster-a/etcd.backup", false, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(hook.entries) != 0 {
+		t.Errorf("expect no warning, get=%v", hook.entries)
+	}
+}
+
+func TestSaveSnapFromVerifyDeclaredRevision(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot-source")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "snapshot.db")
+	if err = ioutil.WriteFile(file, testBoltSnapshot([]int64{2, 3, 5}, 0), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	b := NewMemoryBackend()
+	bm := NewBackupManagerFromWriter(nil, b, nil, nil, "")
+	bm.VerifyRevision = true
+	_, err = bm.SaveSnapFrom(ctx, NewFileSnapshotSource(file, 3), "3.2.13", "cluster-a/etcd.backup", true, nil)
+	if errors.Cause(err) != ErrRevisionMismatch {
+		t.Fatalf("expect error caused by %v, get=%v", ErrRevisionMismatch, err)
+	}
+	if n, err := b.Total(ctx, "cluster-a/etcd.backup"); err != nil || n != 0 {
+		t.Errorf("expect no backup saved, get=%d (err=%v)", n, err)
+	}
+
+	if _, err = bm.SaveSnapFrom(ctx, NewFileSnapshotSource(file, 5), "3.2.13", "cluster-a/etcd.backup", true, nil); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := b.Total(ctx, "cluster-a/etcd.backup"); err != nil || n != 1 {
+		t.Errorf("expect 1 backup saved, get=%d (err=%v)", n, err)
+	}
+}
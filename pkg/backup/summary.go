@@ -0,0 +1,78 @@
+// Copyright 2018 The etcd-operator Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backup
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/coreos/etcd-operator/pkg/backup/reader"
+	"github.com/coreos/etcd-operator/pkg/backup/util"
+)
+
+// BackupSummary describes the backups saved with revision appended to a backup path.
+type BackupSummary struct {
+	// Count is the number of backups.
+	Count int
+	// TotalBytes is the total size of the backups in bytes.
+	TotalBytes int64
+	// Oldest and Newest are the save times of the oldest and the newest backups, or zero if there are none.
+	Oldest time.Time
+	Newest time.Time
+	// Versions is the number of backups of each etcd version. Backups whose names carry no version are not counted.
+	Versions map[string]int
+}
+
+// Summary summarizes the backups saved with revision appended to path of r in a single listing of the backups,
+// e.g. to report the backup status of a cluster. Save times are taken like in AgeHistogram.
+func Summary(ctx context.Context, r reader.Reader, path string) (*BackupSummary, error) {
+	s := &BackupSummary{Versions: map[string]int{}}
+	err := r.WalkBackups(ctx, path, func(info util.BackupInfo) error {
+		s.Count++
+		s.TotalBytes += info.Size
+		saved := info.SavedAt()
+		if s.Oldest.IsZero() || saved.Before(s.Oldest) {
+			s.Oldest = saved
+		}
+		if saved.After(s.Newest) {
+			s.Newest = saved
+		}
+		if len(info.Version) != 0 {
+			s.Versions[info.Version]++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// ListInfo returns the info of the backups saved with revision appended to path of r, including their names
+// and sizes, sorted from the oldest to the latest with util.ByRecency. Like Summary, it is built from a single
+// listing of the backups, which only returns their names and properties, so no backup file is downloaded.
+func ListInfo(ctx context.Context, r reader.Reader, path string) ([]util.BackupInfo, error) {
+	var infos []util.BackupInfo
+	err := r.WalkBackups(ctx, path, func(info util.BackupInfo) error {
+		infos = append(infos, info)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(util.ByRecency(infos))
+	return infos, nil
+}
@@ -0,0 +1,133 @@
+// Copyright 2018 The etcd-operator Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backup
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd-operator/pkg/backup/reader"
+	"github.com/coreos/etcd-operator/pkg/backup/util"
+)
+
+func TestSummary(t *testing.T) {
+	ctx := context.Background()
+	b := NewMemoryBackend()
+	now := time.Now().UTC()
+	backups := []struct {
+		age     time.Duration
+		ver     string
+		content string
+	}{
+		{age: 3 * time.Hour, ver: "3.2.13", content: "backup"},
+		{age: time.Hour, ver: "3.2.13", content: "backup-2"},
+		{age: 30 * time.Minute, ver: "3.5.0-rc.0", content: "backup-03"},
+	}
+	for i, bk := range backups {
+		path := "cluster-a/etcd.backup_" + util.MakeTimestampedBackupName(now.Add(-bk.age), "", bk.ver, int64(i+1))
+		if _, err := b.Write(ctx, path, strings.NewReader(bk.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Backups of other paths are not summarized.
+	if _, err := b.Write(ctx, "cluster-b/etcd.backup_"+util.MakeBackupName("3.2.13", 1), strings.NewReader("backup")); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := Summary(ctx, b, "cluster-a/etcd.backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Count != 3 {
+		t.Errorf("expect count=3, get=%d", s.Count)
+	}
+	if s.TotalBytes != 6+8+9 {
+		t.Errorf("expect total bytes=%d, get=%d", 6+8+9, s.TotalBytes)
+	}
+	// Save times embedded in names are rounded by the BackupTimestampFormat.
+	if oldest := now.Add(-3 * time.Hour); s.Oldest.Sub(oldest) > time.Microsecond || oldest.Sub(s.Oldest) > time.Microsecond {
+		t.Errorf("expect oldest=%v, get=%v", oldest, s.Oldest)
+	}
+	if newest := now.Add(-30 * time.Minute); s.Newest.Sub(newest) > time.Microsecond || newest.Sub(s.Newest) > time.Microsecond {
+		t.Errorf("expect newest=%v, get=%v", newest, s.Newest)
+	}
+	if want := map[string]int{"3.2.13": 2, "3.5.0-rc.0": 1}; !reflect.DeepEqual(s.Versions, want) {
+		t.Errorf("expect versions=%v, get=%v", want, s.Versions)
+	}
+
+	empty, err := Summary(ctx, b, "cluster-c/etcd.backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if empty.Count != 0 || !empty.Oldest.IsZero() || !empty.Newest.IsZero() {
+		t.Errorf("expect an empty summary, get=%+v", empty)
+	}
+}
+
+// downloadCountingReader counts the backup files opened with the reader.Reader it wraps.
+type downloadCountingReader struct {
+	reader.Reader
+	downloads int
+}
+
+func (r *downloadCountingReader) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	r.downloads++
+	return r.Reader.Open(ctx, path)
+}
+
+func (r *downloadCountingReader) OpenLimited(ctx context.Context, path string, maxBytes int64) (io.ReadCloser, error) {
+	r.downloads++
+	return r.Reader.OpenLimited(ctx, path, maxBytes)
+}
+
+func (r *downloadCountingReader) OpenRange(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
+	r.downloads++
+	return r.Reader.OpenRange(ctx, path, offset)
+}
+
+func TestListInfo(t *testing.T) {
+	ctx := context.Background()
+	b := NewMemoryBackend()
+	now := time.Now().UTC()
+	var names []string
+	for i, content := range []string{"backup-03", "backup", "backup-2"} {
+		name := "etcd.backup_" + util.MakeTimestampedBackupName(now.Add(-time.Duration(3-i)*time.Hour), "", "3.2.13", int64(i+1))
+		if _, err := b.Write(ctx, "cluster-a/"+name, strings.NewReader(content)); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+
+	r := &downloadCountingReader{Reader: b}
+	infos, err := ListInfo(ctx, r, "cluster-a/etcd.backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.downloads != 0 {
+		t.Errorf("expect no backup to be downloaded, get %d downloads", r.downloads)
+	}
+	if len(infos) != len(names) {
+		t.Fatalf("expect %d backups, get %d", len(names), len(infos))
+	}
+	for i, size := range []int64{9, 6, 8} {
+		if infos[i].Size != size || !strings.HasSuffix(infos[i].Name, names[i]) {
+			t.Errorf("#%d: expect name=%s size=%d, get name=%s size=%d", i, names[i], size, infos[i].Name, infos[i].Size)
+		}
+	}
+}
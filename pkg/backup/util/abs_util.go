@@ -0,0 +1,375 @@
+// Copyright 2018 The etcd-operator Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/storage"
+	"github.com/pkg/errors"
+)
+
+// ErrContainerNotFound is the cause of the error returned when an ABS container does not exist.
+// Use errors.Cause(err) == ErrContainerNotFound or IsContainerNotFound(err) to check for it.
+var ErrContainerNotFound = errors.New("container does not exist")
+
+type containerNotFoundError struct {
+	container string
+}
+
+func (e *containerNotFoundError) Error() string {
+	return fmt.Sprintf("container %v does not exist", e.container)
+}
+
+func (e *containerNotFoundError) Cause() error {
+	return ErrContainerNotFound
+}
+
+// IsContainerNotFound returns true if the cause of err is ErrContainerNotFound.
+func IsContainerNotFound(err error) bool {
+	return errors.Cause(err) == ErrContainerNotFound
+}
+
+// ErrBlobArchived is the cause of the error returned when reading a blob in the Archive access tier,
+// which has to be rehydrated to the Hot or Cool tier before it can be read.
+var ErrBlobArchived = errors.New("blob is archived")
+
+// blobArchivedErrorCode is the ABS error code returned when reading an archived blob.
+const blobArchivedErrorCode = "BlobArchived"
+
+type blobArchivedError struct {
+	blob string
+}
+
+func (e *blobArchivedError) Error() string {
+	return fmt.Sprintf("blob %v is in the Archive access tier and cannot be read; rehydrate it to the Hot or Cool tier first", e.blob)
+}
+
+func (e *blobArchivedError) Cause() error {
+	return ErrBlobArchived
+}
+
+// IsBlobArchived returns true if the cause of err is ErrBlobArchived.
+func IsBlobArchived(err error) bool {
+	return errors.Cause(err) == ErrBlobArchived
+}
+
+// CheckBlobArchived converts the error returned by reading the given blob into
+// an error with ErrBlobArchived as cause if the blob is archived. Other errors are returned as is.
+func CheckBlobArchived(blob string, err error) error {
+	switch serr := errors.Cause(err).(type) {
+	case storage.AzureStorageServiceError:
+		if serr.Code == blobArchivedErrorCode {
+			return &blobArchivedError{blob}
+		}
+	case *storage.AzureStorageServiceError:
+		if serr.Code == blobArchivedErrorCode {
+			return &blobArchivedError{blob}
+		}
+	}
+	return err
+}
+
+// StorageError is an error returned by an ABS request along with the details Azure support asks for.
+// Use AsStorageError to get it from the errors of backup operations.
+type StorageError struct {
+	// StatusCode is the HTTP status code of the response.
+	StatusCode int
+	// Code is the ABS error code of the response, e.g. "BlobNotFound", if any.
+	Code string
+	// RequestID is the x-ms-request-id of the failed request.
+	RequestID string
+	// Err is the error returned by the storage SDK.
+	Err error
+}
+
+func (e *StorageError) Error() string {
+	return fmt.Sprintf("%v (status code %d, request id %s)", e.Err, e.StatusCode, e.RequestID)
+}
+
+// Cause returns the error of the storage SDK, so that errors.Cause still returns it.
+func (e *StorageError) Cause() error {
+	return e.Err
+}
+
+// WrapStorageError wraps err into a *StorageError if it is an ABS service error.
+// Other errors are returned as is.
+func WrapStorageError(err error) error {
+	switch serr := err.(type) {
+	case storage.AzureStorageServiceError:
+		return &StorageError{StatusCode: serr.StatusCode, Code: serr.Code, RequestID: serr.RequestID, Err: err}
+	case *storage.AzureStorageServiceError:
+		return &StorageError{StatusCode: serr.StatusCode, Code: serr.Code, RequestID: serr.RequestID, Err: err}
+	}
+	return err
+}
+
+// AsStorageError returns the *StorageError found by following the causes of err, and false if there is none.
+func AsStorageError(err error) (*StorageError, bool) {
+	for err != nil {
+		if serr, ok := err.(*StorageError); ok {
+			return serr, true
+		}
+		cause, ok := err.(interface {
+			Cause() error
+		})
+		if !ok {
+			break
+		}
+		err = cause.Cause()
+	}
+	return nil, false
+}
+
+// HasStatusCode returns true if err wraps an ABS service error of the given HTTP status code.
+func HasStatusCode(err error, code int) bool {
+	serr, ok := AsStorageError(err)
+	return ok && serr.StatusCode == code
+}
+
+// GetContainer returns the reference of the given ABS container,
+// or an error with ErrContainerNotFound as cause if it does not exist.
+func GetContainer(abs *storage.BlobStorageClient, container string) (*storage.Container, error) {
+	containerRef := abs.GetContainerReference(container)
+	containerExists, err := containerRef.Exists()
+	if err != nil {
+		return nil, err
+	}
+
+	if !containerExists {
+		return nil, &containerNotFoundError{container}
+	}
+	return containerRef, nil
+}
+
+// GetOrCreateContainer returns the container, creating it with the given public access level if it does not exist.
+func GetOrCreateContainer(abs *storage.BlobStorageClient, container string, access storage.ContainerAccessType) (*storage.Container, error) {
+	containerRef := abs.GetContainerReference(container)
+	if _, err := containerRef.CreateIfNotExists(&storage.CreateContainerOptions{Access: access}); err != nil {
+		return nil, fmt.Errorf("failed to create container (%v): %v", container, err)
+	}
+	return containerRef, nil
+}
+
+// ListWithPrefix lists all blobs in the container whose names start with prefix.
+// If subPrefix is not empty, the listing is scoped to "<prefix>/<subPrefix>" instead,
+// so that backups of different clusters sharing a prefix are kept apart.
+func ListWithPrefix(containerRef *storage.Container, prefix, subPrefix string) ([]storage.Blob, error) {
+	return listBlobs(containerRef, storage.ListBlobsParameters{Prefix: scopedPrefix(prefix, subPrefix)})
+}
+
+// scopedPrefix returns the prefix ListWithPrefix lists blobs with.
+func scopedPrefix(prefix, subPrefix string) string {
+	if len(subPrefix) == 0 {
+		return prefix
+	}
+	return prefix + "/" + subPrefix
+}
+
+// splitClusterPrefix splits the prefix the names of the backups of a backup path start with, e.g.
+// "<namespace>/<cluster>/etcd.backup_", into the prefix of the cluster, "<namespace>/<cluster>",
+// and the sub-prefix of the backups within it, "etcd.backup_", to be listed with ListWithPrefix.
+// A prefix without a "/" followed by more characters is all returned as the prefix of the cluster.
+func splitClusterPrefix(prefix string) (string, string) {
+	i := strings.LastIndex(prefix, "/")
+	if i < 0 || i == len(prefix)-1 {
+		return prefix, ""
+	}
+	return prefix[:i], prefix[i+1:]
+}
+
+// listBlobs lists all blobs in the container matching params, following the continuation markers.
+// Soft deleted blobs are never listed, since listings only include them when asked to.
+// Listings only return the names and properties of blobs, plus their metadata if asked to, never their contents.
+func listBlobs(containerRef *storage.Container, params storage.ListBlobsParameters) ([]storage.Blob, error) {
+	blobs := []storage.Blob{}
+	for {
+		resp, err := containerRef.ListBlobs(params)
+		if err != nil {
+			return nil, err
+		}
+		blobs = append(blobs, resp.Blobs...)
+		if len(resp.NextMarker) == 0 {
+			break
+		}
+		params.Marker = resp.NextMarker
+	}
+	return blobs, nil
+}
+
+// ListUncommittedBlobs lists the blobs whose name starts with prefix in the container that only consist of
+// uncommitted blocks, which interrupted block uploads leave behind. Such blobs are not listed otherwise.
+func ListUncommittedBlobs(containerRef *storage.Container, prefix string) ([]BackupFile, error) {
+	committed, err := ListWithPrefix(containerRef, prefix, "")
+	if err != nil {
+		return nil, err
+	}
+	all, err := listBlobs(containerRef, storage.ListBlobsParameters{
+		Prefix:  prefix,
+		Include: &storage.IncludeBlobDataset{UncommittedBlobs: true},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return uncommittedBlobs(all, committed), nil
+}
+
+// uncommittedBlobs returns the blobs listed along with uncommitted blobs that are not listed as committed ones.
+func uncommittedBlobs(all, committed []storage.Blob) []BackupFile {
+	names := make(map[string]bool, len(committed))
+	for _, blob := range committed {
+		names[blob.Name] = true
+	}
+	var files []BackupFile
+	for _, blob := range all {
+		if names[blob.Name] {
+			continue
+		}
+		files = append(files, BackupFile{
+			Name:         blob.Name,
+			LastModified: time.Time(blob.Properties.LastModified),
+			Size:         blob.Properties.ContentLength,
+		})
+	}
+	return files
+}
+
+// ListBackupFiles lists the backup files saved with revision appended to key in the container.
+// Temporary blobs of backups being saved are not listed.
+func ListBackupFiles(containerRef *storage.Container, key string) ([]BackupFile, error) {
+	files, err := ListBackupFilesWithPrefix(containerRef, fmt.Sprintf("%s_", key))
+	if err != nil {
+		return nil, err
+	}
+	files, _ = SplitTmpFiles(files)
+	return files, nil
+}
+
+// ListBackupFilesWithPrefix lists the blobs whose name starts with prefix in the container as backup files,
+// scoped with ListWithPrefix to the sub-prefix of the backups within the prefix of their cluster.
+func ListBackupFilesWithPrefix(containerRef *storage.Container, prefix string) ([]BackupFile, error) {
+	clusterPrefix, subPrefix := splitClusterPrefix(prefix)
+	blobs, err := ListWithPrefix(containerRef, clusterPrefix, subPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]BackupFile, 0, len(blobs))
+	for _, blob := range blobs {
+		files = append(files, BackupFile{
+			Name:         blob.Name,
+			LastModified: time.Time(blob.Properties.LastModified),
+			Size:         blob.Properties.ContentLength,
+		})
+	}
+	return files, nil
+}
+
+const (
+	// blobAPIVersion is the storage API version of the blob requests the storage SDK has no support for,
+	// the first one supporting Undelete Blob, which is newer than the one the storage SDK speaks.
+	blobAPIVersion = "2017-07-29"
+	// blobSASExpiry bounds the validity of the shared access signature these requests are sent with.
+	blobSASExpiry = 5 * time.Minute
+)
+
+// UndeleteBlob restores the soft deleted blob along with its snapshots, which is only possible
+// within the soft delete retention period of the storage account. Since the storage SDK has no Undelete Blob,
+// the request is sent with http.DefaultClient, authorized by a short-lived shared access signature of the blob.
+// Errors of the service are returned as storage.AzureStorageServiceError like the ones of the storage SDK.
+func UndeleteBlob(ctx context.Context, blob *storage.Blob) error {
+	perms := storage.BlobServiceSASPermissions{Write: true, Delete: true}
+	return putBlobComp(ctx, blob, perms, "undelete", nil, "undelete blob")
+}
+
+// AccessTier is the access tier of a block blob, which trades the cost of storing the blob
+// against the cost and latency of reading it.
+type AccessTier string
+
+const (
+	// AccessTierHot is the tier of blobs read often, cheapest to read.
+	AccessTierHot AccessTier = "Hot"
+	// AccessTierCool is the tier of blobs read rarely, cheaper to store than Hot ones.
+	AccessTierCool AccessTier = "Cool"
+	// AccessTierArchive blobs can't be read until they are rehydrated to the Hot or Cool tier, which takes hours.
+	// Reading them fails with an error whose cause is ErrBlobArchived.
+	AccessTierArchive AccessTier = "Archive"
+)
+
+// ValidateAccessTier checks that tier is one of AccessTierHot, AccessTierCool and AccessTierArchive.
+func ValidateAccessTier(tier AccessTier) error {
+	switch tier {
+	case AccessTierHot, AccessTierCool, AccessTierArchive:
+		return nil
+	}
+	return fmt.Errorf("invalid access tier %q: must be one of %s, %s and %s", tier, AccessTierHot, AccessTierCool, AccessTierArchive)
+}
+
+// SetBlobTier sets the access tier of the block blob, which must be in a storage account supporting
+// blob level tiering, such as general purpose v2 accounts. Moving a blob out of AccessTierArchive starts
+// its rehydration, during which it stays archived. Like UndeleteBlob, the request is sent outside of the storage SDK,
+// which has no Set Blob Tier.
+func SetBlobTier(ctx context.Context, blob *storage.Blob, tier AccessTier) error {
+	if err := ValidateAccessTier(tier); err != nil {
+		return err
+	}
+	header := http.Header{}
+	header.Set("x-ms-access-tier", string(tier))
+	return putBlobComp(ctx, blob, storage.BlobServiceSASPermissions{Write: true}, "tier", header, "set the access tier of blob")
+}
+
+// putBlobComp sends the PUT request of the blob operation comp with the given header to blob,
+// authorized by a short-lived shared access signature of the blob with perms, with http.DefaultClient.
+// Errors of the service are returned as storage.AzureStorageServiceError, describing the failure to op.
+func putBlobComp(ctx context.Context, blob *storage.Blob, perms storage.BlobServiceSASPermissions, comp string, header http.Header, op string) error {
+	uri, err := blob.GetSASURI(storage.BlobSASOptions{
+		BlobServiceSASPermissions: perms,
+		SASOptions: storage.SASOptions{
+			APIVersion: blobAPIVersion,
+			Expiry:     time.Now().Add(blobSASExpiry),
+			UseHTTPS:   true,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPut, uri+"&comp="+comp, nil)
+	if err != nil {
+		return err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("x-ms-version", blobAPIVersion)
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// Set Blob Tier returns 202 Accepted while a blob is rehydrated.
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
+		return storage.AzureStorageServiceError{
+			Code:       resp.Header.Get("x-ms-error-code"),
+			Message:    fmt.Sprintf("failed to %s %s: %s", op, blob.Name, resp.Status),
+			StatusCode: resp.StatusCode,
+			RequestID:  resp.Header.Get("x-ms-request-id"),
+		}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+// Copyright 2018 The etcd-operator Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// ErrChecksumMismatch is the cause of the error returned when the content of a backup
+// does not match its stored checksum.
+var ErrChecksumMismatch = errors.New("backup checksum mismatch")
+
+type checksumReadCloser struct {
+	rc       io.ReadCloser
+	h        hash.Hash
+	checksum string
+}
+
+// NewChecksumReadCloser returns a ReadCloser of rc that computes the SHA-256 checksum of the content as it is read
+// and, once rc returns io.EOF, returns an error with ErrChecksumMismatch as cause instead
+// if it does not equal checksum, the hex encoded SHA-256 checksum stored along with the backup.
+func NewChecksumReadCloser(rc io.ReadCloser, checksum string) io.ReadCloser {
+	return &checksumReadCloser{rc: rc, h: sha256.New(), checksum: checksum}
+}
+
+func (c *checksumReadCloser) Read(p []byte) (int, error) {
+	n, err := c.rc.Read(p)
+	c.h.Write(p[:n])
+	if err == io.EOF {
+		if got := hex.EncodeToString(c.h.Sum(nil)); got != c.checksum {
+			return n, errors.Wrapf(ErrChecksumMismatch, "expected %s, got %s", c.checksum, got)
+		}
+	}
+	return n, err
+}
+
+func (c *checksumReadCloser) Close() error {
+	return c.rc.Close()
+}
@@ -0,0 +1,46 @@
+// Copyright 2018 The etcd-operator Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestChecksumReadCloser(t *testing.T) {
+	sum := sha256.Sum256([]byte("backup"))
+	checksum := hex.EncodeToString(sum[:])
+
+	got, err := ioutil.ReadAll(NewChecksumReadCloser(ioutil.NopCloser(strings.NewReader("backup")), checksum))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "backup" {
+		t.Errorf("expect content=backup, get=%s", got)
+	}
+
+	got, err = ioutil.ReadAll(NewChecksumReadCloser(ioutil.NopCloser(strings.NewReader("corrupted")), checksum))
+	if errors.Cause(err) != ErrChecksumMismatch {
+		t.Errorf("expect error caused by %v, get=%v", ErrChecksumMismatch, err)
+	}
+	if string(got) != "corrupted" {
+		t.Errorf("expect the whole content to be read before the mismatch, get=%s", got)
+	}
+}
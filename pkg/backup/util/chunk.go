@@ -0,0 +1,118 @@
+// Copyright 2018 The etcd-operator Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/rand"
+)
+
+// ChunkSizes bounds the size of the content-defined chunks of a backup.
+type ChunkSizes struct {
+	// Min is the minimum size of a chunk, except the last one.
+	Min int
+	// Avg is the average size of a chunk. It must be a power of two.
+	Avg int
+	// Max is the maximum size of a chunk.
+	Max int
+}
+
+// DefaultChunkSizes are the chunk sizes of deduplicated backups.
+var DefaultChunkSizes = ChunkSizes{Min: 512 * 1024, Avg: 2 * 1024 * 1024, Max: 8 * 1024 * 1024}
+
+// ChunkManifest lists the chunks a deduplicated backup is reassembled from.
+type ChunkManifest struct {
+	// Size is the size of the backup in bytes.
+	Size int64 `json:"size"`
+	// Chunks are the hex encoded SHA-256 checksums of the chunks of the backup, in order.
+	Chunks []string `json:"chunks"`
+}
+
+// gearTable maps each byte to a random value for the rolling hash of SplitChunks.
+// It is generated from a fixed seed so that chunk boundaries are the same across runs.
+var gearTable = func() [256]uint64 {
+	var t [256]uint64
+	rnd := rand.New(rand.NewSource(0x65746364))
+	for i := range t {
+		t[i] = uint64(rnd.Int63())<<1 | uint64(rnd.Int63()&1)
+	}
+	return t
+}()
+
+// SplitChunks splits the content of r into content-defined chunks with a gear rolling hash, calling fn
+// for each of them in order, and returns the size of the content. Since chunk boundaries only depend
+// on the bytes preceding them, content shared by two backups is mostly split into the same chunks.
+// The chunk passed to fn is only valid until fn returns.
+func SplitChunks(r io.Reader, sizes ChunkSizes, fn func(chunk []byte) error) (int64, error) {
+	if sizes.Min <= 0 || sizes.Avg < sizes.Min || sizes.Max < sizes.Avg || sizes.Avg&(sizes.Avg-1) != 0 {
+		return 0, fmt.Errorf("invalid chunk sizes: %+v", sizes)
+	}
+	// A boundary is found when the top bits of the hash, which depend on the last 64 bytes, are all zero.
+	bits := uint(0)
+	for 1<<bits < sizes.Avg {
+		bits++
+	}
+	mask := ^uint64(0) << (64 - bits)
+	if bits == 0 {
+		mask = 0
+	}
+
+	br := bufio.NewReader(r)
+	buf := make([]byte, 0, sizes.Max)
+	var (
+		h    uint64
+		size int64
+	)
+	for {
+		b, err := br.ReadByte()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return size, err
+		}
+		buf = append(buf, b)
+		h = h<<1 + gearTable[b]
+		if (len(buf) >= sizes.Min && h&mask == 0) || len(buf) >= sizes.Max {
+			if err := fn(buf); err != nil {
+				return size, err
+			}
+			size += int64(len(buf))
+			buf, h = buf[:0], 0
+		}
+	}
+	if len(buf) != 0 {
+		if err := fn(buf); err != nil {
+			return size, err
+		}
+		size += int64(len(buf))
+	}
+	return size, nil
+}
+
+// ChunkHash returns the hex encoded SHA-256 checksum identifying chunk.
+func ChunkHash(chunk []byte) string {
+	sum := sha256.Sum256(chunk)
+	return hex.EncodeToString(sum[:])
+}
+
+// ChunkBlobName returns the name of the blob storing the chunk with the given hash.
+func ChunkBlobName(hash string) string {
+	return ChunkPrefix + hash
+}
@@ -0,0 +1,77 @@
+// Copyright 2018 The etcd-operator Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+var testChunkSizes = ChunkSizes{Min: 4 * 1024, Avg: 16 * 1024, Max: 64 * 1024}
+
+func splitTestChunks(t *testing.T, data []byte) []string {
+	var (
+		hashes []string
+		joined []byte
+	)
+	size, err := SplitChunks(bytes.NewReader(data), testChunkSizes, func(chunk []byte) error {
+		if len(chunk) > testChunkSizes.Max {
+			t.Errorf("expect chunks of at most %d bytes, get=%d", testChunkSizes.Max, len(chunk))
+		}
+		hashes = append(hashes, ChunkHash(chunk))
+		joined = append(joined, chunk...)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != int64(len(data)) || !bytes.Equal(joined, data) {
+		t.Fatalf("expect chunks to reassemble the content of %d bytes, get %d bytes", len(data), size)
+	}
+	return hashes
+}
+
+func TestSplitChunks(t *testing.T) {
+	first := make([]byte, 1024*1024)
+	rand.New(rand.NewSource(1)).Read(first)
+	// The second payload differs by a few bytes in the middle, like consecutive snapshots.
+	second := make([]byte, len(first))
+	copy(second, first)
+	copy(second[len(second)/2:], []byte("changed"))
+
+	seen := map[string]bool{}
+	firstChunks := splitTestChunks(t, first)
+	for _, h := range firstChunks {
+		seen[h] = true
+	}
+	added := 0
+	for _, h := range splitTestChunks(t, second) {
+		if !seen[h] {
+			added++
+		}
+	}
+	if added == 0 || added > 2 {
+		t.Errorf("expect the change to add 1 or 2 chunks to the %d ones of the first payload, get=%d", len(firstChunks), added)
+	}
+}
+
+func TestSplitChunksInvalidSizes(t *testing.T) {
+	for _, sizes := range []ChunkSizes{{}, {Min: 4, Avg: 12, Max: 16}, {Min: 8, Avg: 4, Max: 16}, {Min: 4, Avg: 8, Max: 4}} {
+		if _, err := SplitChunks(bytes.NewReader([]byte("backup")), sizes, func([]byte) error { return nil }); err == nil {
+			t.Errorf("expect error for chunk sizes %+v", sizes)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright 2018 The etcd-operator Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+)
+
+// gzipMagic are the first bytes of a gzip stream.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// CompressReader returns a reader which yields the gzip compressed content of r.
+func CompressReader(r io.Reader) io.Reader {
+	pr, pw := io.Pipe()
+	go func() {
+		gw := gzip.NewWriter(pw)
+		_, err := io.Copy(gw, r)
+		if cerr := gw.Close(); err == nil {
+			err = cerr
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
+
+type gzipReadCloser struct {
+	*gzip.Reader
+	rc io.ReadCloser
+}
+
+func (g *gzipReadCloser) Close() error {
+	g.Reader.Close()
+	return g.rc.Close()
+}
+
+// DecompressReadCloser wraps rc to return the decompressed content of a gzip stream.
+// Closing the returned ReadCloser also closes rc.
+func DecompressReadCloser(rc io.ReadCloser) (io.ReadCloser, error) {
+	gr, err := gzip.NewReader(rc)
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+	return &gzipReadCloser{Reader: gr, rc: rc}, nil
+}
+
+type bufferedReadCloser struct {
+	*bufio.Reader
+	rc io.ReadCloser
+}
+
+func (b *bufferedReadCloser) Close() error {
+	return b.rc.Close()
+}
+
+// DecompressIfGzipped wraps rc like DecompressReadCloser if its content starts with the gzip magic bytes,
+// whatever the name of the backup, and otherwise returns a ReadCloser of the content of rc as is.
+// etcd snapshots never start with them since the first page id of a bolt database is 0.
+// Closing the returned ReadCloser also closes rc.
+func DecompressIfGzipped(rc io.ReadCloser) (io.ReadCloser, error) {
+	br := bufio.NewReader(rc)
+	magic, err := br.Peek(len(gzipMagic))
+	if err != nil && err != io.EOF {
+		rc.Close()
+		return nil, err
+	}
+	if len(magic) == len(gzipMagic) && magic[0] == gzipMagic[0] && magic[1] == gzipMagic[1] {
+		return DecompressReadCloser(&bufferedReadCloser{Reader: br, rc: rc})
+	}
+	return &bufferedReadCloser{Reader: br, rc: rc}, nil
+}
@@ -0,0 +1,73 @@
+// Copyright 2018 The etcd-operator Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestCompressRoundTrip(t *testing.T) {
+	data := bytes.Repeat([]byte("etcd-operator backup "), 4096)
+
+	compressed, err := ioutil.ReadAll(CompressReader(bytes.NewReader(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(compressed) >= len(data) {
+		t.Errorf("expect compressed size < %d, get=%d", len(data), len(compressed))
+	}
+
+	rc, err := DecompressReadCloser(ioutil.NopCloser(bytes.NewReader(compressed)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	got, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("decompressed content does not match the original")
+	}
+}
+
+func TestDecompressIfGzipped(t *testing.T) {
+	data := []byte("etcd-operator backup")
+	compressed, err := ioutil.ReadAll(CompressReader(bytes.NewReader(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, content := range [][]byte{compressed, data, {gzipMagic[0]}, nil} {
+		rc, err := DecompressIfGzipped(ioutil.NopCloser(bytes.NewReader(content)))
+		if err != nil {
+			t.Fatalf("#%d: %v", i, err)
+		}
+		got, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("#%d: %v", i, err)
+		}
+		want := content
+		if i == 0 {
+			want = data
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("#%d: expect content=%q, get=%q", i, want, got)
+		}
+	}
+}
@@ -14,6 +14,63 @@
 
 package util
 
+import "time"
+
+// TmpFileMaxAge is the age after which temporary backup files left by interrupted saves are purged.
+const TmpFileMaxAge = time.Hour
+
+// DefaultMinKeep is the number of latest backups purges keep whatever their policy, unless configured otherwise.
+const DefaultMinKeep = 1
+
+// DefaultBlockParallelism is the number of blocks of a backup staged concurrently, unless configured otherwise.
+const DefaultBlockParallelism = 4
+
+// DefaultPurgeWorkers is the number of backups a purge deletes concurrently, unless configured otherwise.
+const DefaultPurgeWorkers = 8
+
+// DefaultOperationTimeout bounds each backup storage request, and each operation not streaming backup content,
+// whose context has no earlier deadline.
+const DefaultOperationTimeout = 5 * time.Minute
+
 const (
 	BackupFilenameSuffix = "etcd.backup"
+	// ClusterNamePrefix prefixes the cluster name segment of backup names made by MakeClusterBackupName.
+	ClusterNamePrefix = "cluster="
+	// BackupTimestampFormat is the layout of the save time embedded in backup names by MakeTimestampedBackupName.
+	// It has a fixed width, so that the names of backups sort in the order they were saved.
+	BackupTimestampFormat = "20060102T150405.000000000Z"
+	// DefaultClusterName is the cluster the backups whose names carry no cluster name belong to.
+	DefaultClusterName = "default"
+	// GzipSuffix is appended to the name of gzip compressed backups.
+	GzipSuffix = ".gz"
+	// TmpSuffix is appended to the name of backups being saved until they are complete.
+	TmpSuffix = ".tmp"
+	// ManifestSuffix is appended to the name of deduplicated backups, which list the chunks of the backup.
+	ManifestSuffix = ".manifest"
+	// LatestPointerSuffix is appended to a backup path to name the blob holding the name of its latest backup.
+	// It does not start with "_" so that the pointer is never listed along with the backups of the path.
+	LatestPointerSuffix = ".LATEST"
+	// IndexSuffix is appended to a backup path to name the blob indexing the checksums and sizes of its backups.
+	IndexSuffix = ".index.json"
+	// WALSuffix is appended to a backup path to name the prefix the WAL segments of its cluster are appended under,
+	// as "<backup-path>.wal/<segment-id>". Like LatestPointerSuffix, it keeps WAL segments apart from the backups.
+	WALSuffix = ".wal"
+	// ChunkPrefix is the blob name prefix the chunks of deduplicated backups are stored under in their container.
+	ChunkPrefix = "chunks/"
+	// MetadataSHA256 is the blob metadata key of the hex encoded SHA-256 checksum of a backup.
+	MetadataSHA256 = "sha256"
+	// MetadataEtcdVersion is the blob metadata key of the etcd version a backup was taken from.
+	MetadataEtcdVersion = "etcd_version"
+	// MetadataEtcdRevision is the blob metadata key of the etcd revision a backup was taken at.
+	MetadataEtcdRevision = "etcd_revision"
+	// MetadataClusterName is the blob metadata key of the name of the backed up etcd cluster.
+	MetadataClusterName = "cluster_name"
+	// MetadataCompleted is the blob metadata key of the RFC 3339 time a backup finished uploading at.
+	MetadataCompleted = "completed"
+	// MetadataTagPrefix prefixes the blob metadata keys of the tags of a backup. Tags are saved as metadata
+	// since the storage SDK in use does not support blob index tags.
+	MetadataTagPrefix = "tag_"
+
+	// BackupContentType is the content type backups are saved with.
+	BackupContentType = "application/octet-stream"
 )
@@ -0,0 +1,113 @@
+// Copyright 2018 The etcd-operator Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+// NewContextReader returns a reader of r that fails with ctx.Err() once ctx is done.
+// It lets uploads and downloads through clients that don't take a context abort on cancellation.
+func NewContextReader(ctx context.Context, r io.Reader) io.Reader {
+	return &contextReader{ctx: ctx, r: r}
+}
+
+func (cr *contextReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.r.Read(p)
+}
+
+type contextReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// NewContextReadCloser returns a ReadCloser of rc that fails reads with ctx.Err() once ctx is done.
+func NewContextReadCloser(ctx context.Context, rc io.ReadCloser) io.ReadCloser {
+	return &contextReadCloser{Reader: NewContextReader(ctx, rc), Closer: rc}
+}
+
+// runContext calls fn and waits for it to return, or returns ctx.Err() once ctx is done.
+// It lets calls through clients that don't take a context give up on hung requests.
+// An abandoned fn keeps running in the background until it returns.
+func runContext(ctx context.Context, fn func() error) error {
+	if ctx.Done() == nil {
+		return fn()
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() { done <- fn() }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type idleReadCloser struct {
+	rc      io.ReadCloser
+	timeout time.Duration
+	timer   *time.Timer
+	expired int32
+}
+
+// NewIdleReadCloser returns a ReadCloser of rc whose reads fail with context.DeadlineExceeded once a read
+// of rc doesn't return within timeout. rc is closed to abort the hung read, so it must allow a concurrent Close,
+// as the bodies of HTTP responses do. Unlike a deadline for the whole content, it doesn't abort
+// a download of a large backup which is slow but makes progress, nor one whose caller is slow to read.
+func NewIdleReadCloser(rc io.ReadCloser, timeout time.Duration) io.ReadCloser {
+	ir := &idleReadCloser{rc: rc, timeout: timeout}
+	ir.timer = time.AfterFunc(timeout, ir.expire)
+	ir.timer.Stop()
+	return ir
+}
+
+func (ir *idleReadCloser) expire() {
+	atomic.StoreInt32(&ir.expired, 1)
+	ir.rc.Close()
+}
+
+func (ir *idleReadCloser) Read(p []byte) (int, error) {
+	if atomic.LoadInt32(&ir.expired) == 1 {
+		return 0, context.DeadlineExceeded
+	}
+	ir.timer.Reset(ir.timeout)
+	n, err := ir.rc.Read(p)
+	if !ir.timer.Stop() {
+		return n, context.DeadlineExceeded
+	}
+	return n, err
+}
+
+func (ir *idleReadCloser) Close() error {
+	ir.timer.Stop()
+	if atomic.LoadInt32(&ir.expired) == 1 {
+		return nil
+	}
+	return ir.rc.Close()
+}
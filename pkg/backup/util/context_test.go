@@ -0,0 +1,107 @@
+// Copyright 2018 The etcd-operator Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestContextReader(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := NewContextReader(ctx, bytes.NewReader(bytes.Repeat([]byte("a"), 16)))
+
+	buf := make([]byte, 8)
+	if _, err := r.Read(buf); err != nil {
+		t.Fatalf("unexpected error before cancel: %v", err)
+	}
+
+	cancel()
+	if _, err := r.Read(buf); err != context.Canceled {
+		t.Errorf("expect error=%v, get=%v", context.Canceled, err)
+	}
+	if _, err := ioutil.ReadAll(r); err != context.Canceled {
+		t.Errorf("expect error=%v, get=%v", context.Canceled, err)
+	}
+}
+
+// slowReadCloser returns one byte of data per read after delay.
+// Once closed, pending and later reads fail.
+type slowReadCloser struct {
+	data   []byte
+	delay  time.Duration
+	closed chan struct{}
+}
+
+func (s *slowReadCloser) Read(p []byte) (int, error) {
+	select {
+	case <-time.After(s.delay):
+	case <-s.closed:
+		return 0, errors.New("read on closed body")
+	}
+	if len(s.data) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p[:1], s.data)
+	s.data = s.data[n:]
+	return n, nil
+}
+
+func (s *slowReadCloser) Close() error {
+	close(s.closed)
+	return nil
+}
+
+func TestIdleReadCloser(t *testing.T) {
+	// The download outlasts the timeout, and so does the caller between reads, but each read makes progress.
+	data := bytes.Repeat([]byte("a"), 20)
+	rc := NewIdleReadCloser(&slowReadCloser{data: data, delay: 5 * time.Millisecond, closed: make(chan struct{})}, 50*time.Millisecond)
+	var got []byte
+	buf := make([]byte, 8)
+	for {
+		n, err := rc.Read(buf)
+		got = append(got, buf[:n]...)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("unexpected error of a slow read: %v", err)
+		}
+		if len(got) == 10 {
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("expect data=%q, get=%q", data, got)
+	}
+	if err := rc.Close(); err != nil {
+		t.Errorf("unexpected error closing: %v", err)
+	}
+
+	// A hung read is aborted once the timeout elapses.
+	rc = NewIdleReadCloser(&slowReadCloser{data: data, delay: time.Hour, closed: make(chan struct{})}, 10*time.Millisecond)
+	if _, err := rc.Read(buf); err != context.DeadlineExceeded {
+		t.Errorf("expect error=%v, get=%v", context.DeadlineExceeded, err)
+	}
+	if _, err := rc.Read(buf); err != context.DeadlineExceeded {
+		t.Errorf("expect error=%v once expired, get=%v", context.DeadlineExceeded, err)
+	}
+	rc.Close()
+}
@@ -0,0 +1,208 @@
+// Copyright 2018 The etcd-operator Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// EncryptionKeySize is the size of the AES-256 key used to encrypt backups.
+const EncryptionKeySize = 32
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != EncryptionKeySize {
+		return nil, fmt.Errorf("invalid encryption key size: expect %d bytes, get %d", EncryptionKeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+const (
+	// encryptSegmentSize is the size of the plaintext segments backups are encrypted in. Each segment is sealed
+	// with AES-256-GCM on its own, so that backups are encrypted and decrypted as they are streamed.
+	encryptSegmentSize = 64 * 1024
+	// encryptNoncePrefixSize is the size of the random nonce prefix prepended to encrypted backups. The nonce of
+	// a segment is the prefix followed by the index of the segment and a byte set for the last segment only,
+	// so that segments can't be reordered or dropped, nor the backup truncated, without failing decryption.
+	encryptNoncePrefixSize = 7
+)
+
+// segmentNonce returns the nonce of the segment of the given index of a backup encrypted with prefix.
+func segmentNonce(nonce, prefix []byte, index uint32, last bool) []byte {
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[encryptNoncePrefixSize:], index)
+	nonce[len(nonce)-1] = 0
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
+
+// segmentCipher seals or opens the segments of an encrypted backup in order.
+type segmentCipher struct {
+	gcm    cipher.AEAD
+	prefix []byte
+	nonce  []byte
+	index  uint32
+	// buf holds the segment being read, followed by the first byte of the next one if any,
+	// which tells whether the segment is the last one.
+	buf   []byte
+	carry int
+	// out is the part of the last sealed or opened segment not read yet.
+	out  []byte
+	done bool
+}
+
+// next reads the next segment of size bytes from r, or up to size bytes for the last one,
+// and returns it along with whether it is the last one.
+func (c *segmentCipher) next(r io.Reader, size int) ([]byte, bool, error) {
+	if c.index == math.MaxUint32 {
+		return nil, false, fmt.Errorf("too many encrypted segments")
+	}
+	n, err := io.ReadFull(r, c.buf[c.carry:size+1])
+	n += c.carry
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		c.carry, c.done = 0, true
+		return c.buf[:n], true, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	c.carry = 1
+	return c.buf[:size], false, nil
+}
+
+// read copies the rest of the last segment to p.
+func (c *segmentCipher) read(p []byte) int {
+	n := copy(p, c.out)
+	c.out = c.out[n:]
+	return n
+}
+
+// shift moves the first byte of the next segment to the start of buf once the segment is sealed or opened.
+func (c *segmentCipher) shift(size int) {
+	if c.carry == 1 {
+		c.buf[0] = c.buf[size]
+	}
+	c.index++
+}
+
+type encryptReader struct {
+	segmentCipher
+	r      io.Reader
+	sealed []byte
+}
+
+// NewEncryptReader returns a reader of the content of r encrypted with AES-256-GCM under key.
+// The content is sealed in segments of encryptSegmentSize bytes, so that memory use doesn't grow with its size,
+// and the random nonce prefix of the segments is prepended to the returned ciphertext.
+func NewEncryptReader(key []byte, r io.Reader) (io.Reader, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	prefix := make([]byte, encryptNoncePrefixSize)
+	if _, err = io.ReadFull(rand.Reader, prefix); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %v", err)
+	}
+	e := &encryptReader{
+		segmentCipher: segmentCipher{
+			gcm:    gcm,
+			prefix: prefix,
+			nonce:  make([]byte, gcm.NonceSize()),
+			buf:    make([]byte, encryptSegmentSize+1),
+			out:    prefix,
+		},
+		r:      r,
+		sealed: make([]byte, 0, encryptSegmentSize+gcm.Overhead()),
+	}
+	return e, nil
+}
+
+func (e *encryptReader) Read(p []byte) (int, error) {
+	for len(e.out) == 0 {
+		if e.done {
+			return 0, io.EOF
+		}
+		segment, last, err := e.next(e.r, encryptSegmentSize)
+		if err != nil {
+			return 0, err
+		}
+		e.sealed = e.gcm.Seal(e.sealed[:0], segmentNonce(e.nonce, e.prefix, e.index, last), segment, nil)
+		e.out = e.sealed
+		e.shift(encryptSegmentSize)
+	}
+	return e.read(p), nil
+}
+
+type decryptReader struct {
+	segmentCipher
+	r      io.Reader
+	opened []byte
+}
+
+// NewDecryptReader returns a reader of the plaintext of the content of r encrypted by NewEncryptReader under key.
+// Reading fails if the content was encrypted under another key, or is corrupted or truncated.
+func NewDecryptReader(key []byte, r io.Reader) (io.Reader, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	return &decryptReader{
+		segmentCipher: segmentCipher{
+			gcm:   gcm,
+			nonce: make([]byte, gcm.NonceSize()),
+			buf:   make([]byte, encryptSegmentSize+gcm.Overhead()+1),
+		},
+		r:      r,
+		opened: make([]byte, 0, encryptSegmentSize),
+	}, nil
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.out) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if d.prefix == nil {
+			prefix := make([]byte, encryptNoncePrefixSize)
+			if _, err := io.ReadFull(d.r, prefix); err != nil {
+				return 0, fmt.Errorf("encrypted data too short: %v", err)
+			}
+			d.prefix = prefix
+		}
+		size := encryptSegmentSize + d.gcm.Overhead()
+		segment, last, err := d.next(d.r, size)
+		if err != nil {
+			return 0, err
+		}
+		d.opened, err = d.gcm.Open(d.opened[:0], segmentNonce(d.nonce, d.prefix, d.index, last), segment, nil)
+		if err != nil {
+			return 0, fmt.Errorf("failed to decrypt segment %d: %v", d.index, err)
+		}
+		d.out = d.opened
+		d.shift(size)
+	}
+	return d.read(p), nil
+}
@@ -0,0 +1,105 @@
+// Copyright 2018 The etcd-operator Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+)
+
+func encryptAll(t *testing.T, key, data []byte) []byte {
+	r, err := NewEncryptReader(key, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encrypted
+}
+
+func decryptAll(key, data []byte) ([]byte, error) {
+	r, err := NewDecryptReader(key, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(r)
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	key := bytes.Repeat([]byte{0x42}, EncryptionKeySize)
+	for _, size := range []int{0, 1, encryptSegmentSize - 1, encryptSegmentSize, encryptSegmentSize + 1, 3*encryptSegmentSize + 17} {
+		data := make([]byte, size)
+		rand.Read(data)
+		encrypted := encryptAll(t, key, data)
+		if size >= 16 && bytes.Contains(encrypted, data[:16]) {
+			t.Errorf("size %d: encrypted data contains the plaintext", size)
+		}
+
+		decrypted, err := decryptAll(key, encrypted)
+		if err != nil {
+			t.Errorf("size %d: %v", size, err)
+			continue
+		}
+		if !bytes.Equal(decrypted, data) {
+			t.Errorf("size %d: expect decrypted data to match", size)
+		}
+	}
+
+	data := []byte("etcd snapshot content")
+	wrongKey := bytes.Repeat([]byte{0x24}, EncryptionKeySize)
+	if _, err := decryptAll(wrongKey, encryptAll(t, key, data)); err == nil {
+		t.Errorf("expect decrypt with wrong key to fail")
+	}
+}
+
+func TestDecryptTruncated(t *testing.T) {
+	key := bytes.Repeat([]byte{0x42}, EncryptionKeySize)
+	data := make([]byte, 2*encryptSegmentSize+100)
+	rand.Read(data)
+	encrypted := encryptAll(t, key, data)
+	sealedSegmentSize := encryptSegmentSize + 16
+
+	for _, n := range []int{
+		len(encrypted) - 1,
+		// Dropping whole segments must fail too, since the last segment left isn't marked as the last one.
+		encryptNoncePrefixSize + 2*sealedSegmentSize,
+		encryptNoncePrefixSize + sealedSegmentSize,
+		encryptNoncePrefixSize,
+		3,
+	} {
+		if _, err := decryptAll(key, encrypted[:n]); err == nil {
+			t.Errorf("expect decrypt of the first %d bytes to fail", n)
+		}
+	}
+
+	// Swapping segments fails, since the nonce of each segment depends on its index.
+	swapped := append([]byte{}, encrypted...)
+	first := encryptNoncePrefixSize
+	copy(swapped[first:], encrypted[first+sealedSegmentSize:first+2*sealedSegmentSize])
+	copy(swapped[first+sealedSegmentSize:], encrypted[first:first+sealedSegmentSize])
+	if _, err := decryptAll(key, swapped); err == nil {
+		t.Errorf("expect decrypt of reordered segments to fail")
+	}
+}
+
+func TestEncryptInvalidKey(t *testing.T) {
+	if _, err := NewEncryptReader([]byte("short"), bytes.NewReader([]byte("data"))); err == nil {
+		t.Errorf("expect encrypt with invalid key size to fail")
+	}
+}
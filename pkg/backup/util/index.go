@@ -0,0 +1,57 @@
+// Copyright 2018 The etcd-operator Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+// BackupIndex records the checksum and size of each backup saved under a backup path,
+// so that backups can be checked for deletion or truncation without downloading them.
+type BackupIndex struct {
+	Backups []BackupIndexEntry `json:"backups"`
+}
+
+// BackupIndexEntry describes a backup of a BackupIndex.
+type BackupIndexEntry struct {
+	// Name is the name of the backup within its storage, e.g. the blob name in its ABS container.
+	Name string `json:"name"`
+	// SHA256 is the hex encoded SHA-256 checksum of the stored backup.
+	SHA256 string `json:"sha256"`
+	// Size is the number of bytes stored.
+	Size int64 `json:"size"`
+}
+
+// Add records e, replacing the entry of a backup of the same name if any.
+func (idx *BackupIndex) Add(e BackupIndexEntry) {
+	for i := range idx.Backups {
+		if idx.Backups[i].Name == e.Name {
+			idx.Backups[i] = e
+			return
+		}
+	}
+	idx.Backups = append(idx.Backups, e)
+}
+
+// Remove removes the entries of the backups of the given names.
+func (idx *BackupIndex) Remove(names ...string) {
+	removed := make(map[string]bool, len(names))
+	for _, name := range names {
+		removed[name] = true
+	}
+	kept := idx.Backups[:0]
+	for _, e := range idx.Backups {
+		if !removed[e.Name] {
+			kept = append(kept, e)
+		}
+	}
+	idx.Backups = kept
+}
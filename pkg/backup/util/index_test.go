@@ -0,0 +1,35 @@
+// Copyright 2018 The etcd-operator Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBackupIndex(t *testing.T) {
+	var idx BackupIndex
+	idx.Add(BackupIndexEntry{Name: "a", SHA256: "1", Size: 1})
+	idx.Add(BackupIndexEntry{Name: "b", SHA256: "2", Size: 2})
+	idx.Add(BackupIndexEntry{Name: "c", SHA256: "3", Size: 3})
+	// Saving a backup again replaces its entry.
+	idx.Add(BackupIndexEntry{Name: "a", SHA256: "4", Size: 4})
+	idx.Remove("b", "missing")
+
+	want := []BackupIndexEntry{{Name: "a", SHA256: "4", Size: 4}, {Name: "c", SHA256: "3", Size: 3}}
+	if !reflect.DeepEqual(idx.Backups, want) {
+		t.Errorf("expect entries=%v, get=%v", want, idx.Backups)
+	}
+}
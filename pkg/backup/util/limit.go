@@ -0,0 +1,53 @@
+// Copyright 2018 The etcd-operator Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrBackupTooLarge is returned when a backup exceeds the size limit it is read with.
+var ErrBackupTooLarge = errors.New("backup exceeds the size limit")
+
+type limitedReadCloser struct {
+	rc io.ReadCloser
+	// n is the number of bytes left before the limit is exceeded.
+	n int64
+}
+
+// NewLimitedReadCloser returns a ReadCloser of rc that returns ErrBackupTooLarge
+// once more than maxBytes are read from rc. Unlike io.LimitReader, exceeding the limit
+// is reported as an error instead of silently truncating the content.
+func NewLimitedReadCloser(rc io.ReadCloser, maxBytes int64) io.ReadCloser {
+	return &limitedReadCloser{rc: rc, n: maxBytes}
+}
+
+func (l *limitedReadCloser) Read(p []byte) (int, error) {
+	// Read at most one byte past the limit to find out whether the content exceeds it.
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+	n, err := l.rc.Read(p)
+	l.n -= int64(n)
+	if l.n < 0 {
+		return n - 1, ErrBackupTooLarge
+	}
+	return n, err
+}
+
+func (l *limitedReadCloser) Close() error {
+	return l.rc.Close()
+}
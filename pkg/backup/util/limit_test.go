@@ -0,0 +1,41 @@
+// Copyright 2018 The etcd-operator Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestLimitedReadCloser(t *testing.T) {
+	data := make([]byte, 1024)
+
+	got, err := ioutil.ReadAll(NewLimitedReadCloser(ioutil.NopCloser(bytes.NewReader(data)), 1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(data) {
+		t.Errorf("expect to read %d bytes, get=%d", len(data), len(got))
+	}
+
+	got, err = ioutil.ReadAll(NewLimitedReadCloser(ioutil.NopCloser(bytes.NewReader(data)), 1023))
+	if err != ErrBackupTooLarge {
+		t.Errorf("expect error=%v, get=%v", ErrBackupTooLarge, err)
+	}
+	if len(got) > 1023 {
+		t.Errorf("expect to read no more than the limit, get=%d bytes", len(got))
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright 2018 The etcd-operator Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import "io"
+
+// ProgressFunc is called with the total number of bytes read so far.
+type ProgressFunc func(bytesRead int64)
+
+type progressReader struct {
+	r    io.Reader
+	fn   ProgressFunc
+	read int64
+}
+
+// NewProgressReader returns a reader of r that calls fn with the total number of bytes read
+// after every read returning data. If fn is nil, r is returned as is.
+func NewProgressReader(r io.Reader, fn ProgressFunc) io.Reader {
+	if fn == nil {
+		return r
+	}
+	return &progressReader{r: r, fn: fn}
+}
+
+func (pr *progressReader) Read(p []byte) (int, error) {
+	n, err := pr.r.Read(p)
+	if n > 0 {
+		pr.read += int64(n)
+		pr.fn(pr.read)
+	}
+	return n, err
+}
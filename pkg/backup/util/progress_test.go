@@ -0,0 +1,64 @@
+// Copyright 2018 The etcd-operator Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+	"testing/iotest"
+)
+
+func TestProgressReader(t *testing.T) {
+	data := make([]byte, 10*1024+17)
+
+	var progress []int64
+	r := NewProgressReader(iotest.HalfReader(bytes.NewReader(data)), func(bytesRead int64) {
+		progress = append(progress, bytesRead)
+	})
+	buf := make([]byte, 1024)
+	for {
+		_, err := io.ReadFull(r, buf)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(progress) < 11 {
+		t.Fatalf("expect progress to be reported for each chunk, get %d reports", len(progress))
+	}
+	for i := 1; i < len(progress); i++ {
+		if progress[i] <= progress[i-1] {
+			t.Fatalf("expect increasing progress, get %d after %d", progress[i], progress[i-1])
+		}
+	}
+	if last := progress[len(progress)-1]; last != int64(len(data)) {
+		t.Errorf("expect final progress=%d, get=%d", len(data), last)
+	}
+}
+
+func TestProgressReaderNilFunc(t *testing.T) {
+	src := bytes.NewReader([]byte("backup"))
+	if r := NewProgressReader(src, nil); r != io.Reader(src) {
+		t.Errorf("expect reader to be returned as is without progress func")
+	}
+	if _, err := ioutil.ReadAll(NewProgressReader(src, nil)); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright 2018 The etcd-operator Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// ErrQuotaExceeded is the cause of the error returned when saving a backup would exceed the quota of its backup path.
+// Use errors.Cause(err) == ErrQuotaExceeded or IsQuotaExceeded(err) to check for it.
+var ErrQuotaExceeded = errors.New("backup quota exceeded")
+
+type quotaExceededError struct {
+	used, size, quota int64
+}
+
+func (e *quotaExceededError) Error() string {
+	return fmt.Sprintf("saving a backup of %d bytes would exceed the quota of %d bytes, of which %d are used", e.size, e.quota, e.used)
+}
+
+func (e *quotaExceededError) Cause() error {
+	return ErrQuotaExceeded
+}
+
+// IsQuotaExceeded returns true if the cause of err is ErrQuotaExceeded.
+func IsQuotaExceeded(err error) bool {
+	return errors.Cause(err) == ErrQuotaExceeded
+}
+
+// CheckQuota returns an error with ErrQuotaExceeded as cause if saving a backup of size bytes
+// along with backups of used bytes would exceed quota.
+func CheckQuota(used, size, quota int64) error {
+	if used+size > quota {
+		return &quotaExceededError{used: used, size: size, quota: quota}
+	}
+	return nil
+}
+
+type quotaKey struct{}
+
+// WithQuota returns a copy of ctx whose saves are refused before uploading anything if the backups
+// of their backup path would exceed maxBytes in total. A maxBytes of 0 or less means no quota.
+func WithQuota(ctx context.Context, maxBytes int64) context.Context {
+	return context.WithValue(ctx, quotaKey{}, maxBytes)
+}
+
+// QuotaFrom returns the quota of the saves with ctx, or 0 if there is none.
+func QuotaFrom(ctx context.Context) int64 {
+	quota, _ := ctx.Value(quotaKey{}).(int64)
+	return quota
+}
+
+// SourceLength returns the number of bytes left to read from r, and false if it can't be known without reading r,
+// e.g. for pipes. It supports readers with a Len method such as *bytes.Buffer, and seekers such as *os.File,
+// whose offset is left as is.
+func SourceLength(r io.Reader) (int64, bool) {
+	switch src := r.(type) {
+	case interface {
+		Len() int
+	}:
+		return int64(src.Len()), true
+	case io.Seeker:
+		cur, err := src.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return 0, false
+		}
+		end, err := src.Seek(0, io.SeekEnd)
+		if err != nil {
+			return 0, false
+		}
+		if _, err := src.Seek(cur, io.SeekStart); err != nil {
+			return 0, false
+		}
+		return end - cur, true
+	}
+	return 0, false
+}
+
+type sizedReader struct {
+	r io.Reader
+	n int64
+}
+
+// NewSizedReader returns a reader of r, which has n bytes left to read, whose Len method reports the bytes left,
+// so that SourceLength knows the length of the content of r through readers wrapping it.
+func NewSizedReader(r io.Reader, n int64) io.Reader {
+	return &sizedReader{r: r, n: n}
+}
+
+func (sr *sizedReader) Read(p []byte) (int, error) {
+	n, err := sr.r.Read(p)
+	sr.n -= int64(n)
+	return n, err
+}
+
+func (sr *sizedReader) Len() int {
+	if sr.n < 0 {
+		return 0
+	}
+	return int(sr.n)
+}
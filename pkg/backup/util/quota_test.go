@@ -0,0 +1,97 @@
+// Copyright 2018 The etcd-operator Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSourceLength(t *testing.T) {
+	f, err := ioutil.TempFile("", "source-length")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if _, err := f.WriteString("0123456789"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Seek(4, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	pr, pw := io.Pipe()
+	defer pw.Close()
+
+	tests := []struct {
+		name   string
+		r      io.Reader
+		wSize  int64
+		wKnown bool
+	}{
+		{name: "bytes.Buffer", r: bytes.NewBufferString("backup"), wSize: 6, wKnown: true},
+		{name: "strings.Reader", r: strings.NewReader("backup"), wSize: 6, wKnown: true},
+		{name: "os.File", r: f, wSize: 6, wKnown: true},
+		{name: "pipe", r: pr},
+		{name: "wrapped", r: io.LimitReader(strings.NewReader("backup"), 3)},
+		{name: "sized", r: NewSizedReader(io.LimitReader(strings.NewReader("backup"), 6), 6), wSize: 6, wKnown: true},
+	}
+	for _, tt := range tests {
+		size, known := SourceLength(tt.r)
+		if size != tt.wSize || known != tt.wKnown {
+			t.Errorf("%s: expect size=%d known=%v, get size=%d known=%v", tt.name, tt.wSize, tt.wKnown, size, known)
+		}
+	}
+	// The offset of seekers is left as is.
+	if data, err := ioutil.ReadAll(f); err != nil || string(data) != "456789" {
+		t.Errorf("expect to read the rest of the file, get=%q (err=%v)", data, err)
+	}
+}
+
+func TestSizedReader(t *testing.T) {
+	r := NewSizedReader(ioutil.NopCloser(strings.NewReader("0123456789")), 10)
+	if _, err := io.ReadFull(r, make([]byte, 4)); err != nil {
+		t.Fatal(err)
+	}
+	if size, known := SourceLength(r); size != 6 || !known {
+		t.Errorf("expect size=6 known=true after reading 4 bytes, get size=%d known=%v", size, known)
+	}
+	if data, err := ioutil.ReadAll(r); err != nil || string(data) != "456789" {
+		t.Errorf("expect to read the rest of the content, get=%q (err=%v)", data, err)
+	}
+	if size, _ := SourceLength(r); size != 0 {
+		t.Errorf("expect size=0 at EOF, get=%d", size)
+	}
+}
+
+func TestCheckQuota(t *testing.T) {
+	if err := CheckQuota(10, 5, 15); err != nil {
+		t.Errorf("expect a save reaching the quota to be allowed, get=%v", err)
+	}
+	if err := CheckQuota(10, 6, 15); !IsQuotaExceeded(err) {
+		t.Errorf("expect error with cause %v, get=%v", ErrQuotaExceeded, err)
+	}
+	if quota := QuotaFrom(context.Background()); quota != 0 {
+		t.Errorf("expect no quota by default, get=%d", quota)
+	}
+	if quota := QuotaFrom(WithQuota(context.Background(), 15)); quota != 15 {
+		t.Errorf("expect quota=15, get=%d", quota)
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2018 The etcd-operator Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+type rateLimitedReader struct {
+	ctx context.Context
+	r   io.Reader
+	// rate is the number of bytes allowed per second, which is also the size of the token bucket.
+	rate   int64
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimitedReader returns a reader of r that reads at most bytesPerSec bytes per second on average,
+// using a token bucket starting empty and holding at most a second worth of bytes.
+// Waiting for tokens is aborted with ctx.Err() once ctx is done. If bytesPerSec is 0 or less, r is returned as is.
+func NewRateLimitedReader(ctx context.Context, r io.Reader, bytesPerSec int64) io.Reader {
+	if bytesPerSec <= 0 {
+		return r
+	}
+	return &rateLimitedReader{ctx: ctx, r: r, rate: bytesPerSec, last: time.Now()}
+}
+
+func (l *rateLimitedReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > l.rate {
+		p = p[:l.rate]
+	}
+	n, err := l.r.Read(p)
+	if n > 0 {
+		if werr := l.wait(n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+// wait takes n tokens from the bucket, waiting for the missing ones to be refilled.
+func (l *rateLimitedReader) wait(n int) error {
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * float64(l.rate)
+	if l.tokens > float64(l.rate) {
+		l.tokens = float64(l.rate)
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return nil
+	}
+	return sleepContext(l.ctx, time.Duration(-l.tokens/float64(l.rate)*float64(time.Second)))
+}
+
+type rateLimitedReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// NewRateLimitedReadCloser returns a ReadCloser of rc that reads at most bytesPerSec bytes per second like
+// NewRateLimitedReader. If bytesPerSec is 0 or less, rc is returned as is.
+func NewRateLimitedReadCloser(ctx context.Context, rc io.ReadCloser, bytesPerSec int64) io.ReadCloser {
+	if bytesPerSec <= 0 {
+		return rc
+	}
+	return &rateLimitedReadCloser{Reader: NewRateLimitedReader(ctx, rc, bytesPerSec), Closer: rc}
+}
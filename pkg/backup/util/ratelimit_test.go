@@ -0,0 +1,56 @@
+// Copyright 2018 The etcd-operator Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestRateLimitedReader(t *testing.T) {
+	data := make([]byte, 4000)
+	start := time.Now()
+	got, err := ioutil.ReadAll(NewRateLimitedReader(context.Background(), bytes.NewReader(data), 10000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(data) {
+		t.Fatalf("expect to read %d bytes, get=%d", len(data), len(got))
+	}
+	// 4000 bytes at 10000 bytes/s take at least 400ms.
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("expect reading to take at least 400ms, get=%v", elapsed)
+	}
+}
+
+func TestRateLimitedReaderUnlimited(t *testing.T) {
+	src := bytes.NewReader(nil)
+	if r := NewRateLimitedReader(context.Background(), src, 0); r != io.Reader(src) {
+		t.Errorf("expect reader to be returned as is without a rate limit")
+	}
+}
+
+func TestRateLimitedReaderCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := ioutil.ReadAll(NewRateLimitedReader(ctx, bytes.NewReader(make([]byte, 100)), 10))
+	if err != context.Canceled {
+		t.Errorf("expect error=%v, get=%v", context.Canceled, err)
+	}
+}
@@ -0,0 +1,193 @@
+// Copyright 2018 The etcd-operator Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/storage"
+	"github.com/pkg/errors"
+)
+
+// DefaultRetryPolicy is the retry policy used for backup storage operations.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 5,
+	BaseDelay:   500 * time.Millisecond,
+	MaxDelay:    30 * time.Second,
+}
+
+// RetryPolicy retries failed operations with exponential backoff and jitter.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of times an operation is tried. Values below 1 mean 1.
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry. It doubles with each retry up to MaxDelay.
+	BaseDelay time.Duration
+	// MaxDelay caps the delay between retries.
+	MaxDelay time.Duration
+
+	// IsRetryable reports whether a failed operation should be retried.
+	// If nil, IsTransientError is used.
+	IsRetryable func(err error) bool
+	// Sleep waits for d, or returns ctx.Err() if ctx is done first.
+	// If nil, a timer is used. Tests can replace it to avoid waiting.
+	Sleep func(ctx context.Context, d time.Duration) error
+}
+
+// Do calls fn until it succeeds, fails with a non-retryable error, or MaxAttempts is reached.
+// Each retry takes a token from the RetryBudget of ctx if any, and fn is not retried once the budget is exhausted.
+// Throttled ABS requests are retried after the delay of their Retry-After header instead of the backoff,
+// if recorded by ThrottleTransport, or not at all if the delay ends after the deadline of ctx.
+// It returns the last error of fn, or ctx.Err() if ctx is done while waiting for fn or to retry.
+// Since fn may be abandoned once ctx is done and keep running, it must keep the results of each attempt
+// in its own variables and only hand them to the caller once it succeeds, and the caller must not read them on failure.
+func (p RetryPolicy) Do(ctx context.Context, fn func() error) error {
+	isRetryable := p.IsRetryable
+	if isRetryable == nil {
+		isRetryable = IsTransientError
+	}
+	sleep := p.Sleep
+	if sleep == nil {
+		sleep = sleepContext
+	}
+
+	budget := retryBudgetFrom(ctx)
+	for attempt := 1; ; attempt++ {
+		err := runContext(ctx, fn)
+		if err == nil || attempt >= p.MaxAttempts || !isRetryable(err) {
+			return err
+		}
+		delay, throttled := RetryAfter(err)
+		if !throttled {
+			delay = p.backoff(attempt)
+		} else if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+			// The service won't take the retry before the operation times out.
+			return err
+		}
+		if !budget.take() {
+			return err
+		}
+		if serr := sleep(ctx, delay); serr != nil {
+			return serr
+		}
+	}
+}
+
+// backoff returns the delay before retrying after the given number of attempts,
+// picked at random between half and all of the exponential delay.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.BaseDelay
+	for i := 1; i < attempt && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+	if d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	if d <= 1 {
+		return d
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)))
+}
+
+// RetryBudget caps the total number of retries of the operations of a backup run, such as a save or a restore,
+// so that the retries of many operations together can't outlast the deadline of the run even if
+// each of them stays within its RetryPolicy. It is safe for concurrent use.
+type RetryBudget struct {
+	mu      sync.Mutex
+	retries int
+}
+
+// NewRetryBudget returns a RetryBudget allowing retries retries in total.
+func NewRetryBudget(retries int) *RetryBudget {
+	return &RetryBudget{retries: retries}
+}
+
+// take takes a token for a retry or returns false if the budget is exhausted. A nil budget is unlimited.
+func (b *RetryBudget) take() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.retries <= 0 {
+		return false
+	}
+	b.retries--
+	return true
+}
+
+// Remaining returns the number of retries left.
+func (b *RetryBudget) Remaining() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.retries
+}
+
+type retryBudgetKey struct{}
+
+// WithRetryBudget returns a copy of ctx whose operations share the retries of budget.
+func WithRetryBudget(ctx context.Context, budget *RetryBudget) context.Context {
+	return context.WithValue(ctx, retryBudgetKey{}, budget)
+}
+
+func retryBudgetFrom(ctx context.Context) *RetryBudget {
+	budget, _ := ctx.Value(retryBudgetKey{}).(*RetryBudget)
+	return budget
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// IsTransientError returns true if err is likely to go away on retry:
+// ABS server errors, request timeouts and throttling, network errors and truncated responses.
+// Not found and authentication failures are not transient.
+func IsTransientError(err error) bool {
+	err = errors.Cause(err)
+	switch e := err.(type) {
+	case storage.AzureStorageServiceError:
+		return isTransientStatusCode(e.StatusCode)
+	case *storage.AzureStorageServiceError:
+		return isTransientStatusCode(e.StatusCode)
+	case net.Error:
+		return true
+	}
+	return err == io.ErrUnexpectedEOF
+}
+
+func isTransientStatusCode(code int) bool {
+	switch code {
+	case http.StatusRequestTimeout,
+		http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
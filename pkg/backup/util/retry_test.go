@@ -0,0 +1,162 @@
+// Copyright 2018 The etcd-operator Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/storage"
+)
+
+func newTestRetryPolicy(delays *[]time.Duration) RetryPolicy {
+	p := DefaultRetryPolicy
+	p.Sleep = func(ctx context.Context, d time.Duration) error {
+		*delays = append(*delays, d)
+		return nil
+	}
+	return p
+}
+
+func TestRetryPolicyRetriesTransientErrors(t *testing.T) {
+	var delays []time.Duration
+	p := newTestRetryPolicy(&delays)
+
+	attempts := 0
+	err := p.Do(context.Background(), func() error {
+		attempts++
+		if attempts <= 2 {
+			return storage.AzureStorageServiceError{StatusCode: 503}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 3 {
+		t.Errorf("expect 3 attempts, get=%d", attempts)
+	}
+	if len(delays) != 2 {
+		t.Fatalf("expect 2 retries, get=%d", len(delays))
+	}
+	for i, d := range delays {
+		max := p.BaseDelay << uint(i)
+		if d < max/2 || d > max {
+			t.Errorf("retry %d: expect delay in [%v, %v], get=%v", i, max/2, max, d)
+		}
+	}
+}
+
+func TestRetryPolicyGivesUp(t *testing.T) {
+	var delays []time.Duration
+	p := newTestRetryPolicy(&delays)
+
+	tests := []struct {
+		err       error
+		wAttempts int
+	}{
+		{err: io.ErrUnexpectedEOF, wAttempts: p.MaxAttempts},
+		{err: storage.AzureStorageServiceError{StatusCode: 404}, wAttempts: 1},
+		{err: &storage.AzureStorageServiceError{StatusCode: 403}, wAttempts: 1},
+		{err: errors.New("invalid path"), wAttempts: 1},
+	}
+	for i, tt := range tests {
+		attempts := 0
+		err := p.Do(context.Background(), func() error {
+			attempts++
+			return tt.err
+		})
+		if err != tt.err {
+			t.Errorf("#%d: expect error=%v, get=%v", i, tt.err, err)
+		}
+		if attempts != tt.wAttempts {
+			t.Errorf("#%d: expect %d attempts, get=%d", i, tt.wAttempts, attempts)
+		}
+	}
+}
+
+func TestRetryPolicyBudget(t *testing.T) {
+	var delays []time.Duration
+	p := newTestRetryPolicy(&delays)
+	budget := NewRetryBudget(3)
+	ctx := WithRetryBudget(context.Background(), budget)
+
+	// Every operation of the run keeps failing with a transient error.
+	attempts := 0
+	for i := 0; i < 4; i++ {
+		err := p.Do(ctx, func() error {
+			attempts++
+			return storage.AzureStorageServiceError{StatusCode: 503}
+		})
+		if err == nil {
+			t.Fatalf("#%d: expect the operation to fail", i)
+		}
+	}
+	// The first operation uses up the budget with 3 of its 4 retries, and the others fail on their first attempt.
+	if attempts != 1+3+3 {
+		t.Errorf("expect %d attempts, get=%d", 1+3+3, attempts)
+	}
+	if len(delays) != 3 {
+		t.Errorf("expect 3 retries, get=%d", len(delays))
+	}
+	if budget.Remaining() != 0 {
+		t.Errorf("expect the budget to be exhausted, get=%d retries left", budget.Remaining())
+	}
+}
+
+func TestRetryPolicyBackoffIsCapped(t *testing.T) {
+	p := DefaultRetryPolicy
+	for attempt := 1; attempt < 20; attempt++ {
+		if d := p.backoff(attempt); d > p.MaxDelay {
+			t.Errorf("attempt %d: expect delay <= %v, get=%v", attempt, p.MaxDelay, d)
+		}
+	}
+}
+
+func TestRetryPolicyCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	attempts := 0
+	err := DefaultRetryPolicy.Do(ctx, func() error {
+		attempts++
+		return io.ErrUnexpectedEOF
+	})
+	if err != context.Canceled {
+		t.Errorf("expect error=%v, get=%v", context.Canceled, err)
+	}
+	if attempts != 0 {
+		t.Errorf("expect no attempt once ctx is done, get=%d", attempts)
+	}
+}
+
+func TestRetryPolicyDoTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	// A hung request of a client that doesn't take a context.
+	hung := make(chan struct{})
+	defer close(hung)
+	err := DefaultRetryPolicy.Do(ctx, func() error {
+		<-hung
+		return nil
+	})
+	if err != context.DeadlineExceeded {
+		t.Errorf("expect error=%v, get=%v", context.DeadlineExceeded, err)
+	}
+}
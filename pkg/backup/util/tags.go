@@ -0,0 +1,53 @@
+// Copyright 2018 The etcd-operator Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+)
+
+// tagKeyPattern matches the tag keys which, prefixed with MetadataTagPrefix, are valid ABS metadata names.
+// ABS metadata names must be C# identifiers and are case insensitive, so only lower case keys are accepted.
+var tagKeyPattern = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// ValidateTags checks that the tags can be saved along with backups.
+func ValidateTags(tags map[string]string) error {
+	for k := range tags {
+		if !tagKeyPattern.MatchString(k) {
+			return fmt.Errorf("invalid tag key %q: only lower case letters, digits and underscores are allowed", k)
+		}
+	}
+	return nil
+}
+
+// TagMetadataKey returns the blob metadata key the tag of the given key is saved as.
+func TagMetadataKey(key string) string {
+	return MetadataTagPrefix + key
+}
+
+type tagsKey struct{}
+
+// WithTags returns a copy of ctx whose saves tag the backups with tags.
+func WithTags(ctx context.Context, tags map[string]string) context.Context {
+	return context.WithValue(ctx, tagsKey{}, tags)
+}
+
+// TagsFrom returns the tags of the backups saved with ctx, or nil if there are none.
+func TagsFrom(ctx context.Context) map[string]string {
+	tags, _ := ctx.Value(tagsKey{}).(map[string]string)
+	return tags
+}
@@ -0,0 +1,114 @@
+// Copyright 2018 The etcd-operator Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/storage"
+	"github.com/pkg/errors"
+)
+
+// maxRetryAfterHints bounds the number of Retry-After hints kept for requests that are never retried.
+const maxRetryAfterHints = 1024
+
+// retryAfterHints holds the Retry-After delays of the throttled ABS responses by request id.
+// The storage SDK drops the headers of failed responses, so the delays are recorded by ThrottleTransport
+// and looked up by RetryPolicy from the request id kept in the SDK error.
+var retryAfterHints = struct {
+	sync.Mutex
+	delays map[string]time.Duration
+}{delays: make(map[string]time.Duration)}
+
+// NewThrottleTransport returns a RoundTripper of rt which records the Retry-After header of the throttled ABS
+// responses, i.e. status 429 or 503, so that RetryPolicy waits as long as the service asks before retrying.
+// It should be set as the transport of the HTTP client of ABS clients. If rt is nil, http.DefaultTransport is used.
+func NewThrottleTransport(rt http.RoundTripper) http.RoundTripper {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	return &throttleTransport{rt: rt}
+}
+
+type throttleTransport struct {
+	rt http.RoundTripper
+}
+
+func (tt *throttleTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := tt.rt.RoundTrip(req)
+	if err != nil || (resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable) {
+		return resp, err
+	}
+	requestID := resp.Header.Get("x-ms-request-id")
+	d, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	if len(requestID) == 0 || !ok {
+		return resp, err
+	}
+	retryAfterHints.Lock()
+	if len(retryAfterHints.delays) >= maxRetryAfterHints {
+		retryAfterHints.delays = make(map[string]time.Duration)
+	}
+	retryAfterHints.delays[requestID] = d
+	retryAfterHints.Unlock()
+	return resp, err
+}
+
+// parseRetryAfter decodes the value of a Retry-After header, either a number of seconds or an HTTP date.
+func parseRetryAfter(v string, now time.Time) (time.Duration, bool) {
+	if len(v) == 0 {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return 0, false
+	}
+	if d := t.Sub(now); d > 0 {
+		return d, true
+	}
+	return 0, true
+}
+
+// RetryAfter returns the delay the ABS service asked for in the Retry-After header of the throttled response
+// err was returned for, or false if there is none. Each delay is returned once.
+func RetryAfter(err error) (time.Duration, bool) {
+	var requestID string
+	if serr, ok := AsStorageError(err); ok {
+		requestID = serr.RequestID
+	} else {
+		switch e := errors.Cause(err).(type) {
+		case storage.AzureStorageServiceError:
+			requestID = e.RequestID
+		case *storage.AzureStorageServiceError:
+			requestID = e.RequestID
+		}
+	}
+	if len(requestID) == 0 {
+		return 0, false
+	}
+	retryAfterHints.Lock()
+	defer retryAfterHints.Unlock()
+	d, ok := retryAfterHints.delays[requestID]
+	delete(retryAfterHints.delays, requestID)
+	return d, ok
+}
@@ -0,0 +1,43 @@
+// Copyright 2018 The etcd-operator Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		v   string
+		wD  time.Duration
+		wOK bool
+	}{
+		{v: "7", wD: 7 * time.Second, wOK: true},
+		{v: now.Add(time.Minute).Format(http.TimeFormat), wD: time.Minute, wOK: true},
+		{v: now.Add(-time.Minute).Format(http.TimeFormat), wD: 0, wOK: true},
+		{v: ""},
+		{v: "-1"},
+		{v: "soon"},
+	}
+	for _, tt := range tests {
+		d, ok := parseRetryAfter(tt.v, now)
+		if d != tt.wD || ok != tt.wOK {
+			t.Errorf("%q: expect delay=%v ok=%v, get delay=%v ok=%v", tt.v, tt.wD, tt.wOK, d, ok)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+// Copyright 2018 The etcd-operator Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"fmt"
+	"io"
+)
+
+// SnapshotTransform processes a snapshot before it is saved, e.g. to rewrite it,
+// and returns the reader of the processed snapshot.
+type SnapshotTransform func(io.Reader) (io.Reader, error)
+
+// ApplyTransforms passes r through transforms in order, each one reading the output of the previous one,
+// and returns the reader of the last one. It returns r as is if transforms is empty.
+func ApplyTransforms(r io.Reader, transforms []SnapshotTransform) (io.Reader, error) {
+	for i, t := range transforms {
+		var err error
+		r, err = t(r)
+		if err != nil {
+			return nil, fmt.Errorf("snapshot transform %d failed: %v", i, err)
+		}
+	}
+	return r, nil
+}
@@ -0,0 +1,58 @@
+// Copyright 2018 The etcd-operator Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestApplyTransforms(t *testing.T) {
+	identity := func(r io.Reader) (io.Reader, error) { return r, nil }
+	upper := func(r io.Reader) (io.Reader, error) {
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		return bytes.NewReader(bytes.ToUpper(b)), nil
+	}
+	suffix := func(r io.Reader) (io.Reader, error) {
+		return io.MultiReader(r, strings.NewReader("-end")), nil
+	}
+
+	r, err := ApplyTransforms(strings.NewReader("snapshot"), []SnapshotTransform{identity, upper, suffix})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The suffix is added after uppercasing, so it stays lowercase.
+	if want := "SNAPSHOT-end"; string(got) != want {
+		t.Errorf("expect content=%q, get=%q", want, got)
+	}
+}
+
+func TestApplyTransformsError(t *testing.T) {
+	failed := func(r io.Reader) (io.Reader, error) { return nil, errors.New("rewrite failed") }
+	if _, err := ApplyTransforms(strings.NewReader("snapshot"), []SnapshotTransform{failed}); err == nil {
+		t.Error("expect error of the failed transform")
+	}
+}
@@ -15,14 +15,103 @@
 package util
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
+
+	"github.com/pborman/uuid"
 )
 
+// ErrNoBackups is returned when no backup is found under a backup path.
+var ErrNoBackups = errors.New("no backups found")
+
+// ErrInvalidMaxBackups is returned when purging stale backups by count is asked to keep no backup at all.
+// Deleting every backup file must be explicitly asked for with DeleteAll instead.
+var ErrInvalidMaxBackups = errors.New("the number of backups to keep must be greater than 0")
+
+// BackupFile describes a backup file stored under a backup path.
+type BackupFile struct {
+	Name         string
+	LastModified time.Time
+	// Size is the size of the backup file in bytes.
+	Size int64
+}
+
 func MakeBackupName(ver string, rev int64) string {
 	return fmt.Sprintf("%s_%016x_%s", ver, rev, BackupFilenameSuffix)
 }
 
+// MakeClusterBackupName is like MakeBackupName, but also embeds the name of the backed up etcd cluster,
+// which must contain neither "_" nor "/". It returns the name of MakeBackupName if clusterName is empty.
+func MakeClusterBackupName(clusterName, ver string, rev int64) string {
+	if len(clusterName) == 0 {
+		return MakeBackupName(ver, rev)
+	}
+	return ClusterNamePrefix + clusterName + "_" + MakeBackupName(ver, rev)
+}
+
+// MakeTimestampedBackupName is like MakeClusterBackupName, but starts with the time t the backup is saved at
+// in the BackupTimestampFormat, so that sorting backup names of the same path sorts the backups chronologically
+// without relying on the modification times kept by the storage.
+func MakeTimestampedBackupName(t time.Time, clusterName, ver string, rev int64) string {
+	return t.UTC().Format(BackupTimestampFormat) + "_" + MakeClusterBackupName(clusterName, ver, rev)
+}
+
+// ParseFilePath returns the local file path of the backup path relative to the root directory.
+// returns error if path is empty or points outside of root.
+func ParseFilePath(root, path string) (string, error) {
+	if len(path) == 0 {
+		return "", fmt.Errorf("empty backup path")
+	}
+	root = filepath.Clean(root)
+	fpath := filepath.Join(root, path)
+	if fpath == root || !strings.HasPrefix(fpath, root+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid backup path (%v): must be within %v", path, root)
+	}
+	return fpath, nil
+}
+
+// ListLocalBackupFiles lists the backup files saved with revision appended to the local file path,
+// using their modification time as last modified time.
+// Temporary files of backups being saved are not listed.
+func ListLocalBackupFiles(fpath string) ([]BackupFile, error) {
+	files, err := ListLocalFilesWithPrefix(filepath.Dir(fpath), filepath.Base(fpath)+"_")
+	if err != nil {
+		return nil, err
+	}
+	files, _ = SplitTmpFiles(files)
+	return files, nil
+}
+
+// ListLocalFilesWithPrefix lists the files whose name starts with prefix in the local directory as backup files.
+func ListLocalFilesWithPrefix(dir, prefix string) ([]BackupFile, error) {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	files := []BackupFile{}
+	for _, fi := range fis {
+		if fi.IsDir() || !strings.HasPrefix(fi.Name(), prefix) {
+			continue
+		}
+		files = append(files, BackupFile{Name: filepath.Join(dir, fi.Name()), LastModified: fi.ModTime(), Size: fi.Size()})
+	}
+	return files, nil
+}
+
 // ParseBucketAndKey parses the path to return the s3 bucket name and key(path in the bucket)
 // returns error if path is not in the format <s3-bucket-name>/<key>
 func ParseBucketAndKey(path string) (string, string, error) {
@@ -32,3 +121,628 @@ func ParseBucketAndKey(path string) (string, string, error) {
 	}
 	return toks[0], toks[1], nil
 }
+
+// NormalizePath trims the leading and trailing slashes of the backup path and collapses repeated slashes,
+// so that no blob name is derived from it with an empty path segment.
+// It returns an error if path is empty or only consists of whitespace and slashes.
+func NormalizePath(path string) (string, error) {
+	var segs []string
+	for _, seg := range strings.Split(path, "/") {
+		if len(seg) != 0 {
+			segs = append(segs, seg)
+		}
+	}
+	normalized := strings.Join(segs, "/")
+	if len(strings.TrimSpace(strings.Replace(normalized, "/", "", -1))) == 0 {
+		return "", fmt.Errorf("invalid backup path (%q): expect a non-empty path", path)
+	}
+	return normalized, nil
+}
+
+const (
+	// PathTemplateNamespace is the placeholder of a backup path template
+	// substituted with the namespace of the backed up cluster.
+	PathTemplateNamespace = "{namespace}"
+	// PathTemplateClusterName is the placeholder of a backup path template
+	// substituted with the name of the backed up cluster.
+	PathTemplateClusterName = "{clusterName}"
+)
+
+// RenderPathTemplate substitutes the placeholders of the backup path template,
+// e.g. "mycontainer/{namespace}/{clusterName}/v1/etcd.backup", with the given namespace and cluster name.
+// Since the values may neither contain "/" nor "_", the backups saved under the paths rendered
+// for different clusters never share a listing prefix.
+// It returns an error if the template uses a placeholder whose value is empty or unknown placeholders.
+func RenderPathTemplate(tmpl, namespace, clusterName string) (string, error) {
+	path := tmpl
+	for _, p := range []struct{ placeholder, value string }{
+		{PathTemplateNamespace, namespace},
+		{PathTemplateClusterName, clusterName},
+	} {
+		if !strings.Contains(path, p.placeholder) {
+			continue
+		}
+		if len(p.value) == 0 || strings.ContainsAny(p.value, "/_") {
+			return "", fmt.Errorf("invalid value (%q) of %s in backup path template (%v)", p.value, p.placeholder, tmpl)
+		}
+		path = strings.Replace(path, p.placeholder, p.value, -1)
+	}
+	if strings.ContainsAny(path, "{}") {
+		return "", fmt.Errorf("unknown placeholder in backup path template (%v)", tmpl)
+	}
+	return path, nil
+}
+
+// BackupInfo describes a backup decoded from its name.
+type BackupInfo struct {
+	// Name is the name of the backup file. Like Created, it is only set by BackupFile.Info.
+	Name string
+	// Version is the etcd version the backup was taken from, or "" if the name does not carry one.
+	Version string
+	// Revision is the etcd revision the backup was taken at.
+	Revision uint64
+	// ClusterName is the name of the backed up etcd cluster, or "" if the name does not carry one.
+	// Such backups belong to the DefaultClusterName.
+	ClusterName string
+	// Created is when the backup was saved according to the storage.
+	// It is only set from the storage by BackupFile.Info.
+	Created time.Time
+	// Timestamp is the save time embedded in the name by MakeTimestampedBackupName, or zero if the name does not carry one.
+	Timestamp time.Time
+	// Size is the size of the backup file in bytes. Like Created, it is only set by BackupFile.Info.
+	Size int64
+}
+
+// ParseBackupName decodes a backup name produced by MakeBackupName, MakeClusterBackupName or MakeTimestampedBackupName, possibly prefixed by the backup path
+// and suffixed with an extension such as GzipSuffix. Older names with only the revision appended are
+// also accepted, leaving Version empty. It returns an error if the name carries no revision.
+// Since the revision always has 16 hex digits and is followed by BackupFilenameSuffix, versions made of
+// anything but "_", such as "3.5.0-rc.0", are recovered as is.
+func ParseBackupName(name string) (BackupInfo, error) {
+	toks := strings.Split(name, "_")
+	// Look for the revision followed by BackupFilenameSuffix first, so that a version or a cluster name
+	// looking like a revision is not taken for it.
+	for i := len(toks) - 2; i >= 1; i-- {
+		rev, ok := parseRevisionToken(toks[i])
+		if !ok || !strings.HasPrefix(toks[i+1], BackupFilenameSuffix) {
+			continue
+		}
+		info := BackupInfo{Revision: rev, Version: toks[i-1]}
+		j := i - 2
+		if j >= 0 && strings.HasPrefix(toks[j], ClusterNamePrefix) {
+			info.ClusterName = toks[j][len(ClusterNamePrefix):]
+			j--
+		}
+		if j >= 0 {
+			if ts, err := time.Parse(BackupTimestampFormat, toks[j]); err == nil {
+				info.Timestamp = ts
+			}
+		}
+		return info, nil
+	}
+	for i := len(toks) - 1; i >= 0; i-- {
+		tok := toks[i]
+		if idx := strings.Index(tok, "."); idx >= 0 {
+			tok = tok[:idx]
+		}
+		if rev, ok := parseRevisionToken(tok); ok {
+			return BackupInfo{Revision: rev}, nil
+		}
+	}
+	return BackupInfo{}, fmt.Errorf("no revision found in backup name (%v)", name)
+}
+
+// parseRevisionToken decodes the 16 hex digits revision segment of a backup name.
+func parseRevisionToken(tok string) (uint64, bool) {
+	if len(tok) != 16 {
+		return 0, false
+	}
+	rev, err := strconv.ParseUint(tok, 16, 64)
+	return rev, err == nil
+}
+
+// BackupPathOf returns the backup path the backups named like name are saved with revision appended to,
+// i.e. name without the part made by MakeBackupName, MakeClusterBackupName or MakeTimestampedBackupName.
+// It returns false if name does not end with such a part.
+func BackupPathOf(name string) (string, bool) {
+	toks := strings.Split(name, "_")
+	// The last tokens are the version, the revision and BackupFilenameSuffix with any extension.
+	i := len(toks) - 2
+	if i < 2 || !strings.HasPrefix(toks[i+1], BackupFilenameSuffix) {
+		return "", false
+	}
+	if _, ok := parseRevisionToken(toks[i]); !ok {
+		return "", false
+	}
+	j := i - 2
+	if j >= 0 && strings.HasPrefix(toks[j], ClusterNamePrefix) {
+		j--
+	}
+	if j >= 0 {
+		if _, err := time.Parse(BackupTimestampFormat, toks[j]); err == nil {
+			j--
+		}
+	}
+	if j < 0 {
+		return "", false
+	}
+	return strings.Join(toks[:j+1], "_"), true
+}
+
+// Info decodes the backup file name, using its last modified time as creation time.
+func (f BackupFile) Info() (BackupInfo, error) {
+	info, err := ParseBackupName(f.Name)
+	if err != nil {
+		return BackupInfo{}, err
+	}
+	info.Name = f.Name
+	info.Created = f.LastModified
+	info.Size = f.Size
+	return info, nil
+}
+
+// WalkBackupFiles calls fn with the info of each of the files in order, skipping temporary files
+// and files whose names don't parse as backup names. It stops at the first error returned by fn and returns it.
+func WalkBackupFiles(files []BackupFile, fn func(BackupInfo) error) error {
+	for _, f := range files {
+		if IsTmpFile(f.Name) {
+			continue
+		}
+		info, err := f.Info()
+		if err != nil {
+			continue
+		}
+		if err := fn(info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BackupFilesOfCluster returns the backup files of the etcd cluster named clusterName.
+// Backup files whose names carry no cluster name belong to the DefaultClusterName, as does an empty clusterName.
+// Files whose names don't parse as backup names are left out.
+func BackupFilesOfCluster(files []BackupFile, clusterName string) []BackupFile {
+	if len(clusterName) == 0 {
+		clusterName = DefaultClusterName
+	}
+	var matched []BackupFile
+	for _, f := range files {
+		info, err := ParseBackupName(f.Name)
+		if err != nil {
+			continue
+		}
+		name := info.ClusterName
+		if len(name) == 0 {
+			name = DefaultClusterName
+		}
+		if name == clusterName {
+			matched = append(matched, f)
+		}
+	}
+	return matched
+}
+
+// ParseVersion returns the etcd version embedded by MakeBackupName in the backup name,
+// or "" if the backup name does not carry one.
+func ParseVersion(name string) string {
+	info, _ := ParseBackupName(name)
+	return info.Version
+}
+
+// ParseRevision returns the etcd revision embedded in the backup name,
+// which is the 16 digits hex segment appended to the backup path, e.g. "0000000000ed1e1c".
+func ParseRevision(name string) (int64, error) {
+	info, err := ParseBackupName(name)
+	if err != nil {
+		return 0, err
+	}
+	if info.Revision > math.MaxInt64 {
+		return 0, fmt.Errorf("revision of backup name (%v) overflows int64", name)
+	}
+	return int64(info.Revision), nil
+}
+
+// SortBackupFilesByDate sorts backup files from the oldest to the latest by their save time embedded in their names
+// by MakeTimestampedBackupName, or else by their last modified time, which can be skewed or reset by copies.
+// Since some storage tiers round the modified time to the second,
+// ties are broken by the revision embedded in the file names. See CompareBackups.
+func SortBackupFilesByDate(files []BackupFile) {
+	sort.Slice(files, func(i, j int) bool {
+		return CompareBackups(fileInfo(files[i]), fileInfo(files[j])) < 0
+	})
+}
+
+// fileInfo returns the info of the backup file f like f.Info, with the revision and save time left zero
+// if its name does not parse as a backup name.
+func fileInfo(f BackupFile) BackupInfo {
+	info, _ := ParseBackupName(f.Name)
+	info.Name = f.Name
+	info.Created = f.LastModified
+	info.Size = f.Size
+	return info
+}
+
+// SavedAt returns when the backup was saved, from the save time embedded in its name if any,
+// and its creation time according to the storage otherwise.
+func (info BackupInfo) SavedAt() time.Time {
+	if !info.Timestamp.IsZero() {
+		return info.Timestamp
+	}
+	return info.Created
+}
+
+// CompareBackups orders backups from the oldest to the latest like SortBackupFilesByDate, by their SavedAt time
+// and then by their revision. It returns a negative number if a is older than b, a positive one if a is more recent,
+// and 0 if their save times and revisions are the same.
+func CompareBackups(a, b BackupInfo) int {
+	ta, tb := a.SavedAt(), b.SavedAt()
+	switch {
+	case ta.Before(tb):
+		return -1
+	case ta.After(tb):
+		return 1
+	case a.Revision < b.Revision:
+		return -1
+	case a.Revision > b.Revision:
+		return 1
+	}
+	return 0
+}
+
+// ByRecency sorts backups from the oldest to the latest with CompareBackups.
+type ByRecency []BackupInfo
+
+func (s ByRecency) Len() int           { return len(s) }
+func (s ByRecency) Less(i, j int) bool { return CompareBackups(s[i], s[j]) < 0 }
+func (s ByRecency) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// GetLatestBackupNameByDate returns the name of the latest backup file, or "" if there is none.
+// Files whose names don't parse as backup names, e.g. manually uploaded ones, are never chosen.
+func GetLatestBackupNameByDate(files []BackupFile) string {
+	latest, ok := GetLatestBackupFileByDate(files)
+	if !ok {
+		return ""
+	}
+	return latest.Name
+}
+
+// GetLatestBackupFileByDate returns the latest backup file like GetLatestBackupNameByDate,
+// and false if there is none.
+func GetLatestBackupFileByDate(files []BackupFile) (BackupFile, bool) {
+	return GetNthLatestBackupFileByDate(files, 0)
+}
+
+// GetNthLatestBackupFileByDate returns the n-th latest backup file, where 0 is the latest one and 1 the one
+// before it, and false if there are not more than n of them or n is negative.
+// Files whose names don't parse as backup names are skipped like in GetLatestBackupNameByDate.
+func GetNthLatestBackupFileByDate(files []BackupFile, n int) (BackupFile, bool) {
+	var valid []BackupFile
+	for _, f := range files {
+		if _, err := ParseBackupName(f.Name); err == nil {
+			valid = append(valid, f)
+		}
+	}
+	if n < 0 || len(valid) <= n {
+		return BackupFile{}, false
+	}
+	SortBackupFilesByDate(valid)
+	return valid[len(valid)-1-n], true
+}
+
+// PageBackupFiles sorts the backup files by date, from the latest if desc is true, and returns
+// at most limit of them starting at offset. A limit less than or equal to 0 means no limit.
+func PageBackupFiles(files []BackupFile, desc bool, limit, offset int) []BackupFile {
+	sorted := make([]BackupFile, len(files))
+	copy(sorted, files)
+	SortBackupFilesByDate(sorted)
+	if desc {
+		for i, j := 0, len(sorted)-1; i < j; i, j = i+1, j-1 {
+			sorted[i], sorted[j] = sorted[j], sorted[i]
+		}
+	}
+
+	if offset < 0 {
+		offset = 0
+	}
+	if offset >= len(sorted) {
+		return nil
+	}
+	sorted = sorted[offset:]
+	if limit > 0 && limit < len(sorted) {
+		sorted = sorted[:limit]
+	}
+	return sorted
+}
+
+// MakeTmpName returns a unique temporary name to save the backup of the given name under until it is complete.
+// Each save gets its own temporary name, so that concurrent saves of the same backup never write into each other.
+func MakeTmpName(name string) string {
+	return fmt.Sprintf("%s.%s%s", name, uuid.New(), TmpSuffix)
+}
+
+// IsTmpFile returns true if name is the temporary file of a backup being saved.
+func IsTmpFile(name string) bool {
+	return strings.HasSuffix(name, TmpSuffix)
+}
+
+// SplitTmpFiles splits the backup files into complete backup files and temporary files of backups being saved.
+func SplitTmpFiles(files []BackupFile) (complete, tmp []BackupFile) {
+	for _, f := range files {
+		if IsTmpFile(f.Name) {
+			tmp = append(tmp, f)
+		} else {
+			complete = append(complete, f)
+		}
+	}
+	return complete, tmp
+}
+
+// StaleTmpFiles returns the temporary backup files last modified before cutoff,
+// which are left by interrupted saves.
+func StaleTmpFiles(files []BackupFile, cutoff time.Time) []BackupFile {
+	stale := []BackupFile{}
+	for _, f := range files {
+		if IsTmpFile(f.Name) && f.LastModified.Before(cutoff) {
+			stale = append(stale, f)
+		}
+	}
+	return stale
+}
+
+// FindDuplicate returns the backup file named name, possibly with an extension such as GzipSuffix,
+// if name carries an etcd version and revision. It is used to detect a backup of the same etcd version
+// and revision saved before, either with or without compression.
+func FindDuplicate(files []BackupFile, name string) (BackupFile, bool) {
+	info, err := ParseBackupName(name)
+	if err != nil || len(info.Version) == 0 {
+		return BackupFile{}, false
+	}
+	for _, f := range files {
+		if IsTmpFile(f.Name) {
+			continue
+		}
+		if f.Name == name || strings.HasPrefix(f.Name, name+".") {
+			return f, true
+		}
+	}
+	return BackupFile{}, false
+}
+
+// GetBackupNameByRevision returns the name of the backup file with the largest revision
+// less than or equal to rev, or "" if there is none. Files without a revision in their name are ignored.
+func GetBackupNameByRevision(files []BackupFile, rev uint64) string {
+	var (
+		name  string
+		found bool
+		best  uint64
+	)
+	for _, f := range files {
+		info, err := ParseBackupName(f.Name)
+		if err != nil || info.Revision > rev {
+			continue
+		}
+		if !found || info.Revision > best {
+			name, best, found = f.Name, info.Revision, true
+		}
+	}
+	return name
+}
+
+// CountBackupsByVersion returns the number of backup files of each etcd version parsed from their names.
+// Files whose names don't carry a version are not counted.
+func CountBackupsByVersion(files []BackupFile) map[string]int {
+	counts := map[string]int{}
+	for _, f := range files {
+		info, err := ParseBackupName(f.Name)
+		if err != nil || len(info.Version) == 0 {
+			continue
+		}
+		counts[info.Version]++
+	}
+	return counts
+}
+
+// TotalSize returns the sum of the sizes of the backup files.
+func TotalSize(files []BackupFile) int64 {
+	var size int64
+	for _, f := range files {
+		size += f.Size
+	}
+	return size
+}
+
+// StaleBackupFilesByCount returns the backup files to purge to keep the latest maxBackups by date.
+func StaleBackupFilesByCount(files []BackupFile, maxBackups int) []BackupFile {
+	SortBackupFilesByDate(files)
+	if len(files) <= maxBackups {
+		return nil
+	}
+	return files[:len(files)-maxBackups]
+}
+
+// StaleBackupFilesBySize returns the oldest backup files by date to purge so that the total size
+// of the remaining ones is at most maxBytes. The latest backup file is never returned,
+// even if it alone exceeds maxBytes.
+func StaleBackupFilesBySize(files []BackupFile, maxBytes int64) []BackupFile {
+	sorted := make([]BackupFile, len(files))
+	copy(sorted, files)
+	SortBackupFilesByDate(sorted)
+
+	size := TotalSize(sorted)
+	n := 0
+	for ; n < len(sorted)-1 && size > maxBytes; n++ {
+		size -= sorted[n].Size
+	}
+	return sorted[:n]
+}
+
+// StaleBackupFilesOlderThan returns the backup files last modified before cutoff.
+// The latest backup file is never returned, so that purging them never leaves no backup at all.
+func StaleBackupFilesOlderThan(files []BackupFile, cutoff time.Time) []BackupFile {
+	sorted := make([]BackupFile, len(files))
+	copy(sorted, files)
+	SortBackupFilesByDate(sorted)
+
+	stale := []BackupFile{}
+	for i := 0; i < len(sorted)-1; i++ {
+		if sorted[i].LastModified.Before(cutoff) {
+			stale = append(stale, sorted[i])
+		}
+	}
+	return stale
+}
+
+// StaleBackupFilesByVersion groups backup files by the etcd version in their names
+// and returns the files to purge in order to keep the latest keepPerVersion of each group.
+// Files whose names don't parse as backup names, e.g. manually uploaded ones, are never returned.
+// Older backups named with only their revision form the group of the empty version.
+func StaleBackupFilesByVersion(files []BackupFile, keepPerVersion int) []BackupFile {
+	groups := make(map[string][]BackupFile)
+	for _, f := range files {
+		info, err := ParseBackupName(f.Name)
+		if err != nil {
+			continue
+		}
+		groups[info.Version] = append(groups[info.Version], f)
+	}
+
+	stale := []BackupFile{}
+	for _, group := range groups {
+		SortBackupFilesByDate(group)
+		if len(group) > keepPerVersion {
+			stale = append(stale, group[:len(group)-keepPerVersion]...)
+		}
+	}
+	return stale
+}
+
+// PurgePolicy chooses the backup files to purge among complete backup files.
+// Backends apply the same policies to the files they list, so that a retention policy behaves the same on every storage.
+type PurgePolicy func(files []BackupFile) []BackupFile
+
+// PurgeByCount returns the policy of StaleBackupFilesByCount.
+func PurgeByCount(maxBackups int) PurgePolicy {
+	return func(files []BackupFile) []BackupFile {
+		return StaleBackupFilesByCount(files, maxBackups)
+	}
+}
+
+// PurgeByVersion returns the policy of StaleBackupFilesByVersion.
+func PurgeByVersion(keepPerVersion int) PurgePolicy {
+	return func(files []BackupFile) []BackupFile {
+		return StaleBackupFilesByVersion(files, keepPerVersion)
+	}
+}
+
+// PurgeOlderThan returns the policy of StaleBackupFilesOlderThan with a cutoff of d before the time it is applied.
+func PurgeOlderThan(d time.Duration) PurgePolicy {
+	return func(files []BackupFile) []BackupFile {
+		return StaleBackupFilesOlderThan(files, time.Now().Add(-d))
+	}
+}
+
+// PurgeToSize returns the policy of StaleBackupFilesBySize.
+func PurgeToSize(maxBytes int64) PurgePolicy {
+	return func(files []BackupFile) []BackupFile {
+		return StaleBackupFilesBySize(files, maxBytes)
+	}
+}
+
+// ValidatePurgeBatch checks the arguments of a purge keeping the latest keep backups
+// and deleting at most maxDelete backups.
+func ValidatePurgeBatch(keep, maxDelete int) error {
+	if keep <= 0 {
+		return ErrInvalidMaxBackups
+	}
+	if maxDelete <= 0 {
+		return fmt.Errorf("invalid maximum number of deletions: %d", maxDelete)
+	}
+	return nil
+}
+
+// BatchBackupFiles returns the oldest maxDelete of the backup files to purge by date and the number of the others.
+func BatchBackupFiles(files []BackupFile, maxDelete int) ([]BackupFile, int) {
+	if len(files) <= maxDelete {
+		return files, 0
+	}
+	sorted := make([]BackupFile, len(files))
+	copy(sorted, files)
+	SortBackupFilesByDate(sorted)
+	return sorted[:maxDelete], len(sorted) - maxDelete
+}
+
+// KeepLatest returns the policy choosing the backup files policy chooses, except the latest minKeep by date.
+func KeepLatest(policy PurgePolicy, minKeep int) PurgePolicy {
+	return func(files []BackupFile) []BackupFile {
+		stale := policy(files)
+		if minKeep <= 0 || len(stale) == 0 {
+			return stale
+		}
+		sorted := make([]BackupFile, len(files))
+		copy(sorted, files)
+		SortBackupFilesByDate(sorted)
+		if len(sorted) > minKeep {
+			sorted = sorted[len(sorted)-minKeep:]
+		}
+		latest := make(map[string]bool, len(sorted))
+		for _, f := range sorted {
+			latest[f.Name] = true
+		}
+		kept := []BackupFile{}
+		for _, f := range stale {
+			if !latest[f.Name] {
+				kept = append(kept, f)
+			}
+		}
+		return kept
+	}
+}
+
+// DeleteConcurrently calls del for each of the names using at most workers goroutines.
+// It waits for all deletions to finish and returns an error listing every name that failed.
+// No more deletions are started once ctx is done, in which case ctx.Err() is returned.
+func DeleteConcurrently(ctx context.Context, names []string, workers int, del func(name string) error) error {
+	if workers <= 0 {
+		workers = 1
+	}
+
+	var (
+		mu     sync.Mutex
+		errors []string
+		wg     sync.WaitGroup
+	)
+	namec := make(chan string)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range namec {
+				if err := del(name); err != nil {
+					mu.Lock()
+					errors = append(errors, fmt.Sprintf("%s: %v", name, err))
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+dispatch:
+	for _, name := range names {
+		select {
+		case namec <- name:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(namec)
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if len(errors) == 0 {
+		return nil
+	}
+	sort.Strings(errors)
+	return fmt.Errorf("failed to delete %d of %d backups: %s", len(errors), len(names), strings.Join(errors, "; "))
+}
@@ -0,0 +1,780 @@
+// Copyright 2018 The etcd-operator Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/storage"
+)
+
+func TestParseRevision(t *testing.T) {
+	tests := []struct {
+		name string
+		wRev int64
+		wErr bool
+	}{
+		{name: "etcd.backup_0000000000ed1e1c", wRev: 0xed1e1c},
+		{name: "etcd.backup_0000000000ed1e1c.gz", wRev: 0xed1e1c},
+		{name: "3.2.13_0000000000000326_etcd.backup", wRev: 0x326},
+		{name: "etcd.backup", wErr: true},
+		{name: "etcd.backup_zzzzzzzzzzzzzzzz", wErr: true},
+		{name: "etcd.backup_ffffffffffffffff", wErr: true},
+	}
+	for i, tt := range tests {
+		rev, err := ParseRevision(tt.name)
+		if (err != nil) != tt.wErr {
+			t.Errorf("#%d: expect error=%v, get=%v", i, tt.wErr, err)
+			continue
+		}
+		if rev != tt.wRev {
+			t.Errorf("#%d: expect revision=%x, get=%x", i, tt.wRev, rev)
+		}
+	}
+}
+
+func TestParseBackupName(t *testing.T) {
+	tests := []struct {
+		name  string
+		wInfo BackupInfo
+		wErr  bool
+	}{
+		{name: MakeBackupName("3.2.13", 0x326), wInfo: BackupInfo{Version: "3.2.13", Revision: 0x326}},
+		{name: "backups/etcd.backup_" + MakeBackupName("3.1.0", 0xed1e1c), wInfo: BackupInfo{Version: "3.1.0", Revision: 0xed1e1c}},
+		{name: "etcd.backup_" + MakeBackupName("3.2.13", 1) + GzipSuffix, wInfo: BackupInfo{Version: "3.2.13", Revision: 1}},
+		{name: "3.2.13_ffffffffffffffff_etcd.backup", wInfo: BackupInfo{Version: "3.2.13", Revision: 0xffffffffffffffff}},
+		{name: "etcd.backup_" + MakeClusterBackupName("cluster-a", "3.2.13", 1), wInfo: BackupInfo{Version: "3.2.13", Revision: 1, ClusterName: "cluster-a"}},
+		{name: MakeClusterBackupName("", "3.2.13", 1), wInfo: BackupInfo{Version: "3.2.13", Revision: 1}},
+		{name: "etcd.backup_" + MakeBackupName("3.5.0-rc.0", 0x326), wInfo: BackupInfo{Version: "3.5.0-rc.0", Revision: 0x326}},
+		{name: "etcd.backup_" + MakeClusterBackupName("cluster-a", "3.5.0-rc.0", 1) + GzipSuffix, wInfo: BackupInfo{Version: "3.5.0-rc.0", Revision: 1, ClusterName: "cluster-a"}},
+		// A version or a cluster name of 16 hex digits is not taken for the revision.
+		{name: "etcd.backup_" + MakeBackupName("0000000000000abc", 1), wInfo: BackupInfo{Version: "0000000000000abc", Revision: 1}},
+		{name: "etcd.backup_" + MakeClusterBackupName("0000000000000abc", "3.5.0", 1), wInfo: BackupInfo{Version: "3.5.0", Revision: 1, ClusterName: "0000000000000abc"}},
+		{name: "etcd.backup_0000000000ed1e1c", wInfo: BackupInfo{Revision: 0xed1e1c}},
+		{name: "etcd.backup_0000000000ED1E1C", wInfo: BackupInfo{Revision: 0xed1e1c}},
+		{name: "etcd.backup", wErr: true},
+		{name: "etcd.backup_3.2.13_326_etcd.backup", wErr: true},
+		{name: "etcd.backup_3.2.13_zzzzzzzzzzzzzzzz_etcd.backup", wErr: true},
+		{name: "", wErr: true},
+	}
+	for i, tt := range tests {
+		info, err := ParseBackupName(tt.name)
+		if (err != nil) != tt.wErr {
+			t.Errorf("#%d: expect error=%v, get=%v", i, tt.wErr, err)
+			continue
+		}
+		if info != tt.wInfo {
+			t.Errorf("#%d: expect info=%+v, get=%+v", i, tt.wInfo, info)
+		}
+	}
+}
+
+func TestBackupNamePreReleaseVersion(t *testing.T) {
+	const ver = "3.5.0-rc.0"
+	ts := time.Date(2021, 6, 15, 12, 0, 0, 0, time.UTC)
+	for _, name := range []string{
+		"backups/etcd.backup_" + MakeBackupName(ver, 0xed1e1c),
+		"backups/etcd.backup_" + MakeTimestampedBackupName(ts, "cluster-a", ver, 0xed1e1c) + GzipSuffix,
+	} {
+		info, err := ParseBackupName(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Version != ver || info.Revision != 0xed1e1c {
+			t.Errorf("%s: expect version=%s revision=%x, get version=%s revision=%x", name, ver, 0xed1e1c, info.Version, info.Revision)
+		}
+		if v := ParseVersion(name); v != ver {
+			t.Errorf("%s: expect ParseVersion=%s, get=%s", name, ver, v)
+		}
+		if path, ok := BackupPathOf(name); !ok || path != "backups/etcd.backup" {
+			t.Errorf("%s: expect backup path backups/etcd.backup, get=%s (ok=%v)", name, path, ok)
+		}
+	}
+}
+
+func TestTimestampedBackupNames(t *testing.T) {
+	start := time.Date(2018, 3, 9, 23, 59, 59, 999999000, time.FixedZone("PST", -8*3600))
+	var files []BackupFile
+	var chronological []string
+	// Save times cross second, day and year boundaries, with storage modification times in reverse.
+	for i, d := range []time.Duration{0, time.Microsecond, time.Second, 10 * time.Hour, 365 * 24 * time.Hour} {
+		ts := start.Add(d)
+		name := "etcd.backup_" + MakeTimestampedBackupName(ts, "", "3.2.13", int64(10-i))
+		chronological = append(chronological, name)
+		files = append(files, BackupFile{Name: name, LastModified: start.Add(-time.Duration(i) * time.Hour)})
+
+		info, err := ParseBackupName(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !info.Timestamp.Equal(ts) || info.Version != "3.2.13" || info.Revision != uint64(10-i) {
+			t.Errorf("unexpected backup info of %v: %+v", name, info)
+		}
+	}
+
+	byName := append([]string(nil), chronological...)
+	sort.Sort(sort.Reverse(sort.StringSlice(byName)))
+	sort.Strings(byName)
+	if !reflect.DeepEqual(byName, chronological) {
+		t.Errorf("expect names to sort chronologically: %v, get=%v", chronological, byName)
+	}
+
+	SortBackupFilesByDate(files)
+	var byDate []string
+	for _, f := range files {
+		byDate = append(byDate, f.Name)
+	}
+	if !reflect.DeepEqual(byDate, chronological) {
+		t.Errorf("expect files sorted by the time in their names: %v, get=%v", chronological, byDate)
+	}
+
+	info, err := ParseBackupName("etcd.backup_" + MakeTimestampedBackupName(start, "cluster-a", "3.2.13", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ClusterName != "cluster-a" || !info.Timestamp.Equal(start) {
+		t.Errorf("unexpected backup info: %+v", info)
+	}
+}
+
+func TestBackupFilesOfCluster(t *testing.T) {
+	files := []BackupFile{
+		{Name: "etcd.backup_" + MakeClusterBackupName("cluster-a", "3.2.13", 1)},
+		{Name: "etcd.backup_" + MakeClusterBackupName("cluster-b", "3.2.13", 2)},
+		{Name: "etcd.backup_" + MakeBackupName("3.2.13", 3)},
+		{Name: "etcd.backup_manual"},
+	}
+	tests := []struct {
+		clusterName string
+		want        []string
+	}{
+		{"cluster-a", []string{files[0].Name}},
+		{"cluster-b", []string{files[1].Name}},
+		{DefaultClusterName, []string{files[2].Name}},
+		{"", []string{files[2].Name}},
+		{"cluster-c", nil},
+	}
+	for i, tt := range tests {
+		var got []string
+		for _, f := range BackupFilesOfCluster(files, tt.clusterName) {
+			got = append(got, f.Name)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("#%d: expect backups of %q=%v, get=%v", i, tt.clusterName, tt.want, got)
+		}
+	}
+}
+
+func TestBackupPathOf(t *testing.T) {
+	ts := time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		wPath string
+		wOK   bool
+	}{
+		{name: "a/etcd.backup_" + MakeBackupName("3.2.13", 1), wPath: "a/etcd.backup", wOK: true},
+		{name: "a/etcd_backup_" + MakeClusterBackupName("prod", "3.2.13", 1) + GzipSuffix, wPath: "a/etcd_backup", wOK: true},
+		{name: "a/etcd.backup_" + MakeTimestampedBackupName(ts, "prod", "3.2.13", 1) + ManifestSuffix, wPath: "a/etcd.backup", wOK: true},
+		{name: MakeBackupName("3.2.13", 1)},
+		{name: "a/etcd.backup"},
+		{name: "a/etcd.backup_0000000000000001"},
+	}
+	for _, tt := range tests {
+		path, ok := BackupPathOf(tt.name)
+		if path != tt.wPath || ok != tt.wOK {
+			t.Errorf("%s: expect path=%q ok=%v, get path=%q ok=%v", tt.name, tt.wPath, tt.wOK, path, ok)
+		}
+	}
+}
+
+func TestBackupFileInfo(t *testing.T) {
+	now := time.Now()
+	info, err := BackupFile{Name: "etcd.backup_" + MakeBackupName("3.2.13", 0x326), LastModified: now}.Info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Version != "3.2.13" || info.Revision != 0x326 || !info.Created.Equal(now) {
+		t.Errorf("unexpected backup info: %+v", info)
+	}
+}
+
+func TestWalkBackupFiles(t *testing.T) {
+	name := func(i int) string { return "etcd.backup_" + MakeBackupName("3.2.13", int64(i)) }
+	files := []BackupFile{
+		{Name: name(1)},
+		{Name: MakeTmpName(name(2))},
+		{Name: "etcd.backup_junk"},
+		{Name: name(3)},
+		{Name: name(4)},
+	}
+
+	var names []string
+	err := WalkBackupFiles(files, func(info BackupInfo) error {
+		names = append(names, info.Name)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := []string{name(1), name(3), name(4)}; !reflect.DeepEqual(names, w) {
+		t.Errorf("expect walked names=%v, get=%v", w, names)
+	}
+
+	errStop := errors.New("stop")
+	names = nil
+	err = WalkBackupFiles(files, func(info BackupInfo) error {
+		names = append(names, info.Name)
+		if info.Revision == 3 {
+			return errStop
+		}
+		return nil
+	})
+	if err != errStop {
+		t.Errorf("expect error=%v, get=%v", errStop, err)
+	}
+	if w := []string{name(1), name(3)}; !reflect.DeepEqual(names, w) {
+		t.Errorf("expect walked names=%v, get=%v", w, names)
+	}
+}
+
+func TestPageBackupFiles(t *testing.T) {
+	now := time.Now()
+	files := []BackupFile{}
+	for _, i := range []int{3, 0, 4, 1, 2} {
+		files = append(files, BackupFile{
+			Name:         "etcd.backup_" + MakeBackupName("3.2.13", int64(i)),
+			LastModified: now.Add(time.Duration(i) * time.Minute),
+		})
+	}
+	name := func(i int) string { return "etcd.backup_" + MakeBackupName("3.2.13", int64(i)) }
+
+	tests := []struct {
+		desc          bool
+		limit, offset int
+		wNames        []string
+	}{
+		{desc: true, limit: 2, offset: 0, wNames: []string{name(4), name(3)}},
+		{desc: true, limit: 2, offset: 4, wNames: []string{name(0)}},
+		{desc: false, limit: 3, offset: 1, wNames: []string{name(1), name(2), name(3)}},
+		{desc: false, limit: 0, offset: 3, wNames: []string{name(3), name(4)}},
+		{desc: false, limit: 2, offset: 5, wNames: nil},
+	}
+	for i, tt := range tests {
+		var names []string
+		for _, f := range PageBackupFiles(files, tt.desc, tt.limit, tt.offset) {
+			names = append(names, f.Name)
+		}
+		if !reflect.DeepEqual(names, tt.wNames) {
+			t.Errorf("#%d: expect names=%v, get=%v", i, tt.wNames, names)
+		}
+	}
+}
+
+func TestFindDuplicate(t *testing.T) {
+	name := "etcd.backup_" + MakeBackupName("3.2.13", 0x326)
+	files := []BackupFile{
+		{Name: "etcd.backup_" + MakeBackupName("3.2.13", 0x325)},
+		{Name: name + GzipSuffix, Size: 17},
+		{Name: "etcd.backup"},
+	}
+	f, ok := FindDuplicate(files, name)
+	if !ok || f.Name != name+GzipSuffix || f.Size != 17 {
+		t.Errorf("expect compressed backup to be a duplicate, get=%+v, %v", f, ok)
+	}
+	if _, ok = FindDuplicate(files, "etcd.backup_"+MakeBackupName("3.1.0", 0x326)); ok {
+		t.Errorf("expect backup of another etcd version not to be a duplicate")
+	}
+	if _, ok = FindDuplicate(files, "etcd.backup"); ok {
+		t.Errorf("expect backup name without version and revision never to be a duplicate")
+	}
+}
+
+func TestGetBackupNameByRevision(t *testing.T) {
+	files := []BackupFile{
+		{Name: "etcd.backup_" + MakeBackupName("3.2.13", 0x300)},
+		{Name: "etcd.backup_" + MakeBackupName("3.2.13", 0x100)},
+		{Name: "etcd.backup_" + MakeBackupName("3.2.13", 0x200)},
+		{Name: "etcd.backup_invalid"},
+	}
+	tests := []struct {
+		rev   uint64
+		wName string
+	}{
+		{rev: 0x200, wName: files[2].Name},
+		{rev: 0x2ff, wName: files[2].Name},
+		{rev: 0x1000, wName: files[0].Name},
+		{rev: 0x100, wName: files[1].Name},
+		{rev: 0xff, wName: ""},
+	}
+	for i, tt := range tests {
+		if name := GetBackupNameByRevision(files, tt.rev); name != tt.wName {
+			t.Errorf("#%d: expect name=%q, get=%q", i, tt.wName, name)
+		}
+	}
+}
+
+func TestCompareBackups(t *testing.T) {
+	start := time.Date(2018, 3, 9, 12, 0, 0, 0, time.UTC)
+	files := []BackupFile{
+		{Name: "etcd.backup_" + MakeBackupName("3.2.13", 3), LastModified: start.Add(time.Hour)},
+		{Name: "etcd.backup_" + MakeBackupName("3.2.13", 1), LastModified: start.Add(2 * time.Hour)},
+		// Ties of the modified time are broken by the revision.
+		{Name: "etcd.backup_" + MakeBackupName("3.2.13", 5), LastModified: start.Add(2 * time.Hour)},
+		// The save time embedded in the name takes precedence over the modified time.
+		{Name: "etcd.backup_" + MakeTimestampedBackupName(start.Add(3*time.Hour), "", "3.2.13", 2), LastModified: start},
+		{Name: "etcd.backup_" + MakeBackupName("3.2.13", 4), LastModified: start},
+	}
+	var infos []BackupInfo
+	for _, f := range files {
+		info, err := f.Info()
+		if err != nil {
+			t.Fatal(err)
+		}
+		infos = append(infos, info)
+	}
+	sort.Sort(ByRecency(infos))
+
+	sorted := append([]BackupFile(nil), files...)
+	SortBackupFilesByDate(sorted)
+	for i := range sorted {
+		if infos[i].Name != sorted[i].Name {
+			t.Errorf("#%d: expect %s sorted like SortBackupFilesByDate, get=%s", i, sorted[i].Name, infos[i].Name)
+		}
+	}
+	if latest := GetLatestBackupNameByDate(files); infos[len(infos)-1].Name != latest {
+		t.Errorf("expect the latest by ByRecency=%s to be the latest by date, get=%s", latest, infos[len(infos)-1].Name)
+	}
+	var revs []uint64
+	for _, info := range infos {
+		revs = append(revs, info.Revision)
+	}
+	if want := []uint64{4, 3, 1, 5, 2}; !reflect.DeepEqual(revs, want) {
+		t.Errorf("expect revisions in the order %v, get=%v", want, revs)
+	}
+
+	if c := CompareBackups(infos[0], infos[0]); c != 0 {
+		t.Errorf("expect a backup to compare equal to itself, get=%d", c)
+	}
+	if c := CompareBackups(infos[1], infos[0]); c <= 0 {
+		t.Errorf("expect the more recent backup to compare greater, get=%d", c)
+	}
+}
+
+func TestGetLatestBackupNameByDateSameTime(t *testing.T) {
+	now := time.Now()
+	files := []BackupFile{
+		{Name: "etcd.backup_0000000000000200", LastModified: now},
+		{Name: "etcd.backup_0000000000000100", LastModified: now},
+		{Name: "etcd.backup_0000000000000300", LastModified: now.Add(-time.Second)},
+	}
+	latest := GetLatestBackupNameByDate(files)
+	if latest != "etcd.backup_0000000000000200" {
+		t.Errorf("expect latest=etcd.backup_0000000000000200, get=%v", latest)
+	}
+}
+
+func TestGetLatestBackupNameByDateTimeZones(t *testing.T) {
+	utc := time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC)
+	plus2 := time.FixedZone("+02:00", 2*60*60)
+	files := []BackupFile{
+		// 13:30+02:00 is 11:30 UTC: its wall clock is later but it was modified earlier.
+		{Name: "etcd.backup_" + MakeBackupName("3.2.13", 1), LastModified: utc.Add(-30 * time.Minute).In(plus2)},
+		{Name: "etcd.backup_" + MakeBackupName("3.2.13", 2), LastModified: utc},
+		// 12:00+02:00 is 10:00 UTC.
+		{Name: "etcd.backup_" + MakeBackupName("3.2.13", 3), LastModified: time.Date(2018, 3, 1, 12, 0, 0, 0, plus2)},
+	}
+	want := "etcd.backup_" + MakeBackupName("3.2.13", 2)
+	if latest := GetLatestBackupNameByDate(files); latest != want {
+		t.Errorf("expect latest=%v, get=%v", want, latest)
+	}
+
+	SortBackupFilesByDate(files)
+	for i, rev := range []int64{3, 1, 2} {
+		if name := "etcd.backup_" + MakeBackupName("3.2.13", rev); files[i].Name != name {
+			t.Errorf("expect #%d backup to be %v, get=%v", i, name, files[i].Name)
+		}
+	}
+}
+
+func TestGetLatestBackupNameByDateIgnoresJunk(t *testing.T) {
+	now := time.Now()
+	files := []BackupFile{
+		{Name: "etcd.backup_" + MakeBackupName("3.2.13", 1), LastModified: now.Add(-time.Minute)},
+		{Name: "etcd.backup_" + MakeBackupName("3.2.13", 2), LastModified: now},
+		{Name: "etcd.backup_manual-copy", LastModified: now.Add(time.Hour)},
+	}
+	want := "etcd.backup_" + MakeBackupName("3.2.13", 2)
+	if latest := GetLatestBackupNameByDate(files); latest != want {
+		t.Errorf("expect latest=%v, get=%v", want, latest)
+	}
+
+	if latest := GetLatestBackupNameByDate(files[2:]); latest != "" {
+		t.Errorf("expect no latest backup among junk files, get=%v", latest)
+	}
+}
+
+func TestContainerNotFoundError(t *testing.T) {
+	err := error(&containerNotFoundError{"backups"})
+	if err.Error() != "container backups does not exist" {
+		t.Errorf("unexpected error message: %v", err)
+	}
+	if !IsContainerNotFound(err) {
+		t.Errorf("expect %v to be caused by ErrContainerNotFound", err)
+	}
+	if IsContainerNotFound(errors.New("container backups does not exist")) {
+		t.Errorf("expect plain error not to be caused by ErrContainerNotFound")
+	}
+}
+
+func TestWrapStorageError(t *testing.T) {
+	serr := storage.AzureStorageServiceError{StatusCode: 503, Code: "ServerBusy", RequestID: "d5f8c4a6-0001"}
+	err := WrapStorageError(serr)
+	wrapped, ok := AsStorageError(err)
+	if !ok {
+		t.Fatalf("expect a *StorageError, get=%v", err)
+	}
+	if wrapped.StatusCode != 503 || wrapped.Code != "ServerBusy" || wrapped.RequestID != "d5f8c4a6-0001" {
+		t.Errorf("unexpected storage error: %+v", wrapped)
+	}
+	if !strings.Contains(err.Error(), "d5f8c4a6-0001") || !strings.Contains(err.Error(), "503") {
+		t.Errorf("expect request id and status code in the error message, get=%v", err)
+	}
+	if !IsTransientError(err) {
+		t.Errorf("expect wrapped error to stay transient")
+	}
+	if other := errors.New("invalid path"); WrapStorageError(other) != other {
+		t.Errorf("expect other errors to be returned as is")
+	}
+	if _, ok := AsStorageError(errors.New("invalid path")); ok {
+		t.Errorf("expect no *StorageError in a plain error")
+	}
+}
+
+func TestValidateTags(t *testing.T) {
+	if err := ValidateTags(map[string]string{"team": "storage", "cost_center_2": "42"}); err != nil {
+		t.Errorf("expect valid tags, get=%v", err)
+	}
+	for _, key := range []string{"Team", "cost-center", ""} {
+		if err := ValidateTags(map[string]string{key: "x"}); err == nil {
+			t.Errorf("expect tag key %q to be invalid", key)
+		}
+	}
+
+	tags := map[string]string{"team": "storage"}
+	if got := TagsFrom(WithTags(context.Background(), tags)); !reflect.DeepEqual(got, tags) {
+		t.Errorf("expect tags=%v, get=%v", tags, got)
+	}
+	if got := TagsFrom(context.Background()); got != nil {
+		t.Errorf("expect no tags, get=%v", got)
+	}
+}
+
+func TestUncommittedBlobs(t *testing.T) {
+	committed := []storage.Blob{{Name: "etcd.backup_1"}, {Name: "etcd.backup_2"}}
+	all := append([]storage.Blob{{Name: "etcd.backup_3"}}, committed...)
+	files := uncommittedBlobs(all, committed)
+	if len(files) != 1 || files[0].Name != "etcd.backup_3" {
+		t.Errorf("expect only etcd.backup_3 to be uncommitted, get=%v", files)
+	}
+}
+
+func TestListWithPrefixScope(t *testing.T) {
+	tests := []struct {
+		prefix, subPrefix, want string
+	}{
+		{prefix: "ns/cluster-a", subPrefix: "etcd.backup_", want: "ns/cluster-a/etcd.backup_"},
+		// An empty sub-prefix lists the prefix as is.
+		{prefix: "ns/cluster-a/etcd.backup_", subPrefix: "", want: "ns/cluster-a/etcd.backup_"},
+		{prefix: "", subPrefix: "", want: ""},
+	}
+	for _, tt := range tests {
+		if got := scopedPrefix(tt.prefix, tt.subPrefix); got != tt.want {
+			t.Errorf("scopedPrefix(%q, %q): expect %q, get %q", tt.prefix, tt.subPrefix, tt.want, got)
+		}
+	}
+
+	for prefix, want := range map[string][2]string{
+		"ns/cluster-a/etcd.backup_": {"ns/cluster-a", "etcd.backup_"},
+		"etcd.backup_":              {"etcd.backup_", ""},
+		"ns/cluster-a/":             {"ns/cluster-a/", ""},
+	} {
+		clusterPrefix, subPrefix := splitClusterPrefix(prefix)
+		if [2]string{clusterPrefix, subPrefix} != want {
+			t.Errorf("splitClusterPrefix(%q): expect %q, get [%q %q]", prefix, want, clusterPrefix, subPrefix)
+		}
+		if got := scopedPrefix(clusterPrefix, subPrefix); got != prefix {
+			t.Errorf("expect the split of %q to list the same prefix, get %q", prefix, got)
+		}
+	}
+}
+
+func TestCheckBlobArchived(t *testing.T) {
+	archived := storage.AzureStorageServiceError{StatusCode: 409, Code: "BlobArchived"}
+	for _, err := range []error{archived, &archived} {
+		if !IsBlobArchived(CheckBlobArchived("backups/etcd.backup", err)) {
+			t.Errorf("expect %#v to be caused by ErrBlobArchived", err)
+		}
+	}
+
+	notFound := storage.AzureStorageServiceError{StatusCode: 404, Code: "BlobNotFound"}
+	if err := CheckBlobArchived("backups/etcd.backup", notFound); err != error(notFound) {
+		t.Errorf("expect error=%v, get=%v", notFound, err)
+	}
+	if err := CheckBlobArchived("backups/etcd.backup", nil); err != nil {
+		t.Errorf("expect nil error, get=%v", err)
+	}
+}
+
+func TestValidateAccessTier(t *testing.T) {
+	for _, tier := range []AccessTier{AccessTierHot, AccessTierCool, AccessTierArchive} {
+		if err := ValidateAccessTier(tier); err != nil {
+			t.Errorf("expect %s to be valid, get=%v", tier, err)
+		}
+	}
+	for _, tier := range []AccessTier{"", "hot", "P10"} {
+		if err := ValidateAccessTier(tier); err == nil {
+			t.Errorf("expect %q to be invalid", tier)
+		}
+	}
+}
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		name string
+		wVer string
+	}{
+		{name: "etcd.backup_" + MakeBackupName("3.1.0", 0x326), wVer: "3.1.0"},
+		{name: "etcd.backup_" + MakeBackupName("3.2.13", 0x326) + GzipSuffix, wVer: "3.2.13"},
+		{name: "etcd.backup_0000000000000326", wVer: ""},
+		{name: "etcd.backup", wVer: ""},
+	}
+	for i, tt := range tests {
+		if ver := ParseVersion(tt.name); ver != tt.wVer {
+			t.Errorf("#%d: expect version=%q, get=%q", i, tt.wVer, ver)
+		}
+	}
+}
+
+func TestStaleBackupFilesByVersion(t *testing.T) {
+	now := time.Now()
+	files := []BackupFile{}
+	for i, ver := range []string{"3.1.0", "3.1.0", "3.1.0", "3.2.0", "3.2.0"} {
+		files = append(files, BackupFile{
+			Name:         "etcd.backup_" + MakeBackupName(ver, int64(i)),
+			LastModified: now.Add(time.Duration(i) * time.Minute),
+		})
+	}
+
+	// Blobs not named like backups, e.g. uploaded by hand under the backup path, are never purged.
+	for i, name := range []string{"etcd.backup_notes.txt", "etcd.backup_copy", "etcd.backup_old.db"} {
+		files = append(files, BackupFile{Name: name, LastModified: now.Add(-time.Duration(i) * time.Hour)})
+	}
+
+	stale := StaleBackupFilesByVersion(files, 1)
+	if len(stale) != 3 {
+		t.Fatalf("expect 3 stale backups, get=%v", stale)
+	}
+	purged := make(map[string]bool)
+	for _, f := range stale {
+		purged[f.Name] = true
+	}
+	for _, f := range append([]BackupFile{files[2], files[4]}, files[5:]...) {
+		if purged[f.Name] {
+			t.Errorf("expect %v to be kept", f.Name)
+		}
+	}
+}
+
+func TestStaleBackupFilesOlderThan(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		ages   []time.Duration
+		wStale int
+	}{
+		// only the backups outside of the window are stale
+		{ages: []time.Duration{40 * day, 31 * day, 10 * day, day}, wStale: 2},
+		// the latest backup is kept even if it's outside of the window
+		{ages: []time.Duration{50 * day, 40 * day, 35 * day}, wStale: 2},
+		{ages: []time.Duration{}, wStale: 0},
+	}
+	for i, tt := range tests {
+		files := []BackupFile{}
+		for j, age := range tt.ages {
+			files = append(files, BackupFile{
+				Name:         "etcd.backup_" + MakeBackupName("3.2.13", int64(j)),
+				LastModified: now.Add(-age),
+			})
+		}
+		stale := StaleBackupFilesOlderThan(files, now.Add(-30*day))
+		if len(stale) != tt.wStale {
+			t.Errorf("#%d: expect %d stale backups, get=%v", i, tt.wStale, stale)
+		}
+		for _, f := range stale {
+			if f.Name == GetLatestBackupNameByDate(files) {
+				t.Errorf("#%d: expect latest backup %v to be kept", i, f.Name)
+			}
+		}
+	}
+}
+
+const day = 24 * time.Hour
+
+func TestStaleBackupFilesBySize(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		sizes    []int64
+		maxBytes int64
+		wStale   int
+	}{
+		// the oldest backups are purged until the rest fits the budget
+		{sizes: []int64{100, 200, 300, 400}, maxBytes: 750, wStale: 2},
+		{sizes: []int64{100, 200, 300, 400}, maxBytes: 1000, wStale: 0},
+		// the latest backup is kept even if it alone exceeds the budget
+		{sizes: []int64{100, 200, 300, 400}, maxBytes: 10, wStale: 3},
+		{sizes: []int64{}, maxBytes: 10, wStale: 0},
+	}
+	for i, tt := range tests {
+		files := []BackupFile{}
+		for j, size := range tt.sizes {
+			files = append(files, BackupFile{
+				Name:         "etcd.backup_" + MakeBackupName("3.2.13", int64(j)),
+				LastModified: now.Add(time.Duration(j) * time.Minute),
+				Size:         size,
+			})
+		}
+		stale := StaleBackupFilesBySize(files, tt.maxBytes)
+		if len(stale) != tt.wStale {
+			t.Errorf("#%d: expect %d stale backups, get=%v", i, tt.wStale, stale)
+		}
+		for j, f := range stale {
+			if f.Name != files[j].Name {
+				t.Errorf("#%d: expect the oldest backups to be purged first, get=%v", i, stale)
+			}
+		}
+	}
+}
+
+func TestDeleteConcurrently(t *testing.T) {
+	names := []string{}
+	for i := 0; i < 100; i++ {
+		names = append(names, "etcd.backup_"+MakeBackupName("3.2.13", int64(i)))
+	}
+	failed := names[42]
+
+	var mu sync.Mutex
+	deleted := make(map[string]bool)
+	err := DeleteConcurrently(context.Background(), names, 8, func(name string) error {
+		if name == failed {
+			return errors.New("permission denied")
+		}
+		mu.Lock()
+		deleted[name] = true
+		mu.Unlock()
+		return nil
+	})
+	if err == nil || !strings.Contains(err.Error(), failed) {
+		t.Errorf("expect error to report %v, get=%v", failed, err)
+	}
+	if len(deleted) != len(names)-1 {
+		t.Errorf("expect %d deleted backups, get=%d", len(names)-1, len(deleted))
+	}
+}
+
+func TestRenderPathTemplate(t *testing.T) {
+	tmpl := "mycontainer/{namespace}/{clusterName}/v1/etcd.backup"
+	root, err := ioutil.TempDir("", "path-template")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	for i, clusterName := range []string{"etcd-a", "etcd-a-1"} {
+		path, err := RenderPathTemplate(tmpl, "default", clusterName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "mycontainer/default/" + clusterName + "/v1/etcd.backup"; path != want {
+			t.Errorf("expect path=%v, get=%v", want, path)
+		}
+		fpath := filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(fpath), 0700); err != nil {
+			t.Fatal(err)
+		}
+		for j := 0; j <= i; j++ {
+			if err := ioutil.WriteFile(fpath+"_"+MakeBackupName("3.2.13", int64(j)), nil, 0600); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	for i, clusterName := range []string{"etcd-a", "etcd-a-1"} {
+		path, _ := RenderPathTemplate(tmpl, "default", clusterName)
+		files, err := ListLocalBackupFiles(filepath.Join(root, path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(files) != i+1 {
+			t.Errorf("expect %d backups of cluster %v, get=%d", i+1, clusterName, len(files))
+		}
+	}
+
+	for _, tt := range []struct{ tmpl, namespace, clusterName string }{
+		{tmpl, "default", ""},
+		{tmpl, "default", "etcd_a"},
+		{"mycontainer/{cluster}/etcd.backup", "default", "etcd-a"},
+	} {
+		if _, err := RenderPathTemplate(tt.tmpl, tt.namespace, tt.clusterName); err == nil {
+			t.Errorf("expect error rendering %v with namespace=%q cluster name=%q", tt.tmpl, tt.namespace, tt.clusterName)
+		}
+	}
+}
+
+func TestNormalizePath(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"/v1/", "v1"},
+		{"v1//x", "v1/x"},
+		{"mycontainer//v1/etcd.backup/", "mycontainer/v1/etcd.backup"},
+		{"mycontainer/etcd.backup", "mycontainer/etcd.backup"},
+	}
+	for _, tt := range tests {
+		path, err := NormalizePath(tt.path)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.path, err)
+			continue
+		}
+		if path != tt.expected {
+			t.Errorf("%q: expect path=%q, get=%q", tt.path, tt.expected, path)
+		}
+	}
+
+	for _, path := range []string{"", "  ", "//", " / "} {
+		if _, err := NormalizePath(path); err == nil {
+			t.Errorf("%q: expect error for an empty path", path)
+		}
+	}
+}
@@ -16,10 +16,21 @@ package writer
 
 import (
 	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io"
-	"sort"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/Azure/azure-sdk-for-go/storage"
 	"github.com/coreos/etcd-operator/pkg/backup/util"
@@ -27,115 +38,1478 @@ import (
 )
 
 var _ Writer = &absWriter{}
+var _ ABSCopier = &absWriter{}
+var _ ABSPruner = &absWriter{}
+var _ ABSArchiver = &absWriter{}
+var _ ABSMover = &absWriter{}
+var _ ABSUndeleter = &absWriter{}
+var _ ABSWALAppender = &absWriter{}
+var _ ABSTierSetter = &absWriter{}
+
+// ABSCopier copies backup files to another ABS container.
+type ABSCopier interface {
+	// CopyTo copies the backup file on path to destContainer of dest, keeping its blob name and metadata.
+	CopyTo(ctx context.Context, path string, dest *storage.BlobStorageClient, destContainer string) error
+}
+
+// ABSPruner reclaims the storage of interrupted block uploads.
+type ABSPruner interface {
+	// PruneUncommitted deletes the blobs whose name starts with the key of path that only consist of
+	// uncommitted blocks last modified more than olderThan ago, and returns how many were deleted.
+	PruneUncommitted(ctx context.Context, path string, olderThan time.Duration) (int, error)
+}
+
+// ABSArchiver purges backup files by moving them under an archive prefix of their container.
+type ABSArchiver interface {
+	// ArchivePurge moves the backup files beyond the latest maxBackups by date to archivePrefix,
+	// so that they are no longer listed under path but remain recoverable, and returns their archived paths.
+	// If dryRun is true, the backup files to archive are returned without moving them.
+	// Like Purge, it returns util.ErrInvalidMaxBackups if maxBackups is 0 or less.
+	ArchivePurge(ctx context.Context, path string, maxBackups int, archivePrefix string, dryRun bool) ([]string, error)
+}
+
+// ABSMover moves backup files within an ABS storage account without downloading them.
+type ABSMover interface {
+	// Rename moves the blob on oldPath to newPath, both in the format "<abs-container-name>/<key>",
+	// with a server side copy followed by the deletion of the original blob.
+	Rename(ctx context.Context, oldPath, newPath string) error
+	// MigratePrefix renames every blob whose path starts with oldPrefix to start with newPrefix instead,
+	// and returns how many were moved.
+	MigratePrefix(ctx context.Context, oldPrefix, newPrefix string) (int, error)
+}
+
+// ABSUndeleter recovers purged backup files from the soft delete of the ABS storage account.
+type ABSUndeleter interface {
+	// Undelete restores the soft deleted backup file on path, in the format "<abs-container-name>/<key>",
+	// within the soft delete retention period of the storage account.
+	Undelete(ctx context.Context, path string) error
+}
+
+// ABSTierSetter moves backup files between the access tiers of ABS, e.g. to archive old backups for long-term retention.
+type ABSTierSetter interface {
+	// SetTier sets the access tier of the backup file on path, in the format "<abs-container-name>/<key>".
+	// A backup file in util.AccessTierArchive can't be read until it is moved back to another tier and rehydrated.
+	SetTier(ctx context.Context, path string, tier util.AccessTier) error
+}
+
+// ABSWALAppender streams etcd WAL segments to ABS between snapshots, so that a restore can replay them
+// from the latest snapshot forward.
+type ABSWALAppender interface {
+	// AppendWAL appends the WAL data read from r to the append blob of the WAL segment segmentID
+	// of the backup path path, creating it if needed.
+	AppendWAL(ctx context.Context, path, segmentID string, r io.Reader) error
+	// ListWALSegments returns the IDs of the WAL segments appended to the backup path path, sorted by ID.
+	ListWALSegments(ctx context.Context, path string) ([]string, error)
+}
 
 type absWriter struct {
 	abs *storage.BlobStorageClient
+	// compress enables gzip compression of backups before upload.
+	compress bool
+	// encryptionKey enables AES-256-GCM encryption of backups before upload if set.
+	encryptionKey []byte
+	// clusterName is recorded in the metadata of backups if set.
+	clusterName string
+	// timeout bounds each request, and each operation not streaming the content of a backup.
+	// Saves and copies, which are as long as the backup is large, are only bounded by the timeouts
+	// of their requests and of each read of a copied blob.
+	timeout time.Duration
+	// createContainer enables creating missing containers with containerAccess.
+	createContainer bool
+	containerAccess storage.ContainerAccessType
+	// retry is the retry policy of blob operations.
+	retry util.RetryPolicy
+	// blockSize is the size of the blocks a backup is staged in.
+	blockSize int
+	// blockParallelism is the number of blocks of a backup staged concurrently.
+	blockParallelism int
+	// minKeep is the number of latest backups purges keep whatever their policy.
+	minKeep int
+	// purgeWorkers is the number of blobs purges and moves delete or move concurrently.
+	purgeWorkers int
+	// dedup enables saving backups as manifests of content-defined chunks split with chunkSizes.
+	dedup      bool
+	chunkSizes util.ChunkSizes
+	// tier is the access tier backups are moved to once saved, or the default tier of the account if empty.
+	tier util.AccessTier
 }
 
 const (
 	// AzureBlobBlockChunkLimitInBytes 100MiB is the limit
 	AzureBlobBlockChunkLimitInBytes = 104857600
+	// AzureBlobMaxBlocks is the maximum number of committed blocks of a block blob.
+	AzureBlobMaxBlocks = 50000
+	// AzureAppendBlockLimitInBytes is the maximum size of a block appended to an append blob.
+	AzureAppendBlockLimitInBytes = 4 * 1024 * 1024
+	// DefaultBlockSizeInBytes is the default size of the blocks a backup is staged in.
+	DefaultBlockSizeInBytes = 4 * 1024 * 1024
+
+	// maxConditionalUpdateAttempts bounds the attempts to update a backup index or a latest backup pointer
+	// changed concurrently by other writers.
+	maxConditionalUpdateAttempts = 5
 )
 
+// ABSWriterConfig configures an abs writer created with NewABSWriterFromConfig.
+type ABSWriterConfig struct {
+	// Compress gzip compresses backups, which are saved with the util.GzipSuffix appended.
+	Compress bool
+	// EncryptionKey encrypts backups after compression if not empty.
+	EncryptionKey []byte
+	// ClusterName is recorded in the metadata of backups if not empty.
+	ClusterName string
+	// Timeout bounds each request, or util.DefaultOperationTimeout if 0.
+	// So does each operation, except for those uploading or copying the content of backups.
+	Timeout time.Duration
+	// BlockSize is the size of the blocks backups are uploaded in, or DefaultBlockSizeInBytes if 0.
+	// Writes fail if it is not valid according to ValidateBlockSize.
+	BlockSize int
+	// BlockParallelism is the number of blocks of a backup staged concurrently, buffering as many blocks in memory,
+	// or util.DefaultBlockParallelism if it is not positive.
+	BlockParallelism int
+	// MinKeep is the number of latest backups purges keep whatever their policy,
+	// or util.DefaultMinKeep if it is not positive.
+	MinKeep int
+	// PurgeWorkers is the number of backups purges delete concurrently, or util.DefaultPurgeWorkers if it is not positive.
+	PurgeWorkers int
+	// CreateContainer creates the container of a backup with the ContainerAccess public access level
+	// if it does not exist. The zero value of ContainerAccess creates private containers.
+	CreateContainer bool
+	ContainerAccess storage.ContainerAccessType
+	// Dedup saves backups deduplicated across backups, see NewABSDedupWriter.
+	// Compress, EncryptionKey, BlockSize and Tier are ignored for deduplicated backups.
+	Dedup bool
+	// Tier is the access tier backups are moved to once saved, or the default access tier of the storage account
+	// if empty. Writes fail if it is not valid according to util.ValidateAccessTier.
+	Tier util.AccessTier
+}
+
+// NewABSWriterFromConfig creates a abs writer configured by cfg.
+func NewABSWriterFromConfig(abs *storage.BlobStorageClient, cfg ABSWriterConfig) Writer {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = util.DefaultOperationTimeout
+	}
+	if cfg.BlockSize == 0 {
+		cfg.BlockSize = DefaultBlockSizeInBytes
+	}
+	if cfg.BlockParallelism <= 0 {
+		cfg.BlockParallelism = util.DefaultBlockParallelism
+	}
+	if cfg.MinKeep <= 0 {
+		cfg.MinKeep = util.DefaultMinKeep
+	}
+	if cfg.PurgeWorkers <= 0 {
+		cfg.PurgeWorkers = util.DefaultPurgeWorkers
+	}
+	absw := &absWriter{
+		blockSize:        cfg.BlockSize,
+		blockParallelism: cfg.BlockParallelism,
+		minKeep:          cfg.MinKeep,
+		purgeWorkers:     cfg.PurgeWorkers,
+		abs:              abs,
+		compress:         cfg.Compress,
+		encryptionKey:    cfg.EncryptionKey,
+		clusterName:      cfg.ClusterName,
+		timeout:          cfg.Timeout,
+		createContainer:  cfg.CreateContainer,
+		containerAccess:  cfg.ContainerAccess,
+		tier:             cfg.Tier,
+		retry:            util.DefaultRetryPolicy,
+	}
+	if cfg.Dedup {
+		absw.compress = false
+		absw.encryptionKey = nil
+		absw.blockSize = DefaultBlockSizeInBytes
+		absw.tier = ""
+		absw.dedup = true
+		absw.chunkSizes = util.DefaultChunkSizes
+	}
+	return absw
+}
+
 // NewABSWriter creates a abs writer.
-func NewABSWriter(abs *storage.BlobStorageClient) Writer {
-	return &absWriter{abs}
+// If compress is true, backups are gzip compressed and saved with the util.GzipSuffix appended.
+// If encryptionKey is not empty, backups are encrypted with it after compression.
+// If clusterName is not empty, it is recorded in the metadata of backups.
+// Each request times out after timeout, or util.DefaultOperationTimeout if timeout is 0.
+// So does each operation, except for those uploading or copying the content of backups.
+// Backups are uploaded in blocks of blockSize bytes, or DefaultBlockSizeInBytes if blockSize is 0.
+// Writes fail if blockSize is not valid according to ValidateBlockSize.
+func NewABSWriter(abs *storage.BlobStorageClient, compress bool, encryptionKey []byte, clusterName string, timeout time.Duration, blockSize int) Writer {
+	return NewABSWriterFromConfig(abs, ABSWriterConfig{
+		Compress:      compress,
+		EncryptionKey: encryptionKey,
+		ClusterName:   clusterName,
+		Timeout:       timeout,
+		BlockSize:     blockSize,
+	})
 }
 
-func (absw *absWriter) getContainer(container string) (*storage.Container, error) {
-	containerRef := absw.abs.GetContainerReference(container)
-	containerExists, err := containerRef.Exists()
-	if err != nil {
-		return nil, err
+// NewABSWriterCreate creates a abs writer like NewABSWriter, which creates the container of a backup
+// with the given public access level if it does not exist. The zero value of access creates private containers.
+func NewABSWriterCreate(abs *storage.BlobStorageClient, compress bool, encryptionKey []byte, clusterName string, timeout time.Duration, blockSize int, access storage.ContainerAccessType) Writer {
+	return NewABSWriterFromConfig(abs, ABSWriterConfig{
+		Compress:        compress,
+		EncryptionKey:   encryptionKey,
+		ClusterName:     clusterName,
+		Timeout:         timeout,
+		BlockSize:       blockSize,
+		CreateContainer: true,
+		ContainerAccess: access,
+	})
+}
+
+// NewABSDedupWriter creates a abs writer like NewABSWriter, which saves backups deduplicated across backups:
+// each backup is split into content-defined chunks stored once under util.ChunkPrefix of its container,
+// and saved as a manifest listing its chunks with the util.ManifestSuffix appended.
+// Deduplicated backups are neither compressed nor encrypted. Purging a backup only deletes its manifest,
+// and copying a backup to another container with CopyTo does not copy its chunks.
+func NewABSDedupWriter(abs *storage.BlobStorageClient, clusterName string, timeout time.Duration) Writer {
+	return NewABSWriterFromConfig(abs, ABSWriterConfig{ClusterName: clusterName, Timeout: timeout, Dedup: true})
+}
+
+// ValidateBlockSize checks that blockSize is a valid size of the blocks of a block blob,
+// that is positive and at most AzureBlobBlockChunkLimitInBytes.
+func ValidateBlockSize(blockSize int) error {
+	if blockSize <= 0 || blockSize > AzureBlobBlockChunkLimitInBytes {
+		return fmt.Errorf("invalid block size (%d): must be between 1 and %d bytes", blockSize, AzureBlobBlockChunkLimitInBytes)
 	}
+	return nil
+}
 
-	if !containerExists {
-		return nil, fmt.Errorf("container %v does not exist", container)
+// do runs the blob request fn with the retry policy, wrapping ABS service errors into *util.StorageError.
+// The request, including its retries, times out after absw.timeout.
+func (absw *absWriter) do(ctx context.Context, fn func() error) error {
+	ctx, cancel := context.WithTimeout(ctx, absw.timeout)
+	defer cancel()
+	return util.WrapStorageError(absw.retry.Do(ctx, fn))
+}
+
+func (absw *absWriter) getContainer(ctx context.Context, container string) (*storage.Container, error) {
+	var containerRef *storage.Container
+	err := absw.do(ctx, func() error {
+		var ref *storage.Container
+		var err error
+		if absw.createContainer {
+			ref, err = util.GetOrCreateContainer(absw.abs, container, absw.containerAccess)
+		} else {
+			ref, err = util.GetContainer(absw.abs, container)
+		}
+		if err != nil {
+			return err
+		}
+		containerRef = ref
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 	return containerRef, nil
 }
 
 // Write writes the backup file to the given abs path, "<abs-container-name>/<key>".
-func (absw *absWriter) Write(path string, r io.Reader) (int64, error) {
-	container, key, err := util.ParseBucketAndKey(path)
+// r is staged block by block until EOF, so its length does not need to be known in advance.
+// The storage client doesn't take a context, so cancellation is checked between staged blocks.
+// If the length of r is known, a save that would exceed util.QuotaFrom(ctx) fails before uploading anything
+// with an error whose cause is util.ErrQuotaExceeded.
+func (absw *absWriter) Write(ctx context.Context, path string, r io.Reader) (int64, error) {
+	res, err := absw.WriteWithResult(ctx, path, r)
 	if err != nil {
 		return 0, err
 	}
-	containerRef, err := absw.getContainer(container)
+	return res.Size, nil
+}
+
+// WriteWithResult writes the backup file to the given abs path like Write.
+// The path of the result is the one of the saved blob, including util.GzipSuffix or util.ManifestSuffix if appended.
+func (absw *absWriter) WriteWithResult(ctx context.Context, path string, r io.Reader) (*SaveResult, error) {
+	start := time.Now()
+	res, err := absw.write(ctx, path, r)
 	if err != nil {
-		return 0, err
+		observeWrite(backendABS, start, 0, err)
+		return nil, err
+	}
+	res.Duration = time.Since(start)
+	observeWrite(backendABS, start, res.Size, nil)
+	return res, nil
+}
+
+// WriteIfAbsent writes the backup file to the given abs path unless a backup of the same
+// etcd version and revision is already saved.
+func (absw *absWriter) WriteIfAbsent(ctx context.Context, path string, r io.Reader) (int64, bool, error) {
+	container, key, err := util.ParseBucketAndKey(path)
+	if err != nil {
+		return 0, false, err
+	}
+	containerRef, err := absw.getContainer(ctx, container)
+	if err != nil {
+		return 0, false, err
 	}
 
+	var files []util.BackupFile
+	err = absw.do(ctx, func() error {
+		list, err := util.ListBackupFilesWithPrefix(containerRef, key)
+		if err != nil {
+			return err
+		}
+		files = list
+		return nil
+	})
+	if err != nil {
+		return 0, false, err
+	}
+	if f, ok := util.FindDuplicate(files, key); ok {
+		return f.Size, true, nil
+	}
+
+	size, err := absw.Write(ctx, path, r)
+	return size, false, err
+}
+
+func (absw *absWriter) write(ctx context.Context, path string, r io.Reader) (*SaveResult, error) {
+	if err := ValidateBlockSize(absw.blockSize); err != nil {
+		return nil, err
+	}
+	if len(absw.tier) != 0 {
+		if err := util.ValidateAccessTier(absw.tier); err != nil {
+			return nil, err
+		}
+	}
+	container, key, err := util.ParseBucketAndKey(path)
+	if err != nil {
+		return nil, err
+	}
+	containerRef, err := absw.getContainer(ctx, container)
+	if err != nil {
+		return nil, err
+	}
+	if err = absw.checkQuota(ctx, containerRef, key, r); err != nil {
+		return nil, err
+	}
+
+	r = util.NewContextReader(ctx, r)
+	var manifest *util.ChunkManifest
+	if absw.dedup {
+		key += util.ManifestSuffix
+		manifest, err = absw.saveChunks(ctx, containerRef, r)
+		if err != nil {
+			return nil, err
+		}
+		data, err := json.Marshal(manifest)
+		if err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(data)
+	}
+	if absw.compress {
+		key += util.GzipSuffix
+		r = util.CompressReader(r)
+	}
+
+	// The backup is uploaded to a temporary blob first and only copied to its name once complete,
+	// so that an interrupted save never leaves an incomplete backup behind.
 	blob := containerRef.GetBlobReference(key)
+	tmpBlob := containerRef.GetBlobReference(util.MakeTmpName(key))
+	defer tmpBlob.Delete(&storage.DeleteBlobOptions{})
 	putBlobOpts := storage.PutBlobOptions{}
 
-	err = blob.CreateBlockBlob(&putBlobOpts)
+	err = absw.do(ctx, func() error {
+		return tmpBlob.CreateBlockBlob(&putBlobOpts)
+	})
 	if err != nil {
-		return 0, err
+		return nil, err
+	}
+
+	if len(absw.encryptionKey) != 0 {
+		r, err = util.NewEncryptReader(absw.encryptionKey, r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt backup: %v", err)
+		}
+	}
+
+	h, md5h := sha256.New(), md5.New()
+	size, err := absw.stageBlocks(ctx, tmpBlob, io.TeeReader(r, io.MultiWriter(h, md5h)))
+	if err != nil {
+		return nil, err
 	}
 
-	buf := new(bytes.Buffer)
-	buf.ReadFrom(r)
-	len := len(buf.Bytes())
-	chunckCount := len/AzureBlobBlockChunkLimitInBytes + 1
-	blocks := make([]storage.Block, 0, chunckCount)
-	for i := 0; i < chunckCount; i++ {
+	tmpBlob.Properties.ContentType = util.BackupContentType
+	tmpBlob.Properties.ContentMD5 = base64.StdEncoding.EncodeToString(md5h.Sum(nil))
+	err = absw.do(ctx, func() error {
+		return tmpBlob.SetProperties(&storage.SetBlobPropertiesOptions{})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to save backup properties: %v", err)
+	}
+
+	tmpBlob.Metadata = backupMetadata(key, absw.clusterName)
+	for k, v := range util.TagsFrom(ctx) {
+		tmpBlob.Metadata[util.TagMetadataKey(k)] = v
+	}
+	tmpBlob.Metadata[util.MetadataSHA256] = hex.EncodeToString(h.Sum(nil))
+	tmpBlob.Metadata[util.MetadataCompleted] = time.Now().UTC().Format(time.RFC3339Nano)
+	err = absw.do(ctx, func() error {
+		return tmpBlob.SetMetadata(&storage.SetBlobMetadataOptions{})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to save backup checksum: %v", err)
+	}
+
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+	// The copy keeps the properties and metadata of the temporary blob.
+	err = absw.do(ctx, func() error {
+		return blob.Copy(tmpBlob.GetURL(), &storage.CopyOptions{})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to commit backup: %v", err)
+	}
+	if err = absw.updateLatestPointer(ctx, containerRef, key); err != nil {
+		return nil, err
+	}
+	if backupPath, ok := util.BackupPathOf(key); ok {
+		entry := util.BackupIndexEntry{Name: key, SHA256: tmpBlob.Metadata[util.MetadataSHA256], Size: size}
+		err = absw.updateIndex(ctx, containerRef, backupPath, func(idx *util.BackupIndex) { idx.Add(entry) })
+		if err != nil {
+			return nil, fmt.Errorf("backup saved but failed to update backup index: %v", err)
+		}
+	}
+	if len(absw.tier) != 0 {
+		err = absw.do(ctx, func() error {
+			return util.SetBlobTier(ctx, blob, absw.tier)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("backup saved but failed to set its access tier: %v", err)
+		}
+	}
+	if manifest != nil {
+		size = manifest.Size
+	}
+	return &SaveResult{Path: container + "/" + key, Size: size, SHA256: tmpBlob.Metadata[util.MetadataSHA256]}, nil
+}
+
+// checkQuota refuses to save the backup key of the content of r before anything is uploaded if the backups
+// of its backup path would exceed util.QuotaFrom(ctx) in total. The content is counted before compression.
+// The check is skipped if there is no quota, if key is not a backup of a backup path,
+// or if the length of r is unknown, in which case the save is not limited.
+func (absw *absWriter) checkQuota(ctx context.Context, containerRef *storage.Container, key string, r io.Reader) error {
+	quota := util.QuotaFrom(ctx)
+	if quota <= 0 {
+		return nil
+	}
+	backupPath, ok := util.BackupPathOf(key)
+	if !ok {
+		return nil
+	}
+	size, ok := util.SourceLength(r)
+	if !ok {
+		return nil
+	}
+	var files []util.BackupFile
+	err := absw.do(ctx, func() error {
+		list, err := util.ListBackupFiles(containerRef, backupPath)
+		if err != nil {
+			return err
+		}
+		files = list
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list backups to check quota: %v", err)
+	}
+	return util.CheckQuota(util.TotalSize(files), size, quota)
+}
+
+// updateLatestPointer records key as the latest backup of its backup path in the pointer blob of the path,
+// named with util.LatestPointerSuffix, so that readers find it without listing every backup.
+// Keys not carrying a revision are not backups of a backup path and leave pointers as they are.
+// The pointer is only replaced if it does not record a more recent backup according to util.CompareBackups,
+// and if no other writer changed it in the meantime, otherwise the update is tried again, so that concurrent saves
+// leave it on the backup a listing of the backups, as done by readers missing the pointer, finds the latest.
+// If the pointer can't be updated, it is deleted so that readers don't take an older backup for the latest one.
+func (absw *absWriter) updateLatestPointer(ctx context.Context, containerRef *storage.Container, key string) error {
+	backupPath, ok := util.BackupPathOf(key)
+	if !ok {
+		return nil
+	}
+	if _, err := util.ParseBackupName(key); err != nil {
+		return nil
+	}
+	info, err := absw.backupInfo(ctx, containerRef, key)
+	if err != nil {
+		return fmt.Errorf("backup saved but failed to get its properties: %v", err)
+	}
+	pointer := containerRef.GetBlobReference(backupPath + util.LatestPointerSuffix)
+	for attempt := 1; ; attempt++ {
+		var current []byte
+		var etag string
+		err = absw.do(ctx, func() error {
+			rc, err := pointer.Get(&storage.GetBlobOptions{})
+			if err != nil {
+				return err
+			}
+			defer rc.Close()
+			data, err := ioutil.ReadAll(rc)
+			if err != nil {
+				return err
+			}
+			current, etag = data, pointer.Properties.Etag
+			return nil
+		})
+		if err != nil && !util.HasStatusCode(err, http.StatusNotFound) {
+			break
+		}
+		// A pointer to a purged backup is replaced, like a pointer which is not a backup name.
+		if latest, err := absw.backupInfo(ctx, containerRef, string(current)); err == nil && util.CompareBackups(latest, info) > 0 {
+			return nil
+		}
+
+		opts := storage.PutBlobOptions{IfMatch: etag}
+		if len(etag) == 0 {
+			opts = storage.PutBlobOptions{IfNoneMatch: "*"}
+		}
+		err = absw.do(ctx, func() error {
+			return pointer.CreateBlockBlobFromReader(strings.NewReader(key), &opts)
+		})
+		if err == nil {
+			return nil
+		}
+		changed := util.HasStatusCode(err, http.StatusPreconditionFailed) || util.HasStatusCode(err, http.StatusConflict)
+		if !changed || attempt >= maxConditionalUpdateAttempts {
+			break
+		}
+	}
+	derr := absw.do(ctx, func() error {
+		_, err := pointer.DeleteIfExists(&storage.DeleteBlobOptions{})
+		return err
+	})
+	if derr != nil {
+		return fmt.Errorf("backup saved but failed to update latest backup pointer: %v", err)
+	}
+	return nil
+}
+
+// backupInfo returns the BackupInfo of the backup saved under key. The last modified time of its blob
+// is only requested if its name has no timestamp, in which case util.CompareBackups orders it by that time
+// as in a listing of the backups.
+func (absw *absWriter) backupInfo(ctx context.Context, containerRef *storage.Container, key string) (util.BackupInfo, error) {
+	info, err := util.ParseBackupName(key)
+	if err != nil || !info.Timestamp.IsZero() {
+		return info, err
+	}
+	blob := containerRef.GetBlobReference(key)
+	err = absw.do(ctx, func() error {
+		return blob.GetProperties(&storage.GetBlobPropertiesOptions{})
+	})
+	if err != nil {
+		return info, err
+	}
+	info.Created = time.Time(blob.Properties.LastModified)
+	return info, nil
+}
+
+// updateIndex applies fn to the backup index of backupPath, named with util.IndexSuffix, and saves it.
+// The index is only replaced if no other writer changed it in the meantime, otherwise the update is tried again.
+func (absw *absWriter) updateIndex(ctx context.Context, containerRef *storage.Container, backupPath string, fn func(idx *util.BackupIndex)) error {
+	blob := containerRef.GetBlobReference(backupPath + util.IndexSuffix)
+	for attempt := 1; ; attempt++ {
+		var idx util.BackupIndex
+		var etag string
+		err := absw.do(ctx, func() error {
+			rc, err := blob.Get(&storage.GetBlobOptions{})
+			if err != nil {
+				return err
+			}
+			defer rc.Close()
+			var decoded util.BackupIndex
+			if err := json.NewDecoder(rc).Decode(&decoded); err != nil {
+				return err
+			}
+			idx, etag = decoded, blob.Properties.Etag
+			return nil
+		})
+		if err != nil && !util.HasStatusCode(err, http.StatusNotFound) {
+			return err
+		}
+
+		fn(&idx)
+		data, err := json.Marshal(&idx)
+		if err != nil {
+			return err
+		}
+		opts := storage.PutBlobOptions{IfMatch: etag}
+		if len(etag) == 0 {
+			opts = storage.PutBlobOptions{IfNoneMatch: "*"}
+		}
+		err = absw.do(ctx, func() error {
+			return blob.CreateBlockBlobFromReader(bytes.NewReader(data), &opts)
+		})
+		changed := util.HasStatusCode(err, http.StatusPreconditionFailed) || util.HasStatusCode(err, http.StatusConflict)
+		if !changed || attempt >= maxConditionalUpdateAttempts {
+			return err
+		}
+	}
+}
+
+// saveChunks splits the content of r into chunks, uploads the ones not stored yet under util.ChunkPrefix
+// of containerRef and returns the manifest listing them.
+func (absw *absWriter) saveChunks(ctx context.Context, containerRef *storage.Container, r io.Reader) (*util.ChunkManifest, error) {
+	manifest := &util.ChunkManifest{Chunks: []string{}}
+	saved := map[string]bool{}
+	size, err := util.SplitChunks(r, absw.chunkSizes, func(chunk []byte) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		hash := util.ChunkHash(chunk)
+		manifest.Chunks = append(manifest.Chunks, hash)
+		if saved[hash] {
+			return nil
+		}
+		blob := containerRef.GetBlobReference(util.ChunkBlobName(hash))
+		err := absw.do(ctx, func() error {
+			exists, err := blob.Exists()
+			if err != nil || exists {
+				return err
+			}
+			return blob.CreateBlockBlobFromReader(bytes.NewReader(chunk), &storage.PutBlobOptions{})
+		})
+		if err != nil {
+			return fmt.Errorf("failed to save chunk %s: %v", hash, err)
+		}
+		saved[hash] = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	manifest.Size = size
+	return manifest, nil
+}
+
+// backupMetadata returns the blob metadata describing the backup saved under key:
+// the etcd version and revision if key carries them, and the cluster name if set.
+func backupMetadata(key, clusterName string) storage.BlobMetadata {
+	m := storage.BlobMetadata{}
+	if info, err := util.ParseBackupName(key); err == nil {
+		if len(info.Version) != 0 {
+			m[util.MetadataEtcdVersion] = info.Version
+		}
+		m[util.MetadataEtcdRevision] = strconv.FormatUint(info.Revision, 10)
+	}
+	if len(clusterName) != 0 {
+		m[util.MetadataClusterName] = clusterName
+	}
+	return m
+}
+
+// stageBlocks uploads the content of r to the block blob in blocks of absw.blockSize bytes
+// and commits them. Each block is sent with its MD5 for the service to validate it.
+// Up to absw.blockParallelism blocks are staged concurrently, and committed in the order of the content.
+// It fails once the content needs more than AzureBlobMaxBlocks blocks.
+// It returns the size of the uploaded content.
+func (absw *absWriter) stageBlocks(ctx context.Context, blob *storage.Blob, r io.Reader) (int64, error) {
+	var mu sync.Mutex
+	blockIDs := map[int]string{}
+	size, err := forEachBlockConcurrently(r, absw.blockSize, absw.blockParallelism, func(i int, chunk []byte) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if i >= AzureBlobMaxBlocks {
+			return fmt.Errorf("backup exceeds the maximum blob size of %d blocks of %d bytes", AzureBlobMaxBlocks, absw.blockSize)
+		}
 		blockID := base64.StdEncoding.EncodeToString([]byte(uuid.New()))
-		blocks = append(blocks, storage.Block{ID: blockID, Status: storage.BlockStatusLatest})
-		start := i * AzureBlobBlockChunkLimitInBytes
-		end := (i + 1) * AzureBlobBlockChunkLimitInBytes
-		if len < end {
-			end = len
+		mu.Lock()
+		blockIDs[i] = blockID
+		mu.Unlock()
+		sum := md5.Sum(chunk)
+		opts := &storage.PutBlockOptions{ContentMD5: base64.StdEncoding.EncodeToString(sum[:])}
+		return absw.do(ctx, func() error {
+			return blob.PutBlock(blockID, chunk, opts)
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if err = ctx.Err(); err != nil {
+		return 0, err
+	}
+	blocks := make([]storage.Block, len(blockIDs))
+	for i, id := range blockIDs {
+		blocks[i] = storage.Block{ID: id, Status: storage.BlockStatusLatest}
+	}
+	err = absw.do(ctx, func() error {
+		return blob.PutBlockList(blocks, &storage.PutBlockListOptions{})
+	})
+	if err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+// CopyTo copies the backup file on the given abs path, "<abs-container-name>/<key>",
+// to destContainer of dest under the same blob name, along with its metadata such as the checksum.
+// The copy is done server side if dest is in the same storage account, and is streamed through otherwise.
+func (absw *absWriter) CopyTo(ctx context.Context, path string, dest *storage.BlobStorageClient, destContainer string) error {
+	container, key, err := util.ParseBucketAndKey(path)
+	if err != nil {
+		return err
+	}
+	containerRef, err := absw.getContainer(ctx, container)
+	if err != nil {
+		return err
+	}
+	var destRef *storage.Container
+	err = absw.do(ctx, func() error {
+		ref, err := util.GetContainer(dest, destContainer)
+		if err != nil {
+			return err
+		}
+		destRef = ref
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	blob := containerRef.GetBlobReference(key)
+	destBlob := destRef.GetBlobReference(key)
+	sameAccount, err := sameStorageAccount(blob, destBlob)
+	if err != nil {
+		return err
+	}
+	if sameAccount {
+		if container == destContainer {
+			return fmt.Errorf("failed to copy backup (%v): destination is the source", path)
 		}
+		return absw.do(ctx, func() error {
+			return destBlob.Copy(blob.GetURL(), &storage.CopyOptions{})
+		})
+	}
 
-		chunk := buf.Bytes()[start:end]
-		err = blob.PutBlock(blockID, chunk, &storage.PutBlockOptions{})
+	err = absw.do(ctx, func() error {
+		return blob.GetMetadata(&storage.GetBlobMetadataOptions{})
+	})
+	if err != nil {
+		return err
+	}
+	var rc io.ReadCloser
+	err = absw.do(ctx, func() error {
+		body, err := blob.Get(&storage.GetBlobOptions{})
 		if err != nil {
-			return 0, err
+			return err
 		}
+		rc = body
+		return nil
+	})
+	if err != nil {
+		return util.CheckBlobArchived(path, err)
 	}
+	rc = util.NewIdleReadCloser(rc, absw.timeout)
+	defer rc.Close()
 
-	err = blob.PutBlockList(blocks, &storage.PutBlockListOptions{})
+	if _, err = absw.stageBlocks(ctx, destBlob, util.NewContextReader(ctx, rc)); err != nil {
+		return err
+	}
+	destBlob.Metadata = blob.Metadata
+	return absw.do(ctx, func() error {
+		return destBlob.SetMetadata(&storage.SetBlobMetadataOptions{})
+	})
+}
+
+// sameStorageAccount returns true if both blobs are served by the same storage account endpoint.
+func sameStorageAccount(a, b *storage.Blob) (bool, error) {
+	ua, err := url.Parse(a.GetURL())
+	if err != nil {
+		return false, err
+	}
+	ub, err := url.Parse(b.GetURL())
+	if err != nil {
+		return false, err
+	}
+	return ua.Host == ub.Host, nil
+}
+
+// maxEmptyReads is the number of consecutive reads returning no data and no error
+// after which a backup source is considered stuck.
+const maxEmptyReads = 100
+
+// forEachBlock reads r in blocks of blockSize bytes until EOF and calls fn on each of them.
+// The length of r does not need to be known in advance, e.g. when streaming the snapshot from etcd,
+// and reads may return any number of bytes. The last block may be shorter.
+// The chunk passed to fn is only valid until fn returns. It returns the total number of bytes read,
+// or io.ErrNoProgress if r keeps returning no data without an error.
+func forEachBlock(r io.Reader, blockSize int, fn func(chunk []byte) error) (int64, error) {
+	return forEachBlockConcurrently(r, blockSize, 1, func(_ int, chunk []byte) error {
+		return fn(chunk)
+	})
+}
+
+// forEachBlockConcurrently reads r in blocks like forEachBlock, but calls fn on up to parallelism blocks
+// concurrently, so that at most parallelism blocks are buffered in memory. The i-th block read is passed to fn
+// with index i. No more blocks are read once fn fails, and the first error is returned once the calls of fn
+// already started return.
+func forEachBlockConcurrently(r io.Reader, blockSize, parallelism int, fn func(i int, chunk []byte) error) (int64, error) {
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	fail := func(err error) {
+		mu.Lock()
+		if firstErr == nil {
+			firstErr = err
+		}
+		mu.Unlock()
+	}
+	failed := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return firstErr != nil
+	}
+
+	// Buffers are allocated as needed, and reused once their block is staged.
+	free := make(chan []byte, parallelism)
+	allocated := 0
+	var size int64
+	for i := 0; ; {
+		var buf []byte
+		if allocated < parallelism {
+			buf = make([]byte, blockSize)
+			allocated++
+		} else {
+			buf = <-free
+		}
+		if failed() {
+			break
+		}
+		n, err := readBlock(r, buf)
+		if n > 0 {
+			wg.Add(1)
+			go func(i int, buf []byte, n int) {
+				defer wg.Done()
+				if err := fn(i, buf[:n]); err != nil {
+					fail(err)
+				}
+				free <- buf
+			}(i, buf, n)
+			size += int64(n)
+			i++
+		} else {
+			free <- buf
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fail(err)
+			break
+		}
+	}
+	wg.Wait()
+	return size, firstErr
+}
+
+// readBlock fills buf from r like io.ReadFull, but returns io.EOF along with the bytes read
+// if r ends before buf is full, and io.ErrNoProgress if r returns no data maxEmptyReads times in a row.
+func readBlock(r io.Reader, buf []byte) (int, error) {
+	n, empty := 0, 0
+	for n < len(buf) {
+		nn, err := r.Read(buf[n:])
+		n += nn
+		if err != nil {
+			return n, err
+		}
+		if nn > 0 {
+			empty = 0
+		} else if empty++; empty >= maxEmptyReads {
+			return n, io.ErrNoProgress
+		}
+	}
+	return n, nil
+}
+
+func (absw *absWriter) Purge(ctx context.Context, path string, maxBackups int, dryRun bool) ([]string, error) {
+	if maxBackups <= 0 {
+		return nil, util.ErrInvalidMaxBackups
+	}
+	ctx, cancel := context.WithTimeout(ctx, absw.timeout)
+	defer cancel()
+
+	containerRef, files, err := absw.listBackupFiles(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return absw.deleteBackupFiles(ctx, containerRef, staleFiles(files, util.PurgeByCount(maxBackups), absw.minKeep), dryRun)
+}
+
+// PurgeBatch purges at most maxDelete stale backup files, keeping the latest keep backups by date.
+func (absw *absWriter) PurgeBatch(ctx context.Context, path string, keep, maxDelete int) (int, int, error) {
+	if err := util.ValidatePurgeBatch(keep, maxDelete); err != nil {
+		return 0, 0, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, absw.timeout)
+	defer cancel()
+
+	containerRef, files, err := absw.listBackupFiles(ctx, path)
+	if err != nil {
+		return 0, 0, err
+	}
+	batch, remaining := util.BatchBackupFiles(staleFiles(files, util.PurgeByCount(keep), absw.minKeep), maxDelete)
+	if _, err := absw.deleteBackupFiles(ctx, containerRef, batch, false); err != nil {
+		return 0, 0, err
+	}
+	return len(batch), remaining, nil
+}
+
+// PurgeByVersion purges stale backup files, keeping the latest keepPerVersion backups by date
+// for each etcd version found in the backup names.
+func (absw *absWriter) PurgeByVersion(ctx context.Context, path string, keepPerVersion int, dryRun bool) ([]string, error) {
+	if keepPerVersion <= 0 {
+		return nil, util.ErrInvalidMaxBackups
+	}
+	ctx, cancel := context.WithTimeout(ctx, absw.timeout)
+	defer cancel()
+
+	containerRef, files, err := absw.listBackupFiles(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return absw.deleteBackupFiles(ctx, containerRef, staleFiles(files, util.PurgeByVersion(keepPerVersion), absw.minKeep), dryRun)
+}
+
+// PurgeOlderThan purges backup files last modified more than d ago, except the latest one.
+func (absw *absWriter) PurgeOlderThan(ctx context.Context, path string, d time.Duration, dryRun bool) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, absw.timeout)
+	defer cancel()
+
+	containerRef, files, err := absw.listBackupFiles(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return absw.deleteBackupFiles(ctx, containerRef, staleFiles(files, util.PurgeOlderThan(d), absw.minKeep), dryRun)
+}
+
+// PurgeToSize purges the oldest backup files until the total size of the remaining ones is at most maxBytes,
+// except the latest one.
+func (absw *absWriter) PurgeToSize(ctx context.Context, path string, maxBytes int64, dryRun bool) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, absw.timeout)
+	defer cancel()
+
+	containerRef, files, err := absw.listBackupFiles(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return absw.deleteBackupFiles(ctx, containerRef, staleFiles(files, util.PurgeToSize(maxBytes), absw.minKeep), dryRun)
+}
+
+// DeleteAll deletes every backup file saved with revision appended to path concurrently,
+// including the latest one and the temporary blobs of backups being saved,
+// and then the latest backup pointer and the backup index of path.
+func (absw *absWriter) DeleteAll(ctx context.Context, path string, dryRun bool) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, absw.timeout)
+	defer cancel()
+
+	containerRef, files, err := absw.listBackupFiles(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	deleted, err := absw.deleteBackupFiles(ctx, containerRef, files, dryRun)
+	if err != nil || dryRun {
+		return deleted, err
+	}
+	_, key, err := util.ParseBucketAndKey(path)
+	if err != nil {
+		return deleted, err
+	}
+	for _, suffix := range []string{util.LatestPointerSuffix, util.IndexSuffix} {
+		blob := containerRef.GetBlobReference(key + suffix)
+		err = absw.do(ctx, func() error {
+			_, err := blob.DeleteIfExists(&storage.DeleteBlobOptions{})
+			return err
+		})
+		if err != nil {
+			return deleted, err
+		}
+	}
+	return deleted, nil
+}
+
+// listBackupFiles lists the backup files saved with revision appended to the given abs path,
+// including the temporary blobs of backups being saved.
+func (absw *absWriter) listBackupFiles(ctx context.Context, path string) (*storage.Container, []util.BackupFile, error) {
+	container, key, err := util.ParseBucketAndKey(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	containerRef, err := absw.getContainer(ctx, container)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var files []util.BackupFile
+	err = absw.do(ctx, func() error {
+		list, err := util.ListBackupFilesWithPrefix(containerRef, key+"_")
+		if err != nil {
+			return err
+		}
+		files = list
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return containerRef, files, nil
+}
+
+// PruneUncommitted deletes the blobs under path that only consist of uncommitted blocks older than olderThan.
+// Azure would only garbage collect them after a week, while they keep consuming storage without being listed.
+func (absw *absWriter) PruneUncommitted(ctx context.Context, path string, olderThan time.Duration) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, absw.timeout)
+	defer cancel()
+
+	container, key, err := util.ParseBucketAndKey(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse abs container and key: %v", err)
+	}
+	containerRef, err := absw.getContainer(ctx, container)
+	if err != nil {
+		return 0, err
+	}
+
+	var files []util.BackupFile
+	err = absw.do(ctx, func() error {
+		list, err := util.ListUncommittedBlobs(containerRef, key)
+		if err != nil {
+			return err
+		}
+		files = list
+		return nil
+	})
 	if err != nil {
 		return 0, err
 	}
 
-	getBlobOpts := &storage.GetBlobOptions{}
-	_, err = blob.Get(getBlobOpts)
+	cutoff := time.Now().Add(-olderThan)
+	var stale []util.BackupFile
+	for _, f := range files {
+		if f.LastModified.Before(cutoff) {
+			stale = append(stale, f)
+		}
+	}
+	pruned, err := absw.deleteBackupFiles(ctx, containerRef, stale, false)
+	return len(pruned), err
+}
+
+// ArchivePurge copies the stale backup files under path to "<archivePrefix>/<blob name>" in the same container
+// with server-side copies and then deletes them. Temporary blobs of interrupted saves are deleted, not archived.
+func (absw *absWriter) ArchivePurge(ctx context.Context, path string, maxBackups int, archivePrefix string, dryRun bool) ([]string, error) {
+	if maxBackups <= 0 {
+		return nil, util.ErrInvalidMaxBackups
+	}
+	ctx, cancel := context.WithTimeout(ctx, absw.timeout)
+	defer cancel()
+
+	_, key, err := util.ParseBucketAndKey(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse abs container and key: %v", err)
+	}
+	archivePrefix = strings.Trim(archivePrefix, "/")
+	if len(archivePrefix) == 0 || strings.HasPrefix(archiveName(archivePrefix, key), key+"_") {
+		return nil, fmt.Errorf("invalid archive prefix (%v): archived backups must not be listed under %v", archivePrefix, path)
+	}
+
+	containerRef, files, err := absw.listBackupFiles(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	complete, tmp := util.SplitTmpFiles(files)
+	if _, err := absw.deleteBackupFiles(ctx, containerRef, util.StaleTmpFiles(tmp, time.Now().Add(-util.TmpFileMaxAge)), dryRun); err != nil {
+		return nil, err
+	}
+
+	stale := util.StaleBackupFilesByCount(complete, maxBackups)
+	names := make([]string, 0, len(stale))
+	paths := make([]string, 0, len(stale))
+	for _, f := range stale {
+		names = append(names, f.Name)
+		paths = append(paths, containerRef.Name+"/"+archiveName(archivePrefix, f.Name))
+	}
+	if dryRun {
+		return paths, nil
+	}
+	err = util.DeleteConcurrently(ctx, names, absw.purgeWorkers, func(name string) error {
+		blob := containerRef.GetBlobReference(name)
+		err := absw.do(ctx, func() error {
+			return containerRef.GetBlobReference(archiveName(archivePrefix, name)).Copy(blob.GetURL(), &storage.CopyOptions{})
+		})
+		if err != nil {
+			return err
+		}
+		err = absw.do(ctx, func() error {
+			return blob.Delete(&storage.DeleteBlobOptions{})
+		})
+		observeDelete(backendABS, err)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := absw.removeFromIndexes(ctx, containerRef, names); err != nil {
+		return nil, fmt.Errorf("backups archived but failed to update backup index: %v", err)
+	}
+	return paths, nil
+}
+
+// Rename moves the blob on oldPath to newPath and moves its entry of the backup index of its backup path, if any,
+// to the backup index of the new backup path. Deduplicated backups can only be moved within their container,
+// since their chunks are not moved along with them.
+func (absw *absWriter) Rename(ctx context.Context, oldPath, newPath string) error {
+	ctx, cancel := context.WithTimeout(ctx, absw.timeout)
+	defer cancel()
+
+	srcRef, srcKey, dstRef, dstKey, err := absw.getMoveContainers(ctx, oldPath, newPath)
+	if err != nil {
+		return err
+	}
+	if err := absw.move(ctx, srcRef, srcKey, dstRef, dstKey); err != nil {
+		return err
+	}
+	return absw.moveIndexEntries(ctx, srcRef, dstRef, map[string]string{srcKey: dstKey})
+}
+
+// MigratePrefix moves the blobs under oldPrefix concurrently like Rename. Temporary blobs of backups being saved
+// are left behind, and so are the latest backup pointers and backup indexes, whose entries are moved instead.
+// If some blobs fail to move, the others are still moved and an error listing the failed ones is returned.
+func (absw *absWriter) MigratePrefix(ctx context.Context, oldPrefix, newPrefix string) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, absw.timeout)
+	defer cancel()
+
+	srcRef, srcKey, dstRef, dstKey, err := absw.getMoveContainers(ctx, oldPrefix, newPrefix)
 	if err != nil {
 		return 0, err
 	}
+	var blobs []storage.Blob
+	err = absw.do(ctx, func() error {
+		list, err := util.ListWithPrefix(srcRef, srcKey, "")
+		if err != nil {
+			return err
+		}
+		blobs = list
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	names := make([]string, 0, len(blobs))
+	for _, blob := range blobs {
+		if !util.IsTmpFile(blob.Name) && !strings.HasSuffix(blob.Name, util.LatestPointerSuffix) && !strings.HasSuffix(blob.Name, util.IndexSuffix) {
+			names = append(names, blob.Name)
+		}
+	}
 
-	return blob.Properties.ContentLength, nil
+	var mu sync.Mutex
+	moved := make(map[string]string, len(names))
+	err = util.DeleteConcurrently(ctx, names, absw.purgeWorkers, func(name string) error {
+		newName := dstKey + strings.TrimPrefix(name, srcKey)
+		if err := absw.move(ctx, srcRef, name, dstRef, newName); err != nil {
+			return err
+		}
+		mu.Lock()
+		moved[name] = newName
+		mu.Unlock()
+		return nil
+	})
+	if ierr := absw.moveIndexEntries(ctx, srcRef, dstRef, moved); ierr != nil && err == nil {
+		err = fmt.Errorf("backups moved but failed to update backup index: %v", ierr)
+	}
+	return len(moved), err
 }
 
-func (absw *absWriter) Purge(path string, maxBackups int) error {
+// Undelete restores the soft deleted blob on path with util.UndeleteBlob, and records it again
+// in the backup index and the latest backup pointer of its backup path, if any.
+// The pointer is only moved to the restored backup if it is of a higher revision than the recorded one.
+func (absw *absWriter) Undelete(ctx context.Context, path string) error {
+	ctx, cancel := context.WithTimeout(ctx, absw.timeout)
+	defer cancel()
+
 	container, key, err := util.ParseBucketAndKey(path)
 	if err != nil {
 		return err
 	}
+	containerRef, err := absw.getContainer(ctx, container)
+	if err != nil {
+		return err
+	}
+	blob := containerRef.GetBlobReference(key)
+	err = absw.do(ctx, func() error {
+		return util.UndeleteBlob(ctx, blob)
+	})
+	if err != nil {
+		return err
+	}
+
+	backupPath, ok := util.BackupPathOf(key)
+	if !ok {
+		return nil
+	}
+	err = absw.do(ctx, func() error {
+		if err := blob.GetProperties(&storage.GetBlobPropertiesOptions{}); err != nil {
+			return err
+		}
+		return blob.GetMetadata(&storage.GetBlobMetadataOptions{})
+	})
+	if err != nil {
+		return fmt.Errorf("backup undeleted but failed to get its properties: %v", err)
+	}
+	entry := util.BackupIndexEntry{Name: key, SHA256: blob.Metadata[util.MetadataSHA256], Size: blob.Properties.ContentLength}
+	if err = absw.updateIndex(ctx, containerRef, backupPath, func(idx *util.BackupIndex) { idx.Add(entry) }); err != nil {
+		return fmt.Errorf("backup undeleted but failed to update backup index: %v", err)
+	}
+	return absw.updateLatestPointer(ctx, containerRef, key)
+}
 
-	containerRef, err := absw.getContainer(container)
+// SetTier sets the access tier of the blob on path with util.SetBlobTier.
+// Deduplicated backups are refused, since their chunks are shared with other backups.
+func (absw *absWriter) SetTier(ctx context.Context, path string, tier util.AccessTier) error {
+	if err := util.ValidateAccessTier(tier); err != nil {
+		return err
+	}
+	container, key, err := util.ParseBucketAndKey(path)
 	if err != nil {
 		return err
 	}
+	if strings.HasSuffix(key, util.ManifestSuffix) {
+		return fmt.Errorf("can't set the access tier of deduplicated backup %s", key)
+	}
+	containerRef, err := absw.getContainer(ctx, container)
+	if err != nil {
+		return err
+	}
+	blob := containerRef.GetBlobReference(key)
+	return absw.do(ctx, func() error {
+		return util.SetBlobTier(ctx, blob, tier)
+	})
+}
 
-	params := storage.ListBlobsParameters{Prefix: fmt.Sprintf("%s_", key)}
-	resp, err := containerRef.ListBlobs(params)
+// AppendWAL appends the WAL data read from r to the append blob "<key>.wal/<segmentID>" of the backup path
+// "<abs-container-name>/<key>", in blocks of at most AzureAppendBlockLimitInBytes. WAL data is appended as is,
+// neither compressed nor encrypted, so that a segment reads back as the concatenation of its appends,
+// and AppendWAL fails if the writer encrypts backups. Appends are not retried, since an append which timed out
+// may still have been applied, and the storage SDK in use cannot make appends conditional on the blob size.
+func (absw *absWriter) AppendWAL(ctx context.Context, path, segmentID string, r io.Reader) error {
+	if len(absw.encryptionKey) != 0 {
+		return fmt.Errorf("failed to append WAL segment %s: WAL segments cannot be encrypted", segmentID)
+	}
+	if len(segmentID) == 0 || strings.Contains(segmentID, "/") {
+		return fmt.Errorf("invalid WAL segment ID (%s): must be non-empty and contain no \"/\"", segmentID)
+	}
+	container, key, err := util.ParseBucketAndKey(path)
 	if err != nil {
 		return err
 	}
+	containerRef, err := absw.getContainer(ctx, container)
+	if err != nil {
+		return err
+	}
+
+	blob := containerRef.GetBlobReference(key + util.WALSuffix + "/" + segmentID)
+	err = absw.do(ctx, func() error {
+		err := blob.PutAppendBlob(&storage.PutBlobOptions{IfNoneMatch: "*"})
+		if util.HasStatusCode(err, http.StatusConflict) || util.HasStatusCode(err, http.StatusPreconditionFailed) {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create WAL segment %s: %v", segmentID, err)
+	}
+	_, err = forEachBlock(r, AzureAppendBlockLimitInBytes, func(chunk []byte) error {
+		ctx, cancel := context.WithTimeout(ctx, absw.timeout)
+		defer cancel()
+		// The zero RetryPolicy tries the append once.
+		return util.WrapStorageError(util.RetryPolicy{}.Do(ctx, func() error {
+			return blob.AppendBlock(chunk, &storage.AppendBlockOptions{})
+		}))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to append to WAL segment %s: %v", segmentID, err)
+	}
+	return nil
+}
+
+// ListWALSegments returns the IDs of the WAL segments appended to the backup path "<abs-container-name>/<key>",
+// in the order of their names like the listing of blobs. The segments can be read back with the
+// OpenRaw of the abs reader, on "<abs-container-name>/<key>.wal/<segment-id>".
+func (absw *absWriter) ListWALSegments(ctx context.Context, path string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, absw.timeout)
+	defer cancel()
+
+	container, key, err := util.ParseBucketAndKey(path)
+	if err != nil {
+		return nil, err
+	}
+	containerRef, err := absw.getContainer(ctx, container)
+	if err != nil {
+		return nil, err
+	}
+	prefix := key + util.WALSuffix + "/"
+	var blobs []storage.Blob
+	err = absw.do(ctx, func() error {
+		list, err := util.ListWithPrefix(containerRef, prefix, "")
+		if err != nil {
+			return err
+		}
+		blobs = list
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	segments := make([]string, 0, len(blobs))
+	for _, blob := range blobs {
+		segments = append(segments, strings.TrimPrefix(blob.Name, prefix))
+	}
+	return segments, nil
+}
+
+// getMoveContainers returns the containers and keys of the source and destination paths of a move.
+func (absw *absWriter) getMoveContainers(ctx context.Context, src, dst string) (*storage.Container, string, *storage.Container, string, error) {
+	srcContainer, srcKey, err := util.ParseBucketAndKey(src)
+	if err != nil {
+		return nil, "", nil, "", err
+	}
+	dstContainer, dstKey, err := util.ParseBucketAndKey(dst)
+	if err != nil {
+		return nil, "", nil, "", err
+	}
+	srcRef, err := absw.getContainer(ctx, srcContainer)
+	if err != nil {
+		return nil, "", nil, "", err
+	}
+	dstRef := srcRef
+	if dstContainer != srcContainer {
+		if dstRef, err = absw.getContainer(ctx, dstContainer); err != nil {
+			return nil, "", nil, "", err
+		}
+	}
+	return srcRef, srcKey, dstRef, dstKey, nil
+}
+
+// move copies the blob srcKey of srcRef to dstKey of dstRef server side, keeping its properties and metadata,
+// and then deletes it.
+func (absw *absWriter) move(ctx context.Context, srcRef *storage.Container, srcKey string, dstRef *storage.Container, dstKey string) error {
+	if strings.HasSuffix(srcKey, util.ManifestSuffix) && srcRef.Name != dstRef.Name {
+		return fmt.Errorf("can't move deduplicated backup %s to another container", srcKey)
+	}
+	src, dst := srcRef.GetBlobReference(srcKey), dstRef.GetBlobReference(dstKey)
+	err := absw.do(ctx, func() error {
+		return dst.Copy(src.GetURL(), &storage.CopyOptions{})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to copy %s: %v", srcKey, err)
+	}
+	err = absw.do(ctx, func() error {
+		return src.Delete(&storage.DeleteBlobOptions{})
+	})
+	if err != nil {
+		return fmt.Errorf("copied %s but failed to delete it: %v", srcKey, err)
+	}
+	return nil
+}
 
-	blobNames := []string{}
-	for _, blob := range resp.Blobs {
-		blobNames = append(blobNames, (blob.Name))
+// moveIndexEntries moves the backup index entries of the moved blobs, which moved maps from their old names
+// in srcRef to their new names in dstRef.
+func (absw *absWriter) moveIndexEntries(ctx context.Context, srcRef, dstRef *storage.Container, moved map[string]string) error {
+	byPath := make(map[string][]string)
+	for name := range moved {
+		if backupPath, ok := util.BackupPathOf(name); ok {
+			byPath[backupPath] = append(byPath[backupPath], name)
+		}
 	}
+	added := make(map[string][]util.BackupIndexEntry)
+	for backupPath, names := range byPath {
+		var entries []util.BackupIndexEntry
+		err := absw.updateIndex(ctx, srcRef, backupPath, func(idx *util.BackupIndex) {
+			// fn is applied again if the index changed in the meantime, so only the entries of the last try are kept.
+			entries = nil
+			for _, name := range names {
+				for _, e := range idx.Backups {
+					if e.Name == name {
+						e.Name = moved[name]
+						entries = append(entries, e)
+					}
+				}
+			}
+			idx.Remove(names...)
+		})
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if newPath, ok := util.BackupPathOf(e.Name); ok {
+				added[newPath] = append(added[newPath], e)
+			}
+		}
+	}
+	for backupPath, entries := range added {
+		err := absw.updateIndex(ctx, dstRef, backupPath, func(idx *util.BackupIndex) {
+			for _, e := range entries {
+				idx.Add(e)
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// archiveName returns the name the blob of the given name is archived under.
+func archiveName(archivePrefix, name string) string {
+	return archivePrefix + "/" + name
+}
+
+// deleteBackupFiles deletes the given backup files concurrently, unless dryRun is true,
+// and returns their paths in the format "<abs-container-name>/<key>".
+// At most absw.purgeWorkers backup files are deleted at a time.
+func (absw *absWriter) deleteBackupFiles(ctx context.Context, containerRef *storage.Container, files []util.BackupFile, dryRun bool) ([]string, error) {
+	names := make([]string, 0, len(files))
+	paths := make([]string, 0, len(files))
+	for _, f := range files {
+		names = append(names, f.Name)
+		paths = append(paths, containerRef.Name+"/"+f.Name)
+	}
+	if dryRun {
+		return paths, nil
+	}
+	err := util.DeleteConcurrently(ctx, names, absw.purgeWorkers, func(name string) error {
+		err := absw.do(ctx, func() error {
+			return containerRef.GetBlobReference(name).Delete(&storage.DeleteBlobOptions{})
+		})
+		observeDelete(backendABS, err)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := absw.removeFromIndexes(ctx, containerRef, names); err != nil {
+		return nil, fmt.Errorf("backups deleted but failed to update backup index: %v", err)
+	}
+	return paths, nil
+}
 
-	// we can just use string comparison
-	sort.Strings(blobNames)
-	for i := 0; i < len(blobNames)-maxBackups; i++ {
-		blob := containerRef.GetBlobReference(blobNames[i])
-		err = blob.Delete(&storage.DeleteBlobOptions{})
+// removeFromIndexes removes the deleted backups of the given names from the indexes of their backup paths.
+func (absw *absWriter) removeFromIndexes(ctx context.Context, containerRef *storage.Container, names []string) error {
+	byPath := make(map[string][]string)
+	for _, name := range names {
+		if backupPath, ok := util.BackupPathOf(name); ok && !util.IsTmpFile(name) {
+			byPath[backupPath] = append(byPath[backupPath], name)
+		}
+	}
+	for backupPath, names := range byPath {
+		err := absw.updateIndex(ctx, containerRef, backupPath, func(idx *util.BackupIndex) { idx.Remove(names...) })
 		if err != nil {
 			return err
 		}
@@ -0,0 +1,1283 @@
+// Copyright 2018 The etcd-operator Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package writer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/storage"
+	"github.com/coreos/etcd-operator/pkg/backup/reader"
+	"github.com/coreos/etcd-operator/pkg/backup/util"
+	"github.com/pborman/uuid"
+)
+
+func TestForEachBlock(t *testing.T) {
+	data := make([]byte, 10*1024+17)
+	rand.Read(data)
+
+	blocks := 0
+	got := new(bytes.Buffer)
+	size, err := forEachBlock(bytes.NewReader(data), 1024, func(chunk []byte) error {
+		blocks++
+		got.Write(chunk)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blocks != 11 {
+		t.Errorf("expect 11 blocks, get=%d", blocks)
+	}
+	if size != int64(len(data)) {
+		t.Errorf("expect size=%d, get=%d", len(data), size)
+	}
+	if !bytes.Equal(got.Bytes(), data) {
+		t.Errorf("reassembled blocks do not match the original content")
+	}
+}
+
+// erraticReader returns the content of r in reads of random sizes, including empty ones,
+// like a stream of unknown length.
+type erraticReader struct {
+	r   io.Reader
+	rnd *rand.Rand
+}
+
+func (er *erraticReader) Read(p []byte) (int, error) {
+	n := er.rnd.Intn(3000)
+	if n > len(p) {
+		n = len(p)
+	}
+	return er.r.Read(p[:n])
+}
+
+func TestForEachBlockErraticReader(t *testing.T) {
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	data := make([]byte, 50*1024+rnd.Intn(10*1024))
+	rnd.Read(data)
+
+	got := new(bytes.Buffer)
+	size, err := forEachBlock(&erraticReader{r: bytes.NewReader(data), rnd: rnd}, 1024, func(chunk []byte) error {
+		if len(chunk) != 1024 && got.Len()+len(chunk) != len(data) {
+			t.Errorf("expect only the last block to be short, get a block of %d bytes at offset %d", len(chunk), got.Len())
+		}
+		got.Write(chunk)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != int64(len(data)) || !bytes.Equal(got.Bytes(), data) {
+		t.Errorf("expect %d bytes staged as read, get size=%d", len(data), size)
+	}
+}
+
+func TestForEachBlockConcurrently(t *testing.T) {
+	data := make([]byte, 64*1024+17)
+	rand.Read(data)
+
+	const parallelism = 4
+	var (
+		mu        sync.Mutex
+		active    int
+		maxActive int
+	)
+	chunks := map[int][]byte{}
+	size, err := forEachBlockConcurrently(bytes.NewReader(data), 1024, parallelism, func(i int, chunk []byte) error {
+		mu.Lock()
+		active++
+		if active > maxActive {
+			maxActive = active
+		}
+		chunks[i] = append([]byte(nil), chunk...)
+		mu.Unlock()
+		time.Sleep(time.Millisecond)
+		mu.Lock()
+		active--
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != int64(len(data)) {
+		t.Errorf("expect size=%d, get=%d", len(data), size)
+	}
+	got := new(bytes.Buffer)
+	for i := 0; i < len(chunks); i++ {
+		got.Write(chunks[i])
+	}
+	if len(chunks) != 65 || !bytes.Equal(got.Bytes(), data) {
+		t.Errorf("expect 65 blocks reassembled in order into the original content, get %d blocks", len(chunks))
+	}
+	if maxActive > parallelism {
+		t.Errorf("expect at most %d blocks staged concurrently, get=%d", parallelism, maxActive)
+	}
+}
+
+func TestForEachBlockConcurrentlyError(t *testing.T) {
+	data := make([]byte, 64*1024)
+	errStage := errors.New("failed to stage block")
+	var mu sync.Mutex
+	staged := 0
+	_, err := forEachBlockConcurrently(bytes.NewReader(data), 1024, 4, func(i int, chunk []byte) error {
+		mu.Lock()
+		staged++
+		mu.Unlock()
+		if i == 2 {
+			return errStage
+		}
+		time.Sleep(time.Millisecond)
+		return nil
+	})
+	if err != errStage {
+		t.Errorf("expect error=%v, get=%v", errStage, err)
+	}
+	// Blocks already read may still be staged, but reading stops soon after the failure.
+	if staged >= 64 {
+		t.Errorf("expect staging to stop after the failure, get %d blocks staged", staged)
+	}
+}
+
+// stuckReader never returns any data nor error.
+type stuckReader struct{}
+
+func (stuckReader) Read(p []byte) (int, error) { return 0, nil }
+
+func TestForEachBlockStuckReader(t *testing.T) {
+	_, err := forEachBlock(stuckReader{}, 1024, func(chunk []byte) error { return nil })
+	if err != io.ErrNoProgress {
+		t.Errorf("expect error=%v, get=%v", io.ErrNoProgress, err)
+	}
+}
+
+func TestBackupMetadata(t *testing.T) {
+	m := backupMetadata("etcd.backup_"+util.MakeBackupName("3.2.13", 26), "example-etcd-cluster")
+	expected := storage.BlobMetadata{
+		util.MetadataEtcdVersion:  "3.2.13",
+		util.MetadataEtcdRevision: "26",
+		util.MetadataClusterName:  "example-etcd-cluster",
+	}
+	if !reflect.DeepEqual(m, expected) {
+		t.Errorf("expect metadata=%v, get=%v", expected, m)
+	}
+
+	if m := backupMetadata("etcd.backup", ""); len(m) != 0 {
+		t.Errorf("expect no metadata for a backup name without version and revision, get=%v", m)
+	}
+}
+
+// newTestABSClient returns an ABS client of the storage account given by the
+// TEST_AZURE_STORAGE_ACCOUNT and TEST_AZURE_STORAGE_KEY environment variables,
+// and skips the test if they are not set.
+func newTestABSClient(t *testing.T) *storage.BlobStorageClient {
+	account, key := os.Getenv("TEST_AZURE_STORAGE_ACCOUNT"), os.Getenv("TEST_AZURE_STORAGE_KEY")
+	if len(account) == 0 || len(key) == 0 {
+		t.Skip("TEST_AZURE_STORAGE_ACCOUNT and TEST_AZURE_STORAGE_KEY are not set")
+	}
+	cli, err := storage.NewBasicClient(account, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	abs := cli.GetBlobService()
+	return &abs
+}
+
+// newTestContainer creates a container for the test and returns its name and a cleanup function.
+func newTestContainer(t *testing.T, abs *storage.BlobStorageClient) (string, func()) {
+	name := "etcd-operator-test-" + strings.ToLower(uuid.New())[:8]
+	containerRef := abs.GetContainerReference(name)
+	if err := containerRef.Create(&storage.CreateContainerOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	return name, func() { containerRef.Delete(&storage.DeleteContainerOptions{}) }
+}
+
+func TestABSWriterCopyTo(t *testing.T) {
+	abs := newTestABSClient(t)
+	src, cleanupSrc := newTestContainer(t, abs)
+	defer cleanupSrc()
+	dest, cleanupDest := newTestContainer(t, abs)
+	defer cleanupDest()
+
+	w := NewABSWriter(abs, false, nil, "", 0, 0).(*absWriter)
+	data := []byte("backup")
+	path := src + "/etcd.backup_" + util.MakeBackupName("3.2.13", 1)
+	if _, err := w.Write(context.Background(), path, bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.CopyTo(context.Background(), path, abs, dest); err != nil {
+		t.Fatal(err)
+	}
+
+	blob := abs.GetContainerReference(dest).GetBlobReference("etcd.backup_" + util.MakeBackupName("3.2.13", 1))
+	if err := blob.GetMetadata(&storage.GetBlobMetadataOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(blob.Metadata[util.MetadataSHA256]) == 0 {
+		t.Errorf("expect checksum to be copied along with the backup")
+	}
+	rc, err := blob.Get(&storage.GetBlobOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	got, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("expect copied content=%q, get=%q", data, got)
+	}
+}
+
+func TestABSWriterProperties(t *testing.T) {
+	abs := newTestABSClient(t)
+	container, cleanup := newTestContainer(t, abs)
+	defer cleanup()
+
+	w := NewABSWriter(abs, false, nil, "example-etcd-cluster", 0, 0)
+	key := "etcd.backup_" + util.MakeBackupName("3.2.13", 26)
+	if _, err := w.Write(context.Background(), container+"/"+key, bytes.NewReader([]byte("backup"))); err != nil {
+		t.Fatal(err)
+	}
+
+	blob := abs.GetContainerReference(container).GetBlobReference(key)
+	if err := blob.GetProperties(&storage.GetBlobPropertiesOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if blob.Properties.ContentType != util.BackupContentType {
+		t.Errorf("expect content type=%s, get=%s", util.BackupContentType, blob.Properties.ContentType)
+	}
+	if len(blob.Properties.ContentMD5) == 0 {
+		t.Errorf("expect content MD5 to be set")
+	}
+	if err := blob.GetMetadata(&storage.GetBlobMetadataOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range map[string]string{
+		util.MetadataEtcdVersion:  "3.2.13",
+		util.MetadataEtcdRevision: "26",
+		util.MetadataClusterName:  "example-etcd-cluster",
+	} {
+		if blob.Metadata[k] != v {
+			t.Errorf("expect metadata %s=%s, get=%s", k, v, blob.Metadata[k])
+		}
+	}
+}
+
+func TestABSWriterPruneUncommitted(t *testing.T) {
+	abs := newTestABSClient(t)
+	container, cleanup := newTestContainer(t, abs)
+	defer cleanup()
+
+	w := NewABSWriter(abs, false, nil, "", 0, 0).(*absWriter)
+	key := "etcd.backup_" + util.MakeBackupName("3.2.13", 1)
+	if _, err := w.Write(context.Background(), container+"/"+key, bytes.NewReader([]byte("backup"))); err != nil {
+		t.Fatal(err)
+	}
+	// Stage a block of another backup without ever committing it, like an interrupted upload.
+	blob := abs.GetContainerReference(container).GetBlobReference("etcd.backup_" + util.MakeBackupName("3.2.13", 2))
+	if err := blob.PutBlock("YmxvY2s=", []byte("block"), &storage.PutBlockOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := w.PruneUncommitted(context.Background(), container+"/etcd.backup", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("expect 1 uncommitted blob to be pruned, get=%d", n)
+	}
+	files, err := util.ListUncommittedBlobs(abs.GetContainerReference(container), "etcd.backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("expect no uncommitted blobs left, get=%v", files)
+	}
+	if exists, err := abs.GetContainerReference(container).GetBlobReference(key).Exists(); err != nil || !exists {
+		t.Errorf("expect committed backup to be kept, exists=%v err=%v", exists, err)
+	}
+}
+
+func TestABSWriterCreateContainer(t *testing.T) {
+	abs := newTestABSClient(t)
+	container := "etcd-operator-test-" + strings.ToLower(uuid.New())[:8]
+	containerRef := abs.GetContainerReference(container)
+	defer containerRef.Delete(&storage.DeleteContainerOptions{})
+
+	path := container + "/etcd.backup"
+	if _, err := NewABSWriter(abs, false, nil, "", 0, 0).Write(context.Background(), path, bytes.NewReader([]byte("backup"))); !util.IsContainerNotFound(err) {
+		t.Fatalf("expect container not found error without container creation, get=%v", err)
+	}
+
+	w := NewABSWriterCreate(abs, false, nil, "", 0, 0, storage.ContainerAccessTypePrivate)
+	if _, err := w.Write(context.Background(), path, bytes.NewReader([]byte("backup"))); err != nil {
+		t.Fatal(err)
+	}
+	if exists, err := containerRef.Exists(); err != nil || !exists {
+		t.Errorf("expect container to be created on first use, exists=%v err=%v", exists, err)
+	}
+}
+
+func TestABSWriterArchivePurgeInvalidPrefix(t *testing.T) {
+	w := NewABSWriter(nil, false, nil, "", 0, 0).(*absWriter)
+	for _, prefix := range []string{"", "/", "etcd.backup_archive"} {
+		if _, err := w.ArchivePurge(context.Background(), "mycontainer/etcd.backup", 1, prefix, false); err == nil {
+			t.Errorf("expect error for archive prefix %q", prefix)
+		}
+	}
+}
+
+func TestABSWriterPurgeInvalidMaxBackups(t *testing.T) {
+	w := NewABSWriter(nil, false, nil, "", 0, 0).(*absWriter)
+	for _, n := range []int{0, -1} {
+		if _, err := w.Purge(context.Background(), "mycontainer/etcd.backup", n, false); err != util.ErrInvalidMaxBackups {
+			t.Errorf("expect Purge(%d) error=%v, get=%v", n, util.ErrInvalidMaxBackups, err)
+		}
+		if _, err := w.ArchivePurge(context.Background(), "mycontainer/etcd.backup", n, "archive", false); err != util.ErrInvalidMaxBackups {
+			t.Errorf("expect ArchivePurge(%d) error=%v, get=%v", n, util.ErrInvalidMaxBackups, err)
+		}
+		if _, err := w.PurgeByVersion(context.Background(), "mycontainer/etcd.backup", n, false); err != util.ErrInvalidMaxBackups {
+			t.Errorf("expect PurgeByVersion(%d) error=%v, get=%v", n, util.ErrInvalidMaxBackups, err)
+		}
+	}
+}
+
+func TestABSWriterArchivePurge(t *testing.T) {
+	abs := newTestABSClient(t)
+	container, cleanup := newTestContainer(t, abs)
+	defer cleanup()
+
+	w := NewABSWriter(abs, false, nil, "", 0, 0).(*absWriter)
+	for i := 1; i <= 3; i++ {
+		path := container + "/etcd.backup_" + util.MakeBackupName("3.2.13", int64(i))
+		if _, err := w.Write(context.Background(), path, bytes.NewReader([]byte("backup"))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	archived, err := w.ArchivePurge(context.Background(), container+"/etcd.backup", 1, "archive", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(archived) != 2 {
+		t.Fatalf("expect 2 archived backups, get=%v", archived)
+	}
+
+	containerRef := abs.GetContainerReference(container)
+	files, err := util.ListBackupFiles(containerRef, "etcd.backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Name != "etcd.backup_"+util.MakeBackupName("3.2.13", 3) {
+		t.Errorf("expect only the latest backup to be listed, get=%v", files)
+	}
+	files, err = util.ListBackupFiles(containerRef, "archive/etcd.backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Errorf("expect 2 backups under the archive prefix, get=%v", files)
+	}
+}
+
+func TestABSWriterDeleteAll(t *testing.T) {
+	abs := newTestABSClient(t)
+	container, cleanup := newTestContainer(t, abs)
+	defer cleanup()
+
+	w := NewABSWriter(abs, false, nil, "", 0, 0)
+	for i := 1; i <= 3; i++ {
+		path := container + "/etcd.backup_" + util.MakeBackupName("3.2.13", int64(i))
+		if _, err := w.Write(context.Background(), path, bytes.NewReader([]byte("backup"))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := w.DeleteAll(context.Background(), container+"/etcd.backup", false); err != nil {
+		t.Fatal(err)
+	}
+	files, err := util.ListBackupFiles(abs.GetContainerReference(container), "etcd.backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("expect no backups left, get=%v", files)
+	}
+}
+
+func TestABSDedupWriter(t *testing.T) {
+	abs := newTestABSClient(t)
+	container, cleanup := newTestContainer(t, abs)
+	defer cleanup()
+
+	w := NewABSDedupWriter(abs, "", 0).(*absWriter)
+	w.chunkSizes = util.ChunkSizes{Min: 4 * 1024, Avg: 16 * 1024, Max: 64 * 1024}
+	first := make([]byte, 1024*1024)
+	rand.New(rand.NewSource(1)).Read(first)
+	second := make([]byte, len(first))
+	copy(second, first)
+	copy(second[len(second)/2:], []byte("changed"))
+
+	containerRef := abs.GetContainerReference(container)
+	countChunks := func() int {
+		blobs, err := util.ListWithPrefix(containerRef, util.ChunkPrefix, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(blobs)
+	}
+
+	size, err := w.Write(context.Background(), container+"/etcd.backup_"+util.MakeBackupName("3.2.13", 1), bytes.NewReader(first))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != int64(len(first)) {
+		t.Errorf("expect size of the backup=%d, get=%d", len(first), size)
+	}
+	firstChunks := countChunks()
+
+	if _, err := w.Write(context.Background(), container+"/etcd.backup_"+util.MakeBackupName("3.2.13", 2), bytes.NewReader(second)); err != nil {
+		t.Fatal(err)
+	}
+	if added := countChunks() - firstChunks; added > 2 {
+		t.Errorf("expect the near-identical backup to add at most 2 chunks to the %d ones, get=%d", firstChunks, added)
+	}
+
+	files, err := util.ListBackupFiles(containerRef, "etcd.backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name, util.ManifestSuffix) {
+			t.Errorf("expect backups to be saved as manifests, get=%v", f.Name)
+		}
+	}
+}
+
+func TestABSWriterWriteWithResult(t *testing.T) {
+	abs := newTestABSClient(t)
+	container, cleanup := newTestContainer(t, abs)
+	defer cleanup()
+
+	path := container + "/etcd.backup_" + util.MakeBackupName("3.2.13", 1)
+	res, err := NewABSWriter(abs, true, nil, "", 0, 0).WriteWithResult(context.Background(), path, bytes.NewReader([]byte("backup")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Path != path+util.GzipSuffix {
+		t.Errorf("expect result path=%v, get=%v", path+util.GzipSuffix, res.Path)
+	}
+
+	blob := abs.GetContainerReference(container).GetBlobReference(strings.TrimPrefix(res.Path, container+"/"))
+	if err := blob.GetProperties(&storage.GetBlobPropertiesOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if blob.Properties.ContentLength != res.Size {
+		t.Errorf("expect result size=%d, get=%d", blob.Properties.ContentLength, res.Size)
+	}
+	if err := blob.GetMetadata(&storage.GetBlobMetadataOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if blob.Metadata[util.MetadataSHA256] != res.SHA256 {
+		t.Errorf("expect result checksum=%v, get=%v", blob.Metadata[util.MetadataSHA256], res.SHA256)
+	}
+}
+
+func TestValidateBlockSize(t *testing.T) {
+	for _, n := range []int{1, DefaultBlockSizeInBytes, AzureBlobBlockChunkLimitInBytes} {
+		if err := ValidateBlockSize(n); err != nil {
+			t.Errorf("expect block size %d to be valid, get=%v", n, err)
+		}
+	}
+	for _, n := range []int{-1, 0, AzureBlobBlockChunkLimitInBytes + 1} {
+		if err := ValidateBlockSize(n); err == nil {
+			t.Errorf("expect block size %d to be invalid", n)
+		}
+	}
+	if _, err := NewABSWriter(nil, false, nil, "", 0, -1).Write(context.Background(), "mycontainer/etcd.backup", bytes.NewReader(nil)); err == nil {
+		t.Error("expect write with an invalid block size to fail")
+	}
+}
+
+func TestABSWriterBlockSize(t *testing.T) {
+	abs := newTestABSClient(t)
+	container, cleanup := newTestContainer(t, abs)
+	defer cleanup()
+
+	data := make([]byte, 10*1024+100)
+	rand.New(rand.NewSource(1)).Read(data)
+	path := container + "/etcd.backup_" + util.MakeBackupName("3.2.13", 1)
+	if _, err := NewABSWriter(abs, false, nil, "", 0, 1024).Write(context.Background(), path, bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	blob := abs.GetContainerReference(container).GetBlobReference("etcd.backup_" + util.MakeBackupName("3.2.13", 1))
+	blocks, err := blob.GetBlockList(storage.BlockListTypeCommitted, &storage.GetBlockListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks.CommittedBlocks) != 11 {
+		t.Errorf("expect 11 blocks of at most 1024 bytes, get=%d", len(blocks.CommittedBlocks))
+	}
+	rc, err := blob.Get(&storage.GetBlobOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	got, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("expect the blocks to reassemble the %d bytes of the backup, get %d bytes", len(data), len(got))
+	}
+}
+
+func TestABSWriterTags(t *testing.T) {
+	abs := newTestABSClient(t)
+	container, cleanup := newTestContainer(t, abs)
+	defer cleanup()
+
+	w := NewABSWriter(abs, false, nil, "", 0, 0)
+	path := container + "/etcd.backup"
+	tags := map[int64]map[string]string{
+		1: {"team": "storage", "env": "prod"},
+		2: {"team": "compute"},
+		3: nil,
+	}
+	for rev, backupTags := range tags {
+		ctx := util.WithTags(context.Background(), backupTags)
+		if _, err := w.Write(ctx, path+"_"+util.MakeBackupName("3.2.13", rev), strings.NewReader("backup")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	paths, err := reader.NewABSReader(abs, nil, 0).(reader.ABSTagLister).ListByTag(context.Background(), path, "team", "storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{path + "_" + util.MakeBackupName("3.2.13", 1)}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("expect backups tagged team=storage: %v, get=%v", want, paths)
+	}
+}
+
+func TestABSWriterLatestPointer(t *testing.T) {
+	abs := newTestABSClient(t)
+	container, cleanup := newTestContainer(t, abs)
+	defer cleanup()
+
+	w := NewABSWriter(abs, false, nil, "", 0, 0)
+	path := container + "/etcd.backup"
+	backupKey := func(rev int64) string {
+		return "etcd.backup_" + util.MakeBackupName("3.2.13", rev)
+	}
+	pointer := abs.GetContainerReference(container).GetBlobReference("etcd.backup" + util.LatestPointerSuffix)
+	for _, rev := range []int64{1, 2} {
+		if _, err := w.Write(context.Background(), container+"/"+backupKey(rev), strings.NewReader("backup")); err != nil {
+			t.Fatal(err)
+		}
+		rc, err := pointer.Get(&storage.GetBlobOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		name, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil || string(name) != backupKey(rev) {
+			t.Fatalf("expect pointer to %s, get=%q (err=%v)", backupKey(rev), name, err)
+		}
+	}
+
+	// Latest trusts the pointer instead of listing, so a pointer to an older backup is returned as is.
+	if err := pointer.CreateBlockBlobFromReader(strings.NewReader(backupKey(1)), &storage.PutBlobOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	r := reader.NewABSReader(abs, nil, 0)
+	if latest, err := r.Latest(context.Background(), path); err != nil || latest != container+"/"+backupKey(1) {
+		t.Errorf("expect latest from pointer=%s, get=%s (err=%v)", container+"/"+backupKey(1), latest, err)
+	}
+
+	// A pointer to a deleted backup is stale, Latest falls back to listing.
+	if err := abs.GetContainerReference(container).GetBlobReference(backupKey(1)).Delete(&storage.DeleteBlobOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if latest, err := r.Latest(context.Background(), path); err != nil || latest != container+"/"+backupKey(2) {
+		t.Errorf("expect latest from listing=%s, get=%s (err=%v)", container+"/"+backupKey(2), latest, err)
+	}
+
+	if _, err := w.DeleteAll(context.Background(), path, false); err != nil {
+		t.Fatal(err)
+	}
+	if exists, err := pointer.Exists(); err != nil || exists {
+		t.Errorf("expect pointer deleted along with the backups, get exists=%v (err=%v)", exists, err)
+	}
+}
+
+func TestABSReaderLatestRepairsPointer(t *testing.T) {
+	abs := newTestABSClient(t)
+	container, cleanup := newTestContainer(t, abs)
+	defer cleanup()
+
+	w := NewABSWriter(abs, false, nil, "", 0, 0)
+	backupKey := func(rev int64) string {
+		return "etcd.backup_" + util.MakeBackupName("3.2.13", rev)
+	}
+	for _, rev := range []int64{1, 2} {
+		if _, err := w.Write(context.Background(), container+"/"+backupKey(rev), strings.NewReader("backup")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Purge the pointed-to backup out of band, leaving the pointer dangling.
+	containerRef := abs.GetContainerReference(container)
+	if err := containerRef.GetBlobReference(backupKey(2)).Delete(&storage.DeleteBlobOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	r := reader.NewABSReader(abs, nil, 0)
+	if latest, err := r.Latest(context.Background(), container+"/etcd.backup"); err != nil || latest != container+"/"+backupKey(1) {
+		t.Errorf("expect latest=%s, get=%s (err=%v)", container+"/"+backupKey(1), latest, err)
+	}
+	rc, err := containerRef.GetBlobReference("etcd.backup" + util.LatestPointerSuffix).Get(&storage.GetBlobOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	if name, err := ioutil.ReadAll(rc); err != nil || string(name) != backupKey(1) {
+		t.Errorf("expect pointer repaired to %s, get=%q (err=%v)", backupKey(1), name, err)
+	}
+}
+
+func TestABSWriterLatestPointerConflict(t *testing.T) {
+	abs := newTestABSClient(t)
+	container, cleanup := newTestContainer(t, abs)
+	defer cleanup()
+
+	w := NewABSWriter(abs, false, nil, "", 0, 0).(*absWriter)
+	backupKey := func(rev int64) string {
+		return "etcd.backup_" + util.MakeBackupName("3.2.13", rev)
+	}
+	// Fewer saves than maxConditionalUpdateAttempts, so that no save gives up on the pointer.
+	const saves = maxConditionalUpdateAttempts - 1
+	var wg sync.WaitGroup
+	for rev := int64(1); rev <= saves; rev++ {
+		wg.Add(1)
+		go func(rev int64) {
+			defer wg.Done()
+			if _, err := w.Write(context.Background(), container+"/"+backupKey(rev), strings.NewReader("backup")); err != nil {
+				t.Error(err)
+			}
+		}(rev)
+	}
+	wg.Wait()
+
+	// A save of an older backup finishing last must not move the pointer back.
+	containerRef := abs.GetContainerReference(container)
+	if err := w.updateLatestPointer(context.Background(), containerRef, backupKey(2)); err != nil {
+		t.Fatal(err)
+	}
+	expectPointerLikeListing(t, containerRef, "etcd.backup")
+}
+
+// expectPointerLikeListing checks that the latest backup pointer of backupPath records the backup
+// a listing of the backups finds the latest, as readers do without the pointer.
+func expectPointerLikeListing(t *testing.T, containerRef *storage.Container, backupPath string) {
+	files, err := util.ListBackupFiles(containerRef, backupPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := util.GetLatestBackupNameByDate(files)
+	rc, err := containerRef.GetBlobReference(backupPath + util.LatestPointerSuffix).Get(&storage.GetBlobOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	if name, err := ioutil.ReadAll(rc); err != nil || string(name) != want {
+		t.Errorf("expect pointer to the latest listed backup %s, get=%q (err=%v)", want, name, err)
+	}
+}
+
+func TestABSWriterLatestPointerOrdersByDate(t *testing.T) {
+	abs := newTestABSClient(t)
+	container, cleanup := newTestContainer(t, abs)
+	defer cleanup()
+
+	w := NewABSWriter(abs, false, nil, "", 0, 0)
+	now := time.Now()
+	// A cluster restored from an older snapshot saves backups of lower revisions than before.
+	for _, b := range []struct {
+		t   time.Time
+		rev int64
+	}{{now.Add(-time.Hour), 100}, {now, 5}, {now.Add(-2 * time.Hour), 200}} {
+		key := "etcd.backup_" + util.MakeTimestampedBackupName(b.t, "", "3.2.13", b.rev)
+		if _, err := w.Write(context.Background(), container+"/"+key, strings.NewReader("backup")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	containerRef := abs.GetContainerReference(container)
+	expectPointerLikeListing(t, containerRef, "etcd.backup")
+
+	latest, err := reader.NewABSReader(abs, nil, 0).Latest(context.Background(), container+"/etcd.backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := container + "/etcd.backup_" + util.MakeTimestampedBackupName(now, "", "3.2.13", 5); latest != want {
+		t.Errorf("expect latest=%s, get=%s", want, latest)
+	}
+}
+
+func TestABSWriterBackupIndex(t *testing.T) {
+	abs := newTestABSClient(t)
+	container, cleanup := newTestContainer(t, abs)
+	defer cleanup()
+
+	w := NewABSWriter(abs, false, nil, "", 0, 0)
+	path := container + "/etcd.backup"
+	backupKey := func(rev int64) string {
+		return "etcd.backup_" + util.MakeBackupName("3.2.13", rev)
+	}
+	for rev := int64(1); rev <= 3; rev++ {
+		if _, err := w.Write(context.Background(), container+"/"+backupKey(rev), strings.NewReader("backup")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	v := reader.NewABSReader(abs, nil, 0).(reader.ABSIndexVerifier)
+	if suspects, err := v.VerifyAll(context.Background(), path); err != nil || len(suspects) != 0 {
+		t.Fatalf("expect no suspect backups, get=%v (err=%v)", suspects, err)
+	}
+
+	// A backup deleted out of band is flagged, while the purged backup 1 is removed from the index.
+	if err := abs.GetContainerReference(container).GetBlobReference(backupKey(2)).Delete(&storage.DeleteBlobOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Purge(context.Background(), path, 1, false); err != nil {
+		t.Fatal(err)
+	}
+	suspects, err := v.VerifyAll(context.Background(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{container + "/" + backupKey(2)}; !reflect.DeepEqual(suspects, want) {
+		t.Errorf("expect suspect backups=%v, get=%v", want, suspects)
+	}
+}
+
+func TestABSWriterUnknownLength(t *testing.T) {
+	abs := newTestABSClient(t)
+	container, cleanup := newTestContainer(t, abs)
+	defer cleanup()
+
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	data := make([]byte, 3*1024*1024+rnd.Intn(1024*1024))
+	rnd.Read(data)
+	w := NewABSWriter(abs, false, nil, "", 0, 1024*1024)
+	path := container + "/etcd.backup_" + util.MakeBackupName("3.2.13", 1)
+	size, err := w.Write(context.Background(), path, &erraticReader{r: bytes.NewReader(data), rnd: rnd})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != int64(len(data)) {
+		t.Errorf("expect size=%d, get=%d", len(data), size)
+	}
+
+	rc, err := reader.NewABSReader(abs, nil, 0).Open(context.Background(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	if got, err := ioutil.ReadAll(rc); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("expect the uploaded content to be the source content (err=%v)", err)
+	}
+}
+
+func TestABSWriterRename(t *testing.T) {
+	abs := newTestABSClient(t)
+	container, cleanup := newTestContainer(t, abs)
+	defer cleanup()
+
+	w := NewABSWriter(abs, false, nil, "", 0, 0).(*absWriter)
+	oldPath := container + "/etcd.backup_" + util.MakeBackupName("3.2.13", 1)
+	newPath := container + "/renamed/etcd.backup_" + util.MakeBackupName("3.2.13", 1)
+	if _, err := w.Write(context.Background(), oldPath, bytes.NewReader([]byte("backup"))); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Rename(context.Background(), oldPath, newPath); err != nil {
+		t.Fatal(err)
+	}
+
+	r := reader.NewABSReader(abs, nil, 0)
+	rc, err := r.Open(context.Background(), newPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	if got, err := ioutil.ReadAll(rc); err != nil || string(got) != "backup" {
+		t.Errorf("expect the renamed backup to be readable, get=%q (err=%v)", got, err)
+	}
+	_, oldKey, _ := util.ParseBucketAndKey(oldPath)
+	if exists, err := abs.GetContainerReference(container).GetBlobReference(oldKey).Exists(); err != nil || exists {
+		t.Errorf("expect the old backup to be gone, exists=%v (err=%v)", exists, err)
+	}
+
+	n, err := w.MigratePrefix(context.Background(), container+"/renamed/", container+"/migrated/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("expect 1 migrated backup, get=%d", n)
+	}
+	files, err := util.ListBackupFiles(abs.GetContainerReference(container), "migrated/etcd.backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("expect 1 backup under the new prefix, get=%v", files)
+	}
+}
+
+func TestABSWriterUndelete(t *testing.T) {
+	abs := newTestABSClient(t)
+	container, cleanup := newTestContainer(t, abs)
+	defer cleanup()
+
+	w := NewABSWriter(abs, false, nil, "", 0, 0).(*absWriter)
+	backupKey := func(rev int64) string {
+		return "etcd.backup_" + util.MakeBackupName("3.2.13", rev)
+	}
+	for _, rev := range []int64{1, 2} {
+		if _, err := w.Write(context.Background(), container+"/"+backupKey(rev), strings.NewReader("backup")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := w.Purge(context.Background(), container+"/etcd.backup", 1, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// The soft deleted backup is not listed.
+	containerRef := abs.GetContainerReference(container)
+	files, err := util.ListBackupFiles(containerRef, "etcd.backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Name != backupKey(2) {
+		t.Fatalf("expect only %s listed, get=%v", backupKey(2), files)
+	}
+
+	err = w.Undelete(context.Background(), container+"/"+backupKey(1))
+	if util.HasStatusCode(err, http.StatusNotFound) {
+		t.Skip("soft delete is not enabled on the test storage account")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files, err = util.ListBackupFiles(containerRef, "etcd.backup"); err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Errorf("expect the purged backup to be restored, get=%v", files)
+	}
+	rc, err := reader.NewABSReader(abs, nil, 0).Open(context.Background(), container+"/"+backupKey(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	if data, err := ioutil.ReadAll(rc); err != nil || string(data) != "backup" {
+		t.Errorf("expect the restored backup to be readable, get=%q (err=%v)", data, err)
+	}
+}
+
+func TestABSWriterSetTier(t *testing.T) {
+	abs := newTestABSClient(t)
+	container, cleanup := newTestContainer(t, abs)
+	defer cleanup()
+
+	w := NewABSWriterFromConfig(abs, ABSWriterConfig{Tier: util.AccessTierCool}).(*absWriter)
+	path := container + "/etcd.backup_" + util.MakeBackupName("3.2.13", 1)
+	_, err := w.Write(context.Background(), path, strings.NewReader("backup"))
+	if util.HasStatusCode(err, http.StatusBadRequest) {
+		t.Skip("blob tiering is not supported by the test storage account")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := reader.NewABSReader(abs, nil, 0)
+	rc, err := r.Open(context.Background(), path)
+	if err != nil {
+		t.Fatalf("expect a cool backup to be readable, get=%v", err)
+	}
+	rc.Close()
+
+	if err = w.SetTier(context.Background(), path, "Frozen"); err == nil {
+		t.Error("expect an invalid tier to be refused")
+	}
+	if err = w.SetTier(context.Background(), path, util.AccessTierArchive); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = r.Open(context.Background(), path); !util.IsBlobArchived(err) {
+		t.Errorf("expect opening an archived backup to fail with cause %v, get=%v", util.ErrBlobArchived, err)
+	}
+	// Moving the backup back to the Hot tier starts its rehydration, during which it still can't be read.
+	if err = w.SetTier(context.Background(), path, util.AccessTierHot); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestABSWriterQuota(t *testing.T) {
+	abs := newTestABSClient(t)
+	container, cleanup := newTestContainer(t, abs)
+	defer cleanup()
+
+	w := NewABSWriter(abs, false, nil, "", 0, 0)
+	backupPath := func(rev int64) string {
+		return container + "/etcd.backup_" + util.MakeBackupName("3.2.13", rev)
+	}
+	ctx := util.WithQuota(context.Background(), 15)
+	if _, err := w.Write(ctx, backupPath(1), strings.NewReader("0123456789")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(ctx, backupPath(2), strings.NewReader("0123456789")); !util.IsQuotaExceeded(err) {
+		t.Fatalf("expect error with cause %v, get=%v", util.ErrQuotaExceeded, err)
+	}
+
+	// The rejected save staged nothing, not even an uncommitted temporary blob.
+	containerRef := abs.GetContainerReference(container)
+	uncommitted, err := util.ListUncommittedBlobs(containerRef, "etcd.backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(uncommitted) != 0 {
+		t.Errorf("expect no uncommitted blobs, get=%v", uncommitted)
+	}
+	files, err := util.ListBackupFiles(containerRef, "etcd.backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("expect only the first backup saved, get=%v", files)
+	}
+
+	// Sources of unknown length are not limited.
+	pr, pw := io.Pipe()
+	go func() {
+		pw.Write([]byte("0123456789"))
+		pw.Close()
+	}()
+	if _, err := w.Write(ctx, backupPath(3), pr); err != nil {
+		t.Errorf("expect a source of unknown length to be saved, get=%v", err)
+	}
+}
+
+func TestABSWriterAppendWAL(t *testing.T) {
+	abs := newTestABSClient(t)
+	container, cleanup := newTestContainer(t, abs)
+	defer cleanup()
+
+	w := NewABSWriter(abs, true, nil, "", 0, 0).(*absWriter)
+	path := container + "/etcd.backup"
+	segment := "0000000000000000-0000000000000000.wal"
+	for _, chunk := range []string{"first chunk,", "second chunk"} {
+		if err := w.AppendWAL(context.Background(), path, segment, strings.NewReader(chunk)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.AppendWAL(context.Background(), path, "0000000000000001-0000000000000010.wal", strings.NewReader("next")); err != nil {
+		t.Fatal(err)
+	}
+
+	segments, err := w.ListWALSegments(context.Background(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{segment, "0000000000000001-0000000000000010.wal"}; !reflect.DeepEqual(segments, want) {
+		t.Errorf("expect segments=%v, get=%v", want, segments)
+	}
+	// WAL segments are not listed as backups.
+	files, err := util.ListBackupFiles(abs.GetContainerReference(container), "etcd.backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("expect no backups listed, get=%v", files)
+	}
+
+	// Appends read back concatenated in order, not compressed.
+	rc, err := abs.GetContainerReference(container).GetBlobReference("etcd.backup" + util.WALSuffix + "/" + segment).Get(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	data, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "first chunk,second chunk" {
+		t.Errorf("expect WAL data=%q, get=%q", "first chunk,second chunk", data)
+	}
+
+	ew := NewABSWriter(abs, false, make([]byte, 32), "", 0, 0).(*absWriter)
+	if err := ew.AppendWAL(context.Background(), path, segment, strings.NewReader("data")); err == nil {
+		t.Error("expect appending WAL data with encryption to fail")
+	}
+}
+
+func TestABSWriterClustersSharingContainer(t *testing.T) {
+	abs := newTestABSClient(t)
+	container, cleanup := newTestContainer(t, abs)
+	defer cleanup()
+
+	w := NewABSWriter(abs, false, nil, "", 0, 0)
+	for _, key := range []string{"cluster-a/etcd.backup", "cluster-b/etcd.backup"} {
+		for _, rev := range []int64{1, 2} {
+			if _, err := w.Write(context.Background(), container+"/"+key+"_"+util.MakeBackupName("3.2.13", rev), strings.NewReader("backup")); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	purged, err := w.Purge(context.Background(), container+"/cluster-a/etcd.backup", 1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{container + "/cluster-a/etcd.backup_" + util.MakeBackupName("3.2.13", 1)}; !reflect.DeepEqual(purged, want) {
+		t.Errorf("expect purged=%v, get=%v", want, purged)
+	}
+
+	// The backups of the other cluster are neither listed with nor purged along with those of cluster-a.
+	containerRef := abs.GetContainerReference(container)
+	for key, want := range map[string]int{"cluster-a/etcd.backup": 1, "cluster-b/etcd.backup": 2} {
+		files, err := util.ListBackupFiles(containerRef, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(files) != want {
+			t.Errorf("expect %d backups of %s, get=%v", want, key, files)
+		}
+		for _, f := range files {
+			if !strings.HasPrefix(f.Name, key+"_") {
+				t.Errorf("expect only backups of %s listed, get %s", key, f.Name)
+			}
+		}
+	}
+
+	// Scoped to the sub-prefix of cluster-b, or without a sub-prefix, the listing holds the blobs of the prefix only.
+	for _, tt := range []struct {
+		prefix, subPrefix string
+		want              int
+	}{
+		{prefix: "cluster-b", subPrefix: "etcd.backup_", want: 2},
+		{prefix: "cluster-b/etcd.backup_", subPrefix: "", want: 2},
+		// Both clusters, along with their latest backup pointers and indexes.
+		{prefix: "cluster-", subPrefix: "", want: 3 + 4},
+	} {
+		blobs, err := util.ListWithPrefix(containerRef, tt.prefix, tt.subPrefix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(blobs) != tt.want {
+			t.Errorf("ListWithPrefix(%q, %q): expect %d blobs, get %d", tt.prefix, tt.subPrefix, tt.want, len(blobs))
+		}
+	}
+}
+
+func TestABSWriterPurgeConcurrently(t *testing.T) {
+	abs := newTestABSClient(t)
+	container, cleanup := newTestContainer(t, abs)
+	defer cleanup()
+
+	w := NewABSWriterFromConfig(abs, ABSWriterConfig{PurgeWorkers: 4})
+	backupKey := func(rev int64) string {
+		return "etcd.backup_" + util.MakeBackupName("3.2.13", rev)
+	}
+	const n = 40
+	for rev := int64(1); rev <= n; rev++ {
+		if _, err := w.Write(context.Background(), container+"/"+backupKey(rev), strings.NewReader("backup")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A leased blob cannot be deleted without its lease ID.
+	containerRef := abs.GetContainerReference(container)
+	leased := containerRef.GetBlobReference(backupKey(7))
+	leaseID, err := leased.AcquireLease(-1, "", &storage.LeaseOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer leased.ReleaseLease(leaseID, &storage.LeaseOptions{})
+
+	_, err = w.Purge(context.Background(), container+"/etcd.backup", 1, false)
+	if err == nil || !strings.Contains(err.Error(), backupKey(7)) {
+		t.Fatalf("expect the failed deletion of %s to be reported, get=%v", backupKey(7), err)
+	}
+
+	files, err := util.ListBackupFiles(containerRef, "etcd.backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range files {
+		names = append(names, f.Name)
+	}
+	sort.Strings(names)
+	if want := []string{backupKey(7), backupKey(n)}; !reflect.DeepEqual(names, want) {
+		t.Errorf("expect only the latest and the leased backups to remain, get=%v", names)
+	}
+}
+
+func TestABSWriterMultiBlockRoundTrip(t *testing.T) {
+	abs := newTestABSClient(t)
+	container, cleanup := newTestContainer(t, abs)
+	defer cleanup()
+
+	data := make([]byte, 10*1024+17)
+	rand.Read(data)
+	path := container + "/etcd.backup_" + util.MakeBackupName("3.2.13", 1)
+	// Blocks staged concurrently must still be committed in the order of the content.
+	w := NewABSWriterFromConfig(abs, ABSWriterConfig{BlockSize: 1024, BlockParallelism: 4})
+	size, err := w.Write(context.Background(), path, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != int64(len(data)) {
+		t.Errorf("expect size=%d, get=%d", len(data), size)
+	}
+
+	rc, err := reader.NewABSReader(abs, nil, 0).Open(context.Background(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	got, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("expect the backup uploaded in 11 blocks to read back identically")
+	}
+}
+
+func TestABSWriterInterruptedSave(t *testing.T) {
+	abs := newTestABSClient(t)
+	container, cleanup := newTestContainer(t, abs)
+	defer cleanup()
+
+	w := NewABSWriter(abs, false, nil, "", 0, 1024)
+	backupKey := func(rev int64) string {
+		return "etcd.backup_" + util.MakeBackupName("3.2.13", rev)
+	}
+	if _, err := w.Write(context.Background(), container+"/"+backupKey(1), strings.NewReader("backup")); err != nil {
+		t.Fatal(err)
+	}
+
+	// A save interrupted after staging some blocks of its temporary blob.
+	pr, pw := io.Pipe()
+	go func() {
+		pw.Write(bytes.Repeat([]byte("a"), 4096))
+		pw.CloseWithError(errors.New("connection reset"))
+	}()
+	if _, err := w.Write(context.Background(), container+"/"+backupKey(2), pr); err == nil {
+		t.Fatal("expect the interrupted save to fail")
+	}
+	// A temporary blob left committed by a save that crashed before copying it to its final name.
+	containerRef := abs.GetContainerReference(container)
+	tmp := util.MakeTmpName(backupKey(3))
+	if err := containerRef.GetBlobReference(tmp).CreateBlockBlobFromReader(strings.NewReader("partial"), &storage.PutBlobOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	r := reader.NewABSReader(abs, nil, 0)
+	path := container + "/etcd.backup"
+	if latest, err := r.Latest(context.Background(), path); err != nil || latest != container+"/"+backupKey(1) {
+		t.Errorf("expect latest=%s, get=%s (err=%v)", container+"/"+backupKey(1), latest, err)
+	}
+	// Without the latest backup pointer, the listing Latest falls back to ignores temporary blobs as well.
+	if err := containerRef.GetBlobReference("etcd.backup" + util.LatestPointerSuffix).Delete(&storage.DeleteBlobOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if latest, err := r.Latest(context.Background(), path); err != nil || latest != container+"/"+backupKey(1) {
+		t.Errorf("expect latest from listing=%s, get=%s (err=%v)", container+"/"+backupKey(1), latest, err)
+	}
+
+	// Temporary blobs younger than util.TmpFileMaxAge may belong to saves in progress, so purges keep them.
+	purged, err := w.Purge(context.Background(), path, 1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(purged) != 0 {
+		t.Errorf("expect nothing purged, get=%v", purged)
+	}
+	if exists, err := containerRef.GetBlobReference(tmp).Exists(); err != nil || !exists {
+		t.Errorf("expect the recent temporary blob to be kept (err=%v)", err)
+	}
+}
+
+func TestABSWriterConcurrentWrites(t *testing.T) {
+	abs := newTestABSClient(t)
+	container, cleanup := newTestContainer(t, abs)
+	defer cleanup()
+
+	// Small blocks interleave the block uploads of both saves.
+	w := NewABSWriter(abs, false, nil, "", 0, 1024)
+	contents := map[string][]byte{}
+	for rev := int64(1); rev <= 2; rev++ {
+		data := make([]byte, 8*1024+int(rev))
+		rand.Read(data)
+		contents[container+"/etcd.backup_"+util.MakeBackupName("3.2.13", rev)] = data
+	}
+	var wg sync.WaitGroup
+	errc := make(chan error, len(contents))
+	for path, data := range contents {
+		wg.Add(1)
+		go func(path string, data []byte) {
+			defer wg.Done()
+			_, err := w.Write(context.Background(), path, bytes.NewReader(data))
+			errc <- err
+		}(path, data)
+	}
+	wg.Wait()
+	close(errc)
+	for err := range errc {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := reader.NewABSReader(abs, nil, 0)
+	for path, data := range contents {
+		rc, err := r.Open(context.Background(), path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("expect %s to be saved intact", path)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+// Copyright 2018 The etcd-operator Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package writer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/coreos/etcd-operator/pkg/backup/util"
+)
+
+var _ Writer = &fsWriter{}
+
+// fsWriter provides Writer implementation for saving backup files
+// under a local directory, e.g. a mounted persistent volume.
+type fsWriter struct {
+	root string
+	// minKeep is the number of latest backup files purges keep whatever their policy.
+	minKeep int
+}
+
+// NewFSWriter creates a writer saving backup files under the root directory.
+func NewFSWriter(root string) Writer {
+	return NewFSWriterMinKeep(root, util.DefaultMinKeep)
+}
+
+// NewFSWriterMinKeep creates a writer like NewFSWriter, whose purges keep the latest minKeep backup files
+// whatever their policy, or util.DefaultMinKeep if minKeep is not positive.
+func NewFSWriterMinKeep(root string, minKeep int) Writer {
+	if minKeep <= 0 {
+		minKeep = util.DefaultMinKeep
+	}
+	return &fsWriter{root: root, minKeep: minKeep}
+}
+
+// Write writes the backup file to the given path relative to the root directory.
+func (fsw *fsWriter) Write(ctx context.Context, path string, r io.Reader) (int64, error) {
+	res, err := fsw.WriteWithResult(ctx, path, r)
+	if err != nil {
+		return 0, err
+	}
+	return res.Size, nil
+}
+
+// WriteWithResult writes the backup file to the given path relative to the root directory like Write.
+func (fsw *fsWriter) WriteWithResult(ctx context.Context, path string, r io.Reader) (*SaveResult, error) {
+	start := time.Now()
+	res, err := fsw.write(ctx, path, r)
+	if err != nil {
+		observeWrite(backendFS, start, 0, err)
+		return nil, err
+	}
+	res.Duration = time.Since(start)
+	observeWrite(backendFS, start, res.Size, nil)
+	return res, nil
+}
+
+// WriteIfAbsent writes the backup file to the given path unless a backup of the same
+// etcd version and revision is already saved.
+func (fsw *fsWriter) WriteIfAbsent(ctx context.Context, path string, r io.Reader) (int64, bool, error) {
+	fpath, err := util.ParseFilePath(fsw.root, path)
+	if err != nil {
+		return 0, false, err
+	}
+	files, err := util.ListLocalFilesWithPrefix(filepath.Dir(fpath), filepath.Base(fpath))
+	if err != nil {
+		return 0, false, err
+	}
+	if f, ok := util.FindDuplicate(files, fpath); ok {
+		return f.Size, true, nil
+	}
+
+	size, err := fsw.Write(ctx, path, r)
+	return size, false, err
+}
+
+func (fsw *fsWriter) write(ctx context.Context, path string, r io.Reader) (*SaveResult, error) {
+	fpath, err := util.ParseFilePath(fsw.root, path)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.MkdirAll(filepath.Dir(fpath), 0700)
+	if err != nil {
+		return nil, err
+	}
+	// The backup is written to a temporary file first and only renamed once complete,
+	// so that an interrupted save never leaves an incomplete backup behind.
+	tmpPath := util.MakeTmpName(fpath)
+	f, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmpPath)
+	defer f.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(f, h), util.NewContextReader(ctx, r))
+	if err != nil {
+		return nil, err
+	}
+	err = f.Sync()
+	if err != nil {
+		return nil, err
+	}
+	err = os.Rename(tmpPath, fpath)
+	if err != nil {
+		return nil, err
+	}
+	return &SaveResult{Path: path, Size: n, SHA256: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+func (fsw *fsWriter) Purge(ctx context.Context, path string, maxBackups int, dryRun bool) ([]string, error) {
+	if maxBackups <= 0 {
+		return nil, util.ErrInvalidMaxBackups
+	}
+	files, err := fsw.listBackupFiles(path)
+	if err != nil {
+		return nil, err
+	}
+	return fsw.deleteFiles(ctx, staleFiles(files, util.PurgeByCount(maxBackups), fsw.minKeep), dryRun)
+}
+
+func (fsw *fsWriter) PurgeBatch(ctx context.Context, path string, keep, maxDelete int) (int, int, error) {
+	if err := util.ValidatePurgeBatch(keep, maxDelete); err != nil {
+		return 0, 0, err
+	}
+	files, err := fsw.listBackupFiles(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	batch, remaining := util.BatchBackupFiles(staleFiles(files, util.PurgeByCount(keep), fsw.minKeep), maxDelete)
+	if _, err := fsw.deleteFiles(ctx, batch, false); err != nil {
+		return 0, 0, err
+	}
+	return len(batch), remaining, nil
+}
+
+func (fsw *fsWriter) PurgeByVersion(ctx context.Context, path string, keepPerVersion int, dryRun bool) ([]string, error) {
+	if keepPerVersion <= 0 {
+		return nil, util.ErrInvalidMaxBackups
+	}
+	files, err := fsw.listBackupFiles(path)
+	if err != nil {
+		return nil, err
+	}
+	return fsw.deleteFiles(ctx, staleFiles(files, util.PurgeByVersion(keepPerVersion), fsw.minKeep), dryRun)
+}
+
+func (fsw *fsWriter) PurgeOlderThan(ctx context.Context, path string, d time.Duration, dryRun bool) ([]string, error) {
+	files, err := fsw.listBackupFiles(path)
+	if err != nil {
+		return nil, err
+	}
+	return fsw.deleteFiles(ctx, staleFiles(files, util.PurgeOlderThan(d), fsw.minKeep), dryRun)
+}
+
+func (fsw *fsWriter) PurgeToSize(ctx context.Context, path string, maxBytes int64, dryRun bool) ([]string, error) {
+	files, err := fsw.listBackupFiles(path)
+	if err != nil {
+		return nil, err
+	}
+	return fsw.deleteFiles(ctx, staleFiles(files, util.PurgeToSize(maxBytes), fsw.minKeep), dryRun)
+}
+
+func (fsw *fsWriter) DeleteAll(ctx context.Context, path string, dryRun bool) ([]string, error) {
+	files, err := fsw.listBackupFiles(path)
+	if err != nil {
+		return nil, err
+	}
+	return fsw.deleteFiles(ctx, files, dryRun)
+}
+
+// listBackupFiles lists the backup files saved with revision appended to the given path,
+// including the temporary files of backups being saved.
+func (fsw *fsWriter) listBackupFiles(path string) ([]util.BackupFile, error) {
+	fpath, err := util.ParseFilePath(fsw.root, path)
+	if err != nil {
+		return nil, err
+	}
+	return util.ListLocalFilesWithPrefix(filepath.Dir(fpath), filepath.Base(fpath)+"_")
+}
+
+// deleteFiles deletes the given backup files, unless dryRun is true,
+// and returns their paths relative to the root directory.
+func (fsw *fsWriter) deleteFiles(ctx context.Context, files []util.BackupFile, dryRun bool) ([]string, error) {
+	paths := make([]string, 0, len(files))
+	for _, f := range files {
+		path, err := filepath.Rel(filepath.Clean(fsw.root), f.Name)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	if dryRun {
+		return paths, nil
+	}
+
+	for _, f := range files {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		err := os.Remove(f.Name)
+		if os.IsNotExist(err) {
+			continue
+		}
+		observeDelete(backendFS, err)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return paths, nil
+}
@@ -0,0 +1,442 @@
+// Copyright 2018 The etcd-operator Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package writer
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/coreos/etcd-operator/pkg/backup/util"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestFSWriterWriteAndPurge(t *testing.T) {
+	root, err := ioutil.TempDir("", "fs-writer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	w := NewFSWriter(root)
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		path := fmt.Sprintf("cluster-a/etcd.backup_%s", util.MakeBackupName("3.2.13", int64(i)))
+		data := []byte(fmt.Sprintf("backup %d", i))
+		n, err := w.Write(context.Background(), path, bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != int64(len(data)) {
+			t.Errorf("expect size=%d, get=%d", len(data), n)
+		}
+		mtime := now.Add(time.Duration(i) * time.Minute)
+		if err = os.Chtimes(filepath.Join(root, path), mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dryRunPaths, err := w.Purge(context.Background(), "cluster-a/etcd.backup", 2, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fis, err := ioutil.ReadDir(filepath.Join(root, "cluster-a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fis) != 5 {
+		t.Fatalf("expect dry run to delete nothing, get %d backups left", len(fis))
+	}
+
+	paths, err := w.Purge(context.Background(), "cluster-a/etcd.backup", 2, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(dryRunPaths, paths) {
+		t.Errorf("expect dry run paths=%v to match purged paths=%v", dryRunPaths, paths)
+	}
+	for i, path := range paths {
+		want := "cluster-a/etcd.backup_" + util.MakeBackupName("3.2.13", int64(i))
+		if path != want {
+			t.Errorf("expect backup %v to be purged, get=%v", want, path)
+		}
+	}
+	fis, err = ioutil.ReadDir(filepath.Join(root, "cluster-a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fis) != 2 {
+		t.Fatalf("expect 2 backups left, get=%d", len(fis))
+	}
+	for i, fi := range fis {
+		want := "etcd.backup_" + util.MakeBackupName("3.2.13", int64(i+3))
+		if fi.Name() != want {
+			t.Errorf("expect backup %v to be kept, get=%v", want, fi.Name())
+		}
+	}
+}
+
+func TestFSWriterInvalidPath(t *testing.T) {
+	w := NewFSWriter("/tmp/backups")
+	if _, err := w.Write(context.Background(), "../etc/passwd", bytes.NewReader(nil)); err == nil {
+		t.Errorf("expect writing outside of the root directory to fail")
+	}
+}
+
+// cancelingReader cancels the context after its first read.
+type cancelingReader struct {
+	r      io.Reader
+	cancel context.CancelFunc
+}
+
+func (cr *cancelingReader) Read(p []byte) (int, error) {
+	defer cr.cancel()
+	return cr.r.Read(p[:1])
+}
+
+func TestFSWriterWriteCanceled(t *testing.T) {
+	root, err := ioutil.TempDir("", "fs-writer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &cancelingReader{r: bytes.NewReader([]byte("backup")), cancel: cancel}
+	_, err = NewFSWriter(root).Write(ctx, "cluster-a/etcd.backup", r)
+	if err != context.Canceled {
+		t.Fatalf("expect error=%v, get=%v", context.Canceled, err)
+	}
+	if _, err = os.Stat(filepath.Join(root, "cluster-a/etcd.backup")); !os.IsNotExist(err) {
+		t.Errorf("expect partially written backup to be removed, get=%v", err)
+	}
+}
+
+func counterValue(t *testing.T, c prometheus.Counter) float64 {
+	m := &dto.Metric{}
+	if err := c.Write(m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetCounter().GetValue()
+}
+
+func TestFSWriterMetrics(t *testing.T) {
+	root, err := ioutil.TempDir("", "fs-writer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	saved := counterValue(t, backupsSaved.WithLabelValues(backendFS))
+	uploaded := counterValue(t, backupBytesUploaded.WithLabelValues(backendFS))
+	failed := counterValue(t, backupFailures.WithLabelValues(backendFS, operationWrite))
+
+	w := NewFSWriter(root)
+	if _, err = w.Write(context.Background(), "cluster-a/etcd.backup", bytes.NewReader([]byte("backup"))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.Write(context.Background(), "../etcd.backup", bytes.NewReader(nil)); err == nil {
+		t.Fatal("expect writing outside of the root directory to fail")
+	}
+
+	if v := counterValue(t, backupsSaved.WithLabelValues(backendFS)); v != saved+1 {
+		t.Errorf("expect saved=%v, get=%v", saved+1, v)
+	}
+	if v := counterValue(t, backupBytesUploaded.WithLabelValues(backendFS)); v != uploaded+6 {
+		t.Errorf("expect uploaded bytes=%v, get=%v", uploaded+6, v)
+	}
+	if v := counterValue(t, backupFailures.WithLabelValues(backendFS, operationWrite)); v != failed+1 {
+		t.Errorf("expect failures=%v, get=%v", failed+1, v)
+	}
+}
+
+func TestFSWriterWriteIfAbsent(t *testing.T) {
+	root, err := ioutil.TempDir("", "fs-writer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	w := NewFSWriter(root)
+	path := "cluster-a/etcd.backup_" + util.MakeBackupName("3.2.13", 0x326)
+	size, skipped, err := w.WriteIfAbsent(context.Background(), path, bytes.NewReader([]byte("backup")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if skipped || size != 6 {
+		t.Fatalf("expect first write to save 6 bytes, get size=%d, skipped=%v", size, skipped)
+	}
+
+	size, skipped, err = w.WriteIfAbsent(context.Background(), path, bytes.NewReader([]byte("another backup")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !skipped || size != 6 {
+		t.Errorf("expect second write to be skipped with the existing size 6, get size=%d, skipped=%v", size, skipped)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(root, path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "backup" {
+		t.Errorf("expect existing backup to be kept, get=%q", data)
+	}
+}
+
+func TestFSWriterPurgeTmpFiles(t *testing.T) {
+	root, err := ioutil.TempDir("", "fs-writer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	w := NewFSWriter(root)
+	path := "cluster-a/etcd.backup_" + util.MakeBackupName("3.2.13", 1)
+	if _, err = w.Write(context.Background(), path, bytes.NewReader([]byte("backup"))); err != nil {
+		t.Fatal(err)
+	}
+
+	// Temporary files left by interrupted saves.
+	now := time.Now()
+	staleTmp := filepath.Join(root, "cluster-a/etcd.backup_"+util.MakeBackupName("3.2.13", 2)+util.TmpSuffix)
+	recentTmp := filepath.Join(root, "cluster-a/etcd.backup_"+util.MakeBackupName("3.2.13", 3)+util.TmpSuffix)
+	for p, mtime := range map[string]time.Time{staleTmp: now.Add(-2 * util.TmpFileMaxAge), recentTmp: now} {
+		if err = ioutil.WriteFile(p, []byte("partial"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err = os.Chtimes(p, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	paths, err := w.Purge(context.Background(), "cluster-a/etcd.backup", 1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"cluster-a/etcd.backup_" + util.MakeBackupName("3.2.13", 2) + util.TmpSuffix}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("expect purged paths=%v, get=%v", want, paths)
+	}
+	for _, p := range []string{filepath.Join(root, path), recentTmp} {
+		if _, err = os.Stat(p); err != nil {
+			t.Errorf("expect %v to be kept: %v", p, err)
+		}
+	}
+}
+
+func TestFSWriterConcurrentWrites(t *testing.T) {
+	root, err := ioutil.TempDir("", "fs-writer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	w := NewFSWriter(root)
+	contents := [][]byte{bytes.Repeat([]byte("a"), 64*1024), bytes.Repeat([]byte("b"), 64*1024)}
+	paths := []string{"cluster-a/etcd.backup", "cluster-a/etcd.backup", "cluster-a/etcd.backup_other"}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(paths))
+	for i, path := range paths {
+		wg.Add(1)
+		go func(i int, path string) {
+			defer wg.Done()
+			_, errs[i] = w.Write(context.Background(), path, iotest.OneByteReader(bytes.NewReader(contents[i%2])))
+		}(i, path)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := ioutil.ReadFile(filepath.Join(root, "cluster-a/etcd.backup"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, contents[0]) && !bytes.Equal(got, contents[1]) {
+		t.Errorf("expect the content of one of the concurrent saves, get %d corrupted bytes", len(got))
+	}
+	got, err = ioutil.ReadFile(filepath.Join(root, "cluster-a/etcd.backup_other"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, contents[0]) {
+		t.Errorf("expect backup saved concurrently to another path to be intact")
+	}
+}
+
+func TestFSWriterPurgeToSize(t *testing.T) {
+	root, err := ioutil.TempDir("", "fs-writer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	w := NewFSWriter(root)
+	now := time.Now()
+	for i := 1; i <= 4; i++ {
+		path := "cluster-a/etcd.backup_" + util.MakeBackupName("3.2.13", int64(i))
+		if _, err := w.Write(context.Background(), path, bytes.NewReader(make([]byte, 100*i))); err != nil {
+			t.Fatal(err)
+		}
+		mtime := now.Add(time.Duration(i) * time.Minute)
+		if err := os.Chtimes(filepath.Join(root, path), mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	purged, err := w.PurgeToSize(context.Background(), "cluster-a/etcd.backup", 750, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"cluster-a/etcd.backup_" + util.MakeBackupName("3.2.13", 1),
+		"cluster-a/etcd.backup_" + util.MakeBackupName("3.2.13", 2),
+	}
+	if !reflect.DeepEqual(purged, want) {
+		t.Errorf("expect purged=%v, get=%v", want, purged)
+	}
+
+	files, err := util.ListLocalBackupFiles(filepath.Join(root, "cluster-a/etcd.backup"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := util.TotalSize(files); size > 750 {
+		t.Errorf("expect total size under the budget, get=%d", size)
+	}
+	if latest := util.GetLatestBackupNameByDate(files); filepath.Base(latest) != "etcd.backup_"+util.MakeBackupName("3.2.13", 4) {
+		t.Errorf("expect the newest backup to be kept, get latest=%v", latest)
+	}
+}
+
+func TestFSWriterDeleteAll(t *testing.T) {
+	root, err := ioutil.TempDir("", "fs-writer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	w := NewFSWriter(root)
+	for i := 1; i <= 3; i++ {
+		path := "cluster-a/etcd.backup_" + util.MakeBackupName("3.2.13", int64(i))
+		if _, err := w.Write(context.Background(), path, bytes.NewReader([]byte("backup"))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := w.Write(context.Background(), "cluster-a/other.backup_"+util.MakeBackupName("3.2.13", 1), bytes.NewReader([]byte("backup"))); err != nil {
+		t.Fatal(err)
+	}
+
+	deleted, err := w.DeleteAll(context.Background(), "cluster-a/etcd.backup", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 3 {
+		t.Errorf("expect 3 deleted backups, get=%v", deleted)
+	}
+
+	files, err := util.ListLocalBackupFiles(filepath.Join(root, "cluster-a/etcd.backup"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("expect no backups left, get=%v", files)
+	}
+	files, err = util.ListLocalBackupFiles(filepath.Join(root, "cluster-a/other.backup"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("expect backups of another path to be kept, get=%v", files)
+	}
+}
+
+func TestFSWriterPurgeInvalidMaxBackups(t *testing.T) {
+	root, err := ioutil.TempDir("", "fs-writer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	w := NewFSWriter(root)
+	for i := 1; i <= 2; i++ {
+		path := "cluster-a/etcd.backup_" + util.MakeBackupName("3.2.13", int64(i))
+		if _, err := w.Write(context.Background(), path, bytes.NewReader([]byte("backup"))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, n := range []int{0, -1} {
+		purged, err := w.Purge(context.Background(), "cluster-a/etcd.backup", n, false)
+		if err != util.ErrInvalidMaxBackups {
+			t.Errorf("expect Purge(%d) error=%v, get=%v", n, util.ErrInvalidMaxBackups, err)
+		}
+		if len(purged) != 0 {
+			t.Errorf("expect nothing purged by Purge(%d), get=%v", n, purged)
+		}
+		purged, err = w.PurgeByVersion(context.Background(), "cluster-a/etcd.backup", n, false)
+		if err != util.ErrInvalidMaxBackups {
+			t.Errorf("expect PurgeByVersion(%d) error=%v, get=%v", n, util.ErrInvalidMaxBackups, err)
+		}
+		if len(purged) != 0 {
+			t.Errorf("expect nothing purged by PurgeByVersion(%d), get=%v", n, purged)
+		}
+	}
+
+	files, err := util.ListLocalBackupFiles(filepath.Join(root, "cluster-a/etcd.backup"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Errorf("expect all backups to be kept, get=%v", files)
+	}
+}
+
+func TestFSWriterWriteWithResult(t *testing.T) {
+	root, err := ioutil.TempDir("", "fs-writer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	data := []byte("backup")
+	path := "cluster-a/etcd.backup_" + util.MakeBackupName("3.2.13", 1)
+	res, err := NewFSWriter(root).WriteWithResult(context.Background(), path, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(data)
+	if res.Path != path || res.Size != int64(len(data)) || res.SHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("expect result of path=%v size=%d sha256=%x, get=%+v", path, len(data), sum, res)
+	}
+	if res.Duration <= 0 {
+		t.Errorf("expect save duration to be recorded, get=%v", res.Duration)
+	}
+}
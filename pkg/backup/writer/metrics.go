@@ -0,0 +1,100 @@
+// Copyright 2018 The etcd-operator Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package writer
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Backend labels of the backup metrics.
+const (
+	backendABS = "abs"
+	backendS3  = "s3"
+	backendFS  = "fs"
+)
+
+// Operation labels of the backup failure metric.
+const (
+	operationWrite = "write"
+	operationPurge = "purge"
+)
+
+var (
+	backupsSaved = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "etcd_operator",
+		Subsystem: "backup",
+		Name:      "saved",
+		Help:      "Total number of backups saved",
+	}, []string{"backend"})
+
+	backupBytesUploaded = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "etcd_operator",
+		Subsystem: "backup",
+		Name:      "uploaded_bytes",
+		Help:      "Total number of bytes of backups saved",
+	}, []string{"backend"})
+
+	backupDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "etcd_operator",
+		Subsystem: "backup",
+		Name:      "duration_seconds",
+		Help:      "Time taken to save a backup",
+		Buckets:   prometheus.ExponentialBuckets(0.5, 2, 12),
+	}, []string{"backend"})
+
+	backupsPurged = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "etcd_operator",
+		Subsystem: "backup",
+		Name:      "purged",
+		Help:      "Total number of backups deleted by purging",
+	}, []string{"backend"})
+
+	backupFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "etcd_operator",
+		Subsystem: "backup",
+		Name:      "failures",
+		Help:      "Total number of failed backup operations",
+	}, []string{"backend", "operation"})
+)
+
+func init() {
+	prometheus.MustRegister(backupsSaved)
+	prometheus.MustRegister(backupBytesUploaded)
+	prometheus.MustRegister(backupDuration)
+	prometheus.MustRegister(backupsPurged)
+	prometheus.MustRegister(backupFailures)
+}
+
+// observeWrite records the outcome of saving a backup of size bytes that started at start.
+func observeWrite(backend string, start time.Time, size int64, err error) {
+	if err != nil {
+		backupFailures.WithLabelValues(backend, operationWrite).Inc()
+		return
+	}
+	backupsSaved.WithLabelValues(backend).Inc()
+	backupBytesUploaded.WithLabelValues(backend).Add(float64(size))
+	backupDuration.WithLabelValues(backend).Observe(time.Since(start).Seconds())
+}
+
+// observeDelete records the outcome of deleting a backup when purging.
+func observeDelete(backend string, err error) {
+	if err != nil {
+		backupFailures.WithLabelValues(backend, operationPurge).Inc()
+		return
+	}
+	backupsPurged.WithLabelValues(backend).Inc()
+}
@@ -15,8 +15,12 @@
 package writer
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
+	"time"
 
 	"github.com/coreos/etcd-operator/pkg/backup/util"
 
@@ -27,43 +31,224 @@ import (
 
 type s3Writer struct {
 	s3 *s3.S3
+	// minKeep is the number of latest backup files purges keep whatever their policy.
+	minKeep int
 }
 
-// NewS3Writer creates a s3 writer.
+// NewS3Writer creates a s3 writer, whose purges keep the latest util.DefaultMinKeep backup files whatever their policy.
 func NewS3Writer(s3 *s3.S3) Writer {
-	return &s3Writer{s3}
+	return &s3Writer{s3: s3, minKeep: util.DefaultMinKeep}
 }
 
 // Write writes the backup file to the given s3 path, "<s3-bucket-name>/<key>".
-func (s3w *s3Writer) Write(path string, r io.Reader) (int64, error) {
-	bk, key, err := util.ParseBucketAndKey(path)
+func (s3w *s3Writer) Write(ctx context.Context, path string, r io.Reader) (int64, error) {
+	res, err := s3w.WriteWithResult(ctx, path, r)
 	if err != nil {
 		return 0, err
 	}
+	return res.Size, nil
+}
+
+// WriteWithResult writes the backup file to the given s3 path like Write.
+func (s3w *s3Writer) WriteWithResult(ctx context.Context, path string, r io.Reader) (*SaveResult, error) {
+	start := time.Now()
+	res, err := s3w.write(ctx, path, r)
+	if err != nil {
+		observeWrite(backendS3, start, 0, err)
+		return nil, err
+	}
+	res.Duration = time.Since(start)
+	observeWrite(backendS3, start, res.Size, nil)
+	return res, nil
+}
+
+// WriteIfAbsent writes the backup file to the given s3 path unless a backup of the same
+// etcd version and revision is already saved.
+func (s3w *s3Writer) WriteIfAbsent(ctx context.Context, path string, r io.Reader) (int64, bool, error) {
+	bk, key, err := util.ParseBucketAndKey(path)
+	if err != nil {
+		return 0, false, err
+	}
+
+	files := []util.BackupFile{}
+	err = s3w.s3.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bk),
+		Prefix: aws.String(key),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, obj := range page.Contents {
+			files = append(files, util.BackupFile{Name: aws.StringValue(obj.Key), Size: aws.Int64Value(obj.Size)})
+		}
+		return true
+	})
+	if err != nil {
+		return 0, false, err
+	}
+	if f, ok := util.FindDuplicate(files, key); ok {
+		return f.Size, true, nil
+	}
 
-	_, err = s3manager.NewUploaderWithClient(s3w.s3).Upload(
+	size, err := s3w.Write(ctx, path, r)
+	return size, false, err
+}
+
+func (s3w *s3Writer) write(ctx context.Context, path string, r io.Reader) (*SaveResult, error) {
+	bk, key, err := util.ParseBucketAndKey(path)
+	if err != nil {
+		return nil, err
+	}
+
+	h := sha256.New()
+	_, err = s3manager.NewUploaderWithClient(s3w.s3).UploadWithContext(ctx,
 		&s3manager.UploadInput{
 			Bucket: aws.String(bk),
 			Key:    aws.String(key),
-			Body:   r,
+			Body:   io.TeeReader(r, h),
 		})
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
-	resp, err := s3w.s3.GetObject(&s3.GetObjectInput{
+	resp, err := s3w.s3.GetObjectWithContext(ctx, &s3.GetObjectInput{
 		Bucket: aws.String(bk),
 		Key:    aws.String(key),
 	})
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 	if resp.ContentLength == nil {
-		return 0, fmt.Errorf("failed to compute s3 object size")
+		return nil, fmt.Errorf("failed to compute s3 object size")
+	}
+	return &SaveResult{Path: path, Size: *resp.ContentLength, SHA256: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+// Purge purges stale backup objects, keeping the latest maxBackups by date.
+func (s3w *s3Writer) Purge(ctx context.Context, path string, maxBackups int, dryRun bool) ([]string, error) {
+	if maxBackups <= 0 {
+		return nil, util.ErrInvalidMaxBackups
+	}
+	return s3w.purge(ctx, path, util.PurgeByCount(maxBackups), dryRun)
+}
+
+// PurgeBatch purges at most maxDelete stale backup objects, keeping the latest keep backups by date.
+func (s3w *s3Writer) PurgeBatch(ctx context.Context, path string, keep, maxDelete int) (int, int, error) {
+	if err := util.ValidatePurgeBatch(keep, maxDelete); err != nil {
+		return 0, 0, err
+	}
+	bucket, files, err := s3w.listBackupFiles(ctx, path)
+	if err != nil {
+		return 0, 0, err
+	}
+	batch, remaining := util.BatchBackupFiles(staleFiles(files, util.PurgeByCount(keep), s3w.minKeep), maxDelete)
+	if _, err := s3w.deleteObjects(ctx, bucket, batch, false); err != nil {
+		return 0, 0, err
+	}
+	return len(batch), remaining, nil
+}
+
+// PurgeByVersion purges stale backup objects, keeping the latest keepPerVersion backups by date
+// for each etcd version found in the backup names.
+func (s3w *s3Writer) PurgeByVersion(ctx context.Context, path string, keepPerVersion int, dryRun bool) ([]string, error) {
+	if keepPerVersion <= 0 {
+		return nil, util.ErrInvalidMaxBackups
+	}
+	return s3w.purge(ctx, path, util.PurgeByVersion(keepPerVersion), dryRun)
+}
+
+// PurgeOlderThan purges backup objects last modified more than d ago, except the latest one.
+func (s3w *s3Writer) PurgeOlderThan(ctx context.Context, path string, d time.Duration, dryRun bool) ([]string, error) {
+	return s3w.purge(ctx, path, util.PurgeOlderThan(d), dryRun)
+}
+
+// PurgeToSize purges the oldest backup objects until the total size of the remaining ones is at most maxBytes,
+// except the latest one.
+func (s3w *s3Writer) PurgeToSize(ctx context.Context, path string, maxBytes int64, dryRun bool) ([]string, error) {
+	return s3w.purge(ctx, path, util.PurgeToSize(maxBytes), dryRun)
+}
+
+// DeleteAll deletes every backup object saved with revision appended to path, including the latest one.
+func (s3w *s3Writer) DeleteAll(ctx context.Context, path string, dryRun bool) ([]string, error) {
+	bucket, files, err := s3w.listBackupFiles(ctx, path)
+	if err != nil {
+		return nil, err
 	}
-	return *resp.ContentLength, nil
+	return s3w.deleteObjects(ctx, bucket, files, dryRun)
 }
 
-func (s3w *s3Writer) Purge(path string, maxBackups int) error {
-	return nil
+func (s3w *s3Writer) purge(ctx context.Context, path string, policy util.PurgePolicy, dryRun bool) ([]string, error) {
+	bucket, files, err := s3w.listBackupFiles(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return s3w.deleteObjects(ctx, bucket, staleFiles(files, policy, s3w.minKeep), dryRun)
+}
+
+// listBackupFiles lists the backup objects saved with revision appended to the given s3 path.
+func (s3w *s3Writer) listBackupFiles(ctx context.Context, path string) (string, []util.BackupFile, error) {
+	bucket, key, err := util.ParseBucketAndKey(path)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to parse s3 bucket and key: %v", err)
+	}
+
+	files := []util.BackupFile{}
+	err = s3w.s3.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(key + "_"),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, obj := range page.Contents {
+			files = append(files, util.BackupFile{
+				Name:         aws.StringValue(obj.Key),
+				LastModified: aws.TimeValue(obj.LastModified),
+				Size:         aws.Int64Value(obj.Size),
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return bucket, files, nil
+}
+
+// maxDeleteObjects is the maximum number of keys of a DeleteObjects request.
+const maxDeleteObjects = 1000
+
+// deleteObjects deletes the given backup objects of bucket in batches, unless dryRun is true,
+// and returns their paths in the format "<s3-bucket-name>/<key>".
+func (s3w *s3Writer) deleteObjects(ctx context.Context, bucket string, files []util.BackupFile, dryRun bool) ([]string, error) {
+	paths := make([]string, 0, len(files))
+	for _, f := range files {
+		paths = append(paths, bucket+"/"+f.Name)
+	}
+	if dryRun {
+		return paths, nil
+	}
+
+	for start := 0; start < len(files); start += maxDeleteObjects {
+		end := start + maxDeleteObjects
+		if end > len(files) {
+			end = len(files)
+		}
+		objects := make([]*s3.ObjectIdentifier, 0, end-start)
+		for _, f := range files[start:end] {
+			objects = append(objects, &s3.ObjectIdentifier{Key: aws.String(f.Name)})
+		}
+		out, err := s3w.s3.DeleteObjectsWithContext(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(bucket),
+			Delete: &s3.Delete{Objects: objects, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			observeDelete(backendS3, err)
+			return nil, err
+		}
+		for i := len(out.Errors); i < end-start; i++ {
+			observeDelete(backendS3, nil)
+		}
+		if len(out.Errors) != 0 {
+			err = fmt.Errorf("failed to delete %d backup objects, e.g. %s: %s",
+				len(out.Errors), aws.StringValue(out.Errors[0].Key), aws.StringValue(out.Errors[0].Message))
+			observeDelete(backendS3, err)
+			return nil, err
+		}
+	}
+	return paths, nil
 }
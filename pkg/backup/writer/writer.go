@@ -14,12 +14,65 @@
 
 package writer
 
-import "io"
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/coreos/etcd-operator/pkg/backup/util"
+)
 
 // Writer defines the required writer operations.
+// All operations abort with ctx.Err() once ctx is done.
+// Purge operations return the paths of the purged backup files. If dryRun is true,
+// they return the paths of the backup files that would be purged without deleting anything.
+// Purge operations keep at least the latest backup files, util.DefaultMinKeep unless the writer is configured
+// otherwise, whatever their policy.
 type Writer interface {
 	// Write writes a backup file to the given path and returns size of written file.
-	Write(path string, r io.Reader) (int64, error)
-	// Purge purges stale backup files according to the appended revision number
-	Purge(path string, maxBackups int) error
+	Write(ctx context.Context, path string, r io.Reader) (int64, error)
+	// WriteWithResult writes a backup file to the given path like Write and describes the saved backup file.
+	WriteWithResult(ctx context.Context, path string, r io.Reader) (*SaveResult, error)
+	// WriteIfAbsent writes a backup file like Write, unless the path carries an etcd version and revision
+	// and a backup file of them is already saved, possibly with a different extension. In which case it writes
+	// nothing and returns the size of the existing backup file and skipped true.
+	WriteIfAbsent(ctx context.Context, path string, r io.Reader) (size int64, skipped bool, err error)
+	// Purge purges stale backup files, keeping the latest maxBackups by date.
+	// It returns util.ErrInvalidMaxBackups without deleting anything if maxBackups is 0 or less.
+	Purge(ctx context.Context, path string, maxBackups int, dryRun bool) ([]string, error)
+	// PurgeByVersion purges stale backup files, keeping the latest keepPerVersion by date for each etcd version
+	// Like Purge, it returns util.ErrInvalidMaxBackups without deleting anything if keepPerVersion is 0 or less.
+	PurgeByVersion(ctx context.Context, path string, keepPerVersion int, dryRun bool) ([]string, error)
+	// PurgeOlderThan purges backup files last modified more than d ago, but never the latest one
+	PurgeOlderThan(ctx context.Context, path string, d time.Duration, dryRun bool) ([]string, error)
+	// PurgeToSize purges the oldest backup files by date until their total size is at most maxBytes,
+	// but never the latest one
+	PurgeToSize(ctx context.Context, path string, maxBytes int64, dryRun bool) ([]string, error)
+	// PurgeBatch purges stale backup files like Purge, keeping the latest keep by date, but deletes at most
+	// maxDelete of them, the oldest first, so that a large purge can be spread over several calls.
+	// It returns the number of backup files deleted and the number of stale ones left.
+	PurgeBatch(ctx context.Context, path string, keep, maxDelete int) (deleted, remaining int, err error)
+	// DeleteAll deletes every backup file saved with revision appended to path, including the latest one,
+	// e.g. when the etcd cluster is decommissioned
+	DeleteAll(ctx context.Context, path string, dryRun bool) ([]string, error)
+}
+
+// SaveResult describes a saved backup file.
+type SaveResult struct {
+	// Path is the path the backup file is saved to, including any suffix added by the writer such as util.GzipSuffix.
+	Path string
+	// Size is the size of the saved backup file as returned by Write.
+	Size int64
+	// SHA256 is the hex encoded SHA-256 checksum of the bytes saved to the storage,
+	// after any compression or encryption.
+	SHA256 string
+	// Duration is how long the save took.
+	Duration time.Duration
+}
+
+// staleFiles returns the backup files to purge: the backups chosen by policy among the complete ones,
+// except the latest minKeep, and the temporary files left by saves interrupted more than util.TmpFileMaxAge ago.
+func staleFiles(files []util.BackupFile, policy util.PurgePolicy, minKeep int) []util.BackupFile {
+	complete, tmp := util.SplitTmpFiles(files)
+	return append(util.KeepLatest(policy, minKeep)(complete), util.StaleTmpFiles(tmp, time.Now().Add(-util.TmpFileMaxAge))...)
 }
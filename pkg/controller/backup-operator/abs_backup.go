@@ -15,12 +15,15 @@
 package controller
 
 import (
+	"context"
 	"crypto/tls"
 	"fmt"
+	"strings"
+	"time"
 
 	api "github.com/coreos/etcd-operator/pkg/apis/etcd/v1beta2"
 	"github.com/coreos/etcd-operator/pkg/backup"
-	"github.com/coreos/etcd-operator/pkg/backup/writer"
+	"github.com/coreos/etcd-operator/pkg/backup/util"
 	"github.com/coreos/etcd-operator/pkg/util/azureutil/absfactory"
 	"github.com/coreos/etcd-operator/pkg/util/etcdutil"
 	"github.com/coreos/etcd-operator/pkg/util/k8sutil"
@@ -30,12 +33,35 @@ import (
 
 // TODO: replace this with generic backend interface for other options (PV, Azure)
 // handleABS saves etcd cluster's backup to specificed ABS path.
-func handleABS(kubecli kubernetes.Interface, s *api.ABSBackupSource, sch api.BackupSchedule, endpoints []string, clientTLSSecret, namespace string) (*api.BackupStatus, error) {
+func handleABS(ctx context.Context, kubecli kubernetes.Interface, s *api.ABSBackupSource, sch api.BackupSchedule, endpoints []string, clientTLSSecret, clusterName, namespace string) (*api.BackupStatus, error) {
+	if strings.ContainsAny(clusterName, "/_") {
+		return nil, fmt.Errorf("invalid cluster name (%v): must contain neither \"/\" nor \"_\"", clusterName)
+	}
+	if err := util.ValidateTags(s.Tags); err != nil {
+		return nil, err
+	}
+	path, err := util.RenderPathTemplate(s.Path, namespace, clusterName)
+	if err != nil {
+		return nil, err
+	}
+	path, err = util.NormalizePath(path)
+	if err != nil {
+		return nil, err
+	}
+
 	cli, err := absfactory.NewClientFromSecret(kubecli, namespace, s.ABSSecret)
 	if err != nil {
 		return nil, err
 	}
 
+	var encryptionKey []byte
+	if len(s.EncryptionSecret) != 0 {
+		encryptionKey, err = absfactory.EncryptionKeyFromSecret(kubecli, namespace, s.EncryptionSecret)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	var tlsConfig *tls.Config
 	if len(clientTLSSecret) != 0 {
 		d, err := k8sutil.GetTLSDataFromSecret(kubecli, namespace, clientTLSSecret)
@@ -48,19 +74,45 @@ func handleABS(kubecli kubernetes.Interface, s *api.ABSBackupSource, sch api.Bac
 		}
 	}
 
-	bm := backup.NewBackupManagerFromWriter(kubecli, writer.NewABSWriter(cli.ABS), tlsConfig, endpoints, namespace)
+	be, err := backup.NewABS(backup.ABSConfig{
+		Client:        cli.ABS,
+		Compress:      s.Compression,
+		EncryptionKey: encryptionKey,
+		ClusterName:   clusterName,
+		Timeout:       time.Duration(s.TimeoutInSecond) * time.Second,
+		BlockSize:     s.BlockSizeBytes,
+		DefaultTier:   util.AccessTier(s.AccessTier),
+	})
+	if err != nil {
+		return nil, err
+	}
+	bm := backup.NewBackupManagerFromWriter(kubecli, be, tlsConfig, endpoints, namespace)
+	bm.ClusterName = clusterName
+	bm.Tags = s.Tags
+	bm.Quota = s.QuotaBytes
 	appendRev := false
 	if sch.BackupIntervalInSecond > 0 {
 		appendRev = true
 	}
-	rev, etcdVersion, err := bm.SaveSnap(s.Path, appendRev)
+	rev, etcdVersion, err := bm.SaveSnap(ctx, path, appendRev)
 	if err != nil {
 		return nil, fmt.Errorf("failed to save snapshot (%v)", err)
 	}
 
-	err = bm.PurgeBackup(s.Path, sch.MaxBackups)
+	if sch.MaxBackupsPerVersion > 0 {
+		err = bm.PurgeBackupByVersion(ctx, path, sch.MaxBackupsPerVersion)
+	} else if sch.MaxBackups > 0 {
+		err = bm.PurgeBackup(ctx, path, sch.MaxBackups)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to purge backups (%v)", err)
 	}
+
+	if sch.MaxBackupAgeInSecond > 0 {
+		err = bm.PurgeBackupOlderThan(ctx, path, time.Duration(sch.MaxBackupAgeInSecond)*time.Second)
+		if err != nil {
+			return nil, fmt.Errorf("failed to purge backups older than %ds (%v)", sch.MaxBackupAgeInSecond, err)
+		}
+	}
 	return &api.BackupStatus{EtcdVersion: etcdVersion, EtcdRevision: rev}, nil
 }
@@ -15,6 +15,7 @@
 package controller
 
 import (
+	"context"
 	"time"
 
 	api "github.com/coreos/etcd-operator/pkg/apis/etcd/v1beta2"
@@ -24,7 +25,7 @@ import (
 
 // Note BackupStatus returned here is from the first round run
 func (b *Backup) handle(spec *api.BackupSpec) (*api.BackupStatus, error) {
-	status, err := b.handleBackup(spec)
+	status, err := b.handleBackup(b.ctx, spec)
 	b.handleBackupSchedule(spec)
 	return status, err
 }
@@ -37,30 +38,33 @@ func (b *Backup) handleBackupSchedule(spec *api.BackupSpec) {
 			interval = minBackupIntervalInSecond
 		}
 		go func() {
-			if spec.BackupSchedule.MaxBackups == 0 {
+			sch := spec.BackupSchedule
+			if sch.MaxBackups == 0 && sch.MaxBackupsPerVersion == 0 && sch.MaxBackupAgeInSecond == 0 {
 				return
 			}
 
 			for {
 				select {
 				case <-time.After(time.Duration(interval) * time.Second):
-					b.handleBackup(spec)
+					b.handleBackup(b.ctx, spec)
+				case <-b.ctx.Done():
+					return
 				}
 			}
 		}()
 	}
 }
 
-func (b *Backup) handleBackup(spec *api.BackupSpec) (*api.BackupStatus, error) {
+func (b *Backup) handleBackup(ctx context.Context, spec *api.BackupSpec) (*api.BackupStatus, error) {
 	switch spec.StorageType {
 	case api.BackupStorageTypeS3:
-		bs, err := handleS3(b.kubecli, spec.S3, spec.EtcdEndpoints, spec.ClientTLSSecret, b.namespace)
+		bs, err := handleS3(ctx, b.kubecli, spec.S3, spec.EtcdEndpoints, spec.ClientTLSSecret, b.namespace)
 		if err != nil {
 			return nil, err
 		}
 		return bs, nil
 	case api.BackupStorageTypeABS:
-		bs, err := handleABS(b.kubecli, spec.ABS, spec.BackupSchedule, spec.EtcdEndpoints, spec.ClientTLSSecret, b.namespace)
+		bs, err := handleABS(ctx, b.kubecli, spec.ABS, spec.BackupSchedule, spec.EtcdEndpoints, spec.ClientTLSSecret, spec.ClusterName, b.namespace)
 		if err != nil {
 			return nil, err
 		}
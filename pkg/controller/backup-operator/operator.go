@@ -46,6 +46,9 @@ type Backup struct {
 	kubeExtCli  apiextensionsclient.Interface
 
 	createCRD bool
+
+	// ctx is the context the operator is started with. Backups in progress are aborted once it is done.
+	ctx context.Context
 }
 
 // New creates a backup operator.
@@ -68,6 +71,7 @@ func (b *Backup) Start(ctx context.Context) error {
 		}
 	}
 
+	b.ctx = ctx
 	go b.run(ctx)
 	<-ctx.Done()
 	return ctx.Err()
@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"time"
 
 	api "github.com/coreos/etcd-operator/pkg/apis/etcd/v1beta2"
 	"github.com/coreos/etcd-operator/pkg/backup/backupapi"
@@ -115,19 +116,35 @@ func (r *Restore) serveBackup(w http.ResponseWriter, req *http.Request) error {
 		}
 		// Nothing to Close for absCli yet
 
-		backupReader = reader.NewABSReader(absCli.ABS)
+		var encryptionKey []byte
+		if len(absRestoreSource.EncryptionSecret) != 0 {
+			encryptionKey, err = absfactory.EncryptionKeyFromSecret(r.kubecli, r.namespace, absRestoreSource.EncryptionSecret)
+			if err != nil {
+				return fmt.Errorf("failed to get backup encryption key: %v", err)
+			}
+		}
+
+		backupReader = reader.NewABSVerifyingReader(absCli.ABS, encryptionKey, time.Duration(absRestoreSource.TimeoutInSecond)*time.Second)
 		path = absRestoreSource.Path
 	default:
 		return fmt.Errorf("unknown backup storage type (%s) for restore CR (%v)", cr.Spec.BackupStorageType, restoreName)
 	}
 
-	rc, err := backupReader.Open(path)
+	rc, err := backupReader.Open(req.Context(), path)
 	if err != nil {
 		return fmt.Errorf("failed to read backup file(%v): %v", path, err)
 	}
 	defer rc.Close()
 
-	_, err = io.Copy(w, rc)
+	// The checksum of an ABS backup is checked as it is streamed, so a corrupted backup is only detected
+	// at the end of its content, with util.ErrChecksumMismatch as cause.
+	n, err := io.Copy(w, rc)
+	if err != nil && n > 0 {
+		// The response is already sent with a 200 status: abort it so that the client sees a broken transfer
+		// instead of a complete backup.
+		logrus.Errorf("failed to write backup file(%v) to %s: %v", path, req.RemoteAddr, err)
+		panic(http.ErrAbortHandler)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to write backup to %s: %v", req.RemoteAddr, err)
 	}
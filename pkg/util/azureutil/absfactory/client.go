@@ -16,9 +16,12 @@ package absfactory
 
 import (
 	"fmt"
+	"net/http"
+	"strings"
 
 	"github.com/Azure/azure-sdk-for-go/storage"
 	api "github.com/coreos/etcd-operator/pkg/apis/etcd/v1beta2"
+	"github.com/coreos/etcd-operator/pkg/backup/util"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
@@ -41,7 +44,6 @@ func NewClientFromSecret(kubecli kubernetes.Interface, namespace, absSecret stri
 		}
 	}()
 
-	w = &ABSClient{}
 	se, err := kubecli.CoreV1().Secrets(namespace).Get(absSecret, metav1.GetOptions{})
 	if err != nil {
 		return nil, fmt.Errorf("failed to get k8s secret: %v", err)
@@ -49,15 +51,43 @@ func NewClientFromSecret(kubecli kubernetes.Interface, namespace, absSecret stri
 
 	storageAccount := se.Data[api.AzureSecretStorageAccount]
 	storageKey := se.Data[api.AzureSecretStorageKey]
+	endpointSuffix := se.Data[api.AzureSecretStorageEndpointSuffix]
 
-	bc, err := storage.NewBasicClient(
-		string(storageAccount),
-		string(storageKey))
+	return NewClient(string(storageAccount), string(storageKey), string(endpointSuffix))
+}
+
+// NewClient returns a ABS client of the given storage account.
+// endpointSuffix is the storage endpoint suffix of the Azure cloud the account lives in,
+// e.g. "core.usgovcloudapi.net" for Azure Government. It defaults to the global Azure cloud if empty.
+func NewClient(storageAccount, storageKey, endpointSuffix string) (*ABSClient, error) {
+	if len(endpointSuffix) == 0 {
+		endpointSuffix = storage.DefaultBaseURL
+	}
+	if strings.Contains(endpointSuffix, "/") {
+		return nil, fmt.Errorf("invalid storage endpoint suffix (%v): expect a domain such as %v", endpointSuffix, storage.DefaultBaseURL)
+	}
+
+	bc, err := storage.NewClient(storageAccount, storageKey, endpointSuffix, storage.DefaultAPIVersion, true)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create Azure storage client: %v", err)
 	}
+	// Let the retries of throttled requests wait as long as the service asks.
+	bc.HTTPClient = &http.Client{Transport: util.NewThrottleTransport(nil)}
 
 	abs := bc.GetBlobService()
-	w.ABS = &abs
-	return w, nil
+	return &ABSClient{ABS: &abs}, nil
+}
+
+// EncryptionKeyFromSecret returns the AES-256 backup encryption key stored in the given k8s secret.
+func EncryptionKeyFromSecret(kubecli kubernetes.Interface, namespace, secret string) ([]byte, error) {
+	se, err := kubecli.CoreV1().Secrets(namespace).Get(secret, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get k8s secret: %v", err)
+	}
+
+	key := se.Data[api.AzureSecretEncryptionKey]
+	if len(key) != util.EncryptionKeySize {
+		return nil, fmt.Errorf("invalid encryption key in secret (%v): expect %d bytes, get %d", secret, util.EncryptionKeySize, len(key))
+	}
+	return key, nil
 }
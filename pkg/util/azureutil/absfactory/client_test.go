@@ -0,0 +1,49 @@
+// Copyright 2018 The etcd-operator Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package absfactory
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+var testStorageKey = base64.StdEncoding.EncodeToString([]byte("storage-key"))
+
+func TestNewClientEndpointSuffix(t *testing.T) {
+	tests := []struct {
+		endpointSuffix string
+		expectedURL    string
+	}{
+		{"", "https://account.blob.core.windows.net/container/etcd.backup"},
+		{"core.usgovcloudapi.net", "https://account.blob.core.usgovcloudapi.net/container/etcd.backup"},
+		{"core.chinacloudapi.cn", "https://account.blob.core.chinacloudapi.cn/container/etcd.backup"},
+	}
+	for _, tt := range tests {
+		cli, err := NewClient("account", testStorageKey, tt.endpointSuffix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		u := cli.ABS.GetContainerReference("container").GetBlobReference("etcd.backup").GetURL()
+		if u != tt.expectedURL {
+			t.Errorf("endpoint suffix %q: expect url=%s, get=%s", tt.endpointSuffix, tt.expectedURL, u)
+		}
+	}
+}
+
+func TestNewClientInvalidEndpointSuffix(t *testing.T) {
+	if _, err := NewClient("account", testStorageKey, "https://core.usgovcloudapi.net/"); err == nil {
+		t.Errorf("expect error for an endpoint suffix with a scheme")
+	}
+}